@@ -0,0 +1,75 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest
+
+import (
+	"math"
+)
+
+// NewAbsoluteDigest returns digest suitable for calculating quantiles
+// of finite non-negative values with maximum absolute error err > 0,
+// for bounded quantities like utilization percentages, where the relative
+// error is a poor fit. It uses buckets of width 2*err, uniformly covering
+// the [min, max] range. Values outside of the range can be added as well,
+// but (except for 0) are clamped to it: they are counted as min or max.
+//
+// Digests created with NewAbsoluteDigest can not be marshaled, and can
+// only be merged with digests with the same err, min and max.
+//
+// NewAbsoluteDigest panics if err is not in (0, math.MaxFloat64],
+// min and max are not in [0, math.MaxFloat64], min >= max,
+// or if the range requires more than 1<<24 buckets.
+func NewAbsoluteDigest(err float64, min float64, max float64, opts ...Option) *Digest {
+	if math.IsNaN(err) || err <= 0 || err > math.MaxFloat64 {
+		panic("err must be in (0, math.MaxFloat64]")
+	}
+	if math.IsNaN(min) || math.IsNaN(max) || min < 0 || max > math.MaxFloat64 || min >= max {
+		panic("min and max must be in [0, math.MaxFloat64] and min must be less than max")
+	}
+	if (max-min)/(2*err) > maxUniformBuckets {
+		panic("(max-min)/(2*err) must not exceed 1<<24")
+	}
+
+	return newDigest(err, uniformMapping{min: min, max: max, width: 2 * err}, opts)
+}
+
+const maxUniformBuckets = 1 << 24
+
+// uniformMapping maps values to buckets of the same width, with bucket 1
+// holding the values in (min, min+width]. Values are clamped to [min, max],
+// which bounds the keys.
+type uniformMapping struct {
+	min   float64
+	max   float64
+	width float64
+}
+
+func (m uniformMapping) Index(v float64) int {
+	v = math.Min(math.Max(v, m.min), m.max)
+	return int(math.Ceil((v - m.min) / m.width))
+}
+
+func (m uniformMapping) Value(k int) float64 {
+	// Bucket holding values close to 0 can extend below 0.
+	return math.Max(m.min+(float64(k)-0.5)*m.width, 0)
+}
+
+func (m uniformMapping) LowerBound(k int) float64 {
+	return m.min + float64(k-1)*m.width
+}
+
+func (m uniformMapping) String() string {
+	return "uniform"
+}
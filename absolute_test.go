@@ -0,0 +1,84 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest_test
+
+import (
+	"math"
+	"sort"
+	"testing"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/rapid"
+)
+
+func TestAbsoluteDigest(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			absErr = rapid.SampledFrom([]float64{0.01, 0.5, 2}).Draw(t, "absolute error")
+			min    = rapid.Float64Range(0, 100).Draw(t, "min")
+			max    = rapid.Float64Range(min+1, 1000).Draw(t, "max")
+			values = rapid.SliceOfN(rapid.Float64Range(0, 1100), 1, 1000).Draw(t, "values")
+		)
+
+		d := bdigest.NewAbsoluteDigest(absErr, min, max)
+		for _, v := range values {
+			d.Add(v)
+		}
+		sort.Float64s(values)
+
+		for _, q := range quantiles {
+			want := values[int(q*float64(len(values)-1))]
+			if want != 0 {
+				want = math.Min(math.Max(want, min), max)
+			}
+			got := d.Quantile(q)
+			if e := math.Abs(got - want); e > absErr*(1+1e-9) {
+				t.Fatalf("q%v: got %v instead of %v (error %v, max %v)", q, got, want, e, absErr)
+			}
+		}
+
+		if err := d.Merge(bdigest.NewAbsoluteDigest(absErr, min, max+1)); err == nil {
+			t.Fatalf("merged digests with different ranges")
+		}
+		if err := d.Merge(bdigest.NewDigest(0.01)); err == nil {
+			t.Fatalf("merged digest with relative error")
+		}
+	})
+}
+
+func TestAbsoluteDigest_OutOfRange(t *testing.T) {
+	t.Parallel()
+
+	d := bdigest.NewAbsoluteDigest(0.5, 10, 100)
+	d.AddValues(0, 1, 1e9, math.MaxFloat64)
+	for _, v := range []float64{5, 1e300} {
+		d.Add(v)
+	}
+
+	if got := d.Quantile(0); got != 0 {
+		t.Fatalf("got q0 %v instead of 0", got)
+	}
+	if got := d.Quantile(0.4); math.Abs(got-10) > 0.5 {
+		t.Fatalf("got q0.4 %v instead of min", got)
+	}
+	if got := d.Quantile(1); math.Abs(got-100) > 0.5 {
+		t.Fatalf("got q1 %v instead of max", got)
+	}
+	if n := d.Size(); n > 100 {
+		t.Fatalf("got %v buckets for out of range values", n)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest
+
+import (
+	"math"
+)
+
+// AccuracyReport is the result of evaluating a digest configuration
+// on a set of values, as returned by MeasureAccuracy.
+type AccuracyReport struct {
+	Digest      string // description of the digest, as returned by Digest.String
+	Count       int
+	Guarantee   float64 // maximum relative error, as returned by Digest.RelativeError
+	Quantiles   []QuantileAccuracy
+	MaxError    float64 // maximum of the observed relative errors
+	Size        int     // number of histogram buckets
+	Populated   int     // number of non-empty histogram buckets
+	MemoryBytes int
+}
+
+// QuantileAccuracy holds the exact q-quantile of values, its estimate
+// by the digest and the observed relative error |Estimate-Exact|/Exact.
+// Error is 0 if both quantiles are 0, and +Inf if only the exact one is 0.
+type QuantileAccuracy struct {
+	Q        float64
+	Exact    float64
+	Estimate float64
+	Error    float64
+}
+
+// MeasureAccuracy adds values to a digest with parameters of NewDigest,
+// and reports the relative errors of its q-quantiles for each of qs
+// (or of a fixed set of points from 0 to 1, if qs is empty), along with
+// the error guaranteed by the digest and its size. Exact quantiles are
+// estimated using the same rank method as the digest (see WithRankMethod).
+//
+// MeasureAccuracy panics if any of values is outside [0, math.MaxFloat64],
+// or if any of qs is outside [0, 1].
+// Quantiles and errors are NaN if values are empty.
+func MeasureAccuracy(values []float64, qs []float64, err float64, opts ...Option) AccuracyReport {
+	if len(qs) == 0 {
+		qs = approxQuantiles
+	}
+
+	d := NewDigest(err, opts...)
+	d.AddValues(values...)
+	ref := NewDigest(err, opts...)
+	ref.exactMax = len(values) + 1
+	ref.budget = 0
+	ref.AddValues(values...)
+
+	r := AccuracyReport{
+		Digest:      d.String(),
+		Count:       len(values),
+		Guarantee:   d.RelativeError(),
+		Quantiles:   make([]QuantileAccuracy, len(qs)),
+		Size:        d.Size(),
+		Populated:   d.Populated(),
+		MemoryBytes: d.MemoryBytes(),
+	}
+	estimates, exact := d.Quantiles(qs...), ref.Quantiles(qs...)
+	for i, q := range qs {
+		e := 0.0
+		switch {
+		case math.IsNaN(exact[i]):
+			e = math.NaN()
+		case estimates[i] == exact[i]:
+		case exact[i] == 0:
+			e = math.Inf(1)
+		default:
+			e = math.Abs(estimates[i]-exact[i]) / exact[i]
+		}
+		r.Quantiles[i] = QuantileAccuracy{Q: q, Exact: exact[i], Estimate: estimates[i], Error: e}
+		r.MaxError = math.Max(r.MaxError, e)
+	}
+
+	return r
+}
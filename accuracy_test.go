@@ -0,0 +1,70 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest_test
+
+import (
+	"math"
+	"sort"
+	"testing"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/rapid"
+)
+
+func TestMeasureAccuracy(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.SampledFrom(errors).Draw(t, "relative error")
+			m      = rapid.SampledFrom(mappings).Draw(t, "mapping")
+			values = rapid.SliceOfN(normalValue, 1, 200).Draw(t, "values")
+		)
+
+		r := bdigest.MeasureAccuracy(values, quantiles, relErr, bdigest.WithMapping(m))
+		if r.Count != len(values) || len(r.Quantiles) != len(quantiles) {
+			t.Fatalf("got report for %v values and %v quantiles", r.Count, len(r.Quantiles))
+		}
+		if r.Guarantee != relErr {
+			t.Fatalf("got guarantee %v instead of %v", r.Guarantee, relErr)
+		}
+		if r.MaxError > r.Guarantee*(1+1e-9) {
+			t.Fatalf("got error %v above guarantee %v", r.MaxError, r.Guarantee)
+		}
+
+		sorted := append([]float64(nil), values...)
+		sort.Float64s(sorted)
+		for i, qa := range r.Quantiles {
+			if qa.Q != quantiles[i] {
+				t.Fatalf("got quantile %v instead of %v", qa.Q, quantiles[i])
+			}
+			if e := exactQuantile(sorted, qa.Q, bdigest.RankLower); qa.Exact != e {
+				t.Fatalf("got exact q%v %v instead of %v", qa.Q, qa.Exact, e)
+			}
+		}
+		if (r.Size == 0 && sorted[len(sorted)-1] > 0) || r.MemoryBytes == 0 {
+			t.Fatalf("got size %v and memory %v", r.Size, r.MemoryBytes)
+		}
+	})
+}
+
+func TestMeasureAccuracy_Empty(t *testing.T) {
+	t.Parallel()
+
+	r := bdigest.MeasureAccuracy(nil, nil, 0.01)
+	if len(r.Quantiles) == 0 || !math.IsNaN(r.Quantiles[0].Error) {
+		t.Fatalf("got report %v for no values", r)
+	}
+}
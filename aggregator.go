@@ -0,0 +1,154 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+// Aggregator is a concurrency-safe tree of named digests (for example,
+// instance → service → region → global), where values added to a node
+// are added to all of its ancestors as well. All the nodes are updated
+// atomically, so that every parent digest is always exactly the merge
+// of its own values and the digests of its children.
+type Aggregator struct {
+	mu    sync.Mutex
+	proto *Digest
+	nodes map[string]*aggNode
+}
+
+type aggNode struct {
+	parent *aggNode
+	d      *Digest
+}
+
+// NewAggregator returns empty tree of digests with parameters of NewDigest.
+func NewAggregator(err float64, opts ...Option) *Aggregator {
+	return &Aggregator{
+		proto: NewDigest(err, opts...),
+		nodes: map[string]*aggNode{},
+	}
+}
+
+// String returns short digest description.
+func (a *Aggregator) String() string {
+	return a.proto.String()
+}
+
+// AddNode adds node with an empty digest as a child of node parent,
+// or as a root node if parent is empty.
+func (a *Aggregator) AddNode(name string, parent string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.nodes[name]; ok {
+		return fmt.Errorf("node %q already exists", name)
+	}
+	n := &aggNode{d: a.proto.Clone()}
+	if parent != "" {
+		p, ok := a.nodes[parent]
+		if !ok {
+			return fmt.Errorf("unknown node %q", parent)
+		}
+		n.parent = p
+	}
+	a.nodes[name] = n
+
+	return nil
+}
+
+// Add adds finite non-negative value v to the digests of the node
+// and all of its ancestors, like Digest.Add.
+//
+// Add returns ErrOverflow if the total count of any of the digests
+// would overflow; the digests are left unchanged in that case.
+// Add panics if v is outside [0, math.MaxFloat64].
+func (a *Aggregator) Add(name string, v float64) error {
+	if math.IsNaN(v) || v < 0 || v > math.MaxFloat64 {
+		panic("v must be in [0, math.MaxFloat64]")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	n, ok := a.nodes[name]
+	if !ok {
+		return fmt.Errorf("unknown node %q", name)
+	}
+	for p := n; p != nil; p = p.parent {
+		if p.d.Count() == math.MaxUint64 {
+			return ErrOverflow
+		}
+	}
+	// Digests of the nodes can be coarsened differently
+	// because of WithMemoryBudget, so each computes its own key.
+	for ; n != nil; n = n.parent {
+		n.d.Add(v)
+	}
+
+	return nil
+}
+
+// Merge merges the content of v into the digests of the node
+// and all of its ancestors, like Digest.Merge.
+//
+// Merge returns ErrOverflow if the total count of any of the digests
+// would overflow; the digests are left unchanged in that case.
+func (a *Aggregator) Merge(name string, v *Digest) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	n, ok := a.nodes[name]
+	if !ok {
+		return fmt.Errorf("unknown node %q", name)
+	}
+	for p := n; p != nil; p = p.parent {
+		if _, err := addCount(p.d.Count(), v.Count()); err != nil {
+			return err
+		}
+	}
+	for ; n != nil; n = n.parent {
+		if err := n.d.Merge(v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Snapshot returns a copy of the digest of the node.
+func (a *Aggregator) Snapshot(name string) (*Digest, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	n, ok := a.nodes[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown node %q", name)
+	}
+
+	return n.d.Clone(), nil
+}
+
+// Reset resets the digests of all the nodes to the initial empty state.
+func (a *Aggregator) Reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, n := range a.nodes {
+		n.d.Reset()
+	}
+}
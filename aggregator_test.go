@@ -0,0 +1,139 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest_test
+
+import (
+	"fmt"
+	"testing"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/rapid"
+)
+
+func TestAggregator(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			nodes  = rapid.IntRange(1, 10).Draw(t, "nodes")
+			adds   = rapid.IntRange(0, 100).Draw(t, "adds")
+		)
+
+		a := bdigest.NewAggregator(relErr)
+		parents := make([]int, nodes)
+		for i := range parents {
+			parents[i] = rapid.IntRange(-1, i-1).Draw(t, "parent")
+			parent := ""
+			if parents[i] >= 0 {
+				parent = fmt.Sprint(parents[i])
+			}
+			if err := a.AddNode(fmt.Sprint(i), parent); err != nil {
+				t.Fatalf("failed to add node: %v", err)
+			}
+		}
+		if err := a.AddNode("0", ""); err == nil {
+			t.Fatalf("successfully added existing node")
+		}
+		if err := a.AddNode("x", "y"); err == nil {
+			t.Fatalf("successfully added node with unknown parent")
+		}
+
+		expected := make([]*bdigest.Digest, nodes)
+		for i := range expected {
+			expected[i] = bdigest.NewDigest(relErr)
+		}
+		for i := 0; i < adds; i++ {
+			n := rapid.IntRange(0, nodes-1).Draw(t, "node")
+			v := rapid.Float64Range(0, 1e6).Draw(t, "value")
+			if rapid.Bool().Draw(t, "merge") {
+				d := bdigest.NewDigest(relErr)
+				d.Add(v)
+				if err := a.Merge(fmt.Sprint(n), d); err != nil {
+					t.Fatalf("failed to merge into node: %v", err)
+				}
+			} else if err := a.Add(fmt.Sprint(n), v); err != nil {
+				t.Fatalf("failed to add to node: %v", err)
+			}
+			for ; n >= 0; n = parents[n] {
+				expected[n].Add(v)
+			}
+		}
+
+		for i, e := range expected {
+			d, err := a.Snapshot(fmt.Sprint(i))
+			if err != nil {
+				t.Fatalf("failed to get node snapshot: %v", err)
+			}
+			data1, _ := d.MarshalBinary()
+			data2, _ := e.MarshalBinary()
+			if string(data1) != string(data2) {
+				t.Fatalf("node %v data %q differs from %q", i, data1, data2)
+			}
+		}
+		if err := a.Add("x", 1); err == nil {
+			t.Fatalf("successfully added to unknown node")
+		}
+	})
+}
+
+func TestAggregator_MemoryBudget(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			budget = rapid.IntRange(64, 1024).Draw(t, "budget")
+			seed   = rapid.Int64().Draw(t, "seed")
+			count  = rapid.IntRange(0, 1000).Draw(t, "count")
+		)
+
+		a := bdigest.NewAggregator(0.01, bdigest.WithMemoryBudget(budget))
+		if err := a.AddNode("root", ""); err != nil {
+			t.Fatalf("failed to add node: %v", err)
+		}
+		if err := a.AddNode("leaf", "root"); err != nil {
+			t.Fatalf("failed to add node: %v", err)
+		}
+
+		expected := map[string]*bdigest.Digest{
+			"root": bdigest.NewDigest(0.01, bdigest.WithMemoryBudget(budget)),
+			"leaf": bdigest.NewDigest(0.01, bdigest.WithMemoryBudget(budget)),
+		}
+		// Values added to the root only make it coarser than the leaf.
+		for _, v := range logNormalValues(seed, count, 5) {
+			if err := a.Add("root", v); err != nil {
+				t.Fatalf("failed to add to node: %v", err)
+			}
+			expected["root"].Add(v)
+		}
+		for _, v := range logNormalValues(seed+1, count, 0) {
+			if err := a.Add("leaf", v); err != nil {
+				t.Fatalf("failed to add to node: %v", err)
+			}
+			expected["root"].Add(v)
+			expected["leaf"].Add(v)
+		}
+
+		for name, e := range expected {
+			d, err := a.Snapshot(name)
+			if err != nil {
+				t.Fatalf("failed to get node snapshot: %v", err)
+			}
+			if !d.Equal(e) {
+				t.Fatalf("node %q digest %v differs from %v", name, d, e)
+			}
+		}
+	})
+}
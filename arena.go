@@ -0,0 +1,183 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest
+
+import (
+	"sync"
+)
+
+const (
+	// DefaultArenaSlabSize is the default number of buckets
+	// in the slabs allocated by Arena, which corresponds to 1MiB of memory.
+	DefaultArenaSlabSize = 1 << 17
+
+	arenaMinSpan = 8
+)
+
+// Arena allocates the histogram buckets of many digests from large shared
+// slabs, which greatly reduces the number of heap objects (and the cost
+// of garbage collection) for large numbers of small digests.
+//
+// Memory of the buckets is only reclaimed by Reset, which frees it for all
+// the digests using the arena at once. Arena is safe for concurrent use,
+// but Reset must not be called concurrently with the use of its digests.
+type Arena struct {
+	mu       sync.Mutex
+	slabSize int
+	slabs    [][]uint64
+	cur      int // index of the slab used for allocation
+	off      int // number of buckets allocated from the current slab
+	gen      uint64
+}
+
+// NewArena returns arena allocating slabs of slabSize buckets,
+// or DefaultArenaSlabSize if slabSize is zero or negative.
+func NewArena(slabSize int) *Arena {
+	if slabSize <= 0 {
+		slabSize = DefaultArenaSlabSize
+	}
+
+	return &Arena{slabSize: slabSize}
+}
+
+// NewStore returns new empty store allocating the buckets from the arena.
+func (a *Arena) NewStore() Store {
+	return &arenaStore{a: a, gen: a.gen}
+}
+
+// WithArena makes digest allocate the histogram buckets from arena a.
+func WithArena(a *Arena) Option {
+	return func(d *Digest) {
+		d.store = a.NewStore()
+	}
+}
+
+// Reset frees the histogram buckets of all the digests using the arena,
+// making the memory of the slabs available for reuse. The digests must not
+// be used after that, unless they are reset with Digest.Reset.
+func (a *Arena) Reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.cur = 0
+	a.off = 0
+	a.gen++
+}
+
+// alloc returns n zero buckets.
+func (a *Arena) alloc(n int) []uint64 {
+	if n > a.slabSize/2 {
+		return make([]uint64, n)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(a.slabs) == 0 || a.off+n > a.slabSize {
+		if len(a.slabs) > 0 {
+			a.cur++
+		}
+		if a.cur == len(a.slabs) {
+			a.slabs = append(a.slabs, make([]uint64, a.slabSize))
+		}
+		a.off = 0
+	}
+
+	b := a.slabs[a.cur][a.off : a.off+n : a.off+n]
+	a.off += n
+	for i := range b {
+		b[i] = 0
+	}
+
+	return b
+}
+
+// arenaStore holds counts of buckets lo, lo+1, ..., lo+len(counts)-1
+// in memory allocated from the arena. It is empty if the arena
+// was reset since the allocation.
+type arenaStore struct {
+	a      *Arena
+	gen    uint64
+	lo     int
+	counts []uint64
+}
+
+func (s *arenaStore) buckets() []uint64 {
+	if s.gen != s.a.gen {
+		return nil
+	}
+	return s.counts
+}
+
+func (s *arenaStore) Add(k int, n uint64) {
+	counts := s.buckets()
+	switch {
+	case len(counts) == 0:
+		s.gen = s.a.gen
+		s.lo = k
+		s.counts = s.a.alloc(arenaMinSpan)
+	case k < s.lo || k >= s.lo+len(counts):
+		lo, hi := s.lo, s.lo+len(counts)
+		m := 2 * len(counts)
+		if k < lo {
+			if m < hi-k {
+				m = hi - k
+			}
+			lo = hi - m
+		} else if m < k-lo+1 {
+			m = k - lo + 1
+		}
+		s.counts = s.a.alloc(m)
+		copy(s.counts[s.lo-lo:], counts)
+		s.lo = lo
+	}
+
+	s.counts[k-s.lo] += n
+}
+
+func (s *arenaStore) Ascend(f func(k int, n uint64) bool) {
+	for i, n := range s.buckets() {
+		if !f(s.lo+i, n) {
+			return
+		}
+	}
+}
+
+func (s *arenaStore) Size() int {
+	return len(s.buckets())
+}
+
+func (s *arenaStore) Reset() {
+	s.lo = 0
+	s.counts = nil
+}
+
+func (s *arenaStore) Empty() Store {
+	return s.a.NewStore()
+}
+
+func (s *arenaStore) reserve(lo int, hi int) {
+	if len(s.buckets()) != 0 {
+		return
+	}
+
+	s.gen = s.a.gen
+	s.lo = lo
+	s.counts = s.a.alloc(hi - lo + 1)
+}
+
+func (s *arenaStore) memSize() int {
+	return cap(s.buckets()) * 8
+}
@@ -0,0 +1,147 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// AtomicDigest is a digest safe for concurrent use without locks,
+// where Add is a single atomic increment. It preallocates
+// the histogram buckets for a fixed range of values, and values
+// outside of the range are clamped to it.
+type AtomicDigest struct {
+	numZero uint64 // first for 64-bit alignment
+	proto   *Digest
+	lo      int // key of counts[0]
+	counts  []uint64
+}
+
+// NewAtomicDigest returns lock-free digest with parameters of NewDigest,
+// for values in [min, max]. Non-zero values less than min are counted
+// as min, values greater than max are counted as max.
+//
+// NewAtomicDigest panics if min and max are not in (0, math.MaxFloat64]
+// or min > max.
+func NewAtomicDigest(err float64, min float64, max float64, opts ...Option) *AtomicDigest {
+	if math.IsNaN(min) || math.IsNaN(max) || min <= 0 || max > math.MaxFloat64 || min > max {
+		panic("min and max must be in (0, math.MaxFloat64] and min must not be greater than max")
+	}
+
+	d := NewDigest(err, opts...)
+	lo, hi := d.bucketKey(min), d.bucketKey(max)
+	return &AtomicDigest{
+		proto:  d,
+		lo:     lo,
+		counts: make([]uint64, hi-lo+1),
+	}
+}
+
+// String returns short digest description.
+func (a *AtomicDigest) String() string {
+	return a.proto.String()
+}
+
+// Add adds finite non-negative value v to the digest, like Digest.Add,
+// clamping it to the range of the digest.
+//
+// Add panics if v is outside [0, math.MaxFloat64].
+func (a *AtomicDigest) Add(v float64) {
+	if math.IsNaN(v) || v < 0 || v > math.MaxFloat64 {
+		panic("v must be in [0, math.MaxFloat64]")
+	}
+
+	if v == 0 {
+		atomic.AddUint64(&a.numZero, 1)
+		return
+	}
+
+	i := a.proto.bucketKey(v) - a.lo
+	if i < 0 {
+		i = 0
+	} else if i >= len(a.counts) {
+		i = len(a.counts) - 1
+	}
+	atomic.AddUint64(&a.counts[i], 1)
+}
+
+// Count returns the number of added values.
+func (a *AtomicDigest) Count() uint64 {
+	n := atomic.LoadUint64(&a.numZero)
+	for i := range a.counts {
+		n += atomic.LoadUint64(&a.counts[i])
+	}
+
+	return n
+}
+
+// Reset resets digest to the initial empty state.
+// Values added concurrently with Reset may or may not be kept.
+func (a *AtomicDigest) Reset() {
+	atomic.StoreUint64(&a.numZero, 0)
+	for i := range a.counts {
+		atomic.StoreUint64(&a.counts[i], 0)
+	}
+}
+
+// Snapshot returns a digest with the content of a.
+// Values added concurrently with Snapshot may or may not be included.
+// If the total count would overflow, the values which do not fit
+// are dropped.
+func (a *AtomicDigest) Snapshot() *Digest {
+	d := a.proto.Clone()
+	d.numZero = atomic.LoadUint64(&a.numZero)
+	for i := range a.counts {
+		if n := atomic.LoadUint64(&a.counts[i]); n > 0 {
+			d.addKeySaturating(a.lo+i, n)
+		}
+	}
+
+	return d
+}
+
+// SnapshotAndReset returns a digest with the content of a, resetting a
+// to the empty state. Every value added concurrently is either included
+// in the returned digest or kept in a, unless it does not fit
+// in the total count, like with Snapshot.
+func (a *AtomicDigest) SnapshotAndReset() *Digest {
+	d := a.proto.Clone()
+	d.numZero = atomic.SwapUint64(&a.numZero, 0)
+	for i := range a.counts {
+		if n := atomic.SwapUint64(&a.counts[i], 0); n > 0 {
+			d.addKeySaturating(a.lo+i, n)
+		}
+	}
+
+	return d
+}
+
+// Quantile returns the q-quantile of added values, like Digest.Quantile.
+// Since it takes a snapshot, prefer Snapshot or Freeze
+// for multiple queries.
+func (a *AtomicDigest) Quantile(q float64) float64 {
+	return a.Snapshot().Quantile(q)
+}
+
+// Freeze returns an immutable snapshot of the digest.
+func (a *AtomicDigest) Freeze() *FrozenDigest {
+	return a.Snapshot().Freeze()
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (a *AtomicDigest) MarshalBinary() ([]byte, error) {
+	return a.Snapshot().MarshalBinary()
+}
@@ -0,0 +1,194 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bdigestgrpc implements the logic of the Digests service defined
+// in bdigest.proto, which aggregates digests submitted by many instances
+// of a service into per-key digests and serves quantile queries.
+//
+// The gRPC transport is out of scope of this package: the module depends
+// only on the standard library, so the package contains no generated code,
+// does not register a grpc.ServiceDesc and can not be served or called
+// over the network by itself. Server and Client work in-process, over
+// plain Go types with the fields of the bdigest.proto messages, and
+// bdigest.proto is the canonical schema for the transport. To serve the
+// service over gRPC, generate the code from bdigest.proto (into package
+// bdigestpb) in the module of the service, and implement the generated
+// server interface by calling Server, copying the messages field by field;
+// on the client side, do the same with the generated client.
+//
+// Recorder records the durations of gRPC calls into the digests
+// of a registry, for use in server and client interceptors.
+package bdigestgrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"pgregory.net/bdigest"
+)
+
+// ErrNotFound is returned for queries of keys without digests.
+var ErrNotFound = errors.New("digest not found")
+
+// SubmitDigestRequest is the request of Digests.SubmitDigest.
+type SubmitDigestRequest struct {
+	Key    string
+	Digest []byte // in any of the formats accepted by Digest.UnmarshalBinary
+}
+
+// SubmitDigestResponse is the response of Digests.SubmitDigest.
+type SubmitDigestResponse struct {
+	Count uint64 // number of values in the aggregate after the merge
+}
+
+// QueryQuantilesRequest is the request of Digests.QueryQuantiles.
+type QueryQuantilesRequest struct {
+	Key       string
+	Quantiles []float64
+}
+
+// QueryQuantilesResponse is the response of Digests.QueryQuantiles.
+type QueryQuantilesResponse struct {
+	Count         uint64
+	Values        []float64
+	RelativeError float64
+}
+
+// ListKeysRequest is the request of Digests.ListKeys.
+type ListKeysRequest struct{}
+
+// ListKeysResponse is the response of Digests.ListKeys.
+type ListKeysResponse struct {
+	Keys []string
+}
+
+// Service is the API of the Digests service, implemented by Server.
+type Service interface {
+	SubmitDigest(ctx context.Context, req *SubmitDigestRequest) (*SubmitDigestResponse, error)
+	QueryQuantiles(ctx context.Context, req *QueryQuantilesRequest) (*QueryQuantilesResponse, error)
+	ListKeys(ctx context.Context, req *ListKeysRequest) (*ListKeysResponse, error)
+}
+
+// Server implements the Digests service over a registry of digests.
+type Server struct {
+	reg *bdigest.Registry
+}
+
+var _ Service = (*Server)(nil)
+
+// NewServer returns server aggregating digests into registry reg.
+func NewServer(reg *bdigest.Registry) *Server {
+	return &Server{reg: reg}
+}
+
+// Registry returns the registry of aggregated digests.
+func (s *Server) Registry() *bdigest.Registry {
+	return s.reg
+}
+
+// SubmitDigest merges the digest of req into the digest of req.Key,
+// which is created if the merge succeeds. The digest must have
+// the same relative error and mapping as the registry digests.
+func (s *Server) SubmitDigest(ctx context.Context, req *SubmitDigestRequest) (*SubmitDigestResponse, error) {
+	if req.Key == "" {
+		return nil, errors.New("key must not be empty")
+	}
+
+	c, err := s.reg.MergeBinaryKey(req.Key, req.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to merge digest %q: %w", req.Key, err)
+	}
+
+	return &SubmitDigestResponse{Count: c.Count()}, nil
+}
+
+// QueryQuantiles returns the quantiles of the digest of req.Key,
+// or ErrNotFound if there is none. Quantiles of empty digest are NaN.
+func (s *Server) QueryQuantiles(ctx context.Context, req *QueryQuantilesRequest) (*QueryQuantilesResponse, error) {
+	for _, q := range req.Quantiles {
+		if math.IsNaN(q) || q < 0 || q > 1 {
+			return nil, fmt.Errorf("quantile %v is not in [0, 1]", q)
+		}
+	}
+	c, ok := s.reg.Get(req.Key)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrNotFound, req.Key)
+	}
+
+	d := c.Snapshot()
+	return &QueryQuantilesResponse{
+		Count:         d.Count(),
+		Values:        d.Quantiles(req.Quantiles...),
+		RelativeError: d.RelativeError(),
+	}, nil
+}
+
+// ListKeys returns the keys of all the digests in increasing order.
+func (s *Server) ListKeys(ctx context.Context, req *ListKeysRequest) (*ListKeysResponse, error) {
+	keys := make([]string, 0, s.reg.Len())
+	s.reg.Range(func(key string, _ *bdigest.Concurrent) bool {
+		keys = append(keys, key)
+		return true
+	})
+
+	return &ListKeysResponse{Keys: keys}, nil
+}
+
+// Client provides convenience methods over an implementation
+// of the Digests service: a Server in the same process, or an adapter
+// of a client generated from bdigest.proto.
+type Client struct {
+	conn Service
+}
+
+// NewClient returns client of the Digests service implemented by conn.
+func NewClient(conn Service) *Client {
+	return &Client{conn: conn}
+}
+
+// Submit submits digest d to be merged into the digest of key.
+func (c *Client) Submit(ctx context.Context, key string, d *bdigest.Digest) error {
+	data, err := d.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	_, err = c.conn.SubmitDigest(ctx, &SubmitDigestRequest{Key: key, Digest: data})
+	return err
+}
+
+// Quantiles returns the q-quantiles of the digest of key for each of qs.
+func (c *Client) Quantiles(ctx context.Context, key string, qs ...float64) ([]float64, error) {
+	resp, err := c.conn.QueryQuantiles(ctx, &QueryQuantilesRequest{Key: key, Quantiles: qs})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Values) != len(qs) {
+		return nil, fmt.Errorf("got %v quantiles instead of %v", len(resp.Values), len(qs))
+	}
+
+	return resp.Values, nil
+}
+
+// Keys returns the keys of all the digests.
+func (c *Client) Keys(ctx context.Context) ([]string, error) {
+	resp, err := c.conn.ListKeys(ctx, &ListKeysRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Keys, nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigestgrpc_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/bdigest/bdigestgrpc"
+)
+
+func TestClient(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	s := bdigestgrpc.NewServer(bdigest.NewRegistry(0.01))
+	c := bdigestgrpc.NewClient(s)
+
+	want := bdigest.NewDigest(0.01)
+	for i := 0; i < 3; i++ {
+		d := bdigest.FromValues(0.01, float64(i+1), float64(i+10))
+		_ = want.Merge(d)
+		if err := c.Submit(ctx, "b", d); err != nil {
+			t.Fatalf("failed to submit digest: %v", err)
+		}
+	}
+	if err := c.Submit(ctx, "a", bdigest.FromValues(0.01, 1)); err != nil {
+		t.Fatalf("failed to submit digest: %v", err)
+	}
+
+	qs := []float64{0, 0.5, 1}
+	vs, err := c.Quantiles(ctx, "b", qs...)
+	if err != nil {
+		t.Fatalf("failed to query quantiles: %v", err)
+	}
+	if !reflect.DeepEqual(vs, want.Quantiles(qs...)) {
+		t.Fatalf("got quantiles %v instead of %v", vs, want.Quantiles(qs...))
+	}
+
+	keys, err := c.Keys(ctx)
+	if err != nil || !reflect.DeepEqual(keys, []string{"a", "b"}) {
+		t.Fatalf("got keys %q: %v", keys, err)
+	}
+}
+
+func TestServer_Errors(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	s := bdigestgrpc.NewServer(bdigest.NewRegistry(0.01))
+	data, _ := bdigest.FromValues(0.05, 1).MarshalBinary()
+	if _, err := s.SubmitDigest(ctx, &bdigestgrpc.SubmitDigestRequest{Key: "a", Digest: data}); err == nil {
+		t.Fatalf("merged digest with different relative error")
+	}
+	if _, err := s.QueryQuantiles(ctx, &bdigestgrpc.QueryQuantilesRequest{Key: "a"}); !errors.Is(err, bdigestgrpc.ErrNotFound) {
+		t.Fatalf("got error %v for key of failed submission", err)
+	}
+	if _, err := s.SubmitDigest(ctx, &bdigestgrpc.SubmitDigestRequest{Digest: data}); err == nil {
+		t.Fatalf("merged digest without key")
+	}
+	if _, err := s.QueryQuantiles(ctx, &bdigestgrpc.QueryQuantilesRequest{Key: "b"}); !errors.Is(err, bdigestgrpc.ErrNotFound) {
+		t.Fatalf("got error %v for missing key", err)
+	}
+	if _, err := s.QueryQuantiles(ctx, &bdigestgrpc.QueryQuantilesRequest{Key: "a", Quantiles: []float64{2}}); err == nil {
+		t.Fatalf("queried invalid quantile")
+	}
+}
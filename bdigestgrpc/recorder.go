@@ -0,0 +1,253 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigestgrpc
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"pgregory.net/bdigest"
+)
+
+const (
+	// DefaultServerMetricName is the default metric name of the digests
+	// of call durations recorded by server recorders.
+	DefaultServerMetricName = "grpc_server_handling_seconds"
+	// DefaultClientMetricName is the default metric name of the digests
+	// of call durations recorded by client recorders.
+	DefaultClientMetricName = "grpc_client_handling_seconds"
+)
+
+// RecorderOption configures the recorder.
+type RecorderOption func(*Recorder)
+
+// WithMetricName sets the metric name of the digest keys.
+func WithMetricName(name string) RecorderOption {
+	return func(r *Recorder) {
+		r.name = name
+	}
+}
+
+// WithCode sets the function returning the status code name of the call
+// error, which is recorded as the code label. Without it, the code is
+// "OK" for nil errors and "Unknown" for the others; with grpc, use
+//
+//	bdigestgrpc.WithCode(func(err error) string { return status.Code(err).String() })
+func WithCode(code func(err error) string) RecorderOption {
+	return func(r *Recorder) {
+		r.code = code
+	}
+}
+
+// WithCodeFilter makes the recorder record only the calls
+// with status code names for which keep returns true.
+func WithCodeFilter(keep func(code string) bool) RecorderOption {
+	return func(r *Recorder) {
+		r.keep = keep
+	}
+}
+
+// Recorder records the durations of gRPC calls in seconds into the
+// registry digests with keys of the form `name{code="OK",method="/pkg.Service/Method"}`.
+// It is used through the interceptors returned by UnaryServerInterceptor,
+// StreamServerInterceptor, UnaryClientInterceptor and StreamClientInterceptor,
+// or directly through Start and Record.
+type Recorder struct {
+	reg  *bdigest.Registry
+	name string
+	code func(err error) string
+	keep func(code string) bool
+}
+
+// NewServerRecorder returns recorder of server call durations
+// into registry reg, with DefaultServerMetricName.
+func NewServerRecorder(reg *bdigest.Registry, opts ...RecorderOption) *Recorder {
+	return newRecorder(reg, DefaultServerMetricName, opts)
+}
+
+// NewClientRecorder returns recorder of client call durations
+// into registry reg, with DefaultClientMetricName.
+func NewClientRecorder(reg *bdigest.Registry, opts ...RecorderOption) *Recorder {
+	return newRecorder(reg, DefaultClientMetricName, opts)
+}
+
+func newRecorder(reg *bdigest.Registry, name string, opts []RecorderOption) *Recorder {
+	r := &Recorder{
+		reg:  reg,
+		name: name,
+		code: defaultCode,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+func defaultCode(err error) string {
+	if err == nil {
+		return "OK"
+	}
+	return "Unknown"
+}
+
+// Start starts timing a call of fullMethod (like "/pkg.Service/Method"),
+// returning the function to call with the call error when it completes.
+func (r *Recorder) Start(fullMethod string) func(err error) {
+	start := time.Now()
+	return func(err error) {
+		r.Record(fullMethod, time.Since(start), err)
+	}
+}
+
+// Record records call of fullMethod which took duration d
+// and completed with err.
+func (r *Recorder) Record(fullMethod string, d time.Duration, err error) {
+	code := r.code(err)
+	if r.keep != nil && !r.keep(code) {
+		return
+	}
+	if d < 0 {
+		d = 0
+	}
+
+	key := bdigest.FormatKey(r.name, bdigest.Label{Name: "code", Value: code}, bdigest.Label{Name: "method", Value: fullMethod})
+	r.reg.GetOrCreate(key).Add(d.Seconds())
+}
+
+// UnaryServerInterceptor returns grpc.UnaryServerInterceptor recording
+// the durations of calls with r, where fullMethod returns info.FullMethod.
+// Since the package does not depend on grpc, the grpc types are type
+// parameters, most of them inferred:
+//
+//	rec := bdigestgrpc.NewServerRecorder(reg, bdigestgrpc.WithCode(code))
+//	srv := grpc.NewServer(grpc.UnaryInterceptor(bdigestgrpc.UnaryServerInterceptor[grpc.UnaryHandler](rec,
+//		func(info *grpc.UnaryServerInfo) string { return info.FullMethod })))
+func UnaryServerInterceptor[Handler ~func(ctx context.Context, req any) (any, error), Info any](r *Recorder, fullMethod func(info *Info) string) func(ctx context.Context, req any, info *Info, handler Handler) (any, error) {
+	return func(ctx context.Context, req any, info *Info, handler Handler) (any, error) {
+		done := r.Start(fullMethod(info))
+		resp, err := handler(ctx, req)
+		done(err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor returns grpc.StreamServerInterceptor recording
+// the durations of streams with r, where fullMethod returns info.FullMethod,
+// like UnaryServerInterceptor:
+//
+//	bdigestgrpc.StreamServerInterceptor[grpc.StreamHandler](rec,
+//		func(info *grpc.StreamServerInfo) string { return info.FullMethod })
+func StreamServerInterceptor[Handler ~func(srv any, stream Stream) error, Stream any, Info any](r *Recorder, fullMethod func(info *Info) string) func(srv any, ss Stream, info *Info, handler Handler) error {
+	return func(srv any, ss Stream, info *Info, handler Handler) error {
+		done := r.Start(fullMethod(info))
+		err := handler(srv, ss)
+		done(err)
+		return err
+	}
+}
+
+// UnaryClientInterceptor returns grpc.UnaryClientInterceptor recording
+// the durations of calls with r, like UnaryServerInterceptor:
+//
+//	rec := bdigestgrpc.NewClientRecorder(reg, bdigestgrpc.WithCode(code))
+//	conn, err := grpc.NewClient(target,
+//		grpc.WithUnaryInterceptor(bdigestgrpc.UnaryClientInterceptor[grpc.UnaryInvoker](rec)))
+func UnaryClientInterceptor[Invoker ~func(ctx context.Context, method string, req any, reply any, cc *Conn, opts ...Option) error, Conn any, Option any](r *Recorder) func(ctx context.Context, method string, req any, reply any, cc *Conn, invoker Invoker, opts ...Option) error {
+	return func(ctx context.Context, method string, req any, reply any, cc *Conn, invoker Invoker, opts ...Option) error {
+		done := r.Start(method)
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		done(err)
+		return err
+	}
+}
+
+// StreamClientInterceptor returns grpc.StreamClientInterceptor recording
+// the durations of streams with r, like UnaryClientInterceptor, where
+// serverStreams returns desc.ServerStreams. The duration is recorded when
+// the stream fails to be created, when RecvMsg returns an error (io.EOF
+// for successful completion) or, for streams without server streaming,
+// when RecvMsg receives the response; streams abandoned before that
+// are not recorded:
+//
+//	bdigestgrpc.StreamClientInterceptor[grpc.Streamer](rec,
+//		func(desc *grpc.StreamDesc) bool { return desc.ServerStreams })
+func StreamClientInterceptor[Streamer ~func(ctx context.Context, desc *Desc, cc *Conn, method string, opts ...Option) (Stream, error), Desc any, Conn any, Stream clientStream[MD], Option any, MD any](r *Recorder, serverStreams func(desc *Desc) bool) func(ctx context.Context, desc *Desc, cc *Conn, method string, streamer Streamer, opts ...Option) (Stream, error) {
+	return func(ctx context.Context, desc *Desc, cc *Conn, method string, streamer Streamer, opts ...Option) (Stream, error) {
+		done := r.Start(method)
+		s, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			done(err)
+			return s, err
+		}
+
+		var w any = &recordedStream[Stream, MD]{Stream: s, serverStreams: serverStreams(desc), done: done}
+		return w.(Stream), nil
+	}
+}
+
+// clientStream is the method set of grpc.ClientStream,
+// where MD is metadata.MD.
+type clientStream[MD any] interface {
+	Header() (MD, error)
+	Trailer() MD
+	CloseSend() error
+	Context() context.Context
+	SendMsg(m any) error
+	RecvMsg(m any) error
+}
+
+// recordedStream calls done when the stream completes.
+type recordedStream[Stream clientStream[MD], MD any] struct {
+	Stream        Stream
+	serverStreams bool
+	done          func(err error)
+	once          sync.Once
+}
+
+func (s *recordedStream[Stream, MD]) Header() (MD, error) {
+	return s.Stream.Header()
+}
+
+func (s *recordedStream[Stream, MD]) Trailer() MD {
+	return s.Stream.Trailer()
+}
+
+func (s *recordedStream[Stream, MD]) CloseSend() error {
+	return s.Stream.CloseSend()
+}
+
+func (s *recordedStream[Stream, MD]) Context() context.Context {
+	return s.Stream.Context()
+}
+
+func (s *recordedStream[Stream, MD]) SendMsg(m any) error {
+	return s.Stream.SendMsg(m)
+}
+
+func (s *recordedStream[Stream, MD]) RecvMsg(m any) error {
+	err := s.Stream.RecvMsg(m)
+	switch {
+	case err == io.EOF:
+		s.once.Do(func() { s.done(nil) })
+	case err != nil:
+		s.once.Do(func() { s.done(err) })
+	case !s.serverStreams:
+		s.once.Do(func() { s.done(nil) })
+	}
+	return err
+}
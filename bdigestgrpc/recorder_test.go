@@ -0,0 +1,217 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigestgrpc_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/bdigest/bdigestgrpc"
+)
+
+func TestRecorder(t *testing.T) {
+	t.Parallel()
+
+	reg := bdigest.NewRegistry(0.01)
+	rec := bdigestgrpc.NewServerRecorder(reg)
+	for i := 0; i < 3; i++ {
+		rec.Start("/pkg.Service/Get")(nil)
+	}
+	rec.Start("/pkg.Service/Get")(errors.New("failed"))
+	rec.Record("/pkg.Service/Put", 2*time.Second, nil)
+
+	for key, n := range map[string]uint64{
+		`grpc_server_handling_seconds{code="OK",method="/pkg.Service/Get"}`:      3,
+		`grpc_server_handling_seconds{code="Unknown",method="/pkg.Service/Get"}`: 1,
+		`grpc_server_handling_seconds{code="OK",method="/pkg.Service/Put"}`:      1,
+	} {
+		if c, ok := reg.Get(key); !ok || c.Count() != n {
+			t.Fatalf("digest %q is missing or has wrong count", key)
+		}
+	}
+	if c, _ := reg.Get(`grpc_server_handling_seconds{code="OK",method="/pkg.Service/Put"}`); c.Quantile(0.5) < 1.9 {
+		t.Fatalf("got duration %v instead of 2", c.Quantile(0.5))
+	}
+}
+
+func TestRecorder_CodeFilter(t *testing.T) {
+	t.Parallel()
+
+	errCanceled := errors.New("canceled")
+	reg := bdigest.NewRegistry(0.01)
+	rec := bdigestgrpc.NewClientRecorder(reg,
+		bdigestgrpc.WithMetricName("rpc"),
+		bdigestgrpc.WithCode(func(err error) string {
+			if err == errCanceled {
+				return "Canceled"
+			}
+			return "OK"
+		}),
+		bdigestgrpc.WithCodeFilter(func(code string) bool { return code != "Canceled" }))
+	rec.Record("/pkg.Service/Get", time.Millisecond, errCanceled)
+	rec.Record("/pkg.Service/Get", time.Millisecond, nil)
+
+	if reg.Len() != 1 {
+		t.Fatalf("got %v digests instead of 1", reg.Len())
+	}
+	if _, ok := reg.Get(`rpc{code="OK",method="/pkg.Service/Get"}`); !ok {
+		t.Fatalf("digest of successful calls is missing")
+	}
+}
+
+// Types with the same definitions as the ones of grpc.
+type (
+	clientConn struct{}
+	callOption interface{}
+	streamDesc struct{ ServerStreams bool }
+	metadataMD map[string][]string
+
+	clientStream interface {
+		Header() (metadataMD, error)
+		Trailer() metadataMD
+		CloseSend() error
+		Context() context.Context
+		SendMsg(m any) error
+		RecvMsg(m any) error
+	}
+	serverStream interface{}
+
+	unaryServerInfo  struct{ FullMethod string }
+	streamServerInfo struct{ FullMethod string }
+
+	unaryHandler            func(ctx context.Context, req any) (any, error)
+	unaryServerInterceptor  func(ctx context.Context, req any, info *unaryServerInfo, handler unaryHandler) (resp any, err error)
+	streamHandler           func(srv any, stream serverStream) error
+	streamServerInterceptor func(srv any, ss serverStream, info *streamServerInfo, handler streamHandler) error
+	unaryInvoker            func(ctx context.Context, method string, req, reply any, cc *clientConn, opts ...callOption) error
+	unaryClientInterceptor  func(ctx context.Context, method string, req, reply any, cc *clientConn, invoker unaryInvoker, opts ...callOption) error
+	streamer                func(ctx context.Context, desc *streamDesc, cc *clientConn, method string, opts ...callOption) (clientStream, error)
+	streamClientInterceptor func(ctx context.Context, desc *streamDesc, cc *clientConn, method string, streamer streamer, opts ...callOption) (clientStream, error)
+)
+
+func TestInterceptors(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	errFailed := errors.New("failed")
+	reg := bdigest.NewRegistry(0.01)
+	srv := bdigestgrpc.NewServerRecorder(reg)
+	cli := bdigestgrpc.NewClientRecorder(reg)
+
+	var (
+		unaryServer unaryServerInterceptor = bdigestgrpc.UnaryServerInterceptor[unaryHandler](srv,
+			func(info *unaryServerInfo) string { return info.FullMethod })
+		streamServer streamServerInterceptor = bdigestgrpc.StreamServerInterceptor[streamHandler](srv,
+			func(info *streamServerInfo) string { return info.FullMethod })
+		unaryClient  unaryClientInterceptor  = bdigestgrpc.UnaryClientInterceptor[unaryInvoker](cli)
+		streamClient streamClientInterceptor = bdigestgrpc.StreamClientInterceptor[streamer](cli,
+			func(desc *streamDesc) bool { return desc.ServerStreams })
+	)
+
+	resp, err := unaryServer(ctx, 1, &unaryServerInfo{FullMethod: "/pkg.Service/Get"}, func(ctx context.Context, req any) (any, error) {
+		return req.(int) + 1, nil
+	})
+	if resp != 2 || err != nil {
+		t.Fatalf("got response %v and error %v instead of handler result", resp, err)
+	}
+	err = streamServer(nil, nil, &streamServerInfo{FullMethod: "/pkg.Service/Watch"}, func(srv any, stream serverStream) error {
+		return errFailed
+	})
+	if err != errFailed {
+		t.Fatalf("got error %v instead of handler error", err)
+	}
+	err = unaryClient(ctx, "/pkg.Service/Get", nil, nil, nil, func(ctx context.Context, method string, req, reply any, cc *clientConn, opts ...callOption) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("got error %v instead of invoker result", err)
+	}
+	_, err = streamClient(ctx, &streamDesc{}, nil, "/pkg.Service/Watch", func(ctx context.Context, desc *streamDesc, cc *clientConn, method string, opts ...callOption) (clientStream, error) {
+		return nil, errFailed
+	})
+	if err != errFailed {
+		t.Fatalf("got error %v instead of streamer error", err)
+	}
+
+	for _, key := range []string{
+		`grpc_server_handling_seconds{code="OK",method="/pkg.Service/Get"}`,
+		`grpc_server_handling_seconds{code="Unknown",method="/pkg.Service/Watch"}`,
+		`grpc_client_handling_seconds{code="OK",method="/pkg.Service/Get"}`,
+		`grpc_client_handling_seconds{code="Unknown",method="/pkg.Service/Watch"}`,
+	} {
+		if c, ok := reg.Get(key); !ok || c.Count() != 1 {
+			t.Fatalf("digest %q is missing or has wrong count", key)
+		}
+	}
+}
+
+type fakeStream struct {
+	clientStream
+	msgs []error
+}
+
+func (s *fakeStream) RecvMsg(m any) error {
+	time.Sleep(10 * time.Millisecond)
+	err := s.msgs[0]
+	s.msgs = s.msgs[1:]
+	return err
+}
+
+func TestStreamClientInterceptor(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	errFailed := errors.New("failed")
+	for _, tt := range []struct {
+		desc streamDesc
+		msgs []error
+		code string
+		recv int
+	}{
+		{streamDesc{ServerStreams: true}, []error{nil, nil, io.EOF}, "OK", 3},
+		{streamDesc{ServerStreams: true}, []error{nil, errFailed}, "Unknown", 2},
+		{streamDesc{ServerStreams: false}, []error{nil}, "OK", 1},
+	} {
+		reg := bdigest.NewRegistry(0.01)
+		var streamClient streamClientInterceptor = bdigestgrpc.StreamClientInterceptor[streamer](bdigestgrpc.NewClientRecorder(reg),
+			func(desc *streamDesc) bool { return desc.ServerStreams })
+
+		s, err := streamClient(ctx, &tt.desc, nil, "/pkg.Service/Watch", func(ctx context.Context, desc *streamDesc, cc *clientConn, method string, opts ...callOption) (clientStream, error) {
+			return &fakeStream{msgs: tt.msgs}, nil
+		})
+		if err != nil {
+			t.Fatalf("got error %v instead of streamer result", err)
+		}
+		if reg.Len() != 0 {
+			t.Fatalf("stream recorded before completion")
+		}
+		for i := 0; i < tt.recv; i++ {
+			_ = s.RecvMsg(nil)
+		}
+
+		key := `grpc_client_handling_seconds{code="` + tt.code + `",method="/pkg.Service/Watch"}`
+		c, ok := reg.Get(key)
+		if !ok || c.Count() != 1 || reg.Len() != 1 {
+			t.Fatalf("digest %q is missing or has wrong count", key)
+		}
+		if want := float64(tt.recv) * 0.01; c.Quantile(0.5) < want*0.99 {
+			t.Fatalf("got duration %v instead of at least %v", c.Quantile(0.5), want)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bdigesthttp serves bdigest digests over HTTP, for example
+// as debug endpoints exposing latency distributions of a service:
+//
+//	http.Handle("/debug/latency", bdigesthttp.Handler(latency))
+//
+// The format of the response is selected by the Accept request header,
+// or by the "format" query parameter, which takes precedence:
+//
+//	json    application/json (default), as returned by bdigest.Digest.Summary
+//	binary  application/octet-stream, as returned by MarshalBinary
+//	text    text/plain, as rendered by bdigest.Digest.RenderText
+//	svg     image/svg+xml, as rendered by bdigestplot.SVG
+//	png     image/png, as rendered by bdigestplot.PNG
+//
+// Quantiles to report or mark on the charts are selected by the "q"
+// query parameters, each holding one or more comma-separated quantiles.
+//
+// Middleware records the durations of requests served by a handler
+// into the digests of a registry, by route.
+package bdigesthttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/bdigest/bdigestplot"
+)
+
+// defaultQuantiles are the quantiles reported in JSON by default.
+var defaultQuantiles = []float64{0.5, 0.9, 0.95, 0.99, 0.999}
+
+var formats = []struct {
+	name string
+	mime string
+}{
+	{"json", "application/json"},
+	{"binary", "application/octet-stream"},
+	{"text", "text/plain"},
+	{"svg", "image/svg+xml"},
+	{"png", "image/png"},
+}
+
+// Source is a digest safe for concurrent use, like bdigest.Concurrent,
+// bdigest.ShardedDigest or bdigest.AtomicDigest.
+type Source interface {
+	Snapshot() *bdigest.Digest
+}
+
+// Handler returns handler serving snapshots of the digest s.
+func Handler(s Source) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		format, qs, ok := parseRequest(w, r)
+		if !ok {
+			return
+		}
+		serveDigest(w, format, s.Snapshot(), qs)
+	})
+}
+
+// RegistryHandler returns handler serving the digests of registry reg.
+// The digest with the key given by the "key" query parameter is served
+// like by Handler. Without the key, all the digests are served in JSON
+// (as an object with digest summaries by key), binary (as returned by
+// bdigest.Registry.MarshalBinary) or text format.
+func RegistryHandler(reg *bdigest.Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		format, qs, ok := parseRequest(w, r)
+		if !ok {
+			return
+		}
+
+		serveRegistry(w, format, reg, r.URL.Query().Get("key"), qs)
+	})
+}
+
+// serveRegistry serves the digest of reg with the given key,
+// or all the digests if key is empty.
+func serveRegistry(w http.ResponseWriter, format string, reg *bdigest.Registry, key string, qs []float64) {
+	if key != "" {
+		c, ok := reg.Get(key)
+		if !ok {
+			http.Error(w, fmt.Sprintf("digest %q not found", key), http.StatusNotFound)
+			return
+		}
+		serveDigest(w, format, c.Snapshot(), qs)
+		return
+	}
+
+	switch format {
+	case "json":
+		summaries := map[string]bdigest.Summary{}
+		reg.Range(func(key string, c *bdigest.Concurrent) bool {
+			summaries[key] = c.Snapshot().Summary(jsonQuantiles(qs)...)
+			return true
+		})
+		writeJSON(w, summaries)
+	case "binary":
+		data, err := reg.MarshalBinary()
+		writeBinary(w, data, err)
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		reg.Range(func(key string, c *bdigest.Concurrent) bool {
+			fmt.Fprintf(w, "%v\n", key)
+			err := c.Snapshot().RenderText(w, textOptions(qs)...)
+			fmt.Fprintln(w)
+			return err == nil
+		})
+	default:
+		http.Error(w, fmt.Sprintf("key is required for %v format", format), http.StatusBadRequest)
+	}
+}
+
+func serveDigest(w http.ResponseWriter, format string, d *bdigest.Digest, qs []float64) {
+	switch format {
+	case "json":
+		writeJSON(w, d.Summary(jsonQuantiles(qs)...))
+	case "binary":
+		data, err := d.MarshalBinary()
+		writeBinary(w, data, err)
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_ = d.RenderText(w, textOptions(qs)...)
+	case "svg", "png":
+		var opts []bdigestplot.Option
+		if qs != nil {
+			opts = append(opts, bdigestplot.Quantiles(qs...))
+		}
+		render := bdigestplot.SVG
+		if format == "png" {
+			render = bdigestplot.PNG
+		}
+		w.Header().Set("Content-Type", mimeType(format))
+		_ = render(w, d, opts...)
+	}
+}
+
+// parseRequest returns the response format and the requested quantiles
+// (nil if none), replying with an error if the request is invalid.
+func parseRequest(w http.ResponseWriter, r *http.Request) (string, []float64, bool) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return "", nil, false
+	}
+
+	query := r.URL.Query()
+	var qs []float64
+	for _, s := range query["q"] {
+		for _, f := range strings.Split(s, ",") {
+			q, err := strconv.ParseFloat(f, 64)
+			if err != nil || !(q >= 0 && q <= 1) {
+				http.Error(w, fmt.Sprintf("quantile %q is not in [0, 1]", f), http.StatusBadRequest)
+				return "", nil, false
+			}
+			qs = append(qs, q)
+		}
+	}
+
+	format := query.Get("format")
+	if format == "" {
+		format = negotiate(r.Header.Get("Accept"))
+	}
+	if mimeType(format) == "" {
+		http.Error(w, fmt.Sprintf("unknown format %q", format), http.StatusBadRequest)
+		return "", nil, false
+	}
+
+	return format, qs, true
+}
+
+// negotiate returns the supported format most preferred by the Accept
+// header, or JSON if there is none.
+func negotiate(accept string) string {
+	format, best := "json", 0.0
+	for _, s := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(s)
+		if err != nil {
+			continue
+		}
+		pref := 1.0
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil {
+			pref = q
+		}
+		for _, f := range formats {
+			if f.mime == mt && pref > best {
+				format, best = f.name, pref
+			}
+		}
+	}
+
+	return format
+}
+
+func mimeType(format string) string {
+	for _, f := range formats {
+		if f.name == format {
+			return f.mime
+		}
+	}
+	return ""
+}
+
+func jsonQuantiles(qs []float64) []float64 {
+	if qs == nil {
+		return defaultQuantiles
+	}
+	return qs
+}
+
+func textOptions(qs []float64) []bdigest.TextOption {
+	if qs == nil {
+		return nil
+	}
+	return []bdigest.TextOption{bdigest.TextQuantiles(qs...)}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(append(data, '\n'))
+}
+
+func writeBinary(w http.ResponseWriter, data []byte, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, _ = w.Write(data)
+}
@@ -0,0 +1,140 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigesthttp_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/bdigest/bdigesthttp"
+)
+
+func get(t *testing.T, h http.Handler, url string, accept string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func newConcurrent() *bdigest.Concurrent {
+	c := bdigest.NewConcurrent(0.01)
+	for i := 1; i <= 100; i++ {
+		c.Add(float64(i))
+	}
+	return c
+}
+
+func TestHandler_Formats(t *testing.T) {
+	t.Parallel()
+
+	h := bdigesthttp.Handler(newConcurrent())
+	for _, tt := range []struct {
+		url    string
+		accept string
+		ctype  string
+	}{
+		{"/", "", "application/json"},
+		{"/", "*/*", "application/json"},
+		{"/", "application/octet-stream", "application/octet-stream"},
+		{"/", "text/html, text/plain;q=0.9, application/json;q=0.5", "text/plain; charset=utf-8"},
+		{"/", "image/svg+xml", "image/svg+xml"},
+		{"/?format=png", "application/json", "image/png"},
+	} {
+		rec := get(t, h, tt.url, tt.accept)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("got status %v for %q with Accept %q: %v", rec.Code, tt.url, tt.accept, rec.Body)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != tt.ctype {
+			t.Fatalf("got content type %q instead of %q for %q with Accept %q", ct, tt.ctype, tt.url, tt.accept)
+		}
+	}
+}
+
+func TestHandler_Quantiles(t *testing.T) {
+	t.Parallel()
+
+	c := newConcurrent()
+	rec := get(t, bdigesthttp.Handler(c), "/?q=0.5,0.9&q=1", "")
+	var s bdigest.Summary
+	if err := json.Unmarshal(rec.Body.Bytes(), &s); err != nil {
+		t.Fatalf("failed to unmarshal summary %q: %v", rec.Body, err)
+	}
+	if s.Count != 100 || len(s.Quantiles) != 3 || s.Quantiles[2].Q != 1 || s.Quantiles[2].Value != c.Quantile(1) {
+		t.Fatalf("got unexpected summary %+v", s)
+	}
+
+	if rec := get(t, bdigesthttp.Handler(c), "/?q=2", ""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("got status %v for invalid quantile", rec.Code)
+	}
+	if rec := get(t, bdigesthttp.Handler(c), "/?format=xml", ""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("got status %v for invalid format", rec.Code)
+	}
+}
+
+func TestHandler_Binary(t *testing.T) {
+	t.Parallel()
+
+	c := newConcurrent()
+	rec := get(t, bdigesthttp.Handler(c), "/?format=binary", "")
+	d := &bdigest.Digest{}
+	if err := d.UnmarshalBinary(rec.Body.Bytes()); err != nil {
+		t.Fatalf("failed to unmarshal digest: %v", err)
+	}
+	if !d.Equal(c.Snapshot()) {
+		t.Fatalf("got digest %v instead of %v", d, c)
+	}
+}
+
+func TestRegistryHandler(t *testing.T) {
+	t.Parallel()
+
+	reg := bdigest.NewRegistry(0.01)
+	reg.GetOrCreate("a").Add(1)
+	reg.GetOrCreate("b").Add(2)
+	h := bdigesthttp.RegistryHandler(reg)
+
+	var summaries map[string]bdigest.Summary
+	rec := get(t, h, "/", "")
+	if err := json.Unmarshal(rec.Body.Bytes(), &summaries); err != nil {
+		t.Fatalf("failed to unmarshal summaries %q: %v", rec.Body, err)
+	}
+	if len(summaries) != 2 || summaries["a"].Count != 1 || summaries["b"].Count != 1 {
+		t.Fatalf("got unexpected summaries %+v", summaries)
+	}
+
+	other := bdigest.NewRegistry(0.01)
+	if err := other.MergeBinary(get(t, h, "/?format=binary", "").Body.Bytes()); err != nil || other.Len() != 2 {
+		t.Fatalf("failed to merge registry (%v digests): %v", other.Len(), err)
+	}
+
+	if rec := get(t, h, "/?key=a&format=text", ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "count=1") {
+		t.Fatalf("got status %v and text %q", rec.Code, rec.Body)
+	}
+	if rec := get(t, h, "/?key=c", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("got status %v for missing key", rec.Code)
+	}
+	if rec := get(t, h, "/", "image/png"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("got status %v for chart without key", rec.Code)
+	}
+}
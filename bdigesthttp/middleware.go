@@ -0,0 +1,231 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigesthttp
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"pgregory.net/bdigest"
+)
+
+// DefaultMetricName is the default name of request duration digests
+// recorded by Middleware.
+const DefaultMetricName = "http_request_duration_seconds"
+
+// MiddlewareOption configures the middleware.
+type MiddlewareOption func(*middleware)
+
+type middleware struct {
+	reg    *bdigest.Registry
+	next   http.Handler
+	name   string
+	route  func(r *http.Request) string
+	status func(code int) string
+}
+
+// WithMetricName sets the metric name of the digest keys
+// (DefaultMetricName by default).
+func WithMetricName(name string) MiddlewareOption {
+	return func(m *middleware) {
+		m.name = name
+	}
+}
+
+// WithRoute sets the function returning the route of the request, like
+// "/users/{id}", to record its duration under. Routes must take a bounded
+// number of values, since there is a digest for each of them: derive them
+// from the router patterns, or at least from NormalizePath of the request
+// path for the known paths only.
+func WithRoute(route func(r *http.Request) string) MiddlewareOption {
+	return func(m *middleware) {
+		m.route = route
+	}
+}
+
+// WithStatusCodes makes the middleware record durations separately
+// for each response status code, like "200" or "404".
+func WithStatusCodes() MiddlewareOption {
+	return func(m *middleware) {
+		m.status = strconv.Itoa
+	}
+}
+
+// WithStatusClasses makes the middleware record durations separately
+// for each response status class, like "2xx" or "4xx".
+func WithStatusClasses() MiddlewareOption {
+	return func(m *middleware) {
+		m.status = func(code int) string {
+			return strconv.Itoa(code/100) + "xx"
+		}
+	}
+}
+
+// Middleware returns handler calling next and recording the duration of
+// each request in seconds into the registry digest with key of the form
+// `name{method="GET",route="/users/{id}",status="200"}`, where the status
+// label is only present with WithStatusCodes or WithStatusClasses.
+// Without WithRoute, the route is "unknown" for all the requests,
+// since the request paths can take unbounded number of values.
+// The digests can be served with RegistryHandler or Server, or sent
+// by the exporters of other packages.
+func Middleware(reg *bdigest.Registry, next http.Handler, opts ...MiddlewareOption) http.Handler {
+	m := &middleware{
+		reg:   reg,
+		next:  next,
+		name:  DefaultMetricName,
+		route: func(r *http.Request) string { return "unknown" },
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
+}
+
+func (m *middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	sw := &statusWriter{ResponseWriter: w}
+	defer func() {
+		d := time.Since(start).Seconds()
+		if d < 0 {
+			d = 0
+		}
+		m.reg.GetOrCreate(m.key(r, sw.status())).Add(d)
+	}()
+
+	m.next.ServeHTTP(sw, r)
+}
+
+func (m *middleware) key(r *http.Request, code int) string {
+	labels := []bdigest.Label{{Name: "method", Value: r.Method}, {Name: "route", Value: m.route(r)}}
+	if m.status != nil {
+		labels = append(labels, bdigest.Label{Name: "status", Value: m.status(code)})
+	}
+
+	return bdigest.FormatKey(m.name, labels...)
+}
+
+// NormalizePath returns path with the segments looking like identifiers
+// (decimal numbers, or hexadecimal numbers and UUIDs of at least 16 digits)
+// replaced by "{id}", to keep the number of distinct routes bounded.
+func NormalizePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if isID(s) {
+			segments[i] = "{id}"
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
+
+func isID(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	decimal, hex := true, 0
+	for _, c := range s {
+		switch {
+		case c >= '0' && c <= '9':
+			hex++
+		case c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F':
+			decimal = false
+			hex++
+		case c == '-':
+			decimal = false
+		default:
+			return false
+		}
+	}
+
+	return decimal && hex > 0 || hex >= 16
+}
+
+// statusWriter records the status code of the response.
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements the http.Flusher interface, if the wrapped writer does.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if w.code == 0 {
+			w.code = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+// ReadFrom implements the io.ReaderFrom interface,
+// using the one of the wrapped writer if it does.
+func (w *statusWriter) ReadFrom(r io.Reader) (int64, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(w.ResponseWriter, r)
+}
+
+// Hijack implements the http.Hijacker interface, if the wrapped writer does.
+// Requests with hijacked connections are recorded with status 101
+// (Switching Protocols), unless the status was written before.
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil && w.code == 0 {
+		w.code = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Unwrap returns the wrapped writer, for http.ResponseController.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *statusWriter) status() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
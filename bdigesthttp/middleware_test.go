@@ -0,0 +1,144 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigesthttp_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/bdigest/bdigesthttp"
+)
+
+func TestNormalizePath(t *testing.T) {
+	t.Parallel()
+
+	for path, want := range map[string]string{
+		"/":                        "/",
+		"/users/42":                "/users/{id}",
+		"/users/42/posts/7/":       "/users/{id}/posts/{id}/",
+		"/v1/items":                "/v1/items",
+		"/files/deadbeef":          "/files/deadbeef",
+		"/files/0123456789abcdef0": "/files/{id}",
+		"/u/123e4567-e89b-12d3-a456-426614174000": "/u/{id}",
+	} {
+		if got := bdigesthttp.NormalizePath(path); got != want {
+			t.Fatalf("got %q instead of %q for %q", got, want, path)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	reg := bdigest.NewRegistry(0.01)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/users/0" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	})
+	h := bdigesthttp.Middleware(reg, mux, bdigesthttp.WithStatusClasses(),
+		bdigesthttp.WithRoute(func(r *http.Request) string { return bdigesthttp.NormalizePath(r.URL.Path) }))
+
+	for _, path := range []string{"/users/1", "/users/2", "/users/0"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/users/3", nil))
+
+	for key, n := range map[string]uint64{
+		`http_request_duration_seconds{method="GET",route="/users/{id}",status="2xx"}`:  2,
+		`http_request_duration_seconds{method="GET",route="/users/{id}",status="4xx"}`:  1,
+		`http_request_duration_seconds{method="POST",route="/users/{id}",status="2xx"}`: 1,
+	} {
+		if c, ok := reg.Get(key); !ok || c.Count() != n {
+			t.Fatalf("digest %q is missing or has wrong count", key)
+		}
+	}
+	if reg.Len() != 3 {
+		t.Fatalf("got %v digests instead of 3", reg.Len())
+	}
+}
+
+func TestMiddleware_Route(t *testing.T) {
+	t.Parallel()
+
+	reg := bdigest.NewRegistry(0.01)
+	h := bdigesthttp.Middleware(reg, http.NotFoundHandler(),
+		bdigesthttp.WithMetricName("latency"),
+		bdigesthttp.WithStatusCodes(),
+		bdigesthttp.WithRoute(func(r *http.Request) string { return "all" }))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/a", nil))
+
+	if _, ok := reg.Get(`latency{method="GET",route="all",status="404"}`); !ok {
+		t.Fatalf("digest with custom route is missing")
+	}
+}
+
+func TestMiddleware_DefaultRoute(t *testing.T) {
+	t.Parallel()
+
+	reg := bdigest.NewRegistry(0.01)
+	h := bdigesthttp.Middleware(reg, http.NotFoundHandler())
+	for _, path := range []string{"/a", "/b", "/c/1"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	if c, ok := reg.Get(`http_request_duration_seconds{method="GET",route="unknown"}`); !ok || c.Count() != 3 {
+		t.Fatalf("digest with default route is missing or has wrong count")
+	}
+	if reg.Len() != 1 {
+		t.Fatalf("got %v digests instead of 1", reg.Len())
+	}
+}
+
+func TestMiddleware_Hijack(t *testing.T) {
+	t.Parallel()
+
+	reg := bdigest.NewRegistry(0.01)
+	h := bdigesthttp.Middleware(reg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(io.ReaderFrom); !ok {
+			t.Errorf("response writer does not implement io.ReaderFrom")
+		}
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("failed to hijack connection: %v", err)
+			return
+		}
+		_, _ = conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\n\r\n"))
+		_ = conn.Close()
+	}), bdigesthttp.WithStatusCodes())
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("failed to get response: %v", err)
+	}
+	_ = resp.Body.Close()
+
+	// The duration is recorded after the response is sent.
+	for i := 0; i < 100 && reg.Len() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, ok := reg.Get(`http_request_duration_seconds{method="GET",route="unknown",status="101"}`); !ok {
+		t.Fatalf("digest of hijacked request is missing")
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigesthttp
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"pgregory.net/bdigest"
+)
+
+// DefaultMaxBodyBytes is the default maximum size of digests
+// submitted to Server in a single request.
+const DefaultMaxBodyBytes = 16 << 20
+
+// Server is an HTTP handler aggregating digests submitted by many
+// instances of a service into per-key digests of a registry,
+// and serving them like RegistryHandler.
+//
+// Requests are routed by the path relative to the server root
+// (use http.StripPrefix to mount the server elsewhere):
+//
+//	POST /key  merges the binary digest in the body into the digest of key
+//	POST /     merges the binary registry in the body into the registry
+//	GET  /key  serves the digest of key
+//	GET  /     serves all the digests
+//
+// Keys may contain slashes. Submitted digests must have the same relative
+// error and mapping as the registry digests.
+type Server struct {
+	reg          *bdigest.Registry
+	maxBodyBytes int64
+}
+
+// NewServer returns server aggregating digests into registry reg.
+func NewServer(reg *bdigest.Registry) *Server {
+	return &Server{
+		reg:          reg,
+		maxBodyBytes: DefaultMaxBodyBytes,
+	}
+}
+
+// SetMaxBodyBytes sets the maximum size of request bodies (DefaultMaxBodyBytes
+// by default). Requests with larger bodies are rejected.
+//
+// SetMaxBodyBytes panics if n is not positive.
+func (s *Server) SetMaxBodyBytes(n int64) {
+	if n <= 0 {
+		panic("n must be positive")
+	}
+
+	s.maxBodyBytes = n
+}
+
+// Registry returns the registry of aggregated digests.
+func (s *Server) Registry() *bdigest.Registry {
+	return s.reg
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	if r.Method == http.MethodPost {
+		s.submit(w, r, key)
+		return
+	}
+
+	format, qs, ok := parseRequest(w, r)
+	if !ok {
+		return
+	}
+	serveRegistry(w, format, s.reg, key, qs)
+}
+
+func (s *Server) submit(w http.ResponseWriter, r *http.Request, key string) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.maxBodyBytes))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read body: %v", err), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if key == "" {
+		err = s.reg.MergeBinary(data)
+	} else {
+		err = s.reg.GetOrCreate(key).MergeBinary(data)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
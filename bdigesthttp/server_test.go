@@ -0,0 +1,108 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigesthttp_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/bdigest/bdigesthttp"
+)
+
+func post(t *testing.T, h http.Handler, url string, body []byte) *httptest.ResponseRecorder {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, url, bytes.NewReader(body)))
+	return rec
+}
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+
+	s := bdigesthttp.NewServer(bdigest.NewRegistry(0.01))
+	want := bdigest.NewDigest(0.01)
+	for i := 0; i < 3; i++ {
+		d := bdigest.FromValues(0.01, float64(i+1), float64(i+10))
+		_ = want.Merge(d)
+		data, _ := d.MarshalBinary()
+		if rec := post(t, s, "/api/latency", data); rec.Code != http.StatusNoContent {
+			t.Fatalf("got status %v: %v", rec.Code, rec.Body)
+		}
+	}
+
+	c, ok := s.Registry().Get("api/latency")
+	if !ok || !c.Snapshot().Equal(want) {
+		t.Fatalf("got aggregated digest %v instead of %v", c, want)
+	}
+
+	var summary bdigest.Summary
+	rec := get(t, s, "/api/latency?q=0.5", "")
+	if err := json.Unmarshal(rec.Body.Bytes(), &summary); err != nil {
+		t.Fatalf("failed to unmarshal summary %q: %v", rec.Body, err)
+	}
+	if summary.Count != 6 || summary.Quantiles[0].Value != want.Quantile(0.5) {
+		t.Fatalf("got unexpected summary %+v", summary)
+	}
+}
+
+func TestServer_Registry(t *testing.T) {
+	t.Parallel()
+
+	s := bdigesthttp.NewServer(bdigest.NewRegistry(0.01))
+	reg := bdigest.NewRegistry(0.01)
+	reg.GetOrCreate("a").Add(1)
+	reg.GetOrCreate("b").Add(2)
+	data, _ := reg.MarshalBinary()
+	for i := 0; i < 2; i++ {
+		if rec := post(t, s, "/", data); rec.Code != http.StatusNoContent {
+			t.Fatalf("got status %v: %v", rec.Code, rec.Body)
+		}
+	}
+
+	var summaries map[string]bdigest.Summary
+	if err := json.Unmarshal(get(t, s, "/", "").Body.Bytes(), &summaries); err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 2 || summaries["a"].Count != 2 || summaries["b"].Count != 2 {
+		t.Fatalf("got unexpected summaries %+v", summaries)
+	}
+}
+
+func TestServer_Invalid(t *testing.T) {
+	t.Parallel()
+
+	s := bdigesthttp.NewServer(bdigest.NewRegistry(0.01))
+	if rec := post(t, s, "/a", []byte("garbage")); rec.Code != http.StatusBadRequest {
+		t.Fatalf("got status %v for invalid digest", rec.Code)
+	}
+	data, _ := bdigest.FromValues(0.05, 1).MarshalBinary()
+	if rec := post(t, s, "/a", data); rec.Code != http.StatusBadRequest {
+		t.Fatalf("got status %v for digest with different error", rec.Code)
+	}
+	if c, _ := s.Registry().Get("a"); c.Count() != 0 {
+		t.Fatalf("invalid submissions added %v values", c.Count())
+	}
+
+	s.SetMaxBodyBytes(4)
+	data, _ = bdigest.FromValues(0.01, 1).MarshalBinary()
+	if rec := post(t, s, "/a", data); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("got status %v for large body", rec.Code)
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bdigestmetrics provides a sample of values backed by a bdigest
+// digest, with the methods of the Sample interface of the go-metrics
+// package (github.com/rcrowley/go-metrics), so that histograms of code
+// using go-metrics get bounded memory use and mergeable state.
+//
+// The package does not depend on go-metrics, so Sample.Snapshot returns
+// *Sample instead of metrics.Sample; a small wrapper adapts it:
+//
+//	type sample struct{ *bdigestmetrics.Sample }
+//
+//	func (s sample) Snapshot() metrics.Sample { return sample{s.Sample.Snapshot()} }
+//
+//	h := metrics.NewHistogram(sample{bdigestmetrics.NewSample(0.01)})
+//
+// Count, Min, Max, Sum and Mean are exact, while percentiles, variance
+// and standard deviation are estimated from the digest.
+package bdigestmetrics
+
+import (
+	"math"
+	"sync"
+
+	"pgregory.net/bdigest"
+)
+
+// Sample is a concurrency-safe sample of int64 values backed by a digest.
+// Negative values are counted as 0, since digests hold non-negative values.
+type Sample struct {
+	mu    sync.Mutex
+	d     *bdigest.Digest
+	count int64
+	sum   int64
+	min   int64
+	max   int64
+}
+
+// NewSample returns empty sample backed by digest with parameters
+// of bdigest.NewDigest.
+func NewSample(err float64, opts ...bdigest.Option) *Sample {
+	return &Sample{d: bdigest.NewDigest(err, opts...)}
+}
+
+// Clear resets the sample to the initial empty state.
+func (s *Sample) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.d.Reset()
+	s.count, s.sum, s.min, s.max = 0, 0, 0, 0
+}
+
+// Update adds value v to the sample.
+func (s *Sample) Update(v int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.count == 0 || v < s.min {
+		s.min = v
+	}
+	if s.count == 0 || v > s.max {
+		s.max = v
+	}
+	s.count++
+	s.sum += v
+	if v < 0 {
+		v = 0
+	}
+	s.d.Add(float64(v))
+}
+
+// Count returns the number of added values.
+func (s *Sample) Count() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.count
+}
+
+// Size returns the number of added values, like Count.
+func (s *Sample) Size() int {
+	return int(s.Count())
+}
+
+// Min returns the minimum of added values, or 0 if there are none.
+func (s *Sample) Min() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.min
+}
+
+// Max returns the maximum of added values, or 0 if there are none.
+func (s *Sample) Max() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.max
+}
+
+// Sum returns the sum of added values.
+func (s *Sample) Sum() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sum
+}
+
+// Mean returns the mean of added values, or 0 if there are none.
+func (s *Sample) Mean() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.count == 0 {
+		return 0
+	}
+	return float64(s.sum) / float64(s.count)
+}
+
+// Percentile returns the p-quantile of added values for p in [0, 1],
+// or 0 if there are none.
+func (s *Sample) Percentile(p float64) float64 {
+	return s.Percentiles([]float64{p})[0]
+}
+
+// Percentiles returns the p-quantiles of added values for each of ps
+// in [0, 1], or zeros if there are none.
+func (s *Sample) Percentiles(ps []float64) []float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.count == 0 {
+		return make([]float64, len(ps))
+	}
+	return s.d.Quantiles(ps...)
+}
+
+// Variance returns the estimate of the population variance of added
+// values, based on the values representing the digest buckets,
+// or 0 if there are none.
+func (s *Sample) Variance() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.count == 0 {
+		return 0
+	}
+	mean := s.d.Summary().Mean
+	v := 0.0
+	s.d.ForEachBucket(func(lo float64, hi float64, count uint64) bool {
+		x := 0.0
+		if hi > 0 {
+			x = 2 * lo * hi / (lo + hi)
+		}
+		v += float64(count) * (x - mean) * (x - mean)
+		return true
+	})
+
+	return v / float64(s.d.Count())
+}
+
+// StdDev returns the estimate of the population standard deviation
+// of added values, like Variance.
+func (s *Sample) StdDev() float64 {
+	return math.Sqrt(s.Variance())
+}
+
+// Values returns nil, since the digest does not keep the added values.
+func (s *Sample) Values() []int64 {
+	return nil
+}
+
+// Snapshot returns an independent copy of the sample.
+func (s *Sample) Snapshot() *Sample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return &Sample{
+		d:     s.d.Clone(),
+		count: s.count,
+		sum:   s.sum,
+		min:   s.min,
+		max:   s.max,
+	}
+}
+
+// Digest returns a copy of the digest of the sample,
+// for example to merge or to marshal it.
+func (s *Sample) Digest() *bdigest.Digest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.d.Clone()
+}
@@ -0,0 +1,107 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigestmetrics_test
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"pgregory.net/bdigest/bdigestmetrics"
+)
+
+// sample is the Sample interface of go-metrics, with Snapshot
+// returning the concrete type.
+type sample interface {
+	Clear()
+	Count() int64
+	Max() int64
+	Mean() float64
+	Min() int64
+	Percentile(float64) float64
+	Percentiles([]float64) []float64
+	Size() int
+	Snapshot() *bdigestmetrics.Sample
+	StdDev() float64
+	Sum() int64
+	Update(int64)
+	Values() []int64
+	Variance() float64
+}
+
+var _ sample = (*bdigestmetrics.Sample)(nil)
+
+func TestSample(t *testing.T) {
+	t.Parallel()
+
+	s := bdigestmetrics.NewSample(0.01)
+	if s.Count() != 0 || s.Percentile(0.5) != 0 || s.Variance() != 0 || s.Mean() != 0 {
+		t.Fatalf("empty sample has non-zero statistics")
+	}
+
+	r := rand.New(rand.NewSource(0))
+	values := make([]int64, 10000)
+	sum, min, max := int64(0), int64(math.MaxInt64), int64(0)
+	for i := range values {
+		v := int64(math.Exp(r.NormFloat64()+10) + 1)
+		values[i] = v
+		s.Update(v)
+		sum += v
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+	if s.Count() != int64(len(values)) || s.Size() != len(values) || s.Sum() != sum || s.Min() != min || s.Max() != max {
+		t.Fatalf("got count %v, sum %v, min %v and max %v", s.Count(), s.Sum(), s.Min(), s.Max())
+	}
+
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	for _, p := range []float64{0.5, 0.75, 0.99} {
+		exact := float64(values[int(p*float64(len(values)-1))])
+		if got := s.Percentile(p); math.Abs(got-exact) > 0.01*exact {
+			t.Fatalf("got p%v %v instead of %v", p, got, exact)
+		}
+	}
+
+	mean, variance := float64(sum)/float64(len(values)), 0.0
+	for _, v := range values {
+		variance += (float64(v) - mean) * (float64(v) - mean)
+	}
+	variance /= float64(len(values))
+	if math.Abs(s.Variance()-variance) > 0.03*variance {
+		t.Fatalf("got variance %v instead of %v", s.Variance(), variance)
+	}
+
+	snap := s.Snapshot()
+	s.Clear()
+	if s.Count() != 0 || snap.Count() != int64(len(values)) || snap.Digest().Count() != uint64(len(values)) {
+		t.Fatalf("snapshot is not independent of the sample")
+	}
+}
+
+func TestSample_Negative(t *testing.T) {
+	t.Parallel()
+
+	s := bdigestmetrics.NewSample(0.01)
+	s.Update(-5)
+	s.Update(5)
+	if s.Min() != -5 || s.Sum() != 0 || s.Percentile(0) != 0 {
+		t.Fatalf("got min %v, sum %v and p0 %v", s.Min(), s.Sum(), s.Percentile(0))
+	}
+}
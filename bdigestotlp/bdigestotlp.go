@@ -0,0 +1,406 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bdigestotlp exports the digests of a bdigest registry to an
+// OpenTelemetry collector as OTLP exponential histograms, using the
+// OTLP/HTTP transport with JSON encoding and only the standard library.
+// Collectors accepting only OTLP/gRPC need an OTLP/HTTP receiver enabled.
+//
+// Exponential histograms have buckets with bounds at the powers of
+// base 2^(2^-scale). Digests are converted with the largest scale whose
+// base is not less than the one of the digest buckets, with each digest
+// bucket counted in the exponential bucket holding its representative
+// value. As a result, the relative error of the exported histogram is
+// up to the sum of the one of the digest and (base-1)/(base+1).
+//
+// Registry keys of the form `name{label="value",other="value"}`
+// (see bdigeststatsd.Key) are exported as the metric name with data point
+// attributes; other keys are exported as metric names as is.
+package bdigestotlp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"pgregory.net/bdigest"
+)
+
+const (
+	// DefaultEndpoint is the default OTLP/HTTP metrics endpoint of a local collector.
+	DefaultEndpoint = "http://localhost:4318/v1/metrics"
+
+	minScale = -10
+	maxScale = 20
+)
+
+// Temporality selects whether exported data points hold the values added
+// since the previous export (Delta) or since the exporter start (Cumulative).
+type Temporality int
+
+const (
+	// Cumulative temporality makes exporter keep the digests,
+	// reporting all the values since the start of the exporter.
+	Cumulative Temporality = iota
+	// Delta temporality makes exporter reset the digests after every export,
+	// reporting only the values added since the previous export.
+	Delta
+)
+
+// Option configures the exporter.
+type Option func(*Exporter)
+
+// WithEndpoint sets the URL of the OTLP/HTTP metrics endpoint
+// (DefaultEndpoint by default).
+func WithEndpoint(url string) Option {
+	return func(e *Exporter) {
+		e.endpoint = url
+	}
+}
+
+// WithTemporality sets the temporality of exported data points
+// (Cumulative by default).
+func WithTemporality(t Temporality) Option {
+	if t != Cumulative && t != Delta {
+		panic(fmt.Sprintf("unknown temporality %v", t))
+	}
+
+	return func(e *Exporter) {
+		e.temporality = t
+	}
+}
+
+// WithResource sets the attributes of the exported resource,
+// like "service.name".
+func WithResource(attrs map[string]string) Option {
+	return func(e *Exporter) {
+		labels := make([]bdigest.Label, 0, len(attrs))
+		for k, v := range attrs {
+			labels = append(labels, bdigest.Label{Name: k, Value: v})
+		}
+		e.resource = attributes(labels)
+	}
+}
+
+// WithHeaders sets additional HTTP headers of export requests,
+// for example for authentication.
+func WithHeaders(headers map[string]string) Option {
+	return func(e *Exporter) {
+		e.headers = headers
+	}
+}
+
+// WithHTTPClient makes exporter use client instead of http.DefaultClient.
+func WithHTTPClient(client *http.Client) Option {
+	return func(e *Exporter) {
+		e.client = client
+	}
+}
+
+// Exporter periodically exports the digests of a registry.
+type Exporter struct {
+	mu          sync.Mutex
+	reg         *bdigest.Registry
+	endpoint    string
+	temporality Temporality
+	resource    []keyValue
+	headers     map[string]string
+	client      *http.Client
+	start       time.Time // of the exporter for Cumulative, of the period for Delta
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+// NewExporter returns exporter of the digests of registry reg, which
+// exports them every period (if it is positive). Errors of the periodic
+// exports are ignored. Call Stop to stop it.
+func NewExporter(reg *bdigest.Registry, period time.Duration, opts ...Option) *Exporter {
+	e := &Exporter{
+		reg:      reg,
+		endpoint: DefaultEndpoint,
+		client:   http.DefaultClient,
+		start:    time.Now(),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if period > 0 {
+		go e.run(period)
+	} else {
+		close(e.done)
+	}
+
+	return e
+}
+
+func (e *Exporter) run(period time.Duration) {
+	defer close(e.done)
+
+	t := time.NewTicker(period)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			ctx, cancel := context.WithTimeout(context.Background(), period)
+			_ = e.Export(ctx)
+			cancel()
+		case <-e.stop:
+			return
+		}
+	}
+}
+
+// Stop stops the periodic exports and exports the digests one last time.
+// Stop must not be called more than once.
+func (e *Exporter) Stop(ctx context.Context) error {
+	close(e.stop)
+	<-e.done
+
+	return e.Export(ctx)
+}
+
+// Export exports the digests of the registry, resetting them
+// for Delta temporality. If the export fails, the values reset
+// for Delta temporality are merged back into the digests,
+// to be exported by the next Export.
+func (e *Exporter) Export(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	metrics := map[string]*metric{}
+	var names []string
+	var reset []resetDigest
+	e.reg.Range(func(key string, c *bdigest.Concurrent) bool {
+		var d *bdigest.Digest
+		if e.temporality == Delta {
+			d = c.SnapshotAndReset()
+			if d.Count() == 0 {
+				return true
+			}
+			reset = append(reset, resetDigest{c, d})
+		} else {
+			d = c.Snapshot()
+		}
+
+		name, labels := bdigest.ParseKey(key)
+		m, ok := metrics[name]
+		if !ok {
+			m = &metric{Name: name}
+			m.ExponentialHistogram.AggregationTemporality = 2
+			if e.temporality == Delta {
+				m.ExponentialHistogram.AggregationTemporality = 1
+			}
+			metrics[name] = m
+			names = append(names, name)
+		}
+		p := convert(d)
+		p.Attributes = attributes(labels)
+		p.StartTimeUnixNano = uint64(e.start.UnixNano())
+		p.TimeUnixNano = uint64(now.UnixNano())
+		m.ExponentialHistogram.DataPoints = append(m.ExponentialHistogram.DataPoints, p)
+		return true
+	})
+	if len(names) == 0 {
+		if e.temporality == Delta {
+			e.start = now
+		}
+		return nil
+	}
+
+	req := exportRequest{ResourceMetrics: []resourceMetrics{{
+		Resource:     resource{Attributes: e.resource},
+		ScopeMetrics: []scopeMetrics{{Scope: scope{Name: "pgregory.net/bdigest"}}},
+	}}}
+	sort.Strings(names)
+	for _, name := range names {
+		sm := &req.ResourceMetrics[0].ScopeMetrics[0]
+		sm.Metrics = append(sm.Metrics, *metrics[name])
+	}
+
+	if err := e.send(ctx, req); err != nil {
+		for _, r := range reset {
+			_ = r.c.Merge(r.d) // same parameters
+		}
+		return err
+	}
+	if e.temporality == Delta {
+		e.start = now
+	}
+
+	return nil
+}
+
+// resetDigest is the content of c reset by Export.
+type resetDigest struct {
+	c *bdigest.Concurrent
+	d *bdigest.Digest
+}
+
+func (e *Exporter) send(ctx context.Context, req exportRequest) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		r.Header.Set(k, v)
+	}
+
+	resp, err := e.client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("export failed with status %v: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
+
+// Scale returns the scale of exponential histograms
+// digests with relative error err are exported with.
+func Scale(err float64) int {
+	gamma := (1 + err) / (1 - err)
+	s := int(math.Floor(-math.Log2(math.Log2(gamma))))
+	if s < minScale {
+		return minScale
+	}
+	if s > maxScale {
+		return maxScale
+	}
+	return s
+}
+
+// convert returns exponential histogram data point with the content of d.
+func convert(d *bdigest.Digest) dataPoint {
+	s := d.Summary()
+	p := dataPoint{
+		Count: s.Count,
+		Sum:   s.Sum,
+		Min:   s.Min,
+		Max:   s.Max,
+		Scale: Scale(d.RelativeError()),
+	}
+
+	var counts []uint64
+	factor := math.Ldexp(1, p.Scale)
+	d.ForEachBucket(func(lo float64, hi float64, count uint64) bool {
+		if hi == 0 {
+			p.ZeroCount += count
+			return true
+		}
+		v := 2 * lo * hi / (lo + hi)
+		i := int32(math.Ceil(math.Log2(v)*factor)) - 1
+		if len(counts) == 0 {
+			p.Positive.Offset = i
+		}
+		for int(i-p.Positive.Offset) >= len(counts) {
+			counts = append(counts, 0)
+		}
+		counts[i-p.Positive.Offset] += count
+		return true
+	})
+	p.Positive.BucketCounts = make([]string, len(counts))
+	for i, n := range counts {
+		p.Positive.BucketCounts[i] = strconv.FormatUint(n, 10)
+	}
+
+	return p
+}
+
+func attributes(labels []bdigest.Label) []keyValue {
+	attrs := make([]keyValue, 0, len(labels))
+	for _, l := range labels {
+		attrs = append(attrs, keyValue{Key: l.Name, Value: anyValue{StringValue: l.Value}})
+	}
+	sort.Slice(attrs, func(i, j int) bool { return attrs[i].Key < attrs[j].Key })
+	return attrs
+}
+
+// The types below mirror the OTLP protobuf messages in their JSON encoding,
+// with 64-bit integers encoded as strings.
+
+type exportRequest struct {
+	ResourceMetrics []resourceMetrics `json:"resourceMetrics"`
+}
+
+type resourceMetrics struct {
+	Resource     resource       `json:"resource"`
+	ScopeMetrics []scopeMetrics `json:"scopeMetrics"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scopeMetrics struct {
+	Scope   scope    `json:"scope"`
+	Metrics []metric `json:"metrics"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type metric struct {
+	Name                 string `json:"name"`
+	ExponentialHistogram struct {
+		DataPoints             []dataPoint `json:"dataPoints"`
+		AggregationTemporality int         `json:"aggregationTemporality"`
+	} `json:"exponentialHistogram"`
+}
+
+type dataPoint struct {
+	Attributes        []keyValue `json:"attributes"`
+	StartTimeUnixNano uint64     `json:"startTimeUnixNano,string"`
+	TimeUnixNano      uint64     `json:"timeUnixNano,string"`
+	Count             uint64     `json:"count,string"`
+	Sum               float64    `json:"sum"`
+	Scale             int        `json:"scale"`
+	ZeroCount         uint64     `json:"zeroCount,string"`
+	Positive          buckets    `json:"positive"`
+	Min               float64    `json:"min"`
+	Max               float64    `json:"max"`
+}
+
+type buckets struct {
+	Offset       int32    `json:"offset"`
+	BucketCounts []string `json:"bucketCounts"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue string `json:"stringValue"`
+}
@@ -0,0 +1,201 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigestotlp_test
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/bdigest/bdigestotlp"
+)
+
+type collector struct {
+	mu       sync.Mutex
+	requests []map[string]interface{}
+	headers  []http.Header
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests = append(c.requests, req)
+	c.headers = append(c.headers, r.Header)
+}
+
+// path returns the value at path of keys and indices in v.
+func path(v interface{}, p ...interface{}) interface{} {
+	for _, k := range p {
+		switch k := k.(type) {
+		case string:
+			v = v.(map[string]interface{})[k]
+		case int:
+			v = v.([]interface{})[k]
+		}
+	}
+	return v
+}
+
+func TestExporter(t *testing.T) {
+	t.Parallel()
+
+	c := &collector{}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	reg := bdigest.NewRegistry(0.01)
+	for i := 0; i < 1000; i++ {
+		reg.GetOrCreate(`latency{route="/a"}`).Add(float64(i % 100))
+	}
+	e := bdigestotlp.NewExporter(reg, 0,
+		bdigestotlp.WithEndpoint(srv.URL),
+		bdigestotlp.WithTemporality(bdigestotlp.Delta),
+		bdigestotlp.WithResource(map[string]string{"service.name": "test"}),
+		bdigestotlp.WithHeaders(map[string]string{"Authorization": "secret"}))
+	if err := e.Export(context.Background()); err != nil {
+		t.Fatalf("failed to export: %v", err)
+	}
+	if err := e.Stop(context.Background()); err != nil {
+		t.Fatalf("failed to stop: %v", err)
+	}
+	if len(c.requests) != 1 {
+		t.Fatalf("got %v requests instead of 1 after reset", len(c.requests))
+	}
+	if h := c.headers[0].Get("Authorization"); h != "secret" {
+		t.Fatalf("got authorization header %q", h)
+	}
+
+	rm := path(c.requests[0], "resourceMetrics", 0)
+	if v := path(rm, "resource", "attributes", 0, "value", "stringValue"); v != "test" {
+		t.Fatalf("got service name %v", v)
+	}
+	m := path(rm, "scopeMetrics", 0, "metrics", 0)
+	if name := path(m, "name"); name != "latency" {
+		t.Fatalf("got metric name %v", name)
+	}
+	if tm := path(m, "exponentialHistogram", "aggregationTemporality"); tm != 1.0 {
+		t.Fatalf("got temporality %v", tm)
+	}
+	p := path(m, "exponentialHistogram", "dataPoints", 0)
+	if route := path(p, "attributes", 0, "value", "stringValue"); route != "/a" {
+		t.Fatalf("got route %v", route)
+	}
+
+	scale := path(p, "scale").(float64)
+	if int(scale) != bdigestotlp.Scale(0.01) {
+		t.Fatalf("got scale %v", scale)
+	}
+	count, _ := strconv.ParseUint(path(p, "count").(string), 10, 64)
+	zero, _ := strconv.ParseUint(path(p, "zeroCount").(string), 10, 64)
+	counts := path(p, "positive", "bucketCounts").([]interface{})
+	sum := zero
+	for _, n := range counts {
+		k, _ := strconv.ParseUint(n.(string), 10, 64)
+		sum += k
+	}
+	if count != 1000 || zero != 10 || sum != count {
+		t.Fatalf("got count %v, zero count %v and bucket counts sum %v", count, zero, sum)
+	}
+
+	base := math.Pow(2, math.Pow(2, -scale))
+	top := path(p, "positive", "offset").(float64) + float64(len(counts)) - 1
+	lo, hi := math.Pow(base, top), math.Pow(base, top+1)
+	if v := 2 * lo * hi / (lo + hi); math.Abs(v-99)/99 > 0.01+(base-1)/(base+1) {
+		t.Fatalf("maximum 99 is estimated as %v", v)
+	}
+}
+
+func TestExporter_Error(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	reg := bdigest.NewRegistry(0.01)
+	reg.GetOrCreate("latency").Add(1)
+	e := bdigestotlp.NewExporter(reg, 0, bdigestotlp.WithEndpoint(srv.URL))
+	if err := e.Export(context.Background()); err == nil {
+		t.Fatalf("export succeeded with failing collector")
+	}
+	if c, _ := reg.Get("latency"); c.Count() != 1 {
+		t.Fatalf("cumulative export reset the digest")
+	}
+}
+
+func TestExporter_DeltaError(t *testing.T) {
+	t.Parallel()
+
+	fail := true
+	c := &collector{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		c.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	reg := bdigest.NewRegistry(0.01)
+	reg.GetOrCreate("latency").AddValues(1, 2, 3)
+	e := bdigestotlp.NewExporter(reg, 0,
+		bdigestotlp.WithEndpoint(srv.URL),
+		bdigestotlp.WithTemporality(bdigestotlp.Delta))
+	if err := e.Export(context.Background()); err == nil {
+		t.Fatalf("export succeeded with failing collector")
+	}
+	if c, _ := reg.Get("latency"); c.Count() != 3 {
+		t.Fatalf("failed delta export left %v values instead of 3", c.Count())
+	}
+
+	reg.GetOrCreate("latency").Add(4)
+	fail = false
+	if err := e.Export(context.Background()); err != nil {
+		t.Fatalf("failed to export: %v", err)
+	}
+	p := path(c.requests[0], "resourceMetrics", 0, "scopeMetrics", 0, "metrics", 0, "exponentialHistogram", "dataPoints", 0)
+	if count := path(p, "count"); count != "4" {
+		t.Fatalf("exported %v values instead of 4", count)
+	}
+	if c, _ := reg.Get("latency"); c.Count() != 0 {
+		t.Fatalf("delta export did not reset the digest")
+	}
+}
+
+func TestScale(t *testing.T) {
+	t.Parallel()
+
+	for _, err := range []float64{0.001, 0.01, 0.05, 0.5} {
+		s := bdigestotlp.Scale(err)
+		gamma := (1 + err) / (1 - err)
+		if base := math.Pow(2, math.Pow(2, -float64(s))); base < gamma || math.Sqrt(base) >= gamma && s < 20 {
+			t.Fatalf("got scale %v with base %v for gamma %v", s, base, gamma)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bdigestplot renders the distributions tracked by bdigest
+// digests as charts in SVG or PNG format, using only the standard library.
+//
+// Charts show the histogram of the digest (with logarithmic value axis,
+// on which its buckets are evenly sized), the cumulative distribution
+// function and the marked quantiles. Zero values can not be shown
+// on the logarithmic axis, so they are only accounted for in the
+// cumulative distribution function, which starts at their fraction.
+package bdigestplot
+
+import (
+	"math"
+	"strconv"
+
+	"pgregory.net/bdigest"
+)
+
+const (
+	margin = 40
+)
+
+// Option configures the rendered chart.
+type Option func(*options)
+
+type options struct {
+	width  int
+	height int
+	qs     []float64
+}
+
+// Size sets the size of the chart in pixels (640x400 by default).
+//
+// Size panics if width or height is not greater than 2*40,
+// the size of the chart margins.
+func Size(width int, height int) Option {
+	if width <= 2*margin || height <= 2*margin {
+		panic("width and height must be greater than the margins")
+	}
+
+	return func(o *options) {
+		o.width = width
+		o.height = height
+	}
+}
+
+// Quantiles sets the quantiles marked on the chart
+// (0.5, 0.9 and 0.99 by default).
+//
+// Quantiles panics if any of qs is outside [0, 1].
+func Quantiles(qs ...float64) Option {
+	for _, q := range qs {
+		if math.IsNaN(q) || q < 0 || q > 1 {
+			panic("q must be in [0, 1]")
+		}
+	}
+
+	return func(o *options) {
+		o.qs = qs
+	}
+}
+
+// chart is the geometry of the chart, shared by the renderers.
+type chart struct {
+	width     int
+	height    int
+	bars      []bar
+	cdf       []point
+	quantiles []mark
+	lo, hi    float64 // range of the value axis
+}
+
+type bar struct {
+	x0, x1, y float64
+	lo, hi    float64
+	count     uint64
+}
+
+type point struct {
+	x, y float64
+}
+
+type mark struct {
+	x     float64
+	q, v  float64
+	label string
+}
+
+func newChart(d *bdigest.Digest, opts []Option) *chart {
+	o := options{width: 640, height: 400, qs: []float64{0.5, 0.9, 0.99}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	type bucket struct {
+		lo, hi float64
+		n      uint64
+	}
+	var (
+		buckets []bucket
+		zeros   uint64
+		top     uint64
+	)
+	d.ForEachBucket(func(lo float64, hi float64, n uint64) bool {
+		if hi == 0 {
+			zeros = n
+			return true
+		}
+		buckets = append(buckets, bucket{lo, hi, n})
+		if n > top {
+			top = n
+		}
+		return true
+	})
+
+	c := &chart{width: o.width, height: o.height}
+	if len(buckets) == 0 {
+		return c
+	}
+
+	c.lo, c.hi = buckets[0].lo, buckets[len(buckets)-1].hi
+	if c.lo <= 0 {
+		c.lo = buckets[0].hi / 2
+	}
+	left, right := float64(margin), float64(o.width-margin)
+	top0, bottom := float64(margin), float64(o.height-margin)
+	x := func(v float64) float64 {
+		if v <= c.lo {
+			return left
+		}
+		return left + (right-left)*math.Log(v/c.lo)/math.Log(c.hi/c.lo)
+	}
+	y := func(f float64) float64 {
+		return bottom - (bottom-top0)*f
+	}
+
+	total := float64(d.Count())
+	cum := float64(zeros)
+	c.cdf = append(c.cdf, point{left, y(cum / total)})
+	for _, b := range buckets {
+		c.bars = append(c.bars, bar{
+			x0:    x(b.lo),
+			x1:    x(b.hi),
+			y:     y(float64(b.n) / float64(top)),
+			lo:    b.lo,
+			hi:    b.hi,
+			count: b.n,
+		})
+		cum += float64(b.n)
+		c.cdf = append(c.cdf, point{x(b.hi), y(cum / total)})
+	}
+	for i, v := range d.Quantiles(o.qs...) {
+		q := o.qs[i]
+		c.quantiles = append(c.quantiles, mark{x: x(v), q: q, v: v, label: label(q)})
+	}
+
+	return c
+}
+
+// label returns the short name of q-quantile, like p99.9.
+func label(q float64) string {
+	return "p" + strconv.FormatFloat(q*100, 'g', 6, 64)
+}
@@ -0,0 +1,137 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigestplot_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"image/png"
+	"io"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/bdigest/bdigestplot"
+	"pgregory.net/rapid"
+)
+
+func logNormalDigest(relErr float64, seed int64, count int, zeros int) *bdigest.Digest {
+	r := rand.New(rand.NewSource(seed))
+	vs := make([]float64, count, count+zeros)
+	for i := range vs {
+		vs[i] = math.Exp(r.NormFloat64())
+	}
+	vs = append(vs, make([]float64, zeros)...)
+
+	return bdigest.FromValues(relErr, vs...)
+}
+
+func TestSVG(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			seed   = rapid.Int64().Draw(t, "seed")
+			count  = rapid.IntRange(0, 1000).Draw(t, "count")
+			zeros  = rapid.IntRange(0, 10).Draw(t, "zeros")
+			qs     = rapid.SliceOfN(rapid.Float64Range(0, 1), 0, 5).Draw(t, "quantiles")
+		)
+
+		d := logNormalDigest(relErr, seed, count, zeros)
+		var buf bytes.Buffer
+		if err := bdigestplot.SVG(&buf, d, bdigestplot.Quantiles(qs...)); err != nil {
+			t.Fatalf("failed to render SVG: %v", err)
+		}
+
+		rects, lines := 0, 0
+		dec := xml.NewDecoder(&buf)
+		for {
+			tok, err := dec.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("invalid SVG: %v", err)
+			}
+			if e, ok := tok.(xml.StartElement); ok {
+				switch e.Name.Local {
+				case "rect":
+					rects++
+				case "line":
+					lines++
+				}
+			}
+		}
+
+		buckets := 0
+		d.ForEachBucket(func(lo float64, hi float64, n uint64) bool {
+			if hi > 0 {
+				buckets++
+			}
+			return true
+		})
+		if want := buckets + 1; rects != want {
+			t.Fatalf("got %v rectangles instead of %v", rects, want)
+		}
+		if want := 2 + len(qs); count > 0 && lines != want {
+			t.Fatalf("got %v lines instead of %v", lines, want)
+		}
+	})
+}
+
+func TestPNG(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			seed   = rapid.Int64().Draw(t, "seed")
+			count  = rapid.IntRange(0, 1000).Draw(t, "count")
+			width  = rapid.IntRange(81, 1000).Draw(t, "width")
+			height = rapid.IntRange(81, 1000).Draw(t, "height")
+		)
+
+		d := logNormalDigest(relErr, seed, count, 0)
+		var buf bytes.Buffer
+		if err := bdigestplot.PNG(&buf, d, bdigestplot.Size(width, height)); err != nil {
+			t.Fatalf("failed to render PNG: %v", err)
+		}
+		img, err := png.Decode(&buf)
+		if err != nil {
+			t.Fatalf("invalid PNG: %v", err)
+		}
+		if b := img.Bounds(); b.Dx() != width || b.Dy() != height {
+			t.Fatalf("image size is %vx%v instead of %vx%v", b.Dx(), b.Dy(), width, height)
+		}
+	})
+}
+
+func TestSVG_AbsoluteDigest(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	d := bdigest.NewAbsoluteDigest(1, 0, 100)
+	d.Add(1)
+	d.Add(10)
+	if err := bdigestplot.SVG(&buf, d); err != nil {
+		t.Fatalf("failed to render SVG: %v", err)
+	}
+	if s := buf.String(); strings.Contains(s, "NaN") || strings.Contains(s, "Inf") {
+		t.Fatalf("invalid coordinates in SVG %q", s)
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigestplot
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+
+	"pgregory.net/bdigest"
+)
+
+var (
+	barRGBA      = color.RGBA{0x4c, 0x72, 0xb0, 0xff}
+	cdfRGBA      = color.RGBA{0xdd, 0x84, 0x52, 0xff}
+	quantileRGBA = color.RGBA{0x55, 0x55, 0x55, 0xff}
+)
+
+// PNG writes the chart of the distribution of values of d to w as PNG.
+// Unlike SVG, PNG charts have no text labels.
+func PNG(w io.Writer, d *bdigest.Digest, opts ...Option) error {
+	c := newChart(d, opts)
+	left, right := float64(margin), float64(c.width-margin)
+	top, bottom := float64(margin), float64(c.height-margin)
+
+	img := image.NewRGBA(image.Rect(0, 0, c.width, c.height))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	for _, r := range c.bars {
+		x0, x1 := int(math.Round(r.x0)), int(math.Round(r.x1))
+		if x1 == x0 {
+			x1++
+		}
+		rect := image.Rect(x0, int(math.Round(r.y)), x1, int(bottom))
+		draw.Draw(img, rect, image.NewUniform(barRGBA), image.Point{}, draw.Src)
+	}
+	for i := 1; i < len(c.cdf); i++ {
+		line(img, c.cdf[i-1], c.cdf[i], cdfRGBA, 0)
+	}
+	for _, m := range c.quantiles {
+		line(img, point{m.x, top}, point{m.x, bottom}, quantileRGBA, 4)
+	}
+	line(img, point{left, bottom}, point{right, bottom}, color.Black, 0)
+	line(img, point{right, top}, point{right, bottom}, color.Black, 0)
+
+	return png.Encode(w, img)
+}
+
+// line draws a line from a to b, dashed with dashes of the given
+// length in pixels if dash is not zero.
+func line(img *image.RGBA, a point, b point, c color.Color, dash int) {
+	n := int(math.Ceil(math.Max(math.Abs(b.x-a.x), math.Abs(b.y-a.y))))
+	for i := 0; i <= n; i++ {
+		if dash > 0 && (i/dash)%2 == 1 {
+			continue
+		}
+		t := 0.0
+		if n > 0 {
+			t = float64(i) / float64(n)
+		}
+		img.Set(int(math.Round(a.x+t*(b.x-a.x))), int(math.Round(a.y+t*(b.y-a.y))), c)
+	}
+}
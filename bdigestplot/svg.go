@@ -0,0 +1,76 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigestplot
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"pgregory.net/bdigest"
+)
+
+const (
+	barColor      = "#4c72b0"
+	cdfColor      = "#dd8452"
+	quantileColor = "#555555"
+	axisColor     = "#000000"
+)
+
+// SVG writes the chart of the distribution of values of d to w as SVG.
+// Histogram buckets have tooltips with their ranges and counts.
+func SVG(w io.Writer, d *bdigest.Digest, opts ...Option) error {
+	c := newChart(d, opts)
+	left, right := float64(margin), float64(c.width-margin)
+	top, bottom := float64(margin), float64(c.height-margin)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif" font-size="10">`+"\n", c.width, c.height, c.width, c.height)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="white"/>`+"\n", c.width, c.height)
+	for _, r := range c.bars {
+		fmt.Fprintf(&b, `<rect x="%.2f" y="%.2f" width="%.2f" height="%.2f" fill="%s"><title>(%.4g, %.4g]: %d</title></rect>`+"\n",
+			r.x0, r.y, r.x1-r.x0, bottom-r.y, barColor, r.lo, r.hi, r.count)
+	}
+	if len(c.cdf) > 0 {
+		points := make([]string, len(c.cdf))
+		for i, p := range c.cdf {
+			points[i] = fmt.Sprintf("%.2f,%.2f", p.x, p.y)
+		}
+		fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="2"/>`+"\n", strings.Join(points, " "), cdfColor)
+	}
+	for i, m := range c.quantiles {
+		fmt.Fprintf(&b, `<line x1="%.2f" y1="%.2f" x2="%.2f" y2="%.2f" stroke="%s" stroke-dasharray="4 2"/>`+"\n", m.x, top, m.x, bottom, quantileColor)
+		fmt.Fprintf(&b, `<text x="%.2f" y="%.2f" fill="%s">%s=%.4g</text>`+"\n", m.x+2, top+10*float64(i+1), quantileColor, m.label, m.v)
+	}
+
+	fmt.Fprintf(&b, `<line x1="%.2f" y1="%.2f" x2="%.2f" y2="%.2f" stroke="%s"/>`+"\n", left, bottom, right, bottom, axisColor)
+	fmt.Fprintf(&b, `<line x1="%.2f" y1="%.2f" x2="%.2f" y2="%.2f" stroke="%s"/>`+"\n", right, top, right, bottom, axisColor)
+	if len(c.bars) > 0 {
+		fmt.Fprintf(&b, `<text x="%.2f" y="%.2f" text-anchor="start">%.4g</text>`+"\n", left, bottom+15, c.lo)
+		fmt.Fprintf(&b, `<text x="%.2f" y="%.2f" text-anchor="end">%.4g</text>`+"\n", right, bottom+15, c.hi)
+	}
+	fmt.Fprintf(&b, `<text x="%.2f" y="%.2f" fill="%s">0</text>`+"\n", right+4, bottom, cdfColor)
+	fmt.Fprintf(&b, `<text x="%.2f" y="%.2f" fill="%s">1</text>`+"\n", right+4, top+4, cdfColor)
+	fmt.Fprintf(&b, `<text x="%.2f" y="%.2f">%s</text>`+"\n", left, top-10, escape(d.String()))
+	b.WriteString("</svg>\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// escape escapes the text for use in SVG.
+func escape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
@@ -0,0 +1,189 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bdigestsql instruments database/sql drivers, recording
+// the durations of queries, executions and transactions into the digests
+// of a bdigest registry, by statement label:
+//
+//	sql.Register("postgres-timed", bdigestsql.Wrap(&pq.Driver{}, reg))
+//	db, err := sql.Open("postgres-timed", dsn)
+//
+// or, with a driver.Connector:
+//
+//	db := sql.OpenDB(bdigestsql.WrapConnector(connector, reg))
+//
+// Durations are recorded in seconds into the digests with keys of the form
+// `name{op="query",statement="GetUser"}`, where op is one of "query",
+// "exec", "prepare", "begin", "commit" and "rollback". Queries are timed
+// until the driver returns the rows, without reading them.
+package bdigestsql
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"time"
+
+	"pgregory.net/bdigest"
+)
+
+// DefaultMetricName is the default name of operation duration digests.
+const DefaultMetricName = "sql_duration_seconds"
+
+// Option configures the instrumentation.
+type Option func(*recorder)
+
+// WithMetricName sets the metric name of the digest keys
+// (DefaultMetricName by default).
+func WithMetricName(name string) Option {
+	return func(r *recorder) {
+		r.name = name
+	}
+}
+
+// WithLabel sets the function returning the label of the statement to
+// record its durations under (Label by default). Labels must take
+// a bounded number of values, since there is a digest for each of them.
+func WithLabel(label func(query string) string) Option {
+	return func(r *recorder) {
+		r.label = label
+	}
+}
+
+// Label returns the name of the query given by a leading comment
+// of the form "-- name: GetUser" (as generated by sqlc), or the
+// upper-cased first word of the query, like "SELECT", otherwise.
+func Label(query string) string {
+	query = strings.TrimSpace(query)
+	if strings.HasPrefix(query, "--") {
+		line := query[2:]
+		if i := strings.IndexByte(line, '\n'); i >= 0 {
+			line = line[:i]
+		}
+		if f := strings.Fields(line); len(f) >= 2 && f[0] == "name:" {
+			return f[1]
+		}
+		if i := strings.IndexByte(query, '\n'); i >= 0 {
+			return Label(query[i+1:])
+		}
+		return ""
+	}
+
+	if i := strings.IndexFunc(query, func(r rune) bool { return r == ' ' || r == '\t' || r == '\n' || r == '(' || r == ';' }); i >= 0 {
+		query = query[:i]
+	}
+	return strings.ToUpper(query)
+}
+
+type recorder struct {
+	reg   *bdigest.Registry
+	name  string
+	label func(query string) string
+}
+
+func newRecorder(reg *bdigest.Registry, opts []Option) *recorder {
+	r := &recorder{
+		reg:   reg,
+		name:  DefaultMetricName,
+		label: Label,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// record records duration of operation op since start, unless it was
+// skipped by the driver.
+func (r *recorder) record(op string, statement string, start time.Time, err error) {
+	if err == driver.ErrSkip {
+		return
+	}
+	d := time.Since(start).Seconds()
+	if d < 0 {
+		d = 0
+	}
+
+	key := bdigest.FormatKey(r.name, bdigest.Label{Name: "op", Value: op}, bdigest.Label{Name: "statement", Value: statement})
+	r.reg.GetOrCreate(key).Add(d)
+}
+
+// Wrap returns driver d instrumented to record operation durations
+// into registry reg.
+func Wrap(d driver.Driver, reg *bdigest.Registry, opts ...Option) driver.Driver {
+	return &wrappedDriver{d: d, rec: newRecorder(reg, opts)}
+}
+
+// WrapConnector returns connector c instrumented to record operation
+// durations into registry reg.
+func WrapConnector(c driver.Connector, reg *bdigest.Registry, opts ...Option) driver.Connector {
+	rec := newRecorder(reg, opts)
+	return &wrappedConnector{c: c, d: &wrappedDriver{d: c.Driver(), rec: rec}}
+}
+
+type wrappedDriver struct {
+	d   driver.Driver
+	rec *recorder
+}
+
+func (d *wrappedDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.d.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{c: c, rec: d.rec}, nil
+}
+
+func (d *wrappedDriver) OpenConnector(name string) (driver.Connector, error) {
+	if dc, ok := d.d.(driver.DriverContext); ok {
+		c, err := dc.OpenConnector(name)
+		if err != nil {
+			return nil, err
+		}
+		return &wrappedConnector{c: c, d: d}, nil
+	}
+	return &dsnConnector{name: name, d: d}, nil
+}
+
+type wrappedConnector struct {
+	c driver.Connector
+	d *wrappedDriver
+}
+
+func (c *wrappedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	cn, err := c.c.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{c: cn, rec: c.d.rec}, nil
+}
+
+func (c *wrappedConnector) Driver() driver.Driver {
+	return c.d
+}
+
+// dsnConnector is the connector of drivers not implementing driver.DriverContext.
+type dsnConnector struct {
+	name string
+	d    *wrappedDriver
+}
+
+func (c *dsnConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.d.Open(c.name)
+}
+
+func (c *dsnConnector) Driver() driver.Driver {
+	return c.d
+}
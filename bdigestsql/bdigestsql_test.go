@@ -0,0 +1,145 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigestsql_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"testing"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/bdigest/bdigestsql"
+)
+
+// fakeDriver is a driver implementing only the required interfaces.
+type fakeDriver struct{}
+
+type fakeConn struct{}
+
+type fakeStmt struct{}
+
+type fakeTx struct{}
+
+type fakeRows struct{ n int }
+
+func (fakeDriver) Open(name string) (driver.Conn, error) { return fakeConn{}, nil }
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{}, nil }
+func (fakeConn) Close() error                              { return nil }
+func (fakeConn) Begin() (driver.Tx, error)                 { return fakeTx{}, nil }
+
+func (fakeStmt) Close() error                                    { return nil }
+func (fakeStmt) NumInput() int                                   { return -1 }
+func (fakeStmt) Exec(args []driver.Value) (driver.Result, error) { return driver.RowsAffected(1), nil }
+func (fakeStmt) Query(args []driver.Value) (driver.Rows, error)  { return &fakeRows{}, nil }
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+func (r *fakeRows) Columns() []string { return []string{"v"} }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.n > 0 {
+		return io.EOF
+	}
+	r.n++
+	dest[0] = int64(42)
+	return nil
+}
+
+func TestLabel(t *testing.T) {
+	t.Parallel()
+
+	for query, label := range map[string]string{
+		"select * from users":                          "SELECT",
+		"  INSERT INTO users VALUES (1)":               "INSERT",
+		"-- name: GetUser :one\nSELECT * FROM users":   "GetUser",
+		"-- fetch everything\nselect(1)":               "SELECT",
+		"WITH x AS (SELECT 1) SELECT * FROM x":         "WITH",
+		"-- name: ListUsers :many\r\nSELECT * FROM us": "ListUsers",
+	} {
+		if got := bdigestsql.Label(query); got != label {
+			t.Fatalf("got label %q instead of %q for %q", got, label, query)
+		}
+	}
+}
+
+func TestWrap(t *testing.T) {
+	t.Parallel()
+
+	reg := bdigest.NewRegistry(0.01)
+	sql.Register("bdigestsql-fake", bdigestsql.Wrap(fakeDriver{}, reg))
+	db, err := sql.Open("bdigestsql-fake", "")
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	defer db.Close()
+
+	var v int
+	if err := db.QueryRow("-- name: GetAnswer\nSELECT 42").Scan(&v); err != nil || v != 42 {
+		t.Fatalf("got %v: %v", v, err)
+	}
+	if _, err := db.Exec("UPDATE answers SET v = ?", 42); err != nil {
+		t.Fatalf("failed to exec: %v", err)
+	}
+	tx, err := db.BeginTx(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("failed to begin: %v", err)
+	}
+	if _, err := tx.Exec("DELETE FROM answers"); err != nil {
+		t.Fatalf("failed to exec in transaction: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	for key, n := range map[string]uint64{
+		`sql_duration_seconds{op="prepare",statement="GetAnswer"}`: 1,
+		`sql_duration_seconds{op="query",statement="GetAnswer"}`:   1,
+		`sql_duration_seconds{op="prepare",statement="UPDATE"}`:    1,
+		`sql_duration_seconds{op="exec",statement="UPDATE"}`:       1,
+		`sql_duration_seconds{op="exec",statement="DELETE"}`:       1,
+		`sql_duration_seconds{op="begin",statement=""}`:            1,
+		`sql_duration_seconds{op="commit",statement=""}`:           1,
+	} {
+		if c, ok := reg.Get(key); !ok || c.Count() != n {
+			t.Fatalf("digest %q is missing or has wrong count", key)
+		}
+	}
+}
+
+type fakeConnector struct{}
+
+func (fakeConnector) Connect(ctx context.Context) (driver.Conn, error) { return fakeConn{}, nil }
+func (fakeConnector) Driver() driver.Driver                            { return fakeDriver{} }
+
+func TestWrapConnector(t *testing.T) {
+	t.Parallel()
+
+	reg := bdigest.NewRegistry(0.01)
+	db := sql.OpenDB(bdigestsql.WrapConnector(fakeConnector{}, reg,
+		bdigestsql.WithMetricName("db"),
+		bdigestsql.WithLabel(func(query string) string { return "q" })))
+	defer db.Close()
+
+	if _, err := db.Exec("UPDATE answers SET v = 1"); err != nil {
+		t.Fatalf("failed to exec: %v", err)
+	}
+	if _, ok := reg.Get(`db{op="exec",statement="q"}`); !ok {
+		t.Fatalf("digest with custom label is missing")
+	}
+}
@@ -0,0 +1,268 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigestsql
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"time"
+)
+
+// conn wraps driver.Conn, implementing all the optional interfaces
+// and falling back to the behavior of database/sql for the ones
+// the wrapped connection does not implement.
+type conn struct {
+	c   driver.Conn
+	rec *recorder
+}
+
+var (
+	_ driver.ConnPrepareContext = (*conn)(nil)
+	_ driver.ConnBeginTx        = (*conn)(nil)
+	_ driver.ExecerContext      = (*conn)(nil)
+	_ driver.QueryerContext     = (*conn)(nil)
+	_ driver.Pinger             = (*conn)(nil)
+	_ driver.SessionResetter    = (*conn)(nil)
+	_ driver.Validator          = (*conn)(nil)
+	_ driver.NamedValueChecker  = (*conn)(nil)
+)
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	label := c.rec.label(query)
+	start := time.Now()
+	var s driver.Stmt
+	var err error
+	if cp, ok := c.c.(driver.ConnPrepareContext); ok {
+		s, err = cp.PrepareContext(ctx, query)
+	} else {
+		s, err = c.c.Prepare(query)
+	}
+	c.rec.record("prepare", label, start, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stmt{s: s, label: label, rec: c.rec}, nil
+}
+
+func (c *conn) Close() error {
+	return c.c.Close()
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	start := time.Now()
+	var t driver.Tx
+	var err error
+	if cb, ok := c.c.(driver.ConnBeginTx); ok {
+		t, err = cb.BeginTx(ctx, opts)
+	} else {
+		switch {
+		case opts.Isolation != 0:
+			return nil, errors.New("sql: driver does not support non-default isolation level")
+		case opts.ReadOnly:
+			return nil, errors.New("sql: driver does not support read-only transactions")
+		}
+		t, err = c.c.Begin()
+	}
+	c.rec.record("begin", "", start, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tx{t: t, rec: c.rec}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	start := time.Now()
+	var res driver.Result
+	var err error
+	switch e := c.c.(type) {
+	case driver.ExecerContext:
+		res, err = e.ExecContext(ctx, query, args)
+	case driver.Execer:
+		var vs []driver.Value
+		if vs, err = values(args); err == nil {
+			res, err = e.Exec(query, vs)
+		}
+	default:
+		return nil, driver.ErrSkip
+	}
+	c.rec.record("exec", c.rec.label(query), start, err)
+
+	return res, err
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	start := time.Now()
+	var rows driver.Rows
+	var err error
+	switch q := c.c.(type) {
+	case driver.QueryerContext:
+		rows, err = q.QueryContext(ctx, query, args)
+	case driver.Queryer:
+		var vs []driver.Value
+		if vs, err = values(args); err == nil {
+			rows, err = q.Query(query, vs)
+		}
+	default:
+		return nil, driver.ErrSkip
+	}
+	c.rec.record("query", c.rec.label(query), start, err)
+
+	return rows, err
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	if p, ok := c.c.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *conn) ResetSession(ctx context.Context) error {
+	if r, ok := c.c.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *conn) IsValid() bool {
+	if v, ok := c.c.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := c.c.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+type stmt struct {
+	s     driver.Stmt
+	label string
+	rec   *recorder
+}
+
+var (
+	_ driver.StmtExecContext   = (*stmt)(nil)
+	_ driver.StmtQueryContext  = (*stmt)(nil)
+	_ driver.NamedValueChecker = (*stmt)(nil)
+)
+
+func (s *stmt) Close() error {
+	return s.s.Close()
+}
+
+func (s *stmt) NumInput() int {
+	return s.s.NumInput()
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	start := time.Now()
+	res, err := s.s.Exec(args)
+	s.rec.record("exec", s.label, start, err)
+	return res, err
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	start := time.Now()
+	rows, err := s.s.Query(args)
+	s.rec.record("query", s.label, start, err)
+	return rows, err
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := s.s.(driver.StmtExecContext)
+	if !ok {
+		vs, err := values(args)
+		if err != nil {
+			return nil, err
+		}
+		return s.Exec(vs)
+	}
+
+	start := time.Now()
+	res, err := e.ExecContext(ctx, args)
+	s.rec.record("exec", s.label, start, err)
+	return res, err
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := s.s.(driver.StmtQueryContext)
+	if !ok {
+		vs, err := values(args)
+		if err != nil {
+			return nil, err
+		}
+		return s.Query(vs)
+	}
+
+	start := time.Now()
+	rows, err := q.QueryContext(ctx, args)
+	s.rec.record("query", s.label, start, err)
+	return rows, err
+}
+
+func (s *stmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := s.s.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+type tx struct {
+	t   driver.Tx
+	rec *recorder
+}
+
+func (t *tx) Commit() error {
+	start := time.Now()
+	err := t.t.Commit()
+	t.rec.record("commit", "", start, err)
+	return err
+}
+
+func (t *tx) Rollback() error {
+	start := time.Now()
+	err := t.t.Rollback()
+	t.rec.record("rollback", "", start, err)
+	return err
+}
+
+// values converts named values to positional ones, like database/sql
+// does for drivers not supporting the named values.
+func values(named []driver.NamedValue) ([]driver.Value, error) {
+	vs := make([]driver.Value, len(named))
+	for i, nv := range named {
+		if nv.Name != "" {
+			return nil, errors.New("sql: driver does not support the use of Named Parameters")
+		}
+		vs[i] = nv.Value
+	}
+
+	return vs, nil
+}
@@ -0,0 +1,306 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bdigeststatsd receives values over UDP in statsd format
+// and adds them to the digests of a bdigest registry, so that processes
+// not written in Go can contribute to the digests with minimal overhead.
+//
+// Each datagram holds one or more newline-delimited lines, either
+// in statsd format (with optional sample rate and DogStatsD tags):
+//
+//	name:value|type|@rate|#tag:value,tag:value
+//
+// or plain values, which are added to the digest of the default key
+// (see Listener.SetDefaultKey):
+//
+//	value
+//
+// Only timers (ms), histograms (h) and distributions (d) are accepted.
+// Sample rates are ignored, since uniform sampling does not change
+// the quantiles. The registry key of a metric with tags is its name
+// followed by the sorted tags, like `name{tag="value",other="value"}`.
+// To bound the memory used, Listener creates at most DefaultMaxKeys
+// digests in the registry (see Listener.SetMaxKeys).
+//
+// In the other direction, Emitter sends the digests of a registry
+// to a DogStatsD agent as distribution metrics.
+package bdigeststatsd
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"pgregory.net/bdigest"
+)
+
+const (
+	// DefaultMaxKeys is the default maximum number of digests
+	// in the registry for Listener to create new ones.
+	DefaultMaxKeys = 10000
+
+	maxDatagramSize = 65535
+)
+
+// Listener adds values received on a packet connection
+// to the digests of a registry.
+type Listener struct {
+	malformed  uint64 // first for 64-bit alignment
+	conn       net.PacketConn
+	reg        *bdigest.Registry
+	mu         sync.Mutex
+	defaultKey string
+	maxKeys    int
+	done       chan struct{}
+	err        error
+}
+
+// Listen listens on UDP address addr (for example, "127.0.0.1:8125")
+// and adds the received values to registry reg.
+func Listen(addr string, reg *bdigest.Registry) (*Listener, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewListener(conn, reg), nil
+}
+
+// NewListener starts the goroutine adding values received on conn
+// to registry reg. Call Close to stop it.
+func NewListener(conn net.PacketConn, reg *bdigest.Registry) *Listener {
+	l := &Listener{
+		conn:    conn,
+		reg:     reg,
+		maxKeys: DefaultMaxKeys,
+		done:    make(chan struct{}),
+	}
+	go l.run()
+
+	return l
+}
+
+// Addr returns the address the listener receives values on.
+func (l *Listener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// SetDefaultKey sets the key of the digest plain values are added to.
+// Without the default key, plain values are counted as malformed.
+func (l *Listener) SetDefaultKey(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.defaultKey = key
+}
+
+// SetMaxKeys sets the maximum number of digests in the registry
+// for the listener to create new ones; values of the other keys are
+// counted as malformed. With n ≤ 0, the number of digests is not limited.
+func (l *Listener) SetMaxKeys(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.maxKeys = n
+}
+
+// Malformed returns the number of received lines which were not
+// valid values, were not of an accepted metric type, or were rejected
+// because of the limit set by SetMaxKeys.
+func (l *Listener) Malformed() uint64 {
+	return atomic.LoadUint64(&l.malformed)
+}
+
+// Close closes the connection and waits for the listener to stop,
+// returning the error it stopped with, if any.
+func (l *Listener) Close() error {
+	err := l.conn.Close()
+	<-l.done
+	if l.err != nil {
+		return l.err
+	}
+
+	return err
+}
+
+func (l *Listener) run() {
+	defer close(l.done)
+
+	buf := make([]byte, maxDatagramSize)
+	b := batch{}
+	for {
+		n, _, err := l.conn.ReadFrom(buf)
+		if n > 0 {
+			l.mu.Lock()
+			defaultKey, maxKeys := l.defaultKey, l.maxKeys
+			l.mu.Unlock()
+
+			malformed := b.parse(buf[:n], defaultKey)
+			malformed += b.flush(l.reg, maxKeys)
+			if malformed > 0 {
+				atomic.AddUint64(&l.malformed, uint64(malformed))
+			}
+		}
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				l.err = err
+			}
+			return
+		}
+	}
+}
+
+// batch holds the values of a datagram grouped by key, so that each
+// of the digests is locked once per datagram.
+type batch struct {
+	keys   []string
+	values map[string][]float64
+}
+
+func (b *batch) parse(data []byte, defaultKey string) int {
+	malformed := 0
+	for len(data) > 0 {
+		var line []byte
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, data = data[:i], data[i+1:]
+		} else {
+			line, data = data, nil
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		key, v, ok := parseLine(string(line), defaultKey)
+		if !ok {
+			malformed++
+			continue
+		}
+		if b.values == nil {
+			b.values = map[string][]float64{}
+		}
+		vs := b.values[key]
+		if len(vs) == 0 {
+			b.keys = append(b.keys, key)
+		}
+		b.values[key] = append(vs, v)
+	}
+
+	return malformed
+}
+
+// flush adds the values to the digests of reg, creating them only while
+// reg has less than maxKeys digests, and returns the number of rejected values.
+func (b *batch) flush(reg *bdigest.Registry, maxKeys int) int {
+	rejected := 0
+	for _, key := range b.keys {
+		vs := b.values[key]
+		c, ok := reg.Get(key)
+		if !ok && (maxKeys <= 0 || reg.Len() < maxKeys) {
+			c, ok = reg.GetOrCreate(key), true
+		}
+		if ok {
+			c.AddValues(vs...)
+		} else {
+			rejected += len(vs)
+		}
+		b.values[key] = vs[:0]
+	}
+	b.keys = b.keys[:0]
+	if len(b.values) > 1024 {
+		b.values = nil
+	}
+
+	return rejected
+}
+
+// parseLine returns the key and the value of statsd or plain line.
+func parseLine(line string, defaultKey string) (string, float64, bool) {
+	colon := strings.IndexByte(line, ':')
+	if colon < 0 {
+		v, ok := parseValue(line)
+		return defaultKey, v, ok && defaultKey != ""
+	}
+
+	name, rest := line[:colon], line[colon+1:]
+	fields := strings.Split(rest, "|")
+	if name == "" || len(fields) < 2 {
+		return "", 0, false
+	}
+	switch fields[1] {
+	case "ms", "h", "d":
+	default:
+		return "", 0, false
+	}
+	v, ok := parseValue(fields[0])
+	if !ok {
+		return "", 0, false
+	}
+
+	var tags []string
+	for _, f := range fields[2:] {
+		switch {
+		case strings.HasPrefix(f, "#"):
+			tags = append(tags, strings.Split(f[1:], ",")...)
+		case strings.HasPrefix(f, "@"):
+			if _, err := strconv.ParseFloat(f[1:], 64); err != nil {
+				return "", 0, false
+			}
+		}
+	}
+
+	return Key(name, tags...), v, true
+}
+
+func parseValue(s string) (float64, bool) {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(v) || v < 0 || v > math.MaxFloat64 {
+		return 0, false
+	}
+	return v, true
+}
+
+// Key returns the registry key of metric name with tags,
+// each either "tag:value" or "tag".
+func Key(name string, tags ...string) string {
+	if len(tags) == 0 {
+		return name
+	}
+
+	labels := make([]bdigest.Label, 0, len(tags))
+	for _, t := range tags {
+		if t == "" {
+			continue
+		}
+		l := bdigest.Label{Name: t}
+		if i := strings.IndexByte(t, ':'); i >= 0 {
+			l = bdigest.Label{Name: t[:i], Value: t[i+1:]}
+		}
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if labels[i].Name != labels[j].Name {
+			return labels[i].Name < labels[j].Name
+		}
+		return labels[i].Value < labels[j].Value
+	})
+
+	return bdigest.FormatKey(name, labels...)
+}
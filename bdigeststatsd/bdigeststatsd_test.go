@@ -0,0 +1,131 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigeststatsd_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/bdigest/bdigeststatsd"
+)
+
+func TestKey(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name string
+		tags []string
+		key  string
+	}{
+		{"latency", nil, "latency"},
+		{"latency", []string{"route:/a", "method:GET"}, `latency{method="GET",route="/a"}`},
+		{"latency", []string{"canary", ""}, `latency{canary=""}`},
+	} {
+		if key := bdigeststatsd.Key(tt.name, tt.tags...); key != tt.key {
+			t.Fatalf("got key %q instead of %q", key, tt.key)
+		}
+	}
+}
+
+func TestListener(t *testing.T) {
+	t.Parallel()
+
+	reg := bdigest.NewRegistry(0.01)
+	l, err := bdigeststatsd.Listen("127.0.0.1:0", reg)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer l.Close()
+	l.SetDefaultKey("plain")
+
+	conn, err := net.Dial("udp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	for _, dgram := range []string{
+		"latency:10|ms\nlatency:20|ms|@0.5\nlatency:5|h|#route:/a",
+		"1.5\n2.5\n",
+		"latency:30|d\nrequests:1|c\nlatency:x|ms\nlatency:-1|ms",
+	} {
+		if _, err := conn.Write([]byte(dgram)); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+	}
+
+	want := map[string]uint64{"latency": 3, `latency{route="/a"}`: 1, "plain": 2}
+	deadline := time.Now().Add(5 * time.Second)
+	for !received(reg, want) || l.Malformed() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %v digests and %v malformed lines", reg.Len(), l.Malformed())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if c, _ := reg.Get("latency"); c.Quantile(1) < 29 {
+		t.Fatalf("got maximum %v instead of 30", c.Quantile(1))
+	}
+
+	if err := l.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+}
+
+func TestListener_MaxKeys(t *testing.T) {
+	t.Parallel()
+
+	reg := bdigest.NewRegistry(0.01)
+	l, err := bdigeststatsd.Listen("127.0.0.1:0", reg)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer l.Close()
+	l.SetMaxKeys(2)
+
+	conn, err := net.Dial("udp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	for _, dgram := range []string{
+		"a:1|ms\nb:1|ms",
+		"c:1|ms\na:2|ms\nd:1|ms|#tag:x\nb:2|ms",
+	} {
+		if _, err := conn.Write([]byte(dgram)); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+	}
+
+	want := map[string]uint64{"a": 2, "b": 2}
+	deadline := time.Now().Add(5 * time.Second)
+	for !received(reg, want) || l.Malformed() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %v digests and %v malformed lines", reg.Len(), l.Malformed())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func received(reg *bdigest.Registry, want map[string]uint64) bool {
+	for key, n := range want {
+		if c, ok := reg.Get(key); !ok || c.Count() != n {
+			return false
+		}
+	}
+	return reg.Len() == len(want)
+}
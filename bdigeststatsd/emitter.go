@@ -0,0 +1,228 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigeststatsd
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"pgregory.net/bdigest"
+)
+
+// DefaultMaxPacketSize is the default maximum size of datagrams
+// sent by Emitter, which fits in the typical MTU.
+const DefaultMaxPacketSize = 1432
+
+// Emitter periodically sends the digests of a registry to a DogStatsD
+// agent as distribution metrics, resetting the digests.
+//
+// Every non-empty histogram bucket of a digest is sent as a single
+// value with the sample rate of 1/count, which the agent counts
+// as count values:
+//
+//	name:value|d|@rate|#tag:value,tag:value
+//
+// Names and tags are parsed back from keys returned by Key;
+// other keys are used as names as is.
+type Emitter struct {
+	mu            sync.Mutex
+	reg           *bdigest.Registry
+	conn          net.Conn
+	maxPacketSize int
+	buf           []byte
+	sent          []*sentDigest // with lines in buf
+	stop          chan struct{}
+	done          chan struct{}
+}
+
+// DialEmitter connects to the DogStatsD agent at UDP address addr
+// (for example, "127.0.0.1:8125") and returns emitter sending it
+// the digests of registry reg every period.
+func DialEmitter(addr string, reg *bdigest.Registry, period time.Duration) (*Emitter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewEmitter(conn, reg, period), nil
+}
+
+// NewEmitter returns emitter sending the digests of registry reg
+// over conn every period (if it is positive). Errors of the periodic
+// sends are ignored. The emitter takes ownership of conn; call Stop
+// to stop it and to close conn.
+func NewEmitter(conn net.Conn, reg *bdigest.Registry, period time.Duration) *Emitter {
+	e := &Emitter{
+		reg:           reg,
+		conn:          conn,
+		maxPacketSize: DefaultMaxPacketSize,
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	if period > 0 {
+		go e.run(period)
+	} else {
+		close(e.done)
+	}
+
+	return e
+}
+
+// SetMaxPacketSize sets the maximum size of sent datagrams
+// (DefaultMaxPacketSize by default). Lines longer than n
+// are sent in datagrams of their own.
+//
+// SetMaxPacketSize panics if n is not positive.
+func (e *Emitter) SetMaxPacketSize(n int) {
+	if n <= 0 {
+		panic("n must be positive")
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.maxPacketSize = n
+}
+
+func (e *Emitter) run(period time.Duration) {
+	defer close(e.done)
+
+	t := time.NewTicker(period)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			_ = e.Flush()
+		case <-e.stop:
+			return
+		}
+	}
+}
+
+// Stop stops the periodic sends, sends the remaining values and closes
+// the connection. Stop must not be called more than once.
+func (e *Emitter) Stop() error {
+	close(e.stop)
+	<-e.done
+
+	err := e.Flush()
+	if cerr := e.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Flush sends the content of the registry digests, resetting them.
+// Digests with lines in the datagrams which failed to be sent are merged
+// back into the registry digests, to be sent by the next Flush; values
+// of such digests sent in several datagrams can then be sent twice.
+func (e *Emitter) Flush() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var err error
+	e.buf = e.buf[:0]
+	e.reg.Range(func(key string, c *bdigest.Concurrent) bool {
+		d := c.SnapshotAndReset()
+		if d.Count() == 0 {
+			return true
+		}
+		s := &sentDigest{c: c, d: d}
+
+		name, labels := bdigest.ParseKey(key)
+		var suffix string
+		if len(labels) > 0 {
+			suffix = "|#" + strings.Join(tags(labels), ",")
+		}
+		d.ForEachBucket(func(lo float64, hi float64, count uint64) bool {
+			v := 0.0
+			if hi > 0 {
+				v = 2 * lo * hi / (lo + hi)
+			}
+			line := name + ":" + strconv.FormatFloat(v, 'g', -1, 64) + "|d"
+			if count > 1 {
+				line += "|@" + strconv.FormatFloat(1/float64(count), 'g', -1, 64)
+			}
+			if werr := e.writeLine(line+suffix, s); err == nil {
+				err = werr
+			}
+			return true
+		})
+		return true
+	})
+	if serr := e.send(); err == nil {
+		err = serr
+	}
+
+	return err
+}
+
+// sentDigest is the content of c reset by Flush.
+type sentDigest struct {
+	c      *bdigest.Concurrent
+	d      *bdigest.Digest
+	merged bool // back into c
+}
+
+// writeLine appends line of digest s to the datagram, sending it first
+// if the line does not fit.
+func (e *Emitter) writeLine(line string, s *sentDigest) error {
+	var err error
+	if len(e.buf) > 0 && len(e.buf)+1+len(line) > e.maxPacketSize {
+		err = e.send()
+	}
+	if len(e.buf) > 0 {
+		e.buf = append(e.buf, '\n')
+	}
+	e.buf = append(e.buf, line...)
+	if n := len(e.sent); n == 0 || e.sent[n-1] != s {
+		e.sent = append(e.sent, s)
+	}
+
+	return err
+}
+
+func (e *Emitter) send() error {
+	if len(e.buf) == 0 {
+		return nil
+	}
+
+	_, err := e.conn.Write(e.buf)
+	for i, s := range e.sent {
+		if err != nil && !s.merged {
+			_ = s.c.Merge(s.d) // same parameters
+			s.merged = true
+		}
+		e.sent[i] = nil
+	}
+	e.buf = e.buf[:0]
+	e.sent = e.sent[:0]
+	return err
+}
+
+// tags returns labels as "tag:value" tags, or "tag" for empty values.
+func tags(labels []bdigest.Label) []string {
+	tags := make([]string, len(labels))
+	for i, l := range labels {
+		tags[i] = l.Name
+		if l.Value != "" {
+			tags[i] += ":" + l.Value
+		}
+	}
+	return tags
+}
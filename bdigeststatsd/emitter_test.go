@@ -0,0 +1,128 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigeststatsd_test
+
+import (
+	"errors"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/bdigest/bdigeststatsd"
+)
+
+func TestEmitter(t *testing.T) {
+	t.Parallel()
+
+	agent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer agent.Close()
+
+	reg := bdigest.NewRegistry(0.01)
+	key := bdigeststatsd.Key("latency", "route:/a", "canary")
+	for i := 0; i < 1000; i++ {
+		reg.GetOrCreate(key).Add(float64(i % 100))
+	}
+	reg.GetOrCreate("empty")
+
+	e, err := bdigeststatsd.DialEmitter(agent.LocalAddr().String(), reg, 0)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	e.SetMaxPacketSize(200)
+	if err := e.Stop(); err != nil {
+		t.Fatalf("failed to stop: %v", err)
+	}
+	if c, _ := reg.Get(key); c.Count() != 0 {
+		t.Fatalf("digest was not reset after flush")
+	}
+
+	buf := make([]byte, 65535)
+	count, max := 0.0, 0.0
+	_ = agent.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	for {
+		n, _, err := agent.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		if n > 200 {
+			t.Fatalf("got datagram of %v bytes", n)
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			name, rest, _ := strings.Cut(line, ":")
+			fields := strings.Split(rest, "|")
+			if name != "latency" || fields[1] != "d" || fields[len(fields)-1] != "#canary,route:/a" {
+				t.Fatalf("got unexpected line %q", line)
+			}
+			v, _ := strconv.ParseFloat(fields[0], 64)
+			rate := 1.0
+			if strings.HasPrefix(fields[2], "@") {
+				rate, _ = strconv.ParseFloat(fields[2][1:], 64)
+			}
+			count += 1 / rate
+			max = math.Max(max, v)
+		}
+	}
+	if math.Round(count) != 1000 {
+		t.Fatalf("got %v values instead of 1000", count)
+	}
+	if math.Abs(max-99)/99 > 0.01 {
+		t.Fatalf("got maximum %v instead of 99", max)
+	}
+}
+
+type failingConn struct {
+	net.Conn
+}
+
+func (failingConn) Write(b []byte) (int, error) {
+	return 0, errors.New("network is unreachable")
+}
+
+func (failingConn) Close() error {
+	return nil
+}
+
+func TestEmitter_Error(t *testing.T) {
+	t.Parallel()
+
+	reg := bdigest.NewRegistry(0.01)
+	for i := 0; i < 1000; i++ {
+		reg.GetOrCreate(bdigeststatsd.Key("latency", "route:/a")).Add(float64(i % 100))
+		reg.GetOrCreate("requests").Add(float64(i))
+	}
+	want := reg.GetOrCreate("requests").Snapshot()
+
+	e := bdigeststatsd.NewEmitter(failingConn{}, reg, 0)
+	e.SetMaxPacketSize(200)
+	if err := e.Flush(); err == nil {
+		t.Fatalf("flush succeeded with failing connection")
+	}
+	if c, _ := reg.Get(bdigeststatsd.Key("latency", "route:/a")); c.Count() != 1000 {
+		t.Fatalf("failed flush left %v values instead of 1000", c.Count())
+	}
+	if c, _ := reg.Get("requests"); !c.Snapshot().Equal(want) {
+		t.Fatalf("failed flush changed the digest")
+	}
+	if err := e.Stop(); err == nil {
+		t.Fatalf("stop succeeded with failing connection")
+	}
+}
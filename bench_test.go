@@ -16,6 +16,7 @@ package bdigest_test
 
 import (
 	"fmt"
+	"io"
 	"math"
 	"math/rand"
 	"testing"
@@ -43,23 +44,57 @@ func BenchmarkNewDigest(b *testing.B) {
 }
 
 func BenchmarkDigest_Add(b *testing.B) {
-	for _, err := range errors {
-		b.Run(fmt.Sprintf("%v", err), func(b *testing.B) {
-			r := rand.New(rand.NewSource(0))
-			values := make([]float64, b.N)
-			for i := 0; i < b.N; i++ {
-				values[i] = math.Exp(r.NormFloat64())
-			}
-			d := bdigest.NewDigest(err)
-			b.ResetTimer()
+	for _, m := range mappings {
+		b.Run(m.String(), func(b *testing.B) {
+			for _, err := range errors {
+				b.Run(fmt.Sprintf("%v", err), func(b *testing.B) {
+					r := rand.New(rand.NewSource(0))
+					values := make([]float64, b.N)
+					for i := 0; i < b.N; i++ {
+						values[i] = math.Exp(r.NormFloat64())
+					}
+					d := bdigest.NewDigest(err, bdigest.WithMapping(m))
+					b.ResetTimer()
 
-			for i := 0; i < b.N; i++ {
-				d.Add(values[i])
+					for i := 0; i < b.N; i++ {
+						d.Add(values[i])
+					}
+				})
 			}
 		})
 	}
 }
 
+func BenchmarkConcurrent_Add(b *testing.B) {
+	c := bdigest.NewConcurrent(0.01)
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(0))
+		for pb.Next() {
+			c.Add(math.Exp(r.NormFloat64()))
+		}
+	})
+}
+
+func BenchmarkShardedDigest_Add(b *testing.B) {
+	s := bdigest.NewShardedDigest(0.01)
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(0))
+		for pb.Next() {
+			s.Add(math.Exp(r.NormFloat64()))
+		}
+	})
+}
+
+func BenchmarkAtomicDigest_Add(b *testing.B) {
+	a := bdigest.NewAtomicDigest(0.01, 1e-3, 1e3)
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(0))
+		for pb.Next() {
+			a.Add(math.Exp(r.NormFloat64()))
+		}
+	})
+}
+
 func BenchmarkDigest_Quantile(b *testing.B) {
 	for _, err := range errors {
 		b.Run(fmt.Sprintf("%v", err), func(b *testing.B) {
@@ -76,6 +111,19 @@ func BenchmarkDigest_Quantile(b *testing.B) {
 	}
 }
 
+func BenchmarkDigest_Quantiles(b *testing.B) {
+	for _, err := range errors {
+		b.Run(fmt.Sprintf("%v", err), func(b *testing.B) {
+			d := logNormalDigest(err, 0, benchElemCount, 0)
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				d.Quantiles(quantiles...)
+			}
+		})
+	}
+}
+
 func BenchmarkDigest_Merge(b *testing.B) {
 	for _, err := range errors {
 		b.Run(fmt.Sprintf("%v", err), func(b *testing.B) {
@@ -90,6 +138,41 @@ func BenchmarkDigest_Merge(b *testing.B) {
 	}
 }
 
+func BenchmarkDigest_MergeAll(b *testing.B) {
+	for _, err := range errors {
+		b.Run(fmt.Sprintf("%v", err), func(b *testing.B) {
+			ds := make([]*bdigest.Digest, 100)
+			for i := range ds {
+				ds[i] = logNormalDigest(err, int64(i), benchElemCount/100, 0)
+			}
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				d := bdigest.NewDigest(err)
+				_ = d.MergeAll(ds...)
+			}
+		})
+	}
+}
+
+func BenchmarkDigest_MergeBinary(b *testing.B) {
+	for _, err := range errors {
+		b.Run(fmt.Sprintf("%v", err), func(b *testing.B) {
+			d1 := logNormalDigest(err, 0, benchElemCount, 0)
+			d2 := logNormalDigest(err, 1, benchElemCount, 0)
+			buf, err := d2.MarshalBinary()
+			if err != nil {
+				b.Fatalf("unexpected error during marshaling: %v", err)
+			}
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				_ = d1.MergeBinary(buf)
+			}
+		})
+	}
+}
+
 func BenchmarkDigest_MarshalBinary(b *testing.B) {
 	for _, err := range errors {
 		b.Run(fmt.Sprintf("%v", err), func(b *testing.B) {
@@ -106,6 +189,24 @@ func BenchmarkDigest_MarshalBinary(b *testing.B) {
 	}
 }
 
+func BenchmarkDigest_AppendBinary(b *testing.B) {
+	for _, err := range errors {
+		b.Run(fmt.Sprintf("%v", err), func(b *testing.B) {
+			d := logNormalDigest(err, 0, benchElemCount, 0)
+			var buf []byte
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				var err error
+				buf, err = d.AppendBinary(buf[:0])
+				if err != nil {
+					b.Fatalf("unexpected error during marshaling: %v", err)
+				}
+			}
+		})
+	}
+}
+
 func BenchmarkDigest_UnmarshalBinary(b *testing.B) {
 	for _, err := range errors {
 		b.Run(fmt.Sprintf("%v", err), func(b *testing.B) {
@@ -127,8 +228,40 @@ func BenchmarkDigest_UnmarshalBinary(b *testing.B) {
 	}
 }
 
-func logNormalDigest(err float64, seed int64, count int, zeroChance int32) *bdigest.Digest {
-	d := bdigest.NewDigest(err)
+func BenchmarkDigest_MarshalCompact(b *testing.B) {
+	for _, err := range errors {
+		b.Run(fmt.Sprintf("%v", err), func(b *testing.B) {
+			d := logNormalDigest(err, 0, benchElemCount, 0)
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				_, err := d.MarshalCompact()
+				if err != nil {
+					b.Fatalf("unexpected error during marshaling: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDigest_WriteTo(b *testing.B) {
+	for _, err := range errors {
+		b.Run(fmt.Sprintf("%v", err), func(b *testing.B) {
+			d := logNormalDigest(err, 0, benchElemCount, 0)
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				_, err := d.WriteTo(io.Discard)
+				if err != nil {
+					b.Fatalf("unexpected error during writing: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func logNormalDigest(err float64, seed int64, count int, zeroChance int32, opts ...bdigest.Option) *bdigest.Digest {
+	d := bdigest.NewDigest(err, opts...)
 
 	r := rand.New(rand.NewSource(seed))
 	for i := 0; i < count; i++ {
@@ -0,0 +1,107 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest
+
+import (
+	"math"
+	"sort"
+)
+
+// Bucket is a histogram bucket holding values in (Lo, Hi].
+// Zero values are held in a separate bucket with both Lo and Hi of 0.
+type Bucket struct {
+	Lo float64
+	Hi float64
+}
+
+// BucketCount holds the number of values in a histogram bucket
+// and their fraction of the total number of added values.
+type BucketCount struct {
+	Bucket
+	Count    uint64
+	Fraction float64
+}
+
+// KeyOf returns the key of the histogram bucket holding positive value v.
+// Keys are only meaningful for digests with the same relative error
+// and mapping, and can change if the digest is coarsened to fit
+// the memory budget.
+//
+// KeyOf panics if v is outside (0, math.MaxFloat64].
+func (d *Digest) KeyOf(v float64) int {
+	if math.IsNaN(v) || v <= 0 || v > math.MaxFloat64 {
+		panic("v must be in (0, math.MaxFloat64]")
+	}
+
+	return d.bucketKey(v)
+}
+
+// BoundsOf returns the bounds of the histogram bucket with the given key,
+// which holds the values in (lo, hi]. Because of rounding, values
+// very close to the bounds can be held in the adjacent bucket instead.
+func (d *Digest) BoundsOf(key int) (lo float64, hi float64) {
+	return d.lowerBound(key), d.lowerBound(key + 1)
+}
+
+// ForEachBucket calls f for each non-empty histogram bucket with its
+// bounds and count, in ascending order of values, until f returns false.
+// The bucket of zero values, if any, comes first with lo and hi of 0.
+func (d *Digest) ForEachBucket(f func(lo float64, hi float64, count uint64) bool) {
+	if d.numZero > 0 && !f(0, 0, d.numZero) {
+		return
+	}
+	d.ascend(func(k int, n uint64) bool {
+		if n == 0 {
+			return true
+		}
+		return f(d.lowerBound(k), d.lowerBound(k+1), n)
+	})
+}
+
+// TopBuckets returns up to k non-empty histogram buckets (including
+// the bucket of zero values) with the largest counts, in descending order
+// of counts, preferring the buckets of lower values in case of ties.
+//
+// TopBuckets panics if k is negative.
+func (d *Digest) TopBuckets(k int) []BucketCount {
+	if k < 0 {
+		panic("k must be non-negative")
+	}
+
+	n := float64(d.Count())
+	var res []BucketCount
+	if d.numZero > 0 {
+		res = append(res, BucketCount{Count: d.numZero, Fraction: float64(d.numZero) / n})
+	}
+	d.ascend(func(key int, c uint64) bool {
+		if c > 0 {
+			res = append(res, BucketCount{
+				Bucket:   Bucket{Lo: d.lowerBound(key), Hi: d.lowerBound(key + 1)},
+				Count:    c,
+				Fraction: float64(c) / n,
+			})
+		}
+		return true
+	})
+
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Count > res[j].Count
+	})
+	if len(res) > k {
+		res = res[:k]
+	}
+
+	return res
+}
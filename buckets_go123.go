@@ -0,0 +1,31 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.23
+
+package bdigest
+
+import (
+	"iter"
+)
+
+// Buckets returns an iterator over the non-empty histogram buckets
+// and their counts, in ascending order of values, like ForEachBucket.
+func (d *Digest) Buckets() iter.Seq2[Bucket, uint64] {
+	return func(yield func(Bucket, uint64) bool) {
+		d.ForEachBucket(func(lo float64, hi float64, n uint64) bool {
+			return yield(Bucket{Lo: lo, Hi: hi}, n)
+		})
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.23
+
+package bdigest_test
+
+import (
+	"testing"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/rapid"
+)
+
+func TestDigest_Buckets(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			seed   = rapid.Int64().Draw(t, "seed")
+			count  = rapid.IntRange(0, 1000).Draw(t, "count")
+		)
+
+		d := logNormalDigest(relErr, seed, count, int32(count)/10)
+		var want []bdigest.BucketCount
+		d.ForEachBucket(func(lo float64, hi float64, n uint64) bool {
+			want = append(want, bdigest.BucketCount{Bucket: bdigest.Bucket{Lo: lo, Hi: hi}, Count: n})
+			return true
+		})
+
+		i := 0
+		for b, n := range d.Buckets() {
+			if i >= len(want) {
+				t.Fatalf("got more than %v buckets", len(want))
+			}
+			if b != want[i].Bucket || n != want[i].Count {
+				t.Fatalf("bucket %v is %+v with %v values instead of %+v", i, b, n, want[i])
+			}
+			i++
+		}
+		if i != len(want) {
+			t.Fatalf("got %v buckets instead of %v", i, len(want))
+		}
+		for range d.Buckets() {
+			break
+		}
+	})
+}
@@ -0,0 +1,139 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest_test
+
+import (
+	"testing"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/rapid"
+)
+
+func TestDigest_TopBuckets(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr  = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			mapping = rapid.SampledFrom(mappings).Draw(t, "mapping")
+			seed    = rapid.Int64().Draw(t, "seed")
+			count   = rapid.IntRange(0, 1000).Draw(t, "count")
+			spike   = rapid.Float64Range(1, 100).Draw(t, "spike")
+			k       = rapid.IntRange(0, 20).Draw(t, "k")
+		)
+
+		d := logNormalDigest(relErr, seed, count, int32(count)/10, bdigest.WithMapping(mapping))
+		for i := 0; i < count+1; i++ {
+			d.Add(spike)
+		}
+
+		top := d.TopBuckets(k)
+		if len(top) > k {
+			t.Fatalf("got %v buckets instead of at most %v", len(top), k)
+		}
+		if k == 0 {
+			return
+		}
+		// Bucket bounds are computed with rounding errors.
+		if b := top[0]; spike <= b.Lo*(1-1e-9) || spike > b.Hi*(1+1e-9) {
+			t.Fatalf("top bucket %+v does not hold spike %v", b, spike)
+		}
+		sum := uint64(0)
+		for i, b := range top {
+			if b.Count == 0 || b.Fraction != float64(b.Count)/float64(d.Count()) {
+				t.Fatalf("bucket %+v has invalid count of %v values", b, d.Count())
+			}
+			if i > 0 && b.Count > top[i-1].Count {
+				t.Fatalf("bucket %+v has greater count than %+v", b, top[i-1])
+			}
+			sum += b.Count
+		}
+		if sum > d.Count() || (len(top) < k && sum != d.Count()) {
+			t.Fatalf("bucket counts sum to %v out of %v", sum, d.Count())
+		}
+	})
+}
+
+func TestDigest_ForEachBucket(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr  = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			mapping = rapid.SampledFrom(mappings).Draw(t, "mapping")
+			seed    = rapid.Int64().Draw(t, "seed")
+			count   = rapid.IntRange(0, 1000).Draw(t, "count")
+			stop    = rapid.IntRange(1, 100).Draw(t, "stop")
+		)
+
+		d := logNormalDigest(relErr, seed, count, int32(count)/10, bdigest.WithMapping(mapping))
+		var (
+			sum    uint64
+			prevHi = -1.0
+			calls  = 0
+		)
+		d.ForEachBucket(func(lo float64, hi float64, n uint64) bool {
+			if n == 0 || lo < prevHi || hi < lo || (hi == lo && lo != 0) {
+				t.Fatalf("invalid bucket (%v, %v] with %v values after %v", lo, hi, n, prevHi)
+			}
+			sum += n
+			prevHi = hi
+			calls++
+			return true
+		})
+		if sum != d.Count() {
+			t.Fatalf("bucket counts sum to %v instead of %v", sum, d.Count())
+		}
+
+		n := 0
+		d.ForEachBucket(func(float64, float64, uint64) bool {
+			n++
+			return n < stop
+		})
+		want := stop
+		if calls < stop {
+			want = calls
+		}
+		if n != want {
+			t.Fatalf("iteration made %v calls instead of %v", n, want)
+		}
+	})
+}
+
+func TestDigest_KeyOf(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr  = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			mapping = rapid.SampledFrom(mappings).Draw(t, "mapping")
+			v       = rapid.Float64Range(1e-9, 1e9).Draw(t, "v")
+		)
+
+		d := bdigest.NewDigest(relErr, bdigest.WithMapping(mapping))
+		k := d.KeyOf(v)
+		if lo, hi := d.BoundsOf(k); v <= lo || v > hi {
+			t.Fatalf("bucket %v (%v, %v] does not hold %v", k, lo, hi, v)
+		}
+
+		d.Add(v)
+		d.ForEachBucket(func(lo float64, hi float64, n uint64) bool {
+			if l, h := d.BoundsOf(k); lo != l || hi != h {
+				t.Fatalf("bucket of %v is (%v, %v] instead of (%v, %v]", v, lo, hi, l, h)
+			}
+			return true
+		})
+	})
+}
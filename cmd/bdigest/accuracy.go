@@ -0,0 +1,73 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"pgregory.net/bdigest"
+)
+
+func accuracy(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	fs := flag.NewFlagSet("accuracy", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: bdigest accuracy [flags] [file ...]\n\n")
+		fmt.Fprintf(stderr, "Accuracy reads whitespace-separated non-negative numbers from files\n")
+		fmt.Fprintf(stderr, "(or stdin), and reports the observed relative errors of quantiles\n")
+		fmt.Fprintf(stderr, "of their digest, along with the guaranteed error and digest size.\n\n")
+		fs.PrintDefaults()
+	}
+	var (
+		relErr = fs.Float64("err", 0.01, "relative error of the digest, in (0, 1)")
+		qs     = quantilesFlag(defaultQuantiles)
+	)
+	fs.Var(&qs, "q", "comma-separated `quantiles` to check")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if *relErr <= 0 || *relErr >= 1 {
+		fmt.Fprintf(stderr, "bdigest accuracy: -err must be in (0, 1)\n")
+		return errUsage
+	}
+
+	var values []float64
+	if err := readInputs(fs.Args(), stdin, func(v float64) { values = append(values, v) }); err != nil {
+		return err
+	}
+	if len(values) == 0 {
+		return fmt.Errorf("no values")
+	}
+
+	r := bdigest.MeasureAccuracy(values, qs, *relErr)
+	tw := tabwriter.NewWriter(stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "quantile\texact\testimate\terror\t\n")
+	for _, qa := range r.Quantiles {
+		fmt.Fprintf(tw, "q%v\t%.6g\t%.6g\t%.4f%%\t\n", qa.Q, qa.Exact, qa.Estimate, qa.Error*100)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "\ndigest:    %v\n", r.Digest)
+	fmt.Fprintf(stdout, "count:     %v\n", r.Count)
+	fmt.Fprintf(stdout, "max error: %.4f%% (guaranteed %v%%)\n", r.MaxError*100, r.Guarantee*100)
+	fmt.Fprintf(stdout, "buckets:   %v (%v non-empty)\n", r.Size, r.Populated)
+	_, err := fmt.Fprintf(stdout, "memory:    %v bytes\n", r.MemoryBytes)
+
+	return err
+}
@@ -0,0 +1,87 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"text/tabwriter"
+)
+
+func diff(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: bdigest diff [flags] a.bd b.bd\n\n")
+		fmt.Fprintf(stderr, "Diff prints the quantiles of binary digests a (the baseline) and b,\n")
+		fmt.Fprintf(stderr, "and the relative differences between them. It exits with status 1\n")
+		fmt.Fprintf(stderr, "if any of the differences exceeds the threshold.\n\n")
+		fs.PrintDefaults()
+	}
+	var (
+		qs        = quantilesFlag(defaultQuantiles)
+		threshold = fs.Float64("threshold", 0, "maximum absolute relative `difference`, 0 for no limit")
+	)
+	fs.Var(&qs, "q", "comma-separated `quantiles` to compare")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errUsage
+	}
+	if math.IsNaN(*threshold) || *threshold < 0 {
+		fmt.Fprintf(stderr, "bdigest diff: -threshold must be non-negative\n")
+		return errUsage
+	}
+
+	a, err := readDigest(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	b, err := readDigest(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	if a.Count() == 0 || b.Count() == 0 {
+		return fmt.Errorf("can not compare empty digests")
+	}
+
+	c := b.Compare(a, qs...)
+	tw := tabwriter.NewWriter(stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "quantile\ta\tb\tdiff\t\n")
+	exceeded := 0
+	for _, d := range c.Quantiles {
+		mark := ""
+		if *threshold > 0 && math.Abs(d.Delta) > *threshold {
+			mark = " !"
+			exceeded++
+		}
+		fmt.Fprintf(tw, "q%v\t%.6g\t%.6g\t%+.2f%%%v\t\n", d.Q, d.Baseline, d.Value, d.Delta*100, mark)
+	}
+	fmt.Fprintf(tw, "count\t%v\t%v\t\t\n", a.Count(), b.Count())
+	fmt.Fprintf(tw, "drift\t\t\t%.4f\t\n", c.Drift)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if exceeded > 0 {
+		return fmt.Errorf("%v of %v quantiles differ by more than %v%%", exceeded, len(c.Quantiles), *threshold*100)
+	}
+
+	return nil
+}
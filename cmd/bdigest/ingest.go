@@ -0,0 +1,153 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"pgregory.net/bdigest"
+)
+
+var defaultQuantiles = []float64{0.5, 0.9, 0.99, 0.999}
+
+func ingest(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	fs := flag.NewFlagSet("ingest", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: bdigest ingest [flags] [file ...]\n\n")
+		fmt.Fprintf(stderr, "Ingest reads whitespace-separated non-negative numbers from files\n")
+		fmt.Fprintf(stderr, "(or stdin), and prints their quantiles or writes their digest.\n\n")
+		fs.PrintDefaults()
+	}
+	var (
+		relErr = fs.Float64("err", 0.01, "relative error of the digest, in (0, 1)")
+		qs     = quantilesFlag(defaultQuantiles)
+		out    = fs.String("o", "", "write the binary digest to `file` instead of printing quantiles")
+	)
+	fs.Var(&qs, "q", "comma-separated `quantiles` to print")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if *relErr <= 0 || *relErr >= 1 {
+		fmt.Fprintf(stderr, "bdigest ingest: -err must be in (0, 1)\n")
+		return errUsage
+	}
+
+	d := bdigest.NewDigest(*relErr)
+	if err := readInputs(fs.Args(), stdin, d.Add); err != nil {
+		return err
+	}
+
+	if *out != "" {
+		if err := writeDigest(*out, d); err != nil {
+			return err
+		}
+		if !flagSet(fs, "q") {
+			return nil
+		}
+	}
+
+	return printQuantiles(stdout, d, qs)
+}
+
+// readInputs calls add for every number read from the named files,
+// or from stdin if there are none.
+func readInputs(names []string, stdin io.Reader, add func(v float64)) error {
+	if len(names) == 0 {
+		return readValues(stdin, "stdin", add)
+	}
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		err = readValues(f, name, add)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readValues calls add for every whitespace-separated number read from r.
+func readValues(r io.Reader, name string, add func(v float64)) error {
+	s := bufio.NewScanner(r)
+	s.Split(bufio.ScanWords)
+	for n := 1; s.Scan(); n++ {
+		v, err := strconv.ParseFloat(s.Text(), 64)
+		if err != nil || math.IsNaN(v) || v < 0 || v > math.MaxFloat64 {
+			return fmt.Errorf("%v: value %v: invalid number %q", name, n, s.Text())
+		}
+		add(v)
+	}
+
+	return s.Err()
+}
+
+func printQuantiles(w io.Writer, d *bdigest.Digest, qs []float64) error {
+	if d.Count() == 0 {
+		return fmt.Errorf("no values")
+	}
+	for i, v := range d.Quantiles(qs...) {
+		if _, err := fmt.Fprintf(w, "%v\tq%v\n", v, qs[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// quantilesFlag is a flag.Value holding comma-separated quantiles.
+type quantilesFlag []float64
+
+func (f *quantilesFlag) String() string {
+	s := make([]string, len(*f))
+	for i, q := range *f {
+		s[i] = strconv.FormatFloat(q, 'g', -1, 64)
+	}
+	return strings.Join(s, ",")
+}
+
+func (f *quantilesFlag) Set(value string) error {
+	var qs []float64
+	for _, s := range strings.Split(value, ",") {
+		q, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil || !(q >= 0 && q <= 1) {
+			return fmt.Errorf("quantile %q is not in [0, 1]", s)
+		}
+		qs = append(qs, q)
+	}
+	*f = qs
+	return nil
+}
+
+func flagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
@@ -0,0 +1,200 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"pgregory.net/bdigest"
+)
+
+func runCmd(t *testing.T, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRun_Usage(t *testing.T) {
+	if code, _, stderr := runCmd(t, ""); code != 2 || !strings.Contains(stderr, "ingest") {
+		t.Fatalf("got exit code %v and usage %q", code, stderr)
+	}
+	if code, _, _ := runCmd(t, "", "frobnicate"); code != 2 {
+		t.Fatalf("got exit code %v for unknown command", code)
+	}
+}
+
+func TestIngest_Quantiles(t *testing.T) {
+	code, stdout, stderr := runCmd(t, "1 2 3\n4\t5\n", "ingest", "-q", "0,0.5,1")
+	if code != 0 {
+		t.Fatalf("got exit code %v: %v", code, stderr)
+	}
+
+	d := bdigest.FromValues(0.01, 1, 2, 3, 4, 5)
+	want := ""
+	for i, v := range d.Quantiles(0, 0.5, 1) {
+		want += fmt.Sprintf("%v\tq%v\n", v, []float64{0, 0.5, 1}[i])
+	}
+	if stdout != want {
+		t.Fatalf("got output %q instead of %q", stdout, want)
+	}
+}
+
+func TestIngest_Invalid(t *testing.T) {
+	for _, in := range []string{"1 x 3", "-1", "NaN", ""} {
+		if code, _, _ := runCmd(t, in, "ingest"); code != 1 {
+			t.Fatalf("got exit code %v for input %q", code, in)
+		}
+	}
+	if code, _, _ := runCmd(t, "1", "ingest", "-err", "2"); code != 2 {
+		t.Fatalf("got exit code %v for invalid error", code)
+	}
+	if code, _, _ := runCmd(t, "1", "ingest", "-q", "1.5"); code != 2 {
+		t.Fatalf("got exit code %v for invalid quantile", code)
+	}
+}
+
+func TestIngest_Files(t *testing.T) {
+	dir := t.TempDir()
+	in1, in2, out := filepath.Join(dir, "1.txt"), filepath.Join(dir, "2.txt"), filepath.Join(dir, "out.bd")
+	if err := os.WriteFile(in1, []byte("0 1 2\n"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(in2, []byte("3 4\n"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	code, stdout, stderr := runCmd(t, "", "ingest", "-err", "0.05", "-o", out, in1, in2)
+	if code != 0 {
+		t.Fatalf("got exit code %v: %v", code, stderr)
+	}
+	if stdout != "" {
+		t.Fatalf("got unexpected output %q", stdout)
+	}
+
+	d := readDigestFile(t, out)
+	if d.Count() != 5 || d.RelativeError() != 0.05 {
+		t.Fatalf("got digest %v with %v values", d, d.Count())
+	}
+}
+
+func readDigestFile(t *testing.T, name string) *bdigest.Digest {
+	t.Helper()
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &bdigest.Digest{}
+	if err := d.UnmarshalBinary(data); err != nil {
+		t.Fatalf("failed to unmarshal %v: %v", name, err)
+	}
+	return d
+}
+
+func TestMerge(t *testing.T) {
+	dir := t.TempDir()
+	var (
+		names []string
+		want  = bdigest.NewDigest(0.01)
+	)
+	for i := 0; i < 3; i++ {
+		d := bdigest.FromValues(0.01, float64(i), float64(i+1), float64(i*10))
+		_ = want.Merge(d)
+		name := filepath.Join(dir, fmt.Sprintf("%v.bd", i))
+		data, _ := d.MarshalBinary()
+		if err := os.WriteFile(name, data, 0o666); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+
+	out := filepath.Join(dir, "out.bd")
+	if code, _, stderr := runCmd(t, "", append([]string{"merge", out}, names...)...); code != 0 {
+		t.Fatalf("got exit code %v: %v", code, stderr)
+	}
+	got, _ := readDigestFile(t, out).MarshalBinary()
+	data, _ := want.MarshalBinary()
+	if string(got) != string(data) {
+		t.Fatalf("merged digest %q differs from %q", got, data)
+	}
+
+	other := filepath.Join(dir, "other.bd")
+	data, _ = bdigest.FromValues(0.05, 1).MarshalBinary()
+	if err := os.WriteFile(other, data, 0o666); err != nil {
+		t.Fatal(err)
+	}
+	if code, _, _ := runCmd(t, "", "merge", out, names[0], other); code != 1 {
+		t.Fatalf("got exit code %v when merging different digests", code)
+	}
+	if code, _, _ := runCmd(t, "", "merge", out); code != 2 {
+		t.Fatalf("got exit code %v without inputs", code)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.bd"), filepath.Join(dir, "b.bd")
+	for name, scale := range map[string]float64{a: 1, b: 1.2} {
+		vs := make([]float64, 100)
+		for i := range vs {
+			vs[i] = float64(i+1) * scale
+		}
+		data, _ := bdigest.FromValues(0.01, vs...).MarshalBinary()
+		if err := os.WriteFile(name, data, 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	code, stdout, stderr := runCmd(t, "", "diff", "-q", "0.5,0.99", a, b)
+	if code != 0 {
+		t.Fatalf("got exit code %v: %v", code, stderr)
+	}
+	if lines := strings.Split(strings.TrimSpace(stdout), "\n"); len(lines) != 5 || !strings.Contains(lines[1], "+") {
+		t.Fatalf("got unexpected output %q", stdout)
+	}
+
+	if code, _, _ := runCmd(t, "", "diff", "-threshold", "0.25", a, b); code != 0 {
+		t.Fatalf("got exit code %v below threshold", code)
+	}
+	if code, _, stderr := runCmd(t, "", "diff", "-threshold", "0.1", a, b); code != 1 || !strings.Contains(stderr, "differ") {
+		t.Fatalf("got exit code %v above threshold: %v", code, stderr)
+	}
+	if code, _, _ := runCmd(t, "", "diff", a); code != 2 {
+		t.Fatalf("got exit code %v with single digest", code)
+	}
+}
+
+func TestAccuracy(t *testing.T) {
+	code, stdout, stderr := runCmd(t, "1 2 3 4 5 6 7 8 9 10", "accuracy", "-err", "0.02", "-q", "0.1,0.5")
+	if code != 0 {
+		t.Fatalf("got exit code %v: %v", code, stderr)
+	}
+	for _, s := range []string{"q0.1", "q0.5", "guaranteed 2%", "buckets:", "memory:"} {
+		if !strings.Contains(stdout, s) {
+			t.Fatalf("output %q does not contain %q", stdout, s)
+		}
+	}
+
+	if code, _, _ := runCmd(t, "", "accuracy"); code != 1 {
+		t.Fatalf("got exit code %v without values", code)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"pgregory.net/bdigest"
+)
+
+func merge(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	fs := flag.NewFlagSet("merge", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: bdigest merge out.bd in.bd ...\n\n")
+		fmt.Fprintf(stderr, "Merge merges binary digests from the input files into the output file.\n")
+		fmt.Fprintf(stderr, "All the digests must have the same relative error and mapping.\n")
+	}
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return errUsage
+	}
+
+	out, in := fs.Arg(0), fs.Args()[1:]
+	d, err := readDigest(in[0])
+	if err != nil {
+		return err
+	}
+	for _, name := range in[1:] {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if err := d.MergeBinary(data); err != nil {
+			return fmt.Errorf("%v: %w", name, err)
+		}
+	}
+
+	return writeDigest(out, d)
+}
+
+// readDigest reads the binary digest from file name.
+func readDigest(name string) (*bdigest.Digest, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	d := &bdigest.Digest{}
+	if err := d.UnmarshalBinary(data); err != nil {
+		return nil, fmt.Errorf("%v: %w", name, err)
+	}
+
+	return d, nil
+}
+
+// writeDigest writes d to file name in the binary format.
+func writeDigest(name string, d *bdigest.Digest) error {
+	data, err := d.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(name, data, 0o666)
+}
@@ -0,0 +1,193 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// MarshalCompact returns compact binary representation of the digest,
+// which is typically several times smaller than the one of MarshalBinary.
+// The result can be decoded with UnmarshalBinary.
+//
+// Compact format has the same structure as the one of MarshalBinary,
+// but with numbers stored as varints and runs of empty buckets stored
+// as a zero followed by the run length.
+func (d *Digest) MarshalCompact() ([]byte, error) {
+	return d.appendCompact(make([]byte, 0, prefixSize+8+3*binary.MaxVarintLen64+d.Size()))
+}
+
+func (d *Digest) appendCompact(buf []byte) ([]byte, error) {
+	if d.index != nil {
+		return buf, errCustomMapping
+	}
+
+	buf = append(buf, magic...)
+	buf = append(buf, d.version(formatCompact))
+	buf = appendUint64(buf, math.Float64bits(d.alpha))
+	buf = appendUvarint(buf, d.numZero)
+	neg, pos := d.buckets()
+	buf = appendUvarint(buf, uint64(len(neg)))
+	buf = appendUvarint(buf, uint64(len(pos)))
+	buf = appendCompactBuckets(buf, neg)
+	buf = appendCompactBuckets(buf, pos)
+
+	return buf, nil
+}
+
+func (d *Digest) unmarshalCompact(data []byte, m Mapping, maxBuckets uint64) error {
+	r := bytes.NewReader(data)
+	err := d.readCompact(r, m, maxBuckets)
+	if err != nil {
+		return err
+	}
+	if r.Len() != 0 {
+		return fmt.Errorf("%v bytes of trailing data", r.Len())
+	}
+
+	return nil
+}
+
+type compactReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// readCompact reads the compact format following the format version.
+func (d *Digest) readCompact(r compactReader, m Mapping, maxBuckets uint64) error {
+	var buf [8]byte
+	_, err := io.ReadFull(r, buf[:])
+	if err != nil {
+		return fmt.Errorf("failed to read relative error: %w", err)
+	}
+	alpha := math.Float64frombits(binary.LittleEndian.Uint64(buf[:]))
+	if math.IsNaN(alpha) || alpha <= 0 || alpha >= 1 {
+		return fmt.Errorf("invalid relative error %v", alpha)
+	}
+
+	var hdr [3]uint64
+	for i := range hdr {
+		hdr[i], err = binary.ReadUvarint(r)
+		if err != nil {
+			return fmt.Errorf("failed to read header: %w", err)
+		}
+	}
+	numZero, lenNeg, lenPos := hdr[0], hdr[1], hdr[2]
+	err = checkBuckets(lenNeg, lenPos, maxBuckets)
+	if err != nil {
+		return err
+	}
+
+	neg, numNeg, err := readCompactBuckets(r, lenNeg)
+	if err != nil {
+		return err
+	}
+	pos, numPos, err := readCompactBuckets(r, lenPos)
+	if err != nil {
+		return err
+	}
+	num, err := addCount(numNeg, numPos)
+	if err != nil {
+		return err
+	}
+
+	return d.setFrom(alpha, m, numZero, neg, pos, num)
+}
+
+func appendCompactBuckets(buf []byte, buckets []uint64) []byte {
+	for i := 0; i < len(buckets); {
+		if buckets[i] != 0 {
+			buf = appendUvarint(buf, buckets[i])
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(buckets) && buckets[j] == 0 {
+			j++
+		}
+		buf = append(buf, 0)
+		buf = appendUvarint(buf, uint64(j-i))
+		i = j
+	}
+
+	return buf
+}
+
+// readCompactBuckets reads n buckets, growing the slice as they are
+// decoded, so that the declared n does not cause large allocations
+// for truncated or hostile data.
+func readCompactBuckets(r io.ByteReader, n uint64) ([]uint64, uint64, error) {
+	var buckets []uint64
+	sum := uint64(0)
+	for i := uint64(0); i < n; i = uint64(len(buckets)) {
+		v, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read histogram bucket %v: %w", i, err)
+		}
+		if v != 0 {
+			buckets = append(buckets, v)
+			sum, err = addCount(sum, v)
+			if err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+		run, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read run of empty histogram buckets at %v: %w", i, err)
+		}
+		if run == 0 || run > n-i {
+			return nil, 0, fmt.Errorf("invalid run of %v empty histogram buckets at %v", run, i)
+		}
+		buckets = grow(buckets, int(i+run)-1)
+	}
+
+	return buckets, sum, nil
+}
+
+// countingReader adapts io.Reader to io.ByteReader without reading
+// past the requested data, counting the number of bytes read.
+type countingReader struct {
+	r   io.Reader
+	n   int64
+	buf [1]byte
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	_, err := io.ReadFull(c, c.buf[:])
+	return c.buf[0], err
+}
+
+func appendUint64(buf []byte, v uint64) []byte {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	return append(buf, b[:]...)
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	return append(buf, b[:n]...)
+}
@@ -0,0 +1,55 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest_test
+
+import (
+	"reflect"
+	"testing"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/rapid"
+)
+
+func TestDigestMarshalCompactRoundtrip(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-5, 1-1e-5).Draw(t, "relative error")
+			seed   = rapid.Int64().Draw(t, "seed")
+			count  = rapid.IntRange(0, 100000).Draw(t, "count")
+		)
+
+		d1 := logNormalDigest(relErr, seed, count, int32(count)/10)
+		data, err := d1.MarshalCompact()
+		if err != nil {
+			t.Fatalf("failed to marshal digest: %v", err)
+		}
+		fixed, _ := d1.MarshalBinary()
+		if len(data) > len(fixed) {
+			t.Fatalf("compact size %v is bigger than fixed size %v", len(data), len(fixed))
+		}
+
+		d2 := &bdigest.Digest{}
+		err = d2.UnmarshalBinary(data)
+		if err != nil {
+			t.Fatalf("failed to unmarshal digest: %v", err)
+		}
+
+		if !reflect.DeepEqual(d1, d2) {
+			t.Fatalf("got back %#v which is different than %#v", d2, d1)
+		}
+	})
+}
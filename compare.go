@@ -0,0 +1,253 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest
+
+import (
+	"math"
+)
+
+// approxQuantiles are the quantiles compared by ApproxEqual.
+var approxQuantiles = []float64{0, 0.01, 0.05, 0.1, 0.25, 0.5, 0.75, 0.9, 0.95, 0.99, 0.999, 1}
+
+// compareQuantiles are the quantiles compared by Compare by default.
+var compareQuantiles = []float64{0.5, 0.9, 0.95, 0.99, 0.999}
+
+// Equal returns whether digests have the same relative error and mapping,
+// and the same counts of values in each of the histogram buckets.
+// Empty buckets, the kind of bucket store and the options affecting
+// only the queries (like WithInterpolation) are not compared.
+func (d *Digest) Equal(v *Digest) bool {
+	if d.alpha != v.alpha || d.mapping != v.mapping || d.index != v.index {
+		return false
+	}
+	if d.numZero != v.numZero || d.numNonZero != v.numNonZero {
+		return false
+	}
+
+	var c, e cumulative
+	c.build(d.store)
+	e.build(v.store)
+	if len(c.keys) != len(e.keys) {
+		return false
+	}
+	for i := range c.keys {
+		if c.keys[i] != e.keys[i] || c.sums[i] != e.sums[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ApproxEqual returns whether digests have the same number of values,
+// and their quantiles at a fixed set of points from 0 to 1 differ by
+// a relative error of at most tolerance. Unlike Equal, it can compare
+// digests with different relative errors or mappings.
+//
+// ApproxEqual panics if tolerance is negative or NaN.
+func (d *Digest) ApproxEqual(v *Digest, tolerance float64) bool {
+	if math.IsNaN(tolerance) || tolerance < 0 {
+		panic("tolerance must be non-negative")
+	}
+
+	if d.Count() != v.Count() {
+		return false
+	}
+	if d.Count() == 0 {
+		return true
+	}
+
+	for _, q := range approxQuantiles {
+		a, b := d.Quantile(q), v.Quantile(q)
+		if math.Abs(a-b) > tolerance*math.Max(a, b) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Comparison is the result of comparing a digest with a baseline,
+// as returned by Digest.Compare.
+type Comparison struct {
+	Quantiles []QuantileDelta
+	Drift     float64 // Kolmogorov-Smirnov distance from the baseline
+}
+
+// QuantileDelta holds the q-quantiles of a digest and of a baseline,
+// and the relative difference (Value-Baseline)/Baseline between them.
+type QuantileDelta struct {
+	Q        float64
+	Value    float64
+	Baseline float64
+	Delta    float64
+}
+
+// Compare compares the distribution of added values with the one
+// of baseline, returning the relative deltas of the q-quantiles for each
+// of qs (or of 0.5, 0.9, 0.95, 0.99 and 0.999, if qs is empty), and
+// the drift score equal to KSDistance(d, baseline). Delta is 0 if both
+// quantiles are 0, and +Inf if only the baseline quantile is 0.
+//
+// Compare panics if any of qs is outside [0, 1].
+// Quantiles, deltas and drift are NaN if any of the digests is empty.
+func (d *Digest) Compare(baseline *Digest, qs ...float64) Comparison {
+	if len(qs) == 0 {
+		qs = compareQuantiles
+	}
+
+	vs, bs := d.Quantiles(qs...), baseline.Quantiles(qs...)
+	c := Comparison{
+		Quantiles: make([]QuantileDelta, len(qs)),
+		Drift:     KSDistance(d, baseline),
+	}
+	for i, q := range qs {
+		delta := 0.0
+		if vs[i] != bs[i] {
+			delta = (vs[i] - bs[i]) / bs[i]
+		}
+		c.Quantiles[i] = QuantileDelta{Q: q, Value: vs[i], Baseline: bs[i], Delta: delta}
+	}
+
+	return c
+}
+
+// KSDistance returns the Kolmogorov-Smirnov distance between distributions
+// of values of digests a and b: the maximum absolute difference of their
+// cumulative distribution functions, from 0 to 1. It is computed at the
+// upper bounds of histogram buckets of both digests; for digests with
+// the same relative error and mapping, it is exact at those points,
+// and can be smaller than the distance between the added values by at
+// most the fraction of values in a single bucket.
+//
+// KSDistance returns NaN if any of the digests is empty.
+func KSDistance(a *Digest, b *Digest) float64 {
+	if a.Count() == 0 || b.Count() == 0 {
+		return math.NaN()
+	}
+
+	bounds := mergeBounds(a.upperBounds(), b.upperBounds())
+	ca, cb := a.cumulativeAt(bounds), b.cumulativeAt(bounds)
+	na, nb := float64(a.Count()), float64(b.Count())
+	dist := 0.0
+	for i := range bounds {
+		dist = math.Max(dist, math.Abs(float64(ca[i])/na-float64(cb[i])/nb))
+	}
+
+	return dist
+}
+
+// QQ returns the pairs of quantiles of digests a and b at n points
+// evenly spaced from 0 to 1, suitable for a quantile-quantile plot.
+// Quantiles of empty digest are NaN.
+//
+// QQ panics if n is less than 2.
+func QQ(a *Digest, b *Digest, n int) [][2]float64 {
+	if n < 2 {
+		panic("n must be at least 2")
+	}
+
+	qs := make([]float64, n)
+	for i := range qs {
+		qs[i] = float64(i) / float64(n-1)
+	}
+	qa, qb := a.Quantiles(qs...), b.Quantiles(qs...)
+	res := make([][2]float64, n)
+	for i := range res {
+		res[i] = [2]float64{qa[i], qb[i]}
+	}
+
+	return res
+}
+
+// WassersteinDistance returns the Wasserstein-1 (earth mover's) distance
+// between distributions of values of digests a and b: the area between
+// their cumulative distribution functions, in the units of the values.
+// Values are assumed to be distributed uniformly within each histogram
+// bucket, so that the distance can differ from the one between the added
+// values by up to 2*err/(1-err) of the mean of values of each digest.
+//
+// WassersteinDistance returns NaN if any of the digests is empty.
+func WassersteinDistance(a *Digest, b *Digest) float64 {
+	if a.Count() == 0 || b.Count() == 0 {
+		return math.NaN()
+	}
+
+	bounds := mergeBounds(a.bucketBounds(), b.bucketBounds())
+	ca, cb := a.cdfAt(bounds), b.cdfAt(bounds)
+	na, nb := float64(a.Count()), float64(b.Count())
+	dist := 0.0
+	for i := 1; i < len(bounds); i++ {
+		// Both distribution functions are linear between the bounds.
+		f0 := ca[i-1]/na - cb[i-1]/nb
+		f1 := ca[i]/na - cb[i]/nb
+		w := bounds[i] - bounds[i-1]
+		if (f0 >= 0) == (f1 >= 0) {
+			dist += w * math.Abs(f0+f1) / 2
+		} else {
+			dist += w * (f0*f0 + f1*f1) / (2 * (math.Abs(f0) + math.Abs(f1)))
+		}
+	}
+
+	return dist
+}
+
+// bucketBounds returns 0 and the positive bounds of non-empty histogram buckets.
+func (d *Digest) bucketBounds() []float64 {
+	bounds := []float64{0}
+	d.ascend(func(k int, n uint64) bool {
+		if n == 0 {
+			return true
+		}
+		if lo := d.lowerBound(k); lo > bounds[len(bounds)-1] {
+			bounds = append(bounds, lo)
+		}
+		bounds = append(bounds, d.lowerBound(k+1))
+		return true
+	})
+
+	return bounds
+}
+
+// upperBounds returns 0 and the upper bounds of non-empty histogram buckets.
+func (d *Digest) upperBounds() []float64 {
+	bounds := []float64{0}
+	d.ascend(func(k int, n uint64) bool {
+		if n > 0 {
+			bounds = append(bounds, d.lowerBound(k+1))
+		}
+		return true
+	})
+
+	return bounds
+}
+
+// mergeBounds returns the sorted union of sorted bounds a and b.
+func mergeBounds(a []float64, b []float64) []float64 {
+	res := make([]float64, 0, len(a)+len(b))
+	for len(a) > 0 || len(b) > 0 {
+		var v float64
+		if len(b) == 0 || (len(a) > 0 && a[0] <= b[0]) {
+			v, a = a[0], a[1:]
+		} else {
+			v, b = b[0], b[1:]
+		}
+		if len(res) == 0 || v > res[len(res)-1] {
+			res = append(res, v)
+		}
+	}
+
+	return res
+}
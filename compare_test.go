@@ -0,0 +1,271 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest_test
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/rapid"
+)
+
+func TestDigest_Equal(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			seed   = rapid.Int64().Draw(t, "seed")
+			count  = rapid.IntRange(0, 10000).Draw(t, "count")
+			store  = rapid.SampledFrom(storeNames).Draw(t, "store")
+		)
+
+		d1 := logNormalDigest(relErr, seed, count, int32(count)/10)
+		d2 := logNormalDigest(relErr, seed, count, int32(count)/10, stores[store]...)
+		if !d1.Equal(d2) || !d2.Equal(d1) {
+			t.Fatalf("%v store digest is not equal to the dense one", store)
+		}
+		if !d1.ApproxEqual(d2, 0) {
+			t.Fatalf("%v store digest is not approximately equal to the dense one", store)
+		}
+
+		d3 := logNormalDigest(relErr*0.9, seed, count, int32(count)/10)
+		if d1.Equal(d3) {
+			t.Fatalf("digests with different relative errors are equal")
+		}
+		if tol := 2 * relErr / (1 - relErr); !d1.ApproxEqual(d3, tol*1.01) {
+			t.Fatalf("digests with relative errors %v and %v differ by more than %v", relErr, relErr*0.9, tol)
+		}
+
+		if bdigest.NewDigest(relErr).Equal(bdigest.NewDigest(relErr, bdigest.WithMapping(bdigest.MappingCubic))) {
+			t.Fatalf("digests with different mappings are equal")
+		}
+
+		d2.Add(1)
+		if d1.Equal(d2) || d1.ApproxEqual(d2, 1) {
+			t.Fatalf("digests with different counts are equal")
+		}
+	})
+}
+
+func TestKSDistance(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			mu     = rapid.Float64Range(-1, 1).Draw(t, "mu")
+			seedA  = rapid.Int64().Draw(t, "seed a")
+			seedB  = rapid.Int64().Draw(t, "seed b")
+			countA = rapid.IntRange(1, 1000).Draw(t, "count a")
+			countB = rapid.IntRange(1, 1000).Draw(t, "count b")
+		)
+
+		va, vb := logNormalValues(seedA, countA, 0), logNormalValues(seedB, countB, mu)
+		a, b := bdigest.NewDigest(relErr), bdigest.NewDigest(relErr)
+		for _, v := range va {
+			a.Add(v)
+		}
+		for _, v := range vb {
+			b.Add(v)
+		}
+
+		if d := bdigest.KSDistance(a, a); d != 0 {
+			t.Fatalf("distance of digest to itself is %v", d)
+		}
+		d := bdigest.KSDistance(a, b)
+		if r := bdigest.KSDistance(b, a); r != d {
+			t.Fatalf("distance is not symmetric: %v and %v", d, r)
+		}
+		if exact := exactKSDistance(va, vb); d < 0 || d > exact+1e-9 {
+			t.Fatalf("distance is %v, exact %v", d, exact)
+		}
+	})
+}
+
+func logNormalValues(seed int64, count int, mu float64) []float64 {
+	r := rand.New(rand.NewSource(seed))
+	values := make([]float64, count)
+	for i := range values {
+		values[i] = math.Exp(mu + r.NormFloat64())
+	}
+	sort.Float64s(values)
+
+	return values
+}
+
+func exactKSDistance(a []float64, b []float64) float64 {
+	dist, i, j := 0.0, 0, 0
+	for i < len(a) || j < len(b) {
+		v := math.Inf(1)
+		if i < len(a) {
+			v = a[i]
+		}
+		if j < len(b) && b[j] < v {
+			v = b[j]
+		}
+		for i < len(a) && a[i] == v {
+			i++
+		}
+		for j < len(b) && b[j] == v {
+			j++
+		}
+		dist = math.Max(dist, math.Abs(float64(i)/float64(len(a))-float64(j)/float64(len(b))))
+	}
+
+	return dist
+}
+
+func TestWassersteinDistance(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			mu     = rapid.Float64Range(-1, 1).Draw(t, "mu")
+			seedA  = rapid.Int64().Draw(t, "seed a")
+			seedB  = rapid.Int64().Draw(t, "seed b")
+			countA = rapid.IntRange(1, 1000).Draw(t, "count a")
+			countB = rapid.IntRange(1, 1000).Draw(t, "count b")
+		)
+
+		va, vb := logNormalValues(seedA, countA, 0), logNormalValues(seedB, countB, mu)
+		a, b := bdigest.NewDigest(relErr), bdigest.NewDigest(relErr)
+		for _, v := range va {
+			a.Add(v)
+		}
+		for _, v := range vb {
+			b.Add(v)
+		}
+
+		if d := bdigest.WassersteinDistance(a, a); d != 0 {
+			t.Fatalf("distance of digest to itself is %v", d)
+		}
+		d := bdigest.WassersteinDistance(a, b)
+		if r := bdigest.WassersteinDistance(b, a); math.Abs(r-d) > 1e-9*d {
+			t.Fatalf("distance is not symmetric: %v and %v", d, r)
+		}
+		exact := exactWassersteinDistance(va, vb)
+		if maxErr := 2 * relErr / (1 - relErr) * (mean(va) + mean(vb)); math.Abs(d-exact) > maxErr*(1+1e-9) {
+			t.Fatalf("distance is %v instead of %v (max error %v)", d, exact, maxErr)
+		}
+	})
+}
+
+func mean(values []float64) float64 {
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+func exactWassersteinDistance(a []float64, b []float64) float64 {
+	dist, prev, i, j := 0.0, 0.0, 0, 0
+	for i < len(a) || j < len(b) {
+		v := math.Inf(1)
+		if i < len(a) {
+			v = a[i]
+		}
+		if j < len(b) && b[j] < v {
+			v = b[j]
+		}
+		dist += (v - prev) * math.Abs(float64(i)/float64(len(a))-float64(j)/float64(len(b)))
+		for i < len(a) && a[i] == v {
+			i++
+		}
+		for j < len(b) && b[j] == v {
+			j++
+		}
+		prev = v
+	}
+
+	return dist
+}
+
+func TestQQ(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			seed   = rapid.Int64().Draw(t, "seed")
+			count  = rapid.IntRange(0, 1000).Draw(t, "count")
+			n      = rapid.IntRange(2, 100).Draw(t, "n")
+		)
+
+		a := logNormalDigest(relErr, seed, count, int32(count)/10)
+		b := logNormalDigest(relErr, seed+1, count, 0)
+		qq := bdigest.QQ(a, b, n)
+		if len(qq) != n {
+			t.Fatalf("got %v points instead of %v", len(qq), n)
+		}
+		for i, p := range qq {
+			q := float64(i) / float64(n-1)
+			if count == 0 {
+				if !math.IsNaN(p[0]) || !math.IsNaN(p[1]) {
+					t.Fatalf("got %v for empty digests", p)
+				}
+				continue
+			}
+			if p[0] != a.Quantile(q) || p[1] != b.Quantile(q) {
+				t.Fatalf("point %v is %v instead of q%v of the digests", i, p, q)
+			}
+			if i > 0 && (p[0] < qq[i-1][0] || p[1] < qq[i-1][1]) {
+				t.Fatalf("point %v %v is less than previous %v", i, p, qq[i-1])
+			}
+		}
+	})
+}
+
+func TestDigest_Compare(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			seed   = rapid.Int64().Draw(t, "seed")
+			count  = rapid.IntRange(1, 1000).Draw(t, "count")
+			qs     = rapid.SliceOfN(rapid.Float64Range(0, 1), 0, 10).Draw(t, "quantiles")
+		)
+
+		a := logNormalDigest(relErr, seed, count, 0)
+		b := logNormalDigest(relErr, seed+1, count, 0)
+		c := a.Compare(b, qs...)
+		if c.Drift != bdigest.KSDistance(a, b) {
+			t.Fatalf("drift is %v instead of KS distance %v", c.Drift, bdigest.KSDistance(a, b))
+		}
+		if len(qs) == 0 {
+			qs = []float64{0.5, 0.9, 0.95, 0.99, 0.999}
+		}
+		if len(c.Quantiles) != len(qs) {
+			t.Fatalf("got %v quantiles instead of %v", len(c.Quantiles), len(qs))
+		}
+		for i, q := range qs {
+			v, base := a.Quantile(q), b.Quantile(q)
+			p := c.Quantiles[i]
+			if p.Q != q || p.Value != v || p.Baseline != base || math.Abs(p.Delta-(v-base)/base) > 1e-9 {
+				t.Fatalf("quantile delta %+v instead of q%v %v vs %v", p, q, v, base)
+			}
+		}
+
+		if s := a.Compare(a, qs...); s.Drift != 0 || s.Quantiles[0].Delta != 0 {
+			t.Fatalf("comparison with itself is %+v", s)
+		}
+	})
+}
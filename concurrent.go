@@ -0,0 +1,154 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest
+
+import (
+	"sync"
+)
+
+// Concurrent is a digest safe for concurrent use.
+//
+// Queries of Concurrent can run in parallel with each other,
+// but not with the modifications.
+type Concurrent struct {
+	mu sync.RWMutex
+	d  *Digest
+}
+
+// NewConcurrent returns concurrency-safe digest
+// with parameters of NewDigest.
+func NewConcurrent(err float64, opts ...Option) *Concurrent {
+	return &Concurrent{d: NewDigest(err, opts...)}
+}
+
+// String returns short digest description.
+func (c *Concurrent) String() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.d.String()
+}
+
+// Add adds finite non-negative value v to the digest, like Digest.Add.
+func (c *Concurrent) Add(v float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.d.Add(v)
+}
+
+// AddValues adds finite non-negative values vs to the digest,
+// like Digest.AddValues.
+func (c *Concurrent) AddValues(vs ...float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.d.AddValues(vs...)
+}
+
+// Merge merges the content of v into the digest, like Digest.Merge.
+// Digest v must not be modified concurrently; use Snapshot
+// to merge other Concurrent digests.
+func (c *Concurrent) Merge(v *Digest) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.d.Merge(v)
+}
+
+// MergeBinary merges serialized digest into the digest,
+// like Digest.MergeBinary.
+func (c *Concurrent) MergeBinary(data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.d.MergeBinary(data)
+}
+
+// Reset resets digest to the initial empty state.
+func (c *Concurrent) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.d.Reset()
+}
+
+// Count returns the number of added values.
+func (c *Concurrent) Count() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.d.Count()
+}
+
+// Size returns the number of histogram buckets.
+func (c *Concurrent) Size() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.d.Size()
+}
+
+// Quantile returns the q-quantile of added values, like Digest.Quantile.
+func (c *Concurrent) Quantile(q float64) float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.d.Quantile(q)
+}
+
+// Quantiles returns the q-quantiles of added values for each of qs,
+// like Digest.Quantiles.
+func (c *Concurrent) Quantiles(qs ...float64) []float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.d.Quantiles(qs...)
+}
+
+// Snapshot returns a copy of the digest.
+func (c *Concurrent) Snapshot() *Digest {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.d.Clone()
+}
+
+// SnapshotAndReset atomically returns a copy of the digest
+// and resets it to the empty state, keeping the allocated memory.
+func (c *Concurrent) SnapshotAndReset() *Digest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	d := c.d.Clone()
+	c.d.Reset()
+	return d
+}
+
+// Freeze returns an immutable snapshot of the digest.
+func (c *Concurrent) Freeze() *FrozenDigest {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.d.Freeze()
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (c *Concurrent) MarshalBinary() ([]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.d.MarshalBinary()
+}
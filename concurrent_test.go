@@ -0,0 +1,335 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest_test
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/rapid"
+)
+
+func TestConcurrent(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr  = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			workers = rapid.IntRange(1, 8).Draw(t, "workers")
+			count   = rapid.IntRange(0, 1000).Draw(t, "count")
+			store   = rapid.SampledFrom(storeNames).Draw(t, "store")
+		)
+
+		c := bdigest.NewConcurrent(relErr, stores[store]...)
+		d := bdigest.NewDigest(relErr)
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			r := rand.New(rand.NewSource(int64(i)))
+			values := make([]float64, count)
+			for j := range values {
+				values[j] = math.Exp(r.NormFloat64())
+				d.Add(values[j])
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j, v := range values {
+					c.Add(v)
+					if j%100 == 0 {
+						c.Quantile(0.5)
+						c.Snapshot().Count()
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		if c.Count() != d.Count() {
+			t.Fatalf("count is %v instead of %v", c.Count(), d.Count())
+		}
+		if q1, q2 := c.Quantile(0.99), d.Quantile(0.99); q1 != q2 && count > 0 {
+			t.Fatalf("q0.99 is %v instead of %v", q1, q2)
+		}
+		s := c.Snapshot()
+		c.Add(1)
+		if s.Count() != d.Count() {
+			t.Fatalf("snapshot changed after Add")
+		}
+	})
+}
+
+func TestShardedDigest(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr  = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			workers = rapid.IntRange(1, 8).Draw(t, "workers")
+			count   = rapid.IntRange(0, 1000).Draw(t, "count")
+			store   = rapid.SampledFrom(storeNames).Draw(t, "store")
+		)
+
+		s := bdigest.NewShardedDigest(relErr, stores[store]...)
+		d := bdigest.NewDigest(relErr)
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			r := rand.New(rand.NewSource(int64(i)))
+			values := make([]float64, count)
+			for j := range values {
+				values[j] = math.Exp(r.NormFloat64())
+				d.Add(values[j])
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j, v := range values {
+					s.Add(v)
+					if j%100 == 0 {
+						s.Quantile(0.5)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		if s.Count() != d.Count() {
+			t.Fatalf("count is %v instead of %v", s.Count(), d.Count())
+		}
+		data1, _ := s.MarshalBinary()
+		data2, _ := d.MarshalBinary()
+		if string(data1) != string(data2) {
+			t.Fatalf("sharded digest data %q differs from %q", data1, data2)
+		}
+		s.Reset()
+		if s.Count() != 0 {
+			t.Fatalf("count is %v after Reset", s.Count())
+		}
+	})
+}
+
+func TestAtomicDigest(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr  = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			min     = rapid.Float64Range(1e-3, 1).Draw(t, "min")
+			max     = rapid.Float64Range(1, 1e3).Draw(t, "max")
+			workers = rapid.IntRange(1, 8).Draw(t, "workers")
+			count   = rapid.IntRange(0, 1000).Draw(t, "count")
+		)
+
+		a := bdigest.NewAtomicDigest(relErr, min, max)
+		d := bdigest.NewDigest(relErr)
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			r := rand.New(rand.NewSource(int64(i)))
+			values := make([]float64, count)
+			for j := range values {
+				values[j] = math.Exp(r.NormFloat64())
+				d.Add(math.Min(math.Max(values[j], min), max))
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for _, v := range values {
+					a.Add(v)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if a.Count() != d.Count() {
+			t.Fatalf("count is %v instead of %v", a.Count(), d.Count())
+		}
+		for _, q := range []float64{0, 0.5, 0.99, 1} {
+			if q1, q2 := a.Quantile(q), d.Quantile(q); q1 != q2 && count > 0 {
+				t.Fatalf("q%v is %v instead of %v", q, q1, q2)
+			}
+		}
+	})
+}
+
+func TestDigest_Clone(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			seed   = rapid.Int64().Draw(t, "seed")
+			count  = rapid.IntRange(0, 10000).Draw(t, "count")
+			store  = rapid.SampledFrom(storeNames).Draw(t, "store")
+		)
+
+		d := logNormalDigest(relErr, seed, count, int32(count)/10, stores[store]...)
+		c := d.Clone()
+		data1, _ := d.MarshalBinary()
+		data2, _ := c.MarshalBinary()
+		if string(data1) != string(data2) {
+			t.Fatalf("clone data %q differs from %q", data2, data1)
+		}
+		c.Add(1)
+		if c.Count() == d.Count() {
+			t.Fatalf("digest changed after adding to its clone")
+		}
+	})
+}
+
+func TestSnapshotAndReset(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr  = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			workers = rapid.IntRange(1, 8).Draw(t, "workers")
+			count   = rapid.IntRange(0, 1000).Draw(t, "count")
+			kind    = rapid.SampledFrom([]string{"concurrent", "sharded", "atomic"}).Draw(t, "kind")
+		)
+
+		var s interface {
+			Add(v float64)
+			SnapshotAndReset() *bdigest.Digest
+		}
+		switch kind {
+		case "concurrent":
+			s = bdigest.NewConcurrent(relErr)
+		case "sharded":
+			s = bdigest.NewShardedDigest(relErr)
+		case "atomic":
+			s = bdigest.NewAtomicDigest(relErr, 1e-9, 1e9)
+		}
+
+		d := bdigest.NewDigest(relErr)
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			r := rand.New(rand.NewSource(int64(i)))
+			values := make([]float64, count)
+			for j := range values {
+				values[j] = math.Exp(r.NormFloat64())
+				d.Add(values[j])
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for _, v := range values {
+					s.Add(v)
+				}
+			}()
+		}
+
+		total := bdigest.NewDigest(relErr)
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		for stop := false; !stop; {
+			select {
+			case <-done:
+				stop = true
+			default:
+			}
+			if err := total.Merge(s.SnapshotAndReset()); err != nil {
+				t.Fatalf("failed to merge snapshot: %v", err)
+			}
+		}
+
+		data1, _ := total.MarshalBinary()
+		data2, _ := d.MarshalBinary()
+		if string(data1) != string(data2) {
+			t.Fatalf("total of %v snapshots data %q differs from %q", kind, data1, data2)
+		}
+	})
+}
+
+func TestParallelMerge(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr  = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			n       = rapid.IntRange(0, 20).Draw(t, "digests")
+			workers = rapid.IntRange(-1, 8).Draw(t, "workers")
+		)
+
+		ds := make([]*bdigest.Digest, n)
+		d := bdigest.NewDigest(relErr)
+		for i := range ds {
+			count := rapid.IntRange(0, 1000).Draw(t, "count")
+			ds[i] = logNormalDigest(relErr, int64(i), count, int32(count)/10, stores[rapid.SampledFrom(storeNames).Draw(t, "store")]...)
+			_ = d.Merge(ds[i])
+		}
+
+		m, err := bdigest.ParallelMerge(ds, workers)
+		if n == 0 {
+			if err == nil {
+				t.Fatalf("successfully merged no digests")
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("failed to merge digests: %v", err)
+		}
+		data1, _ := m.MarshalBinary()
+		data2, _ := d.MarshalBinary()
+		if string(data1) != string(data2) {
+			t.Fatalf("parallel merge data %q differs from %q", data1, data2)
+		}
+
+		if _, err = bdigest.ParallelMerge(append(ds, bdigest.NewDigest(relErr/2)), workers); err == nil {
+			t.Fatalf("successfully merged digest with different relative error")
+		}
+	})
+}
+
+func TestMergeOverflow(t *testing.T) {
+	t.Parallel()
+
+	v := bdigest.NewDigest(0.01)
+	v.Add(1)
+	v.Add(2)
+	d := bdigest.NewDigest(0.01)
+	if err := d.MergeWeighted(v, 1<<62); err != nil {
+		t.Fatalf("failed to merge with weight: %v", err)
+	}
+
+	if _, err := bdigest.ParallelMerge([]*bdigest.Digest{d, d.Clone(), v}, 2); err != bdigest.ErrOverflow {
+		t.Fatalf("got %v instead of overflow error from ParallelMerge", err)
+	}
+
+	s := bdigest.NewShardedDigest(0.01)
+	for i := 0; i < 4; i++ {
+		_ = s.Merge(d) // fails if the shard is full
+	}
+	if n := s.Snapshot().Count(); n < d.Count() {
+		t.Fatalf("got snapshot count %v less than %v", n, d.Count())
+	}
+
+	a := bdigest.NewAggregator(0.01)
+	_ = a.AddNode("root", "")
+	_ = a.AddNode("leaf", "root")
+	if err := a.Merge("root", d); err != nil {
+		t.Fatalf("failed to merge into root: %v", err)
+	}
+	if err := a.Merge("leaf", d); err != bdigest.ErrOverflow {
+		t.Fatalf("got %v instead of overflow error from Aggregator.Merge", err)
+	}
+	if leaf, _ := a.Snapshot("leaf"); !leaf.IsEmpty() {
+		t.Fatalf("failed merge changed leaf digest to %v", leaf)
+	}
+}
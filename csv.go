@@ -0,0 +1,108 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+var (
+	csvHeader = []string{"lower_bound", "upper_bound", "count"}
+)
+
+// WriteCSV writes the non-empty histogram buckets of the digest to w
+// in CSV format, as rows of exclusive lower bound, inclusive upper bound
+// and count, after the header row "lower_bound,upper_bound,count".
+// Zero values are written as a row with both bounds of 0.
+func (d *Digest) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+
+	var err error
+	d.ForEachBucket(func(lo float64, hi float64, n uint64) bool {
+		err = cw.Write([]string{
+			strconv.FormatFloat(lo, 'g', -1, 64),
+			strconv.FormatFloat(hi, 'g', -1, 64),
+			strconv.FormatUint(n, 10),
+		})
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// ReadCSV returns digest with parameters of NewDigest and the content
+// read from r in the format of WriteCSV. Counts of rows not matching the
+// histogram buckets of the digest (for example, written by a digest
+// with different relative error) are apportioned like in FromHistogram.
+//
+// ReadCSV returns an error if the data is malformed,
+// or ErrOverflow if the total count overflows.
+//
+// ReadCSV panics if err is outside (0, 1).
+func ReadCSV(r io.Reader, err float64, opts ...Option) (*Digest, error) {
+	d := NewDigest(err, opts...)
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = len(csvHeader)
+	cr.ReuseRecord = true
+	header, e := cr.Read()
+	if e != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", e)
+	}
+	for i, h := range csvHeader {
+		if header[i] != h {
+			return nil, fmt.Errorf("unexpected CSV header column %q instead of %q", header[i], h)
+		}
+	}
+
+	total := uint64(0)
+	for line := 2; ; line++ {
+		row, e := cr.Read()
+		if e == io.EOF {
+			break
+		}
+		if e != nil {
+			return nil, e
+		}
+
+		lo, e1 := strconv.ParseFloat(row[0], 64)
+		hi, e2 := strconv.ParseFloat(row[1], 64)
+		n, e3 := strconv.ParseUint(row[2], 10, 64)
+		if e1 != nil || e2 != nil || e3 != nil {
+			return nil, fmt.Errorf("line %v: invalid bucket %q", line, row)
+		}
+		if math.IsNaN(lo) || math.IsNaN(hi) || lo < 0 || hi > math.MaxFloat64 || (lo >= hi && hi != 0) || (hi == 0 && lo != 0) {
+			return nil, fmt.Errorf("line %v: invalid bucket bounds (%v, %v]", line, lo, hi)
+		}
+		if total, e = addCount(total, n); e != nil {
+			return nil, e
+		}
+		d.addInterval(lo, hi, n)
+	}
+	d.fitBudget()
+
+	return d, nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/rapid"
+)
+
+func TestDigest_WriteCSV(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr  = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			mapping = rapid.SampledFrom(mappings).Draw(t, "mapping")
+			seed    = rapid.Int64().Draw(t, "seed")
+			count   = rapid.IntRange(0, 1000).Draw(t, "count")
+		)
+
+		d := logNormalDigest(relErr, seed, count, int32(count)/10, bdigest.WithMapping(mapping))
+		var buf bytes.Buffer
+		if err := d.WriteCSV(&buf); err != nil {
+			t.Fatalf("failed to write CSV: %v", err)
+		}
+		buckets := 0
+		d.ForEachBucket(func(float64, float64, uint64) bool {
+			buckets++
+			return true
+		})
+		if rows := strings.Count(buf.String(), "\n"); rows != buckets+1 {
+			t.Fatalf("got %v CSV rows for %v buckets", rows, buckets)
+		}
+
+		r, err := bdigest.ReadCSV(&buf, relErr, bdigest.WithMapping(mapping))
+		if err != nil {
+			t.Fatalf("failed to read CSV: %v", err)
+		}
+		if !r.Equal(d) {
+			t.Fatalf("digest read from CSV %v differs from %v", r, d)
+		}
+	})
+}
+
+func TestReadCSV_Errors(t *testing.T) {
+	t.Parallel()
+
+	for _, data := range []string{
+		"",
+		"lo,hi,count\n",
+		"lower_bound,upper_bound\n",
+		"lower_bound,upper_bound,count\n1,2\n",
+		"lower_bound,upper_bound,count\n1,2,x\n",
+		"lower_bound,upper_bound,count\n2,1,1\n",
+		"lower_bound,upper_bound,count\n1,0,1\n",
+		"lower_bound,upper_bound,count\n-1,2,1\n",
+		"lower_bound,upper_bound,count\nNaN,2,1\n",
+		"lower_bound,upper_bound,count\n1,2,18446744073709551615\n2,3,1\n",
+	} {
+		if _, err := bdigest.ReadCSV(strings.NewReader(data), 0.01); err == nil {
+			t.Errorf("read digest from invalid CSV %q", data)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// maxDecayExp limits the growth of the weights of DecayingDigest
+	// before they are renormalized, keeping the sums far from overflow.
+	maxDecayExp = 300
+)
+
+// DecayingDigest tracks distribution of values weighted by their recency:
+// weight of value added Δt ago is e^(−Δt/τ).
+//
+// Decay is applied lazily using the forward decay technique: instead of
+// damping existing weights as time goes by, new values are added with
+// exponentially growing weights, which are occasionally renormalized.
+//
+// Like Digest, DecayingDigest is not safe for concurrent use.
+type DecayingDigest struct {
+	proto   *Digest // relative error and mapping
+	tau     float64 // in nanoseconds
+	t0      time.Time
+	init    bool
+	numZero float64
+	lo      int       // key of counts[0]
+	counts  []float64 // weights relative to time t0
+}
+
+// NewDecayingDigest returns digest with parameters of NewDigest,
+// with values decaying with time constant tau.
+//
+// NewDecayingDigest panics if tau is not positive.
+func NewDecayingDigest(err float64, tau time.Duration, opts ...Option) *DecayingDigest {
+	if tau <= 0 {
+		panic("tau must be positive")
+	}
+
+	return &DecayingDigest{
+		proto: NewDigest(err, opts...),
+		tau:   float64(tau),
+	}
+}
+
+// String returns short digest description.
+func (d *DecayingDigest) String() string {
+	return "Decaying" + d.proto.String()
+}
+
+// Reset resets digest to the initial empty state.
+func (d *DecayingDigest) Reset() {
+	d.init = false
+	d.numZero = 0
+	d.lo = 0
+	d.counts = d.counts[:0]
+}
+
+// Add adds finite non-negative value v to the digest at the current time.
+func (d *DecayingDigest) Add(v float64) {
+	d.AddAt(time.Now(), v)
+}
+
+// AddAt adds finite non-negative value v to the digest at time t.
+// Values do not have to be added in the order of time.
+//
+// AddAt panics if v is outside [0, math.MaxFloat64].
+func (d *DecayingDigest) AddAt(t time.Time, v float64) {
+	if math.IsNaN(v) || v < 0 || v > math.MaxFloat64 {
+		panic("v must be in [0, math.MaxFloat64]")
+	}
+
+	w := d.weight(t)
+	if v == 0 {
+		d.numZero += w
+		return
+	}
+
+	k := d.proto.bucketKey(v)
+	switch {
+	case len(d.counts) == 0:
+		d.lo = k
+		d.counts = append(d.counts, 0)
+	case k < d.lo:
+		m := d.lo - k
+		d.counts = append(make([]float64, m, m+len(d.counts)), d.counts...)
+		d.lo = k
+	default:
+		d.counts = grow(d.counts, k-d.lo)
+	}
+	d.counts[k-d.lo] += w
+}
+
+// weight returns the weight of value added at time t,
+// renormalizing the weights if needed.
+func (d *DecayingDigest) weight(t time.Time) float64 {
+	if !d.init {
+		d.t0 = t
+		d.init = true
+	}
+
+	x := float64(t.Sub(d.t0)) / d.tau
+	if x <= maxDecayExp {
+		return math.Exp(x)
+	}
+
+	f := math.Exp(-x)
+	d.numZero *= f
+	for i := range d.counts {
+		d.counts[i] *= f
+	}
+	d.t0 = t
+
+	return 1
+}
+
+// Weight returns the total weight of the values at the current time.
+func (d *DecayingDigest) Weight() float64 {
+	return d.WeightAt(time.Now())
+}
+
+// WeightAt returns the total weight of the values at time t.
+func (d *DecayingDigest) WeightAt(t time.Time) float64 {
+	if !d.init {
+		return 0
+	}
+
+	return d.total() * math.Exp(-float64(t.Sub(d.t0))/d.tau)
+}
+
+func (d *DecayingDigest) total() float64 {
+	sum := d.numZero
+	for _, w := range d.counts {
+		sum += w
+	}
+
+	return sum
+}
+
+// Quantile returns the q-quantile of the values weighted by their recency,
+// with the same relative error guarantees as Digest.Quantile. Since all
+// the weights decay at the same rate, the result does not depend on time.
+// For empty digest, Quantile returns NaN.
+//
+// Quantile panics if q is outside [0, 1].
+func (d *DecayingDigest) Quantile(q float64) float64 {
+	if math.IsNaN(q) || q < 0 || q > 1 {
+		panic("q must be in [0, 1]")
+	}
+
+	return d.quantileAt(d.total(), q)
+}
+
+// Quantiles returns the q-quantiles of the values weighted by their recency
+// for each of qs, like Quantile.
+//
+// Quantiles panics if any of qs is outside [0, 1].
+func (d *DecayingDigest) Quantiles(qs ...float64) []float64 {
+	for _, q := range qs {
+		if math.IsNaN(q) || q < 0 || q > 1 {
+			panic("q must be in [0, 1]")
+		}
+	}
+
+	total := d.total()
+	res := make([]float64, len(qs))
+	for i, q := range qs {
+		res[i] = d.quantileAt(total, q)
+	}
+
+	return res
+}
+
+func (d *DecayingDigest) quantileAt(total float64, q float64) float64 {
+	if total == 0 {
+		return math.NaN()
+	}
+
+	rank := q * total
+	if d.numZero > 0 && rank <= d.numZero {
+		return 0
+	}
+
+	sum, last := d.numZero, -1
+	for i, w := range d.counts {
+		if w == 0 {
+			continue
+		}
+		sum += w
+		last = i
+		if sum >= rank {
+			break
+		}
+	}
+	if last < 0 {
+		return 0 // only zeros have non-zero weight
+	}
+
+	return d.proto.quantile(d.lo + last)
+}
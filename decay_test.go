@@ -0,0 +1,79 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest_test
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/rapid"
+)
+
+func TestDecayingDigest(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			tau    = time.Duration(rapid.Int64Range(1, 1e12).Draw(t, "tau"))
+			seed   = rapid.Int64().Draw(t, "seed")
+			count  = rapid.IntRange(0, 1000).Draw(t, "count")
+			steps  = rapid.IntRange(1, 5).Draw(t, "steps")
+		)
+
+		d := bdigest.NewDecayingDigest(relErr, tau)
+		if !math.IsNaN(d.Quantile(0.5)) {
+			t.Fatalf("q0.5 of empty digest is %v instead of NaN", d.Quantile(0.5))
+		}
+
+		// Values added 1000τ ago have weights of e^-1000, which are rounded to 0.
+		now := time.Unix(0, 0)
+		r := rand.New(rand.NewSource(seed))
+		var last *bdigest.Digest
+		for i := 0; i < steps; i++ {
+			now = now.Add(1000 * tau)
+			last = bdigest.NewDigest(relErr)
+			for j := 0; j < count; j++ {
+				v := math.Exp(r.NormFloat64())
+				if r.Intn(10) == 0 {
+					v = 0
+				}
+				d.AddAt(now, v)
+				last.Add(v)
+			}
+		}
+
+		if w := d.WeightAt(now); math.Abs(w-float64(count)) > 1e-9*float64(count) {
+			t.Fatalf("weight is %v instead of %v", w, count)
+		}
+		if w := d.WeightAt(now.Add(tau)); math.Abs(w-float64(count)/math.E) > 1e-9*float64(count) {
+			t.Fatalf("weight after τ is %v instead of %v", w, float64(count)/math.E)
+		}
+		for _, q := range []float64{0, 1} {
+			if dq, lq := d.Quantile(q), last.Quantile(q); dq != lq && !(math.IsNaN(dq) && math.IsNaN(lq)) {
+				t.Fatalf("q%v is %v instead of %v", q, dq, lq)
+			}
+		}
+		qs := d.Quantiles(0, 0.1, 0.25, 0.5, 0.75, 0.9, 1)
+		for i := 1; i < len(qs); i++ {
+			if qs[i] < qs[i-1] {
+				t.Fatalf("quantiles %v are not monotonic", qs)
+			}
+		}
+	})
+}
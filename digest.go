@@ -18,25 +18,173 @@ package bdigest
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unsafe"
 )
 
 const (
 	headerSize = 8 /* alpha */ + 8 /* numZero */ + 2*4 /* len(neg), len(pos) */
+
+	magic      = "bdg"
+	prefixSize = len(magic) + 1
+
+	formatFixed   = 1
+	formatCompact = 2
+)
+
+var (
+	// ErrOverflow is returned when the total count of values
+	// in the digest would overflow uint64.
+	ErrOverflow = errors.New("total count overflow")
 )
 
 // Digest tracks distribution of values using histograms
 // with exponentially sized buckets.
 type Digest struct {
-	alpha   float64
-	gamma   float64
-	gammaLn float64
-	neg     []uint64
-	pos     []uint64
-	numNeg  uint64
-	numPos  uint64
-	numZero uint64
+	alpha       float64
+	mapping     builtinMapping
+	index       IndexMapping // overrides mapping if not nil
+	store       Store
+	numNonZero  uint64
+	numZero     uint64
+	cum         atomic.Value // *cumulative cache for Quantile, nil if invalid
+	hint        [2]float64   // expected range of values, if not zero
+	interpolate bool
+	rank        RankMethod
+	budget      int            // maximum size of the store in bytes, if not zero
+	budgetSeen  int            // size of the store coarsening failed to reduce
+	fineAlpha   float64        // alpha before coarsening, if coarsened
+	fineMapping builtinMapping // mapping before coarsening, if coarsened
+	exact       []float64      // all the non-zero values, if len(exact) == numNonZero
+	exactMax    int
+	dropped     uint64 // number of values removed by Prune
+}
+
+// Option configures digest created with NewDigest.
+type Option func(*Digest)
+
+// WithSparseStore makes digest keep only the non-empty histogram buckets.
+// It reduces memory usage for data with huge dynamic range (where most
+// buckets would otherwise be empty) at the cost of slower Add.
+func WithSparseStore() Option {
+	return func(d *Digest) {
+		d.store = &sparseStore{}
+	}
+}
+
+// WithStore makes digest keep the histogram buckets in new empty store
+// of the same kind as s (as returned by s.Empty), instead of the default
+// dense store.
+func WithStore(s Store) Option {
+	return func(d *Digest) {
+		d.store = s.Empty()
+	}
+}
+
+// WithRLEStore makes digest keep the histogram buckets run-length encoded,
+// typically reducing memory usage by an order of magnitude for digests
+// that are mostly read and merged. Adding or merging values switches
+// the digest to the regular representation; digest is encoded back
+// by Compact.
+func WithRLEStore() Option {
+	return func(d *Digest) {
+		d.store = &rleStore{}
+	}
+}
+
+// WithChunkedStore makes digest keep the histogram buckets in fixed-size
+// chunks, allocated only for the populated ranges of values. Unlike with
+// the default store, outliers extending the range of values do not cause
+// large reallocations and copies of the buckets.
+func WithChunkedStore() Option {
+	return func(d *Digest) {
+		d.store = &chunkedStore{}
+	}
+}
+
+// WithSmallCounters makes digest keep the histogram bucket counts as 32-bit
+// integers, halving the memory usage. Digest switches to 64-bit counts
+// when any of the counts overflows, so that there is no accuracy impact.
+func WithSmallCounters() Option {
+	return func(d *Digest) {
+		d.store = &smallStore{}
+	}
+}
+
+// WithValueRangeHint makes digest preallocate the histogram buckets
+// for values in [min, max], so that adding the first values does not cause
+// repeated reallocations. Values outside of the range can still be added.
+// Not all stores support preallocation.
+//
+// WithValueRangeHint panics if min and max are not in (0, math.MaxFloat64]
+// or min > max.
+func WithValueRangeHint(min float64, max float64) Option {
+	if math.IsNaN(min) || math.IsNaN(max) || min <= 0 || max > math.MaxFloat64 || min > max {
+		panic("min and max must be in (0, math.MaxFloat64] and min must not be greater than max")
+	}
+
+	return func(d *Digest) {
+		d.hint = [2]float64{min, max}
+	}
+}
+
+// WithInterpolation makes Quantile interpolate linearly between the bounds
+// of the histogram bucket holding the quantile, based on the rank of the
+// quantile among the values of the bucket. It makes quantiles change
+// smoothly instead of in steps, but increases the maximum relative error
+// from err to 2*err/(1-err), since the interpolated value can be anywhere
+// in the bucket rather than in its middle. The weaker guarantee is the one
+// reported by RelativeError, and applies to everything derived from
+// the quantiles, like Summary.
+func WithInterpolation() Option {
+	return func(d *Digest) {
+		d.interpolate = true
+	}
+}
+
+// WithMemoryBudget limits the memory used by the histogram buckets
+// to about n bytes. When the limit is exceeded, digest transparently
+// merges pairs of adjacent buckets, squaring gamma and increasing
+// the relative error to 2*err/(1+err*err), instead of growing further.
+// Use RelativeError to check the current relative error.
+// Digests with custom index mapping are never coarsened,
+// and MappingHDR is never coarsened beyond the powers of 2.
+//
+// Coarsened digest can still be merged with digests having
+// the original relative error, and is restored to it by Reset.
+//
+// WithMemoryBudget panics if n is not positive.
+func WithMemoryBudget(n int) Option {
+	if n <= 0 {
+		panic("n must be positive")
+	}
+
+	return func(d *Digest) {
+		d.budget = n
+	}
+}
+
+// WithExactSamples makes digest keep up to n non-zero values added,
+// in addition to the histogram buckets, so that Quantile is exact
+// until more than n non-zero values are added (or other digest
+// without exact values is merged), and after Reset.
+//
+// WithExactSamples panics if n is not positive.
+func WithExactSamples(n int) Option {
+	if n <= 0 {
+		panic("n must be positive")
+	}
+
+	return func(d *Digest) {
+		d.exactMax = n
+	}
 }
 
 // NewDigest returns digest suitable for calculating quantiles
@@ -47,92 +195,702 @@ type Digest struct {
 // Size of digest is inversely proportional to the relative error.
 // That is, digest with 2% relative error is twice as small
 // as digest with 1% relative error.
-func NewDigest(err float64) *Digest {
+func NewDigest(err float64, opts ...Option) *Digest {
 	if math.IsNaN(err) || err <= 0 || err >= 1 {
 		panic("err must be in (0, 1)")
 	}
 
-	return &Digest{
-		alpha:   err,
-		gamma:   1 + 2*err/(1-err),
-		gammaLn: math.Log1p(2 * err / (1 - err)),
+	return newDigest(err, nil, opts)
+}
+
+// FromValues returns digest with maximum relative error err ∈ (0, 1)
+// and values vs added with AddValues. It is the fastest way
+// to digest a slice of values.
+//
+// FromValues panics if err is outside (0, 1)
+// or any of vs is outside [0, math.MaxFloat64].
+func FromValues(err float64, vs ...float64) *Digest {
+	d := NewDigest(err)
+	d.AddValues(vs...)
+	return d
+}
+
+func newDigest(alpha float64, index IndexMapping, opts []Option) *Digest {
+	d := &Digest{
+		alpha:   alpha,
+		mapping: newBuiltinMapping(MappingLogarithmic, alpha),
+		index:   index,
+		store:   &denseStore{},
+	}
+	for _, opt := range opts {
+		opt(d)
 	}
+	if s, ok := d.store.(reserveStore); ok && d.hint[0] != 0 {
+		s.reserve(d.bucketKey(d.hint[0]), d.bucketKey(d.hint[1]))
+	}
+
+	return d
 }
 
 // Reset resets digest to the initial empty state.
 func (d *Digest) Reset() {
-	d.neg = d.neg[:0]
-	d.pos = d.pos[:0]
-	d.numNeg = 0
-	d.numPos = 0
+	if d.store != nil {
+		d.store.Reset()
+	}
+	d.numNonZero = 0
 	d.numZero = 0
+	d.invalidate()
+	d.exact = d.exact[:0]
+	d.dropped = 0
+	d.budgetSeen = 0
+	if d.fineAlpha != 0 {
+		d.alpha, d.mapping = d.fineAlpha, d.fineMapping
+		d.fineAlpha = 0
+	}
 }
 
+// String returns short digest description. For non-empty digest,
+// it includes the numbers of non-empty and allocated histogram buckets.
 func (d *Digest) String() string {
-	return fmt.Sprintf("Digest(err=%v%%)", d.alpha*100)
+	var buckets string
+	if d.store != nil && d.Count() > 0 {
+		buckets = fmt.Sprintf(", buckets=%v/%v", d.Populated(), d.Size())
+	}
+	if m, ok := d.index.(uniformMapping); ok {
+		return fmt.Sprintf("Digest(abserr=%v, range=[%v, %v]%v)", d.alpha, m.min, m.max, buckets)
+	}
+	if d.index != nil {
+		return fmt.Sprintf("Digest(err=%v%%, mapping=%v%v)", d.alpha*100, d.index, buckets)
+	}
+	if d.mapping.kind != MappingLogarithmic {
+		return fmt.Sprintf("Digest(err=%v%%, mapping=%v%v)", d.alpha*100, d.mapping.kind, buckets)
+	}
+	return fmt.Sprintf("Digest(err=%v%%%v)", d.alpha*100, buckets)
 }
 
-// Size returns the number of histogram buckets.
+// Size returns the number of allocated histogram buckets,
+// including the empty ones. See also Populated.
 func (d *Digest) Size() int {
-	return len(d.neg) + len(d.pos)
+	if d.store == nil {
+		return 0
+	}
+	return d.store.Size()
 }
 
-// Count returns the number of added values.
+// Populated returns the number of non-empty histogram buckets.
+func (d *Digest) Populated() int {
+	n := 0
+	d.ascend(func(_ int, c uint64) bool {
+		if c != 0 {
+			n++
+		}
+		return true
+	})
+
+	return n
+}
+
+// MemoryBytes returns the approximate size of the memory used by the digest
+// in bytes, including the memory allocated for future use.
+func (d *Digest) MemoryBytes() int {
+	return int(unsafe.Sizeof(*d)) + d.cacheBytes() + cap(d.exact)*8 + d.storeBytes()
+}
+
+func (d *Digest) cacheBytes() int {
+	c, _ := d.cum.Load().(*cumulative)
+	if c == nil {
+		return 0
+	}
+	return cap(c.keys)*wordSize + cap(c.sums)*8 + cap(c.exact)*8
+}
+
+func (d *Digest) storeBytes() int {
+	switch s := d.store.(type) {
+	case nil:
+		return 0
+	case memStore:
+		return s.memSize()
+	default:
+		return s.Size() * 8
+	}
+}
+
+// RelativeError returns the maximum relative error of Quantile, which is
+// larger than the one digest was created with if it was coarsened because
+// of WithMemoryBudget, or if WithInterpolation is used.
+func (d *Digest) RelativeError() float64 {
+	if d.interpolate {
+		return 2 * d.alpha / (1 - d.alpha)
+	}
+	return d.alpha
+}
+
+// Compact removes the empty histogram buckets where possible and releases
+// the memory retained by the digest for reuse (for example, after Reset
+// or after adding an outlier), returning the number of bytes freed.
+// Stores other than the ones provided by the package are kept unchanged.
+func (d *Digest) Compact() int {
+	n := d.cacheBytes()
+	d.invalidate()
+
+	if s, ok := d.store.(compactStore); ok {
+		m := s.memSize()
+		s.compact()
+		n += m - s.memSize()
+	}
+
+	return n
+}
+
+// Trim removes the empty histogram buckets with the smallest and
+// the largest keys (which can be left, for example, by unmarshaling
+// padded data), keeping the allocated memory, and returns the number
+// of buckets removed. Binary formats never include such buckets,
+// so digests are trimmed automatically when marshaled.
+// Stores other than the ones provided by the package are kept unchanged.
+func (d *Digest) Trim() int {
+	s, ok := d.store.(trimStore)
+	if !ok {
+		return 0
+	}
+
+	n := s.Size()
+	s.trim()
+	return n - s.Size()
+}
+
+// Prune removes the histogram buckets with the smallest counts,
+// as long as the total number of removed values stays within
+// maxDroppedFraction of the number of values added, and returns
+// the number of values removed by this call. Quantiles of the remaining
+// values differ from the quantiles of all the added values by at most
+// DroppedFraction in rank. Zero values are never removed.
+//
+// Prune panics if maxDroppedFraction is outside [0, 1].
+func (d *Digest) Prune(maxDroppedFraction float64) uint64 {
+	if math.IsNaN(maxDroppedFraction) || maxDroppedFraction < 0 || maxDroppedFraction > 1 {
+		panic("maxDroppedFraction must be in [0, 1]")
+	}
+
+	type bucket struct {
+		k int
+		n uint64
+	}
+	var bs []bucket
+	d.ascend(func(k int, n uint64) bool {
+		if n > 0 {
+			bs = append(bs, bucket{k, n})
+		}
+		return true
+	})
+	sort.SliceStable(bs, func(i, j int) bool {
+		return bs[i].n < bs[j].n
+	})
+
+	limit := maxDroppedFraction * (float64(d.Count()) + float64(d.dropped))
+	m, drop := 0, uint64(0)
+	for ; m < len(bs) && float64(d.dropped+drop+bs[m].n) <= limit; m++ {
+		drop += bs[m].n
+	}
+	if m == 0 {
+		return 0
+	}
+
+	s := d.store
+	d.store = s.Empty()
+	for _, b := range bs[m:] {
+		d.store.Add(b.k, b.n)
+	}
+	d.numNonZero -= drop
+	d.dropped += drop
+	d.exact = d.exact[:0]
+	d.invalidate()
+
+	return drop
+}
+
+// Dropped returns the number of values removed by Prune.
+func (d *Digest) Dropped() uint64 {
+	return d.dropped
+}
+
+// DroppedFraction returns the fraction of values removed by Prune
+// out of all the values added, which bounds the additional rank error
+// of quantiles.
+func (d *Digest) DroppedFraction() float64 {
+	if d.dropped == 0 {
+		return 0
+	}
+	return float64(d.dropped) / (float64(d.Count()) + float64(d.dropped))
+}
+
+// Count returns the number of added values,
+// not including the ones removed by Prune.
 func (d *Digest) Count() uint64 {
-	return d.numNeg + d.numPos + d.numZero
+	return d.numNonZero + d.numZero
+}
+
+// IsEmpty returns whether no values were added to the digest.
+func (d *Digest) IsEmpty() bool {
+	return d.Count() == 0
+}
+
+// Validate checks the internal consistency of the digest, returning
+// a descriptive error if the relative error is invalid, the histogram
+// buckets are not in increasing key order, or their counts do not sum up
+// to the number of non-zero values. It is useful to check digests
+// using custom bucket stores, or coming from untrusted sources, before
+// merging them into long-lived aggregates.
+func (d *Digest) Validate() error {
+	if math.IsNaN(d.alpha) || d.alpha <= 0 || d.alpha > math.MaxFloat64 || (d.index == nil && d.alpha >= 1) {
+		return fmt.Errorf("invalid relative error %v", d.alpha)
+	}
+	if d.index == nil && d.mapping.kind >= numMappings {
+		return fmt.Errorf("unknown mapping %v", d.mapping.kind)
+	}
+	if _, err := addCount(d.numNonZero, d.numZero); err != nil {
+		return err
+	}
+	if d.store == nil {
+		if d.numNonZero != 0 {
+			return fmt.Errorf("no histogram buckets for %v non-zero values", d.numNonZero)
+		}
+		return nil
+	}
+
+	var (
+		sum  uint64
+		prev int
+		err  error
+	)
+	first := true
+	d.store.Ascend(func(k int, n uint64) bool {
+		if !first && k <= prev {
+			err = fmt.Errorf("histogram bucket %v follows bucket %v", k, prev)
+			return false
+		}
+		if sum, err = addCount(sum, n); err != nil {
+			return false
+		}
+		first, prev = false, k
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if sum != d.numNonZero {
+		return fmt.Errorf("histogram bucket counts sum up to %v instead of %v", sum, d.numNonZero)
+	}
+	if d.isExact() {
+		for _, v := range d.exact {
+			if math.IsNaN(v) || v <= 0 || v > math.MaxFloat64 {
+				return fmt.Errorf("invalid exact value %v", v)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Clone returns a copy of the digest, using bucket store of the same kind.
+func (d *Digest) Clone() *Digest {
+	c := &Digest{
+		alpha:       d.alpha,
+		mapping:     d.mapping,
+		index:       d.index,
+		store:       &denseStore{},
+		numNonZero:  d.numNonZero,
+		numZero:     d.numZero,
+		hint:        d.hint,
+		interpolate: d.interpolate,
+		rank:        d.rank,
+		budget:      d.budget,
+		budgetSeen:  d.budgetSeen,
+		fineAlpha:   d.fineAlpha,
+		fineMapping: d.fineMapping,
+		exactMax:    d.exactMax,
+		dropped:     d.dropped,
+	}
+	if d.isExact() {
+		c.exact = append([]float64(nil), d.exact...)
+	}
+	if d.store != nil {
+		c.store = d.store.Empty()
+		mergeStores(c.store, d.store)
+	}
+
+	return c
 }
 
 // Merge merges the content of v into the digest.
 // Merge preserves relative error guarantees of Quantile.
 //
-// Merge returns an error if digests have different relative errors.
+// Merge returns an error if digests have different relative errors,
+// or ErrOverflow if the total count overflows; the digest is left
+// unchanged in that case.
 func (d *Digest) Merge(v *Digest) error {
+	if _, err := addCount(d.Count(), v.Count()); err != nil {
+		return err
+	}
+	n, err := d.checkMergeCoarse(v)
+	if err != nil {
+		return err
+	}
+
+	if n < 0 {
+		d.mergeCoarse(v, -n)
+		return nil
+	}
+	for ; n > 0; n-- {
+		d.coarsen()
+	}
+	d.merge(v)
+	return nil
+}
+
+// MergeAll merges the content of all of ds into the digest, like Merge,
+// but faster than merging them one by one: the histogram buckets
+// are grown only once to fit all the digests.
+//
+// MergeAll returns an error if any of ds has different relative error,
+// or ErrOverflow if the total count overflows; the digest is left
+// unchanged in that case.
+func (d *Digest) MergeAll(ds ...*Digest) error {
+	total := d.Count()
+	for _, v := range ds {
+		if err := d.checkMerge(v); err != nil {
+			return err
+		}
+		var err error
+		if total, err = addCount(total, v.Count()); err != nil {
+			return err
+		}
+	}
+
+	if s, ok := d.store.(*denseStore); ok {
+		lenNeg, lenPos := len(s.neg), len(s.pos)
+		for _, v := range ds {
+			if vs, ok := v.store.(*denseStore); ok {
+				if len(vs.neg) > lenNeg {
+					lenNeg = len(vs.neg)
+				}
+				if len(vs.pos) > lenPos {
+					lenPos = len(vs.pos)
+				}
+			}
+		}
+		s.neg = grow(s.neg, lenNeg-1)
+		s.pos = grow(s.pos, lenPos-1)
+	}
+	for _, v := range ds {
+		d.merge(v)
+	}
+
+	return nil
+}
+
+// MergeWeighted merges the content of v into the digest like Merge,
+// with the counts of v multiplied by weight w and rounded to the nearest
+// integers. It can be used to merge digests of values sampled at different
+// rates, or to reduce the weight of older digests.
+//
+// MergeWeighted returns an error if digests have different relative errors,
+// or ErrOverflow if the total count overflows; the digest is left
+// unchanged in that case.
+// MergeWeighted panics if w is negative, infinite or NaN.
+func (d *Digest) MergeWeighted(v *Digest, w float64) error {
+	if math.IsNaN(w) || w < 0 || w > math.MaxFloat64 {
+		panic("w must be in [0, math.MaxFloat64]")
+	}
+	if w == 1 {
+		return d.Merge(v)
+	}
+	n, err := d.checkMergeCoarse(v)
+	if err != nil {
+		return err
+	}
+
+	scale := func(n uint64) uint64 {
+		return uint64(math.Round(float64(n) * w))
+	}
+	total := float64(scale(v.numZero))
+	v.ascend(func(_ int, n uint64) bool {
+		total += float64(scale(n))
+		return true
+	})
+	if total >= float64(math.MaxUint64-d.Count()) {
+		return ErrOverflow
+	}
+
+	if v == d {
+		v = d.Clone()
+	}
+	shift := 0
+	if n < 0 {
+		shift = -n
+	}
+	for ; n > 0; n-- {
+		d.coarsen()
+	}
+	d.numZero += scale(v.numZero)
+	d.dropped += scale(v.dropped)
+	v.ascend(func(k int, c uint64) bool {
+		if m := scale(c); m > 0 {
+			d.addKey(-(-k >> shift), m)
+		}
+		return true
+	})
+	d.fitBudget()
+
+	return nil
+}
+
+func (d *Digest) checkMerge(v *Digest) error {
 	if v.alpha != d.alpha {
 		return fmt.Errorf("can not merge digest with relative error %v%% into one with %v%%", v.alpha*100, d.alpha*100)
 	}
+	if v.mapping.kind != d.mapping.kind || v.index != d.index {
+		return fmt.Errorf("can not merge digests with different mappings")
+	}
+
+	return nil
+}
 
-	d.neg = grow(d.neg, len(v.neg)-1)
-	for i, n := range v.neg {
-		d.neg[i] += n
+// checkMergeCoarse is like checkMerge, but with the memory budget it also
+// accepts digests with the same mapping coarsened a different number
+// of times, returning the number of times d has to be coarsened to merge v,
+// or minus the number of times the keys of v have to be coarsened.
+func (d *Digest) checkMergeCoarse(v *Digest) (int, error) {
+	if d.budget != 0 && v.alpha != d.alpha && v.index == nil && d.index == nil {
+		if n := v.mapping.doublings(d.mapping); n > 0 {
+			return -n, nil
+		}
+		if n := d.mapping.doublings(v.mapping); n > 0 {
+			c := &Digest{alpha: (v.mapping.gamma - 1) / (v.mapping.gamma + 1), mapping: v.mapping}
+			if err := c.checkMerge(v); err != nil {
+				return 0, err
+			}
+			return n, nil
+		}
+	}
+
+	return 0, d.checkMerge(v)
+}
+
+func (d *Digest) merge(v *Digest) {
+	if d.exactMax > 0 && d.isExact() && v.numNonZero > 0 {
+		if v.isExact() && len(d.exact)+len(v.exact) <= d.exactMax {
+			d.exact = append(d.exact, v.exact...)
+		} else {
+			d.exact = d.exact[:0]
+		}
+		d.invalidate()
 	}
-	d.pos = grow(d.pos, len(v.pos)-1)
-	for i, n := range v.pos {
-		d.pos[i] += n
+	if v.store != nil {
+		mergeStores(d.store, v.store)
+		d.invalidate()
 	}
-	d.numNeg += v.numNeg
-	d.numPos += v.numPos
+	d.numNonZero += v.numNonZero
 	d.numZero += v.numZero
+	d.dropped += v.dropped
+	d.fitBudget()
+}
 
-	return nil
+// mergeSaturating merges v like Merge, but instead of failing with
+// ErrOverflow it drops the largest values of v which do not fit
+// in the total count.
+func (d *Digest) mergeSaturating(v *Digest) error {
+	if r := math.MaxUint64 - d.Count(); v.Count() > r {
+		v = v.truncated(r)
+	}
+	return d.Merge(v)
+}
+
+// truncated returns a copy of d with only n of its smallest values,
+// suitable for merging only.
+func (d *Digest) truncated(n uint64) *Digest {
+	t := &Digest{alpha: d.alpha, mapping: d.mapping, index: d.index, store: &denseStore{}}
+	t.numZero = d.numZero
+	if t.numZero > n {
+		t.numZero = n
+	}
+	n -= t.numZero
+	d.ascend(func(k int, c uint64) bool {
+		if c > n {
+			c = n
+		}
+		if c > 0 {
+			t.addKey(k, c)
+			n -= c
+		}
+		return n > 0
+	})
+
+	return t
+}
+
+// addKeySaturating is like addKey, but drops the values
+// which do not fit in the total count.
+func (d *Digest) addKeySaturating(k int, n uint64) {
+	if r := math.MaxUint64 - d.Count(); n > r {
+		n = r
+	}
+	if n > 0 {
+		d.addKey(k, n)
+	}
+}
+
+// mergeCoarse merges v, which has n times less coarsened mapping.
+func (d *Digest) mergeCoarse(v *Digest, n int) {
+	v.ascend(func(k int, c uint64) bool {
+		d.store.Add(-(-k >> n), c)
+		return true
+	})
+	d.numNonZero += v.numNonZero
+	d.numZero += v.numZero
+	d.dropped += v.dropped
+	d.invalidate()
+	d.fitBudget()
+}
+
+// fitBudget coarsens the digest until the store fits in the memory
+// budget, giving up until the store grows if coarsening does not help.
+func (d *Digest) fitBudget() {
+	for {
+		n := d.storeBytes()
+		if d.budget == 0 || n <= d.budget || n <= d.budgetSeen {
+			return
+		}
+		if !d.coarsen() || d.storeBytes() >= n {
+			d.budgetSeen = d.storeBytes()
+			return
+		}
+	}
+}
+
+// coarsen merges pairs of adjacent buckets, squaring gamma.
+func (d *Digest) coarsen() bool {
+	if d.index != nil {
+		return false
+	}
+	m, ok := d.mapping.doubled()
+	if !ok {
+		return false
+	}
+
+	if d.fineAlpha == 0 {
+		d.fineAlpha, d.fineMapping = d.alpha, d.mapping
+	}
+	s := d.store.Empty()
+	d.store.Ascend(func(k int, n uint64) bool {
+		s.Add(-(-k >> 1), n)
+		return true
+	})
+	d.store = s
+	d.alpha = (m.gamma - 1) / (m.gamma + 1)
+	d.mapping = m
+	d.invalidate()
+
+	return true
 }
 
 // Add adds finite non-negative value v to the digest.
 //
-// Add panics if v is outside [0, math.MaxFloat64].
+// Add panics if v is outside [0, math.MaxFloat64],
+// or if the total count would overflow.
 func (d *Digest) Add(v float64) {
 	if math.IsNaN(v) || v < 0 || v > math.MaxFloat64 {
 		panic("v must be in [0, math.MaxFloat64]")
 	}
+	if d.Count() == math.MaxUint64 {
+		panic("total count overflow")
+	}
 
 	if v == 0 {
 		d.numZero++
 		return
 	}
 
-	k := d.bucketKey(v)
-	if k < 1 {
-		d.neg = grow(d.neg, -k)
-		d.neg[-k]++
-		d.numNeg++
-	} else {
-		d.pos = grow(d.pos, k-1)
-		d.pos[k-1]++
-		d.numPos++
+	if d.exactMax > 0 && d.isExact() {
+		if len(d.exact) < d.exactMax {
+			d.exact = append(d.exact, v)
+		} else {
+			d.exact = d.exact[:0]
+		}
+	}
+	d.addKey(d.bucketKey(v), 1)
+	if d.budget != 0 {
+		d.fitBudget()
+	}
+}
+
+// AddValues adds finite non-negative values vs to the digest, like
+// calling Add for each of them, but faster: the histogram buckets
+// are grown only once to fit all the values.
+//
+// AddValues panics if any of vs is outside [0, math.MaxFloat64],
+// or if the total count would overflow; the digest is left unchanged
+// in that case.
+func (d *Digest) AddValues(vs ...float64) {
+	lo, hi, numZero := math.MaxInt, math.MinInt, 0
+	for _, v := range vs {
+		if math.IsNaN(v) || v < 0 || v > math.MaxFloat64 {
+			panic("v must be in [0, math.MaxFloat64]")
+		}
+		if v == 0 {
+			numZero++
+			continue
+		}
+		k := d.bucketKey(v)
+		if k < lo {
+			lo = k
+		}
+		if k > hi {
+			hi = k
+		}
+	}
+	if d.Count() > math.MaxUint64-uint64(len(vs)) {
+		panic("total count overflow")
+	}
+	d.numZero += uint64(numZero)
+	if numZero == len(vs) {
+		return
+	}
+
+	if d.exactMax > 0 && d.isExact() {
+		if len(d.exact)+len(vs)-numZero <= d.exactMax {
+			for _, v := range vs {
+				if v != 0 {
+					d.exact = append(d.exact, v)
+				}
+			}
+		} else {
+			d.exact = d.exact[:0]
+		}
+	}
+	if s, ok := d.store.(reserveStore); ok {
+		s.reserve(lo, hi)
+	}
+	for _, v := range vs {
+		if v != 0 {
+			d.addKey(d.bucketKey(v), 1)
+		}
+	}
+	if d.budget != 0 {
+		d.fitBudget()
 	}
 }
 
 // Quantile returns the q-quantile of added values
-// with a maximum relative error of err.
+// with a maximum relative error of err (unless WithInterpolation is used),
+// or exactly while all the values are kept because of WithExactSamples.
+// For n values, the quantile is estimated as the value of 0-based rank
+// ⌊q*(n-1)⌋ among the sorted values, unless WithRankMethod is used.
+//
+// Quantile caches the cumulative counts of histogram buckets
+// until the next modification of the digest, which makes subsequent
+// calls fast and allocation-free. Like the other methods not modifying
+// the digest, Quantile can be called concurrently with them.
 //
 // Quantile panics if q is outside [0, 1].
 // Quantile returns NaN for empty digest.
@@ -141,144 +899,459 @@ func (d *Digest) Quantile(q float64) float64 {
 		panic("q must be in [0, 1]")
 	}
 
-	if d.Count() == 0 {
-		return math.NaN()
-	}
-
-	rank := uint64(1 + q*float64(d.Count()-1))
-	if rank <= d.numZero {
-		return 0
-	} else if rank <= d.numZero+d.numNeg {
-		k := rankIndexRev(rank-d.numZero, d.neg)
-		return d.quantile(-k)
-	} else {
-		k := rankIndex(rank-d.numZero-d.numNeg, d.pos)
-		return d.quantile(k + 1)
-	}
+	return d.quantileAt(d.cumulative(), q)
 }
 
 // MarshalBinary implements the encoding.BinaryMarshaler interface.
+//
+// Binary representation starts with a magic number and a format version
+// byte, followed by relative error, number of zero values, numbers
+// of histogram buckets and their counts as fixed-width integers.
 func (d *Digest) MarshalBinary() ([]byte, error) {
-	size := headerSize + len(d.neg)*8 + len(d.pos)*8
-	buf := make([]byte, size)
-	i := 0
-
-	binary.LittleEndian.PutUint64(buf[i:], math.Float64bits(d.alpha))
-	i += 8
-	binary.LittleEndian.PutUint64(buf[i:], d.numZero)
-	i += 8
-	binary.LittleEndian.PutUint32(buf[i:], uint32(len(d.neg)))
-	i += 4
-	binary.LittleEndian.PutUint32(buf[i:], uint32(len(d.pos)))
-	i += 4
-	for _, b := range d.neg {
+	return d.AppendBinary(nil)
+}
+
+// AppendBinary appends the binary representation of the digest
+// (as returned by MarshalBinary) to dst and returns the extended buffer.
+// AppendBinary does not allocate if dst has enough spare capacity.
+func (d *Digest) AppendBinary(dst []byte) ([]byte, error) {
+	if d.index != nil {
+		return dst, errCustomMapping
+	}
+
+	neg, pos := d.buckets()
+	size := prefixSize + headerSize + len(neg)*8 + len(pos)*8
+	dst = growBytes(dst, size)
+	buf := dst[len(dst) : len(dst)+size]
+	putPrefix(buf, d.version(formatFixed))
+	d.putHeader(buf[prefixSize:], len(neg), len(pos))
+	i := prefixSize + headerSize
+
+	for _, b := range neg {
 		binary.LittleEndian.PutUint64(buf[i:], b)
 		i += 8
 	}
-	for _, b := range d.pos {
+	for _, b := range pos {
 		binary.LittleEndian.PutUint64(buf[i:], b)
 		i += 8
 	}
 
-	return buf, nil
+	return dst[:len(dst)+size], nil
 }
 
 // UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+//
+// UnmarshalBinary accepts data produced by MarshalBinary, MarshalCompact
+// and MarshalOptions, as well as the legacy unversioned format
+// which lacks the magic number and the format version.
+// It applies the default limits of UnmarshalOptions.
 func (d *Digest) UnmarshalBinary(data []byte) error {
+	return UnmarshalOptions{}.Unmarshal(data, d)
+}
+
+func (d *Digest) unmarshalFixed(data []byte, m Mapping, maxBuckets uint64) error {
 	if len(data) < headerSize {
 		return fmt.Errorf("not enough data to read header: %v bytes instead of minimum %v", len(data), headerSize)
 	}
 
-	i := 0
-	alpha := math.Float64frombits(binary.LittleEndian.Uint64(data[i:]))
-	i += 8
-	if math.IsNaN(alpha) || alpha <= 0 || alpha >= 1 {
-		return fmt.Errorf("invalid relative error %v", alpha)
+	alpha, numZero, lenNeg, lenPos, err := parseHeader(data)
+	if err != nil {
+		return err
 	}
-	numZero := binary.LittleEndian.Uint64(data[i:])
-	i += 8
-	lenNeg := binary.LittleEndian.Uint32(data[i:])
-	i += 4
-	lenPos := binary.LittleEndian.Uint32(data[i:])
-	i += 4
+	err = checkBuckets(uint64(lenNeg), uint64(lenPos), maxBuckets)
+	if err != nil {
+		return err
+	}
+	i := headerSize
 
-	if uint32(len(data[i:])) != (lenNeg+lenPos)*8 {
-		return fmt.Errorf("wrong histograms data size: %v bytes instead of %v", len(data[i:]), (lenNeg+lenPos)*8)
+	if size := (uint64(lenNeg) + uint64(lenPos)) * 8; uint64(len(data[i:])) != size {
+		return fmt.Errorf("wrong histograms data size: %v bytes instead of %v", len(data[i:]), size)
 	}
+	num := uint64(0)
 	var neg []uint64
-	numNeg := uint64(0)
 	if lenNeg > 0 {
 		neg = make([]uint64, lenNeg)
 		for j := range neg {
 			v := binary.LittleEndian.Uint64(data[i:])
-			numNeg += v
+			num, err = addCount(num, v)
+			if err != nil {
+				return err
+			}
 			neg[j] = v
 			i += 8
 		}
 	}
 	var pos []uint64
-	numPos := uint64(0)
 	if lenPos > 0 {
 		pos = make([]uint64, lenPos)
 		for j := range pos {
 			v := binary.LittleEndian.Uint64(data[i:])
-			numPos += v
+			num, err = addCount(num, v)
+			if err != nil {
+				return err
+			}
 			pos[j] = v
 			i += 8
 		}
 	}
 
-	*d = Digest{
-		alpha:   alpha,
-		gamma:   1 + 2*alpha/(1-alpha),
-		gammaLn: math.Log1p(2 * alpha / (1 - alpha)),
-		neg:     neg,
-		pos:     pos,
-		numNeg:  numNeg,
-		numPos:  numPos,
-		numZero: numZero,
+	return d.setFrom(alpha, m, numZero, neg, pos, num)
+}
+
+// WriteTo implements the io.WriterTo interface. It writes digest
+// in the format of MarshalBinary, without materializing it in memory.
+func (d *Digest) WriteTo(w io.Writer) (int64, error) {
+	if d.index != nil {
+		return 0, errCustomMapping
+	}
+
+	var buf [512]byte
+	neg, pos := d.buckets()
+	putPrefix(buf[:], d.version(formatFixed))
+	d.putHeader(buf[prefixSize:], len(neg), len(pos))
+	i := prefixSize + headerSize
+
+	n := int64(0)
+	for _, buckets := range [][]uint64{neg, pos} {
+		for _, b := range buckets {
+			if i+8 > len(buf) {
+				m, err := w.Write(buf[:i])
+				n += int64(m)
+				if err != nil {
+					return n, err
+				}
+				i = 0
+			}
+			binary.LittleEndian.PutUint64(buf[i:], b)
+			i += 8
+		}
+	}
+	m, err := w.Write(buf[:i])
+	n += int64(m)
+
+	return n, err
+}
+
+// ReadFrom implements the io.ReaderFrom interface. It reads exactly
+// one digest in any of the formats accepted by UnmarshalBinary, so that
+// multiple digests can be read in sequence from a single stream.
+// ReadFrom returns io.EOF only if no data was read. It applies
+// the default limits of UnmarshalOptions.
+func (d *Digest) ReadFrom(r io.Reader) (int64, error) {
+	return UnmarshalOptions{}.Decode(r, d)
+}
+
+// readFixed reads histogram buckets of the fixed-width format from r,
+// with buf containing the already read header.
+func (d *Digest) readFixed(r io.Reader, buf []byte, m Mapping, maxBuckets uint64) (int64, error) {
+	alpha, numZero, lenNeg, lenPos, err := parseHeader(buf)
+	if err != nil {
+		return 0, err
+	}
+	err = checkBuckets(uint64(lenNeg), uint64(lenPos), maxBuckets)
+	if err != nil {
+		return 0, err
+	}
+	neg, numNeg, n, err := readBuckets(r, int(lenNeg), buf)
+	if err != nil {
+		return n, err
+	}
+	pos, numPos, n2, err := readBuckets(r, int(lenPos), buf)
+	n += n2
+	if err != nil {
+		return n, err
+	}
+	num, err := addCount(numNeg, numPos)
+	if err != nil {
+		return n, err
+	}
+
+	return n, d.setFrom(alpha, m, numZero, neg, pos, num)
+}
+
+// version returns the format version byte of the given format,
+// which also records the mapping of the digest.
+func (d *Digest) version(format byte) byte {
+	return format | byte(d.mapping.kind)<<mappingShift
+}
+
+func putPrefix(buf []byte, version byte) {
+	copy(buf, magic)
+	buf[len(magic)] = version
+}
+
+// hasPrefix reports whether data starts with the magic number,
+// as opposed to data in the legacy unversioned format.
+func hasPrefix(data []byte) bool {
+	return len(data) >= prefixSize && string(data[:len(magic)]) == magic
+}
+
+func (d *Digest) putHeader(buf []byte, lenNeg int, lenPos int) {
+	binary.LittleEndian.PutUint64(buf[0:], math.Float64bits(d.alpha))
+	binary.LittleEndian.PutUint64(buf[8:], d.numZero)
+	binary.LittleEndian.PutUint32(buf[16:], uint32(lenNeg))
+	binary.LittleEndian.PutUint32(buf[20:], uint32(lenPos))
+}
+
+func parseHeader(data []byte) (alpha float64, numZero uint64, lenNeg uint32, lenPos uint32, err error) {
+	alpha = math.Float64frombits(binary.LittleEndian.Uint64(data[0:]))
+	if math.IsNaN(alpha) || alpha <= 0 || alpha >= 1 {
+		return 0, 0, 0, 0, fmt.Errorf("invalid relative error %v", alpha)
 	}
+	numZero = binary.LittleEndian.Uint64(data[8:])
+	lenNeg = binary.LittleEndian.Uint32(data[16:])
+	lenPos = binary.LittleEndian.Uint32(data[20:])
+
+	return alpha, numZero, lenNeg, lenPos, nil
+}
+
+// readBuckets reads n buckets from r using buf for buffering.
+// Memory is allocated as the data arrives, not upfront.
+func readBuckets(r io.Reader, n int, buf []byte) ([]uint64, uint64, int64, error) {
+	var buckets []uint64
+	sum := uint64(0)
+	read := int64(0)
+	for len(buckets) < n {
+		m := (n - len(buckets)) * 8
+		if m > len(buf) {
+			m = len(buf)
+		}
+		k, err := io.ReadFull(r, buf[:m])
+		read += int64(k)
+		if err != nil {
+			return nil, 0, read, fmt.Errorf("failed to read histogram buckets: %w", err)
+		}
+		for i := 0; i < m; i += 8 {
+			v := binary.LittleEndian.Uint64(buf[i:])
+			sum, err = addCount(sum, v)
+			if err != nil {
+				return nil, 0, read, err
+			}
+			buckets = append(buckets, v)
+		}
+	}
+
+	return buckets, sum, read, nil
+}
+
+// GobEncode implements the gob.GobEncoder interface
+// using the binary representation of MarshalBinary.
+func (d *Digest) GobEncode() ([]byte, error) {
+	return d.MarshalBinary()
+}
+
+// GobDecode implements the gob.GobDecoder interface.
+func (d *Digest) GobDecode(data []byte) error {
+	return d.UnmarshalBinary(data)
+}
+
+// MarshalText implements the encoding.TextMarshaler interface.
+//
+// The text form consists of the relative error, followed by the number
+// of zero values (if any) as zero:count, followed by key:count pairs
+// for each non-empty histogram bucket in increasing key order,
+// all separated by spaces, e.g. "0.01 zero:3 -2:1 5:7".
+func (d *Digest) MarshalText() ([]byte, error) {
+	if d.index != nil {
+		return nil, errCustomMapping
+	}
+
+	buf := strconv.AppendFloat(nil, d.alpha, 'g', -1, 64)
+	if d.mapping.kind != MappingLogarithmic {
+		buf = append(buf, " mapping:"...)
+		buf = append(buf, d.mapping.kind.String()...)
+	}
+	if d.numZero > 0 {
+		buf = append(buf, " zero:"...)
+		buf = strconv.AppendUint(buf, d.numZero, 10)
+	}
+	d.ascend(func(k int, n uint64) bool {
+		if n > 0 {
+			buf = append(buf, ' ')
+			buf = strconv.AppendInt(buf, int64(k), 10)
+			buf = append(buf, ':')
+			buf = strconv.AppendUint(buf, n, 10)
+		}
+		return true
+	})
+
+	return buf, nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (d *Digest) UnmarshalText(text []byte) error {
+	fields := strings.Fields(string(text))
+	if len(fields) == 0 {
+		return fmt.Errorf("no relative error in digest text")
+	}
+
+	alpha, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil || math.IsNaN(alpha) || alpha <= 0 || alpha >= 1 {
+		return fmt.Errorf("invalid relative error %q", fields[0])
+	}
+	v := &Digest{store: d.store}
+	v.reinit(alpha, MappingLogarithmic)
+	for j, f := range fields[1:] {
+		i := strings.IndexByte(f, ':')
+		if i < 0 {
+			return fmt.Errorf("invalid bucket %q: expected key:count", f)
+		}
+		if j == 0 && f[:i] == "mapping" {
+			m, err := parseMapping(f[i+1:])
+			if err != nil {
+				return err
+			}
+			v.mapping = newBuiltinMapping(m, alpha)
+			continue
+		}
+		n, err := strconv.ParseUint(f[i+1:], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid count in bucket %q: %w", f, err)
+		}
+		if _, err := addCount(v.Count(), n); err != nil {
+			return err
+		}
+		if f[:i] == "zero" {
+			v.numZero += n
+			continue
+		}
+		k, err := strconv.ParseInt(f[:i], 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid key in bucket %q: %w", f, err)
+		}
+		if k < 1-DefaultMaxBuckets || k > DefaultMaxBuckets {
+			return fmt.Errorf("bucket key %v is out of range", k)
+		}
+		if n > 0 {
+			v.addKey(int(k), n)
+		}
+	}
+
+	*d = *v
 
 	return nil
 }
 
+// setFrom sets the digest state from the decoded data, keeping the kind
+// of bucket store, and making sure that the total count does not overflow.
+func (d *Digest) setFrom(alpha float64, m Mapping, numZero uint64, neg []uint64, pos []uint64, num uint64) error {
+	if _, err := addCount(num, numZero); err != nil {
+		return err
+	}
+
+	v := Digest{store: d.store}
+	v.reinit(alpha, m)
+	if _, ok := v.store.(*denseStore); ok {
+		v.store = &denseStore{neg: neg, pos: pos}
+	} else {
+		mergeStores(v.store, &denseStore{neg: neg, pos: pos})
+	}
+	v.numNonZero = num
+	v.numZero = numZero
+	*d = v
+
+	return nil
+}
+
+// reinit sets the relative error and the mapping of the digest and makes
+// it empty, using new empty bucket store of the current kind.
+func (d *Digest) reinit(alpha float64, m Mapping) {
+	d.alpha = alpha
+	d.mapping = newBuiltinMapping(m, alpha)
+	d.index = nil
+	if d.store == nil {
+		d.store = &denseStore{}
+	} else {
+		d.store = d.store.Empty()
+	}
+	d.numNonZero = 0
+	d.numZero = 0
+	d.fineAlpha = 0
+}
+
+// isExact returns whether all the non-zero values are kept in exact.
+func (d *Digest) isExact() bool {
+	return d.exactMax > 0 && uint64(len(d.exact)) == d.numNonZero
+}
+
 func (d *Digest) bucketKey(x float64) int {
-	logGammaX := math.Log(x) / d.gammaLn
-	return int(math.Ceil(logGammaX))
+	if d.index != nil {
+		return d.index.Index(x)
+	}
+	return d.mapping.Index(x)
 }
 
 func (d *Digest) quantile(k int) float64 {
-	powGammaK := math.Exp(float64(k) * d.gammaLn)
-	return 2 * powGammaK / (d.gamma + 1)
+	if d.index != nil {
+		return d.index.Value(k)
+	}
+	return d.mapping.Value(k)
 }
 
-func grow(buckets []uint64, ix int) []uint64 {
-	n := ix + 1 - len(buckets)
-	if n <= 0 {
-		return buckets
+// invalidate drops the cached cumulative counts.
+func (d *Digest) invalidate() {
+	if c, _ := d.cum.Load().(*cumulative); c != nil {
+		d.cum.Store((*cumulative)(nil))
 	}
+}
 
-	return append(buckets, make([]uint64, n)...)
+func (d *Digest) addKey(k int, n uint64) {
+	d.store.Add(k, n)
+	d.numNonZero += n
+	d.invalidate()
 }
 
-func rankIndexRev(rank uint64, buckets []uint64) int {
-	n := uint64(0)
-	for i := len(buckets) - 1; i >= 0; i-- {
-		n += buckets[i]
-		if n >= rank {
-			return i
-		}
+func (d *Digest) lowerBound(k int) float64 {
+	if d.index != nil {
+		return d.index.LowerBound(k)
 	}
-	return 0
+	return d.mapping.LowerBound(k)
 }
 
-func rankIndex(rank uint64, buckets []uint64) int {
-	n := uint64(0)
-	for i, b := range buckets {
-		n += b
-		if n >= rank {
-			return i
-		}
+// ascend calls f for each histogram bucket in increasing key order,
+// until f returns false.
+func (d *Digest) ascend(f func(k int, n uint64) bool) {
+	if d.store != nil {
+		d.store.Ascend(f)
+	}
+}
+
+// buckets returns the histogram buckets in the layout of the binary formats,
+// without the empty buckets with the smallest and the largest keys.
+func (d *Digest) buckets() ([]uint64, []uint64) {
+	if d.store == nil {
+		return nil, nil
+	}
+	neg, pos := denseBuckets(d.store)
+	return trimTrailing(neg), trimTrailing(pos)
+}
+
+// growBytes ensures that buf has at least n bytes of spare capacity.
+func growBytes(buf []byte, n int) []byte {
+	if cap(buf)-len(buf) >= n {
+		return buf
+	}
+
+	b := make([]byte, len(buf), len(buf)+n)
+	copy(b, buf)
+	return b
+}
+
+func reserve[T uint32 | uint64](buckets []T, n int) []T {
+	if cap(buckets) >= n {
+		return buckets
 	}
-	return len(buckets) - 1
+
+	b := make([]T, len(buckets), n)
+	copy(b, buckets)
+	return b
+}
+
+func grow[T uint32 | uint64 | float64](buckets []T, ix int) []T {
+	n := ix + 1 - len(buckets)
+	if n <= 0 {
+		return buckets
+	}
+
+	return append(buckets, make([]T, n)...)
 }
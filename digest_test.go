@@ -15,6 +15,9 @@
 package bdigest_test
 
 import (
+	"bytes"
+	"encoding/gob"
+	"io"
 	"math"
 	"math/rand"
 	"reflect"
@@ -203,6 +206,8 @@ type digestMachine struct {
 	min     float64
 	max     float64
 	err     float64
+	mapping bdigest.Mapping
+	interp  bool
 	digests []digestPair
 }
 
@@ -219,6 +224,16 @@ func (m *digestMachine) Init(t *rapid.T) {
 	m.min = rapid.Float64Range(minVal, 1-1e-10).Draw(t, "digest min")
 	m.max = rapid.Float64Range(1+1e-10, maxVal).Draw(t, "digest max")
 	m.err = rapid.Float64Range(minErr, 1-1e-5).Draw(t, "relative error")
+	m.mapping = rapid.SampledFrom(mappings).Draw(t, "mapping")
+	m.interp = rapid.Bool().Draw(t, "interpolation")
+}
+
+// maxErr returns the maximum relative error of quantiles.
+func (m *digestMachine) maxErr() float64 {
+	if m.interp {
+		return 2 * m.err / (1 - m.err)
+	}
+	return m.err
 }
 
 func (m *digestMachine) Check(*rapid.T) {}
@@ -232,8 +247,13 @@ func (m *digestMachine) AddDigest(t *rapid.T) {
 	gen := rapid.SampledFrom(generatorNames).Draw(t, "generator")
 	seed := rapid.Int64().Draw(t, "seed")
 	count := rapid.IntRange(0, maxSize).Draw(t, "count")
+	store := rapid.SampledFrom(storeNames).Draw(t, "store")
 
-	d := &approxDigest{bdigest.NewDigest(m.err)}
+	opts := append([]bdigest.Option{bdigest.WithMapping(m.mapping)}, stores[store]...)
+	if m.interp {
+		opts = append(opts, bdigest.WithInterpolation())
+	}
+	d := &approxDigest{bdigest.NewDigest(m.err, opts...)}
 	r := &perfectDigest{values: make([]float64, 0, count)}
 	t.Logf("using %v/%v for %v:", gen, count, d.Digest)
 
@@ -255,7 +275,8 @@ func (m *digestMachine) AddDigest(t *rapid.T) {
 	}
 
 	q := rapid.Float64Range(0, 1).Draw(t, "quantile")
-	checkDigest(t, d, r, q, m.err)
+	checkDigest(t, d, r, q, m.maxErr())
+	checkBounds(t, d, r, q)
 
 	m.digests = append(m.digests, digestPair{d, r})
 }
@@ -272,7 +293,8 @@ func (m *digestMachine) MergeDigests(t *rapid.T) {
 	to.r.Merge(from.r)
 
 	q := rapid.Float64Range(0, 1).Draw(t, "quantile")
-	checkDigest(t, to.d, to.r, q, m.err)
+	checkDigest(t, to.d, to.r, q, m.maxErr())
+	checkBounds(t, to.d.(*approxDigest), to.r, q)
 }
 
 func checkDigest(t *rapid.T, d digest, r digest, q float64, err float64) {
@@ -348,3 +370,526 @@ func TestDigest_Reset(t *testing.T) {
 		}
 	})
 }
+
+func TestDigest_MergeAll(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			err   = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			n     = rapid.IntRange(0, 10).Draw(t, "digests")
+			store = rapid.SampledFrom(storeNames).Draw(t, "store")
+		)
+
+		ds := make([]*bdigest.Digest, n)
+		for i := range ds {
+			count := rapid.IntRange(0, 1000).Draw(t, "count")
+			ds[i] = logNormalDigest(err, int64(i), count, int32(count)/10, stores[rapid.SampledFrom(storeNames).Draw(t, "input store")]...)
+		}
+
+		d1 := logNormalDigest(err, -1, 100, 10, stores[store]...)
+		d2 := logNormalDigest(err, -1, 100, 10, stores[store]...)
+		if e := d1.MergeAll(ds...); e != nil {
+			t.Fatalf("failed to merge digests: %v", e)
+		}
+		for _, d := range ds {
+			if e := d2.Merge(d); e != nil {
+				t.Fatalf("failed to merge digests: %v", e)
+			}
+		}
+		data1, _ := d1.MarshalBinary()
+		data2, _ := d2.MarshalBinary()
+		if string(data1) != string(data2) {
+			t.Fatalf("MergeAll data %q differs from Merge data %q", data1, data2)
+		}
+
+		if e := d1.MergeAll(append(ds, bdigest.NewDigest(err/2))...); e == nil {
+			t.Fatalf("successfully merged digest with different relative error")
+		}
+		data3, _ := d1.MarshalBinary()
+		if string(data1) != string(data3) {
+			t.Fatalf("digest has changed after failed MergeAll")
+		}
+	})
+}
+
+func TestDigest_MergeWeighted(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			err   = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			seed  = rapid.Int64().Draw(t, "seed")
+			count = rapid.IntRange(0, 1000).Draw(t, "count")
+			store = rapid.SampledFrom(storeNames).Draw(t, "store")
+			k     = rapid.IntRange(0, 5).Draw(t, "weight")
+		)
+
+		v := logNormalDigest(err, seed, count, int32(count)/10, stores[store]...)
+		d1 := logNormalDigest(err, -1, 100, 10)
+		d2 := logNormalDigest(err, -1, 100, 10)
+		if e := d1.MergeWeighted(v, float64(k)); e != nil {
+			t.Fatalf("failed to merge digest: %v", e)
+		}
+		for i := 0; i < k; i++ {
+			_ = d2.Merge(v)
+		}
+		data1, _ := d1.MarshalBinary()
+		data2, _ := d2.MarshalBinary()
+		if string(data1) != string(data2) {
+			t.Fatalf("digest merged with weight %v data %q differs from %q", k, data1, data2)
+		}
+
+		d3 := bdigest.NewDigest(err)
+		if e := d3.MergeWeighted(v, 0.5); e != nil {
+			t.Fatalf("failed to merge digest: %v", e)
+		}
+		if c := d3.Count(); math.Abs(float64(c)-float64(v.Count())/2) > float64(v.Populated()+1) {
+			t.Fatalf("count of digest merged with weight 0.5 is %v instead of about %v", c, v.Count()/2)
+		}
+	})
+}
+
+func TestDigestMarshalTextRoundtrip(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-5, 1-1e-5).Draw(t, "relative error")
+			seed   = rapid.Int64().Draw(t, "seed")
+			count  = rapid.IntRange(0, 100000).Draw(t, "count")
+		)
+
+		d1 := logNormalDigest(relErr, seed, count, int32(count)/10)
+		text, err := d1.MarshalText()
+		if err != nil {
+			t.Fatalf("failed to marshal digest: %v", err)
+		}
+
+		d2 := &bdigest.Digest{}
+		err = d2.UnmarshalText(text)
+		if err != nil {
+			t.Fatalf("failed to unmarshal digest from %q: %v", text, err)
+		}
+
+		if !reflect.DeepEqual(d1, d2) {
+			t.Fatalf("got back %#v which is different than %#v", d2, d1)
+		}
+	})
+}
+
+func TestDigestGobRoundtrip(t *testing.T) {
+	t.Parallel()
+
+	type wrapper struct {
+		Name   string
+		Digest *bdigest.Digest
+	}
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-5, 1-1e-5).Draw(t, "relative error")
+			seed   = rapid.Int64().Draw(t, "seed")
+			count  = rapid.IntRange(0, 100000).Draw(t, "count")
+		)
+
+		w1 := wrapper{Name: "latency", Digest: logNormalDigest(relErr, seed, count, int32(count)/10)}
+		var buf bytes.Buffer
+		err := gob.NewEncoder(&buf).Encode(w1)
+		if err != nil {
+			t.Fatalf("failed to encode digest: %v", err)
+		}
+
+		var w2 wrapper
+		err = gob.NewDecoder(&buf).Decode(&w2)
+		if err != nil {
+			t.Fatalf("failed to decode digest: %v", err)
+		}
+
+		if !reflect.DeepEqual(w1, w2) {
+			t.Fatalf("got back %#v which is different than %#v", w2, w1)
+		}
+	})
+}
+
+func TestDigestWriteToReadFrom(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-5, 1-1e-5).Draw(t, "relative error")
+			seeds  = rapid.SliceOfN(rapid.Int64(), 0, 5).Draw(t, "seeds")
+			count  = rapid.IntRange(0, 100000).Draw(t, "count")
+		)
+
+		var buf bytes.Buffer
+		var ds []*bdigest.Digest
+		for _, seed := range seeds {
+			d := logNormalDigest(relErr, seed, count, int32(count)/10)
+			data, err := d.MarshalBinary()
+			if err != nil {
+				t.Fatalf("failed to marshal digest: %v", err)
+			}
+			l := buf.Len()
+			n, err := d.WriteTo(&buf)
+			if err != nil {
+				t.Fatalf("failed to write digest: %v", err)
+			}
+			if n != int64(len(data)) || !bytes.Equal(buf.Bytes()[l:], data) {
+				t.Fatalf("written data differs from MarshalBinary")
+			}
+			ds = append(ds, d)
+		}
+
+		for _, d1 := range ds {
+			d2 := &bdigest.Digest{}
+			_, err := d2.ReadFrom(&buf)
+			if err != nil {
+				t.Fatalf("failed to read digest: %v", err)
+			}
+			if !reflect.DeepEqual(d1, d2) {
+				t.Fatalf("got back %#v which is different than %#v", d2, d1)
+			}
+		}
+		n, err := (&bdigest.Digest{}).ReadFrom(&buf)
+		if n != 0 || err != io.EOF {
+			t.Fatalf("got (%v, %v) instead of EOF after the last digest", n, err)
+		}
+	})
+}
+
+func TestDigest_AppendBinary(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-5, 1-1e-5).Draw(t, "relative error")
+			seed   = rapid.Int64().Draw(t, "seed")
+			count  = rapid.IntRange(0, 100000).Draw(t, "count")
+			prefix = rapid.SliceOf(rapid.Byte()).Draw(t, "prefix")
+		)
+
+		d := logNormalDigest(relErr, seed, count, int32(count)/10)
+		data, err := d.MarshalBinary()
+		if err != nil {
+			t.Fatalf("failed to marshal digest: %v", err)
+		}
+
+		buf, err := d.AppendBinary(append([]byte(nil), prefix...))
+		if err != nil {
+			t.Fatalf("failed to append digest: %v", err)
+		}
+		if !bytes.Equal(buf[:len(prefix)], prefix) || !bytes.Equal(buf[len(prefix):], data) {
+			t.Fatalf("appended data differs from MarshalBinary")
+		}
+
+		buf = make([]byte, 0, len(data))
+		allocs := testing.AllocsPerRun(10, func() {
+			buf, _ = d.AppendBinary(buf[:0])
+		})
+		if allocs != 0 {
+			t.Fatalf("%v allocations when appending into a large enough buffer", allocs)
+		}
+	})
+}
+
+func TestDigestUnmarshalBinaryFormats(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-5, 1-1e-5).Draw(t, "relative error")
+			seed   = rapid.Int64().Draw(t, "seed")
+			count  = rapid.IntRange(0, 100000).Draw(t, "count")
+		)
+
+		d1 := logNormalDigest(relErr, seed, count, int32(count)/10)
+		fixed, err := d1.MarshalBinary()
+		if err != nil {
+			t.Fatalf("failed to marshal digest: %v", err)
+		}
+		compact, err := d1.MarshalCompact()
+		if err != nil {
+			t.Fatalf("failed to marshal compact digest: %v", err)
+		}
+		legacy := fixed[4:] // legacy format lacks magic and version
+
+		var stream bytes.Buffer
+		for _, data := range [][]byte{fixed, compact, legacy} {
+			d2 := &bdigest.Digest{}
+			err = d2.UnmarshalBinary(data)
+			if err != nil {
+				t.Fatalf("failed to unmarshal digest: %v", err)
+			}
+			if !reflect.DeepEqual(d1, d2) {
+				t.Fatalf("got back %#v which is different than %#v", d2, d1)
+			}
+			stream.Write(data)
+		}
+
+		for stream.Len() > 0 {
+			d2 := &bdigest.Digest{}
+			_, err = d2.ReadFrom(&stream)
+			if err != nil {
+				t.Fatalf("failed to read digest: %v", err)
+			}
+			if !reflect.DeepEqual(d1, d2) {
+				t.Fatalf("got back %#v which is different than %#v", d2, d1)
+			}
+		}
+
+		unknown := append([]byte(nil), fixed...)
+		unknown[3] = 0xff
+		err = (&bdigest.Digest{}).UnmarshalBinary(unknown)
+		if err == nil {
+			t.Fatalf("unmarshaled data with unknown format version")
+		}
+	})
+}
+
+func checkBounds(t *rapid.T, d *approxDigest, r digest, q float64) {
+	t.Helper()
+
+	lo, hi := d.QuantileBounds(q)
+	rq := r.Quantile(q)
+	if rq == 0 && lo != 0 {
+		t.Errorf("q%v bounds (%v, %v] do not contain zero", q, lo, hi)
+	}
+	if rq != 0 && (rq <= lo*(1-1e-9) || rq > hi*(1+1e-9)) {
+		t.Errorf("q%v bounds (%v, %v] do not contain %v", q, lo, hi, rq)
+	}
+}
+
+func TestWithMemoryBudget(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr  = rapid.Float64Range(1e-3, 0.1).Draw(t, "relative error")
+			mapping = rapid.SampledFrom(mappings).Draw(t, "mapping")
+			budget  = rapid.IntRange(256, 4096).Draw(t, "budget")
+			seed    = rapid.Int64().Draw(t, "seed")
+			count   = rapid.IntRange(1, 10000).Draw(t, "count")
+		)
+
+		d := bdigest.NewDigest(relErr, bdigest.WithMapping(mapping), bdigest.WithMemoryBudget(budget))
+		base := d.MemoryBytes()
+		r := rand.New(rand.NewSource(seed))
+		values := make([]float64, count)
+		for i := range values {
+			values[i] = math.Exp(r.NormFloat64() * 3)
+			d.Add(values[i])
+		}
+		sort.Float64s(values)
+
+		// MappingHDR can not be coarser than the powers of 2.
+		if m := d.MemoryBytes() - base; m > budget && mapping != bdigest.MappingHDR {
+			t.Fatalf("%v uses %v bytes over the budget of %v", d, m, budget)
+		}
+		if d.RelativeError() < relErr {
+			t.Fatalf("relative error is %v, less than %v", d.RelativeError(), relErr)
+		}
+		for _, q := range quantiles {
+			want := values[int(q*float64(count-1))]
+			if got := d.Quantile(q); math.Abs(got-want) > want*d.RelativeError()*(1+1e-9) {
+				t.Fatalf("q%v is %v instead of %v (relative error %v)", q, got, want, d.RelativeError())
+			}
+		}
+
+		f := logNormalDigest(relErr, seed, count, 0, bdigest.WithMapping(mapping))
+		if err := d.Merge(f); err != nil {
+			t.Fatalf("failed to merge %v into coarsened %v: %v", f, d, err)
+		}
+		if d.Count() != uint64(2*count) {
+			t.Fatalf("merged count is %v instead of %v", d.Count(), 2*count)
+		}
+		if err := d.MergeWeighted(f, 0.5); err != nil {
+			t.Fatalf("failed to merge %v with weight into coarsened %v: %v", f, d, err)
+		}
+		if m := d.MemoryBytes() - base; m > budget && mapping != bdigest.MappingHDR {
+			t.Fatalf("%v uses %v bytes over the budget of %v after weighted merge", d, m, budget)
+		}
+		e := bdigest.NewDigest(relErr, bdigest.WithMapping(mapping), bdigest.WithMemoryBudget(budget))
+		if err := e.MergeWeighted(d, 2); err != nil {
+			t.Fatalf("failed to merge coarsened %v with weight: %v", d, err)
+		}
+		if e.RelativeError() < d.RelativeError() {
+			t.Fatalf("relative error after weighted merge is %v, less than %v", e.RelativeError(), d.RelativeError())
+		}
+
+		d.Reset()
+		if d.RelativeError() != relErr {
+			t.Fatalf("relative error after reset is %v instead of %v", d.RelativeError(), relErr)
+		}
+	})
+}
+
+func TestDigest_MergeCoarsenedFailure(t *testing.T) {
+	t.Parallel()
+
+	v := logNormalDigest(0.01, 1, 10000, 0, bdigest.WithMemoryBudget(256))
+	if v.RelativeError() == 0.01 {
+		t.Fatalf("%v is not coarsened", v)
+	}
+	if err := v.MergeWeighted(v.Clone(), 1<<50); err != nil {
+		t.Fatalf("failed to merge with weight: %v", err)
+	}
+	one := bdigest.NewDigest(0.01)
+	one.Add(1)
+	d := bdigest.NewDigest(0.01, bdigest.WithMemoryBudget(256))
+	if err := d.MergeWeighted(one, 1<<63); err != nil {
+		t.Fatalf("failed to merge with weight: %v", err)
+	}
+	c := d.Clone()
+
+	if err := d.Merge(v); err != bdigest.ErrOverflow {
+		t.Fatalf("got %v instead of overflow error", err)
+	}
+	if !d.Equal(c) || d.RelativeError() != 0.01 {
+		t.Fatalf("failed merge changed the digest to %v", d)
+	}
+}
+
+func TestDigest_MergeOverflow(t *testing.T) {
+	t.Parallel()
+
+	v := bdigest.NewDigest(0.01)
+	v.Add(1)
+	v.Add(0)
+	d := bdigest.NewDigest(0.01)
+	if err := d.MergeWeighted(v, 1<<62); err != nil {
+		t.Fatalf("failed to merge with weight: %v", err)
+	}
+	n := d.Count()
+
+	if err := d.Merge(d); err != bdigest.ErrOverflow {
+		t.Fatalf("got %v instead of overflow error", err)
+	}
+	if err := d.MergeAll(v, d); err != bdigest.ErrOverflow {
+		t.Fatalf("got %v instead of overflow error from MergeAll", err)
+	}
+	if err := d.MergeWeighted(v, 1<<62); err != bdigest.ErrOverflow {
+		t.Fatalf("got %v instead of overflow error from MergeWeighted", err)
+	}
+	if d.Count() != n {
+		t.Fatalf("failed merge changed count from %v to %v", n, d.Count())
+	}
+}
+
+func TestDigest_Prune(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr   = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			store    = rapid.SampledFrom(storeNames).Draw(t, "store")
+			seed     = rapid.Int64().Draw(t, "seed")
+			count    = rapid.IntRange(1, 1000).Draw(t, "count")
+			fraction = rapid.Float64Range(0, 1).Draw(t, "max dropped fraction")
+			q        = rapid.Float64Range(0, 1).Draw(t, "q")
+		)
+
+		d := logNormalDigest(relErr, seed, count, int32(count)/10, stores[store]...)
+		p := d.Clone()
+		n := p.Prune(fraction)
+		if p.Count()+n != d.Count() || p.Dropped() != n {
+			t.Fatalf("pruned %v values, count changed from %v to %v", n, d.Count(), p.Count())
+		}
+		delta := p.DroppedFraction()
+		if delta > fraction || delta != float64(n)/float64(d.Count()) {
+			t.Fatalf("dropped fraction %v is invalid for %v values dropped out of %v, max %v", delta, n, d.Count(), fraction)
+		}
+		if err := p.Validate(); err != nil {
+			t.Fatalf("pruned digest is invalid: %v", err)
+		}
+		if p.Count() == 0 {
+			return
+		}
+
+		// Rank of the quantile changes by at most the dropped fraction.
+		eps := delta + 1/float64(count)
+		lo, hi := d.Quantile(math.Max(0, q-eps)), d.Quantile(math.Min(1, q+eps))
+		if v := p.Quantile(q); v < lo || v > hi {
+			t.Fatalf("q%v of pruned digest is %v, outside [%v, %v]", q, v, lo, hi)
+		}
+
+		m := p.Clone()
+		if err := m.Merge(p); err != nil || m.Dropped() != 2*n {
+			t.Fatalf("merged digest dropped %v values instead of %v (error %v)", m.Dropped(), 2*n, err)
+		}
+		p.Reset()
+		if p.Dropped() != 0 {
+			t.Fatalf("reset digest dropped %v values", p.Dropped())
+		}
+	})
+}
+
+func TestDigest_AddValues(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			store  = rapid.SampledFrom(storeNames).Draw(t, "store")
+			exact  = rapid.IntRange(0, 20).Draw(t, "exact samples")
+			seed   = rapid.Int64().Draw(t, "seed")
+			count  = rapid.IntRange(0, 1000).Draw(t, "count")
+			values = rapid.SliceOfN(rapid.Float64Range(0, 1e9), 0, 30).Draw(t, "values")
+		)
+
+		opts := stores[store]
+		if exact > 0 {
+			opts = append([]bdigest.Option{bdigest.WithExactSamples(exact)}, opts...)
+		}
+		d1 := logNormalDigest(relErr, seed, count, int32(count)/10, opts...)
+		d2 := d1.Clone()
+		for _, v := range values {
+			d1.Add(v)
+		}
+		d2.AddValues(values...)
+		if !d1.Equal(d2) {
+			t.Fatalf("digest with added values %v differs from %v", d2, d1)
+		}
+		for _, q := range quantiles {
+			if q1, q2 := d1.Quantile(q), d2.Quantile(q); q1 != q2 && !(math.IsNaN(q1) && math.IsNaN(q2)) {
+				t.Fatalf("q%v of digest with added values is %v instead of %v", q, q2, q1)
+			}
+		}
+
+		if count == 0 {
+			f := bdigest.FromValues(relErr, values...)
+			d := bdigest.NewDigest(relErr)
+			for _, v := range values {
+				d.Add(v)
+			}
+			if !f.Equal(d) {
+				t.Fatalf("digest from values %v differs from %v", f, d)
+			}
+		}
+	})
+}
+
+func TestDigest_IsEmpty(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			seed   = rapid.Int64().Draw(t, "seed")
+			count  = rapid.IntRange(0, 100).Draw(t, "count")
+		)
+
+		d := logNormalDigest(relErr, seed, count, int32(count)/10)
+		if d.IsEmpty() != (count == 0) {
+			t.Fatalf("digest of %v values is empty: %v", count, d.IsEmpty())
+		}
+		if err := d.RelativeError(); err != relErr {
+			t.Fatalf("relative error is %v instead of %v", err, relErr)
+		}
+		if err := d.Merge(bdigest.NewDigest(d.RelativeError())); err != nil {
+			t.Fatalf("failed to merge digest with the same relative error: %v", err)
+		}
+		d.Reset()
+		if !d.IsEmpty() {
+			t.Fatalf("reset digest is not empty")
+		}
+	})
+}
@@ -0,0 +1,170 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	dumpHeader = "bdigest dump v1"
+)
+
+// Dump writes the digest to w in a stable line-oriented text format,
+// suitable for diffing, test golden files and editing by hand:
+//
+//	bdigest dump v1
+//	err 0.01
+//	mapping logarithmic
+//	zero 3
+//	bucket 5 3 # (1.0833, 1.1052]
+//	bucket 6 2 # (1.1052, 1.1275]
+//
+// Histogram buckets are written in increasing key order, with their
+// bounds in comments (starting with # and ignored by ParseDump).
+// Only non-empty buckets are written, and options other than the mapping
+// are not.
+//
+// Dump returns an error if the digest uses custom index mapping.
+func (d *Digest) Dump(w io.Writer) error {
+	if d.index != nil {
+		return errCustomMapping
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, dumpHeader)
+	fmt.Fprintf(bw, "err %v\n", strconv.FormatFloat(d.alpha, 'g', -1, 64))
+	fmt.Fprintf(bw, "mapping %v\n", d.mapping.kind)
+	fmt.Fprintf(bw, "zero %v\n", d.numZero)
+	d.ascend(func(k int, n uint64) bool {
+		if n > 0 {
+			fmt.Fprintf(bw, "bucket %v %v # (%.5g, %.5g]\n", k, n, d.lowerBound(k), d.lowerBound(k+1))
+		}
+		return true
+	})
+
+	return bw.Flush()
+}
+
+// ParseDump returns digest read from r in the format of Dump.
+// Lines can be in any order after the header, and blank lines
+// and comments are ignored.
+//
+// ParseDump returns an error if the data is malformed or the buckets
+// exceed DefaultMaxBuckets, or ErrOverflow if the total count overflows.
+func ParseDump(r io.Reader) (*Digest, error) {
+	s := bufio.NewScanner(r)
+	if !s.Scan() || strings.TrimSpace(s.Text()) != dumpHeader {
+		if err := s.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("missing %q header", dumpHeader)
+	}
+
+	var (
+		alpha float64
+		m     = MappingLogarithmic
+		zero  uint64
+		keys  = map[int]uint64{}
+		order []int
+		total uint64
+	)
+	for line := 2; s.Scan(); line++ {
+		text := s.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+
+		var err error
+		switch {
+		case fields[0] == "err" && len(fields) == 2:
+			alpha, err = strconv.ParseFloat(fields[1], 64)
+			if err == nil && (math.IsNaN(alpha) || alpha <= 0 || alpha >= 1) {
+				err = fmt.Errorf("invalid relative error %v", alpha)
+			}
+		case fields[0] == "mapping" && len(fields) == 2:
+			m, err = parseMapping(fields[1])
+		case fields[0] == "zero" && len(fields) == 2:
+			zero, err = strconv.ParseUint(fields[1], 10, 64)
+			if err == nil {
+				total, err = addCount(total, zero)
+			}
+		case fields[0] == "bucket" && len(fields) == 3:
+			var (
+				k int
+				n uint64
+			)
+			k, err = strconv.Atoi(fields[1])
+			if err == nil {
+				n, err = strconv.ParseUint(fields[2], 10, 64)
+			}
+			if _, ok := keys[k]; err == nil && ok {
+				err = fmt.Errorf("duplicate bucket %v", k)
+			}
+			if err == nil {
+				total, err = addCount(total, n)
+			}
+			if err == nil {
+				keys[k] = n
+				order = append(order, k)
+			}
+		default:
+			err = fmt.Errorf("unexpected %q", fields[0])
+		}
+		if err == ErrOverflow {
+			return nil, err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", line, err)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if alpha == 0 {
+		return nil, fmt.Errorf("missing relative error")
+	}
+	lenNeg, lenPos := uint64(0), uint64(0)
+	for k, n := range keys {
+		if n > 0 && k < 1 && uint64(-k)+1 > lenNeg {
+			lenNeg = uint64(-k) + 1
+		}
+		if n > 0 && k > 0 && uint64(k) > lenPos {
+			lenPos = uint64(k)
+		}
+	}
+	if err := checkBuckets(lenNeg, lenPos, DefaultMaxBuckets); err != nil {
+		return nil, err
+	}
+
+	d := NewDigest(alpha, WithMapping(m))
+	d.numZero = zero
+	for _, k := range order {
+		if n := keys[k]; n > 0 {
+			d.addKey(k, n)
+		}
+	}
+
+	return d, nil
+}
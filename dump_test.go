@@ -0,0 +1,98 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/rapid"
+)
+
+func TestDigest_Dump(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr  = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			mapping = rapid.SampledFrom(mappings).Draw(t, "mapping")
+			seed    = rapid.Int64().Draw(t, "seed")
+			count   = rapid.IntRange(0, 1000).Draw(t, "count")
+		)
+
+		d := logNormalDigest(relErr, seed, count, int32(count)/10, bdigest.WithMapping(mapping))
+		var buf bytes.Buffer
+		if err := d.Dump(&buf); err != nil {
+			t.Fatalf("failed to dump digest: %v", err)
+		}
+		dump := buf.String()
+
+		p, err := bdigest.ParseDump(&buf)
+		if err != nil {
+			t.Fatalf("failed to parse dump: %v\n%v", err, dump)
+		}
+		if !p.Equal(d) {
+			t.Fatalf("parsed digest %v differs from %v", p, d)
+		}
+
+		buf.Reset()
+		if err := p.Dump(&buf); err != nil || buf.String() != dump {
+			t.Fatalf("dump of parsed digest %q differs from %q (error %v)", buf.String(), dump, err)
+		}
+	})
+}
+
+func TestParseDump(t *testing.T) {
+	t.Parallel()
+
+	d, err := bdigest.ParseDump(strings.NewReader(`bdigest dump v1
+# edited by hand
+bucket 2 3
+
+bucket -1 1 # (0.96, 0.98]
+err 0.01
+zero 2
+`))
+	if err != nil {
+		t.Fatalf("failed to parse dump: %v", err)
+	}
+	want := bdigest.NewDigest(0.01)
+	for _, v := range []float64{0, 0, 0.97, 1.03, 1.03, 1.03} {
+		want.Add(v)
+	}
+	if !d.Equal(want) {
+		t.Fatalf("parsed digest %v differs from %v", d, want)
+	}
+
+	for _, data := range []string{
+		"",
+		"err 0.01\n",
+		"bdigest dump v1\n",
+		"bdigest dump v1\nerr 1\n",
+		"bdigest dump v1\nerr 0.01\nmapping unknown\n",
+		"bdigest dump v1\nerr 0.01\nbucket 1\n",
+		"bdigest dump v1\nerr 0.01\nbucket 1 1\nbucket 1 2\n",
+		"bdigest dump v1\nerr 0.01\nbucket 1000000000 1\n",
+		"bdigest dump v1\nerr 0.01\nzero -1\n",
+		"bdigest dump v1\nerr 0.01\ncount 1\n",
+		"bdigest dump v1\nerr 0.01\nzero 18446744073709551615\nbucket 1 1\n",
+	} {
+		if _, err := bdigest.ParseDump(strings.NewReader(data)); err == nil {
+			t.Errorf("parsed invalid dump %q", data)
+		}
+	}
+}
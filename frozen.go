@@ -0,0 +1,161 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest
+
+import (
+	"math"
+	"sort"
+)
+
+// FrozenDigest is an immutable snapshot of a digest, optimized for
+// repeated queries. It holds only the non-empty histogram buckets,
+// with cumulative counts which make Quantile a binary search.
+// FrozenDigest is safe for concurrent use.
+type FrozenDigest struct {
+	d Digest // relative error, mapping and counts only
+	c cumulative
+}
+
+// Freeze returns an immutable snapshot of the digest.
+func (d *Digest) Freeze() *FrozenDigest {
+	f := &FrozenDigest{
+		d: Digest{
+			alpha:       d.alpha,
+			mapping:     d.mapping,
+			index:       d.index,
+			numNonZero:  d.numNonZero,
+			numZero:     d.numZero,
+			interpolate: d.interpolate,
+			rank:        d.rank,
+			exactMax:    d.exactMax,
+		},
+	}
+	if d.isExact() {
+		f.d.exact = append([]float64(nil), d.exact...)
+		sort.Float64s(f.d.exact)
+	}
+	f.c.build(d.store)
+	f.c.exact = f.d.exact
+
+	return f
+}
+
+// Thaw returns new digest with the same content as f.
+func (f *FrozenDigest) Thaw() *Digest {
+	d := &Digest{
+		alpha:       f.d.alpha,
+		mapping:     f.d.mapping,
+		index:       f.d.index,
+		store:       &denseStore{},
+		numNonZero:  f.d.numNonZero,
+		numZero:     f.d.numZero,
+		interpolate: f.d.interpolate,
+		rank:        f.d.rank,
+		exactMax:    f.d.exactMax,
+		exact:       append([]float64(nil), f.d.exact...),
+	}
+	prev := uint64(0)
+	for i, k := range f.c.keys {
+		d.store.Add(k, f.c.sums[i]-prev)
+		prev = f.c.sums[i]
+	}
+
+	return d
+}
+
+// String returns short digest description.
+func (f *FrozenDigest) String() string {
+	return "Frozen" + f.d.String()
+}
+
+// Size returns the number of non-empty histogram buckets.
+func (f *FrozenDigest) Size() int {
+	return len(f.c.keys)
+}
+
+// Count returns the number of added values.
+func (f *FrozenDigest) Count() uint64 {
+	return f.d.Count()
+}
+
+// Quantile returns the q-quantile of added values,
+// like Digest.Quantile.
+//
+// Quantile panics if q is outside [0, 1].
+// Quantile returns NaN for empty digest.
+func (f *FrozenDigest) Quantile(q float64) float64 {
+	if math.IsNaN(q) || q < 0 || q > 1 {
+		panic("q must be in [0, 1]")
+	}
+
+	return f.d.quantileAt(&f.c, q)
+}
+
+// QuantileBounds returns the bounds of the histogram bucket holding
+// the q-quantile of added values, like Digest.QuantileBounds.
+func (f *FrozenDigest) QuantileBounds(q float64) (lo float64, hi float64) {
+	if math.IsNaN(q) || q < 0 || q > 1 {
+		panic("q must be in [0, 1]")
+	}
+
+	return f.d.quantileBoundsAt(&f.c, q)
+}
+
+// Quantiles returns the q-quantiles of added values for each of qs.
+//
+// Quantiles panics if any of qs is outside [0, 1].
+func (f *FrozenDigest) Quantiles(qs ...float64) []float64 {
+	res := make([]float64, len(qs))
+	for i, q := range qs {
+		res[i] = f.Quantile(q)
+	}
+
+	return res
+}
+
+// CDF returns the estimated fraction of added values
+// less than or equal to v. Counts of histogram buckets
+// are assumed to be distributed uniformly within the buckets.
+//
+// CDF returns NaN for empty digest.
+func (f *FrozenDigest) CDF(v float64) float64 {
+	total := f.Count()
+	if total == 0 || math.IsNaN(v) {
+		return math.NaN()
+	}
+	if v < 0 {
+		return 0
+	}
+	if v == 0 || len(f.c.keys) == 0 {
+		return float64(f.d.numZero) / float64(total)
+	}
+
+	k := f.d.bucketKey(math.Min(v, math.MaxFloat64))
+	i := sort.SearchInts(f.c.keys, k)
+	acc := float64(f.d.numZero)
+	if i > 0 {
+		acc += float64(f.c.sums[i-1])
+	}
+	if i < len(f.c.keys) && f.c.keys[i] == k {
+		lo, hi := f.d.lowerBound(k), f.d.lowerBound(k+1)
+		n := f.c.sums[i]
+		if i > 0 {
+			n -= f.c.sums[i-1]
+		}
+		acc += float64(n) * math.Min((v-lo)/(hi-lo), 1)
+	}
+
+	return acc / float64(total)
+}
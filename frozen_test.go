@@ -0,0 +1,73 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest_test
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"pgregory.net/rapid"
+)
+
+func TestDigest_Freeze(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			seed   = rapid.Int64().Draw(t, "seed")
+			count  = rapid.IntRange(0, 10000).Draw(t, "count")
+			store  = rapid.SampledFrom(storeNames).Draw(t, "store")
+			q      = rapid.Float64Range(0, 1).Draw(t, "quantile")
+		)
+
+		d := logNormalDigest(relErr, seed, count, int32(count)/10, stores[store]...)
+		f := d.Freeze()
+		if f.Count() != d.Count() {
+			t.Fatalf("count is %v instead of %v", f.Count(), d.Count())
+		}
+		fq, dq := f.Quantile(q), d.Quantile(q)
+		if fq != dq && !(math.IsNaN(fq) && math.IsNaN(dq)) {
+			t.Fatalf("q%v is %v instead of %v", q, fq, dq)
+		}
+		if count > 0 {
+			c := f.CDF(dq) * float64(d.Count())
+			h := d.ToHistogram([]float64{dq})
+			if math.Abs(c-float64(h[0])) > 0.5+1e-6*c {
+				t.Fatalf("CDF at %v is %v of %v instead of %v", dq, c, d.Count(), h[0])
+			}
+			if f.CDF(0) > f.CDF(dq) || f.CDF(dq) > f.CDF(math.Inf(1)) || f.CDF(math.Inf(1)) != 1 {
+				t.Fatalf("CDF is not monotonic")
+			}
+		}
+
+		data1, err := d.MarshalBinary()
+		if err != nil {
+			t.Fatalf("failed to marshal digest: %v", err)
+		}
+		d.Add(1)
+		if f.Count() == d.Count() {
+			t.Fatalf("frozen digest changed after Add")
+		}
+		data2, err := f.Thaw().MarshalBinary()
+		if err != nil {
+			t.Fatalf("failed to marshal thawed digest: %v", err)
+		}
+		if !bytes.Equal(data1, data2) {
+			t.Fatalf("thawed digest data %q differs from %q", data2, data1)
+		}
+	})
+}
@@ -0,0 +1,279 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ToHistogram returns the number of added values in each of the intervals
+// (-∞, bounds[0]], (bounds[0], bounds[1]], ..., (bounds[n-1], +∞),
+// suitable for exporting to systems that only support classic
+// fixed-boundary histograms. The result has len(bounds)+1 elements.
+//
+// Counts of digest buckets straddling one of the bounds are apportioned
+// between the adjacent intervals in proportion to the overlap.
+//
+// ToHistogram panics if bounds are not sorted in increasing order.
+func (d *Digest) ToHistogram(bounds []float64) []uint64 {
+	checkBounds(bounds)
+
+	cum := d.cumulativeAt(bounds)
+	counts := make([]uint64, len(bounds)+1)
+	prev := uint64(0)
+	for i, c := range cum {
+		counts[i] = c - prev
+		prev = c
+	}
+	counts[len(bounds)] = d.Count() - prev
+
+	return counts
+}
+
+// Rebin returns the number of added values in each of the intervals
+// (bounds[0], bounds[1]], ..., (bounds[n-2], bounds[n-1]], for feeding
+// systems expecting a fixed bucket layout. Like in ToHistogram, counts
+// of digest buckets straddling one of the bounds are apportioned between
+// the adjacent intervals in proportion to the overlap. Values outside
+// of (bounds[0], bounds[n-1]] are not counted; use ToHistogram to get
+// the counts of the unbounded intervals as well.
+// The result has len(bounds)-1 elements, or none if bounds are empty.
+//
+// Rebin panics if bounds are not sorted in increasing order.
+func (d *Digest) Rebin(bounds []float64) []uint64 {
+	checkBounds(bounds)
+	if len(bounds) == 0 {
+		return nil
+	}
+
+	cum := d.cumulativeAt(bounds)
+	counts := make([]uint64, len(bounds)-1)
+	for i := range counts {
+		counts[i] = cum[i+1] - cum[i]
+	}
+
+	return counts
+}
+
+// cumulativeAt returns the estimated number of added values
+// less than or equal to each of the (sorted) bounds.
+func (d *Digest) cumulativeAt(bounds []float64) []uint64 {
+	cum := make([]uint64, len(bounds))
+	total := d.Count()
+	for i, f := range d.cdfAt(bounds) {
+		c := uint64(math.Round(f))
+		if c > total {
+			c = total
+		}
+		cum[i] = c
+	}
+
+	return cum
+}
+
+// cdfAt is like cumulativeAt, but does not round the estimates.
+func (d *Digest) cdfAt(bounds []float64) []float64 {
+	cum := make([]float64, len(bounds))
+
+	j := 0
+	for j < len(bounds) && bounds[j] < 0 {
+		j++
+	}
+
+	acc := float64(d.numZero)
+	d.ascend(func(k int, n uint64) bool {
+		lo, hi := d.lowerBound(k), d.lowerBound(k+1)
+		for j < len(bounds) && bounds[j] < hi {
+			f := 0.0
+			if bounds[j] > lo {
+				f = (bounds[j] - lo) / (hi - lo)
+			}
+			cum[j] = acc + f*float64(n)
+			j++
+		}
+		acc += float64(n)
+		return j < len(bounds)
+	})
+	for ; j < len(bounds); j++ {
+		cum[j] = acc
+	}
+
+	return cum
+}
+
+func checkBounds(bounds []float64) {
+	for i, b := range bounds {
+		if math.IsNaN(b) || (i > 0 && b <= bounds[i-1]) {
+			panic("bounds must be sorted in increasing order")
+		}
+	}
+}
+
+// FromHistogram returns digest with maximum relative error err ∈ (0, 1)
+// approximating the distribution described by a classic fixed-boundary
+// histogram: counts[i] is the number of values in the interval
+// (bounds[i-1], bounds[i]], with the first and the last intervals
+// being unbounded below and above respectively, as returned by ToHistogram.
+//
+// Since values inside each interval are unknown, they are assumed to be
+// distributed uniformly within it. As a result, quantiles of the returned
+// digest are guaranteed only to lie in the correct interval (widened
+// by the relative error); the error of err applies on top of that.
+// Values in the last, unbounded interval are assumed to be equal to its
+// lower bound, and negative values are treated as zero. If the total
+// count would overflow, the values which do not fit are dropped,
+// starting from the last interval.
+//
+// FromHistogram panics if bounds are not sorted in increasing order,
+// or if the length of counts is not len(bounds)+1.
+func FromHistogram(err float64, bounds []float64, counts []uint64) *Digest {
+	checkBounds(bounds)
+	if len(counts) != len(bounds)+1 {
+		panic("counts must have exactly one more element than bounds")
+	}
+
+	d := NewDigest(err)
+	for i, c := range counts {
+		if c == 0 {
+			continue
+		}
+		lo, hi := math.Inf(-1), math.Inf(1)
+		if i > 0 {
+			lo = bounds[i-1]
+		}
+		if i < len(bounds) {
+			hi = bounds[i]
+		}
+		if r := math.MaxUint64 - d.Count(); c > r {
+			c = r
+		}
+		d.addInterval(lo, hi, c)
+	}
+
+	return d
+}
+
+// FromBuckets returns digest with relative error err ∈ (0, 1), numZero
+// zero values and histogram buckets with counts neg and pos, where neg[i]
+// is the count of the bucket with key -i, and pos[i] is the count
+// of the bucket with key i+1, as numbered by KeyOf and BoundsOf.
+// This is the layout of the binary formats, and of the digests restored
+// from columnar storage. The counts are copied.
+//
+// FromBuckets returns an error if err is invalid,
+// or ErrOverflow if the total count overflows.
+func FromBuckets(err float64, numZero uint64, neg []uint64, pos []uint64, opts ...Option) (*Digest, error) {
+	if math.IsNaN(err) || err <= 0 || err >= 1 {
+		return nil, fmt.Errorf("invalid relative error %v", err)
+	}
+
+	total := numZero
+	for _, buckets := range [][]uint64{neg, pos} {
+		for _, n := range buckets {
+			var e error
+			if total, e = addCount(total, n); e != nil {
+				return nil, e
+			}
+		}
+	}
+
+	d := NewDigest(err, opts...)
+	d.numZero = numZero
+	for i := len(neg) - 1; i >= 0; i-- {
+		if neg[i] > 0 {
+			d.addKey(-i, neg[i])
+		}
+	}
+	for i, n := range pos {
+		if n > 0 {
+			d.addKey(i+1, n)
+		}
+	}
+	d.fitBudget()
+
+	return d, nil
+}
+
+// addInterval adds n values distributed uniformly over (lo, hi].
+func (d *Digest) addInterval(lo float64, hi float64, n uint64) {
+	if hi <= 0 || (math.IsInf(hi, 1) && lo <= 0) {
+		d.numZero += n
+		return
+	}
+	if math.IsInf(hi, 1) {
+		d.addKey(d.bucketKey(lo), n)
+		return
+	}
+	if lo < 0 {
+		lo = 0
+	}
+
+	// Walk the buckets down from hi, rounding the cumulative count
+	// to keep the total exact.
+	k := d.bucketKey(hi)
+	added := uint64(0)
+	for added < n {
+		c := n
+		if b := d.lowerBound(k); b >= hi {
+			c = 0
+		} else if b > lo {
+			if f := math.Round(float64(n) * (hi - b) / (hi - lo)); f < float64(n) {
+				c = uint64(f)
+			}
+		}
+		if c > added {
+			d.addKey(k, c-added)
+			added = c
+		}
+		k--
+	}
+}
+
+// FractionBelow returns the estimated fraction of added values less than
+// or equal to each of the thresholds, like the share of requests served
+// within an SLO latency target. Counts of digest buckets straddling
+// a threshold are apportioned in proportion to the overlap.
+//
+// FractionBelow panics if any of the thresholds is NaN.
+// FractionBelow returns NaNs for empty digest.
+func (d *Digest) FractionBelow(thresholds ...float64) []float64 {
+	order := make([]int, len(thresholds))
+	for i, t := range thresholds {
+		if math.IsNaN(t) {
+			panic("thresholds must not be NaN")
+		}
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return thresholds[order[i]] < thresholds[order[j]] })
+
+	bounds := make([]float64, 0, len(thresholds))
+	for _, i := range order {
+		if t := thresholds[i]; len(bounds) == 0 || t > bounds[len(bounds)-1] {
+			bounds = append(bounds, t)
+		}
+	}
+	cum := d.cdfAt(bounds)
+
+	res := make([]float64, len(thresholds))
+	n := float64(d.Count())
+	for i, t := range thresholds {
+		j := sort.SearchFloat64s(bounds, t)
+		res[i] = math.Min(cum[j]/n, 1)
+	}
+
+	return res
+}
@@ -0,0 +1,251 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest_test
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/rapid"
+)
+
+func TestDigest_ToHistogram(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			seed   = rapid.Int64().Draw(t, "seed")
+			count  = rapid.IntRange(0, 10000).Draw(t, "count")
+			bounds = rapid.SliceOfNDistinct(rapid.Float64Range(-1, 10), 0, 20, rapid.ID[float64]).Draw(t, "bounds")
+		)
+		sort.Float64s(bounds)
+
+		d := bdigest.NewDigest(relErr)
+		values := make([]float64, count)
+		r := rand.New(rand.NewSource(seed))
+		for i := range values {
+			values[i] = math.Exp(r.NormFloat64())
+			d.Add(values[i])
+		}
+		sort.Float64s(values)
+
+		counts := d.ToHistogram(bounds)
+		if len(counts) != len(bounds)+1 {
+			t.Fatalf("got %v counts for %v bounds", len(counts), len(bounds))
+		}
+
+		gamma := (1 + relErr) / (1 - relErr)
+		cum := uint64(0)
+		for i, b := range bounds {
+			cum += counts[i]
+			lo := sort.SearchFloat64s(values, b/gamma)
+			hi := sort.SearchFloat64s(values, b*gamma*(1+1e-9))
+			if int(cum)+1 < lo || int(cum) > hi+1 {
+				t.Fatalf("cumulative count at %v is %v, outside of [%v, %v]", b, cum, lo, hi)
+			}
+		}
+		if cum+counts[len(bounds)] != d.Count() {
+			t.Fatalf("histogram total is %v instead of %v", cum+counts[len(bounds)], d.Count())
+		}
+	})
+}
+
+func TestDigest_Rebin(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			seed   = rapid.Int64().Draw(t, "seed")
+			count  = rapid.IntRange(0, 10000).Draw(t, "count")
+			bounds = rapid.SliceOfNDistinct(rapid.Float64Range(-1, 10), 0, 20, rapid.ID[float64]).Draw(t, "bounds")
+		)
+		sort.Float64s(bounds)
+
+		d := logNormalDigest(relErr, seed, count, int32(count)/10)
+		counts := d.Rebin(bounds)
+		if len(bounds) == 0 {
+			if len(counts) != 0 {
+				t.Fatalf("got %v counts for no bounds", len(counts))
+			}
+			return
+		}
+		if len(counts) != len(bounds)-1 {
+			t.Fatalf("got %v counts for %v bounds", len(counts), len(bounds))
+		}
+
+		h := d.ToHistogram(bounds)
+		for i, c := range counts {
+			if c != h[i+1] {
+				t.Fatalf("count in (%v, %v] is %v instead of %v", bounds[i], bounds[i+1], c, h[i+1])
+			}
+		}
+	})
+}
+
+func TestFromHistogram(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			seed   = rapid.Int64().Draw(t, "seed")
+			count  = rapid.IntRange(1, 10000).Draw(t, "count")
+			bounds = rapid.SliceOfNDistinct(rapid.Float64Range(-1, 10), 0, 20, rapid.ID[float64]).Draw(t, "bounds")
+			q      = rapid.Float64Range(0, 1).Draw(t, "quantile")
+		)
+		sort.Float64s(bounds)
+
+		values := make([]float64, count)
+		r := rand.New(rand.NewSource(seed))
+		for i := range values {
+			values[i] = math.Exp(r.NormFloat64())
+		}
+		sort.Float64s(values)
+
+		counts := make([]uint64, len(bounds)+1)
+		for _, v := range values {
+			counts[sort.SearchFloat64s(bounds, v)]++
+		}
+
+		d := bdigest.FromHistogram(relErr, bounds, counts)
+		if d.Count() != uint64(count) {
+			t.Fatalf("count is %v instead of %v", d.Count(), count)
+		}
+
+		v := values[int(q*float64(count-1))]
+		i := sort.SearchFloat64s(bounds, v)
+		lo, hi := 0.0, math.Inf(1)
+		if i > 0 {
+			lo = math.Max(bounds[i-1], 0)
+		}
+		if i < len(bounds) {
+			hi = bounds[i]
+		} else {
+			hi = lo
+		}
+		dq := d.Quantile(q)
+		if dq < lo*(1-relErr)*(1-1e-9) || dq > hi*(1+relErr)*(1+1e-9) {
+			t.Fatalf("q%v is %v, outside of [%v, %v]", q, dq, lo, hi)
+		}
+	})
+}
+
+func TestFromHistogram_Overflow(t *testing.T) {
+	t.Parallel()
+
+	d := bdigest.FromHistogram(0.01, []float64{1, 2}, []uint64{0, math.MaxUint64 - 1, math.MaxUint64})
+	if d.Count() != math.MaxUint64 {
+		t.Fatalf("count is %v instead of %v", d.Count(), uint64(math.MaxUint64))
+	}
+	if q := d.Quantile(0.5); q < 0.99 || q > 2.02 {
+		t.Fatalf("median is %v, outside of [1, 2]", q)
+	}
+}
+
+func TestFromBuckets(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr  = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			mapping = rapid.SampledFrom(mappings).Draw(t, "mapping")
+			numZero = rapid.Uint64Range(0, 100).Draw(t, "zeros")
+			neg     = rapid.SliceOfN(rapid.Uint64Range(0, 100), 0, 20).Draw(t, "negative buckets")
+			pos     = rapid.SliceOfN(rapid.Uint64Range(0, 100), 0, 20).Draw(t, "positive buckets")
+		)
+
+		d, err := bdigest.FromBuckets(relErr, numZero, neg, pos, bdigest.WithMapping(mapping))
+		if err != nil {
+			t.Fatalf("failed to create digest from buckets: %v", err)
+		}
+		if err := d.Validate(); err != nil {
+			t.Fatalf("digest from buckets is invalid: %v", err)
+		}
+
+		type bucket struct {
+			lo, hi float64
+			n      uint64
+		}
+		var want []bucket
+		if numZero > 0 {
+			want = append(want, bucket{0, 0, numZero})
+		}
+		for i := len(neg) - 1; i >= 0; i-- {
+			if neg[i] > 0 {
+				lo, hi := d.BoundsOf(-i)
+				want = append(want, bucket{lo, hi, neg[i]})
+			}
+		}
+		for i, n := range pos {
+			if n > 0 {
+				lo, hi := d.BoundsOf(i + 1)
+				want = append(want, bucket{lo, hi, n})
+			}
+		}
+		var got []bucket
+		d.ForEachBucket(func(lo float64, hi float64, n uint64) bool {
+			got = append(got, bucket{lo, hi, n})
+			return true
+		})
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("digest buckets are %v instead of %v", got, want)
+		}
+	})
+
+	if _, err := bdigest.FromBuckets(1, 0, nil, nil); err == nil {
+		t.Fatalf("created digest with invalid relative error")
+	}
+	if _, err := bdigest.FromBuckets(0.01, 1, nil, []uint64{math.MaxUint64}); err != bdigest.ErrOverflow {
+		t.Fatalf("got %v instead of overflow error", err)
+	}
+}
+
+func TestDigest_FractionBelow(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr     = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			seed       = rapid.Int64().Draw(t, "seed")
+			count      = rapid.IntRange(1, 1000).Draw(t, "count")
+			thresholds = rapid.SliceOfN(rapid.Float64Range(-1, 10), 0, 10).Draw(t, "thresholds")
+		)
+
+		d := bdigest.NewDigest(relErr)
+		values := logNormalValues(seed, count, 0)
+		for _, v := range values {
+			d.Add(v)
+		}
+
+		below := func(x float64) float64 {
+			return float64(sort.Search(count, func(i int) bool { return values[i] > x })) / float64(count)
+		}
+		gamma := 1 + 2*relErr/(1-relErr)
+		fs := d.FractionBelow(thresholds...)
+		for i, th := range thresholds {
+			// Only the values in the bucket holding the threshold can be misplaced.
+			want := below(th)
+			if maxErr := below(th*gamma) - below(th/gamma); math.Abs(fs[i]-want) > maxErr+1e-9 {
+				t.Fatalf("fraction below %v is %v instead of %v", th, fs[i], want)
+			}
+		}
+	})
+}
@@ -0,0 +1,110 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest
+
+import (
+	"math"
+)
+
+// Ingester adds values to a digest on a dedicated goroutine,
+// so that values can be recorded from many goroutines
+// without any synchronization.
+//
+// Values are processed in order: Snapshot includes all the values
+// added before it by the same goroutine.
+type Ingester struct {
+	ops  chan ingestOp
+	done chan struct{}
+	d    *Digest
+}
+
+type ingestOp struct {
+	v    float64
+	snap chan *Digest // not nil for Snapshot
+}
+
+// NewIngester starts the goroutine adding values to digest created with
+// parameters of NewDigest, with room for bufSize values waiting to be added.
+// Call Close to stop the goroutine.
+func NewIngester(err float64, bufSize int, opts ...Option) *Ingester {
+	i := &Ingester{
+		ops:  make(chan ingestOp, bufSize),
+		done: make(chan struct{}),
+		d:    NewDigest(err, opts...),
+	}
+	go i.run()
+
+	return i
+}
+
+func (i *Ingester) run() {
+	defer close(i.done)
+
+	for op := range i.ops {
+		if op.snap != nil {
+			op.snap <- i.d.Clone()
+			continue
+		}
+		i.d.Add(op.v)
+	}
+}
+
+// Add queues finite non-negative value v to be added to the digest,
+// blocking if the buffer is full.
+//
+// Add panics if v is outside [0, math.MaxFloat64], or if called after Close.
+func (i *Ingester) Add(v float64) {
+	if math.IsNaN(v) || v < 0 || v > math.MaxFloat64 {
+		panic("v must be in [0, math.MaxFloat64]")
+	}
+
+	i.ops <- ingestOp{v: v}
+}
+
+// TryAdd is like Add, but does not block. It reports
+// whether v was queued, which is not the case if the buffer is full.
+func (i *Ingester) TryAdd(v float64) bool {
+	if math.IsNaN(v) || v < 0 || v > math.MaxFloat64 {
+		panic("v must be in [0, math.MaxFloat64]")
+	}
+
+	select {
+	case i.ops <- ingestOp{v: v}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Snapshot returns a copy of the digest, after adding
+// the values queued before the call.
+//
+// Snapshot panics if called after Close.
+func (i *Ingester) Snapshot() *Digest {
+	snap := make(chan *Digest, 1)
+	i.ops <- ingestOp{snap: snap}
+
+	return <-snap
+}
+
+// Close adds the queued values, stops the goroutine
+// and returns the resulting digest.
+// Close must not be called concurrently with other methods.
+func (i *Ingester) Close() *Digest {
+	close(i.ops)
+	<-i.done
+
+	return i.d
+}
@@ -0,0 +1,78 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest_test
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/rapid"
+)
+
+func TestIngester(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr  = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			bufSize = rapid.IntRange(0, 100).Draw(t, "buffer size")
+			workers = rapid.IntRange(1, 8).Draw(t, "workers")
+			count   = rapid.IntRange(0, 1000).Draw(t, "count")
+		)
+
+		in := bdigest.NewIngester(relErr, bufSize)
+		d := bdigest.NewDigest(relErr)
+		var wg sync.WaitGroup
+		counts := make([]uint64, workers)
+		for i := 0; i < workers; i++ {
+			r := rand.New(rand.NewSource(int64(i)))
+			values := make([]float64, count)
+			for j := range values {
+				values[j] = math.Exp(r.NormFloat64())
+				d.Add(values[j])
+			}
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for _, v := range values {
+					if !in.TryAdd(v) {
+						in.Add(v)
+					}
+				}
+				counts[i] = in.Snapshot().Count()
+			}(i)
+		}
+		wg.Wait()
+
+		for i, n := range counts {
+			if n < uint64(count) {
+				t.Fatalf("snapshot count of worker %v is %v after adding %v values", i, n, count)
+			}
+		}
+
+		if s := in.Snapshot(); s.Count() != d.Count() {
+			t.Fatalf("snapshot count is %v instead of %v", s.Count(), d.Count())
+		}
+		res := in.Close()
+		data1, _ := res.MarshalBinary()
+		data2, _ := d.MarshalBinary()
+		if string(data1) != string(data2) {
+			t.Fatalf("ingested digest data %q differs from %q", data1, data2)
+		}
+	})
+}
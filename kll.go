@@ -0,0 +1,376 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+const (
+	formatKLL = 3
+
+	kllMinWidth = 8
+	kllMaxK     = 1 << 16
+)
+
+// KLL is a quantile sketch with guaranteed rank error instead of relative
+// error of values, for data where the relative error is a poor fit
+// (for example, scores in [0, 1], where the median matters most).
+// It is based on the KLL sketch by Karnin, Lang and Liberty.
+//
+// Size of sketch grows only logarithmically with the number of values,
+// and is inversely proportional to the rank error.
+type KLL struct {
+	k      int
+	n      uint64
+	levels [][]float64 // values of levels[h] have weight 2^h
+	rnd    uint64      // state of xorshift generator for compactions
+}
+
+// NewKLL returns sketch estimating quantiles with rank error of at most
+// err ∈ (0, 1): the rank of the returned q-quantile among n values differs
+// from q*(n-1) by at most err*n with probability of 99%.
+func NewKLL(err float64) *KLL {
+	if math.IsNaN(err) || err <= 0 || err >= 1 {
+		panic("err must be in (0, 1)")
+	}
+
+	// Empirical bound on the single-quantile normalized rank error
+	// at 99% confidence, as used by Apache DataSketches.
+	k := math.Ceil(math.Pow(2.296/err, 1/0.9723))
+	if k > kllMaxK {
+		k = kllMaxK
+	}
+
+	return newKLL(int(k))
+}
+
+func newKLL(k int) *KLL {
+	if k < kllMinWidth {
+		k = kllMinWidth
+	}
+
+	return &KLL{
+		k:      k,
+		levels: [][]float64{nil},
+		rnd:    1,
+	}
+}
+
+func (s *KLL) String() string {
+	return fmt.Sprintf("KLL(k=%v)", s.k)
+}
+
+// Size returns the number of values retained by the sketch.
+func (s *KLL) Size() int {
+	n := 0
+	for _, l := range s.levels {
+		n += len(l)
+	}
+	return n
+}
+
+// Count returns the number of added values.
+func (s *KLL) Count() uint64 {
+	return s.n
+}
+
+// Reset resets sketch to the initial empty state.
+func (s *KLL) Reset() {
+	s.n = 0
+	s.levels = s.levels[:1]
+	s.levels[0] = s.levels[0][:0]
+	s.rnd = 1
+}
+
+// Add adds value v to the sketch.
+//
+// Add panics if v is NaN.
+func (s *KLL) Add(v float64) {
+	if math.IsNaN(v) {
+		panic("v must not be NaN")
+	}
+
+	s.levels[0] = append(s.levels[0], v)
+	s.n++
+	s.compress()
+}
+
+// Merge merges the content of v into the sketch.
+//
+// Merge returns an error if sketches have different accuracy.
+func (s *KLL) Merge(v *KLL) error {
+	if v.k != s.k {
+		return fmt.Errorf("can not merge sketch with k=%v into one with k=%v", v.k, s.k)
+	}
+
+	levels := v.levels
+	if v == s {
+		levels = make([][]float64, len(v.levels))
+		for h, l := range v.levels {
+			levels[h] = append([]float64(nil), l...)
+		}
+	}
+	for h, l := range levels {
+		if h == len(s.levels) {
+			s.levels = append(s.levels, nil)
+		}
+		s.levels[h] = append(s.levels[h], l...)
+	}
+	s.n += v.n
+	s.compress()
+
+	return nil
+}
+
+func (s *KLL) capacity(h int) int {
+	depth := len(s.levels) - 1 - h
+	c := int(math.Ceil(float64(s.k) * math.Pow(2.0/3, float64(depth))))
+	if c < kllMinWidth {
+		c = kllMinWidth
+	}
+	return c
+}
+
+func (s *KLL) compress() {
+	for {
+		size, max := 0, 0
+		for h, l := range s.levels {
+			size += len(l)
+			max += s.capacity(h)
+		}
+		if size <= max {
+			return
+		}
+
+		for h, l := range s.levels {
+			if len(l) >= s.capacity(h) {
+				s.compact(h)
+				break
+			}
+		}
+	}
+}
+
+// compact moves every other of the sorted values of level h,
+// starting at random offset, to the level above.
+func (s *KLL) compact(h int) {
+	if h+1 == len(s.levels) {
+		s.levels = append(s.levels, nil)
+	}
+
+	l := s.levels[h]
+	sort.Float64s(l)
+	odd := len(l) % 2
+	for i := odd + int(s.random()&1); i < len(l); i += 2 {
+		s.levels[h+1] = append(s.levels[h+1], l[i])
+	}
+	s.levels[h] = l[:odd]
+}
+
+func (s *KLL) random() uint64 {
+	s.rnd ^= s.rnd << 13
+	s.rnd ^= s.rnd >> 7
+	s.rnd ^= s.rnd << 17
+	return s.rnd
+}
+
+type weighted struct {
+	v float64
+	w uint64
+}
+
+func (s *KLL) sorted() []weighted {
+	items := make([]weighted, 0, s.Size())
+	for h, l := range s.levels {
+		for _, v := range l {
+			items = append(items, weighted{v, 1 << h})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].v < items[j].v })
+
+	return items
+}
+
+// Quantile returns the q-quantile of added values: the value of 0-based
+// rank ⌊q*(n-1)⌋ among the n sorted values, within the rank error.
+//
+// Quantile panics if q is outside [0, 1].
+// Quantile returns NaN for empty sketch.
+func (s *KLL) Quantile(q float64) float64 {
+	if math.IsNaN(q) || q < 0 || q > 1 {
+		panic("q must be in [0, 1]")
+	}
+	if s.n == 0 {
+		return math.NaN()
+	}
+
+	return weightedQuantile(s.sorted(), uint64(q*float64(s.n-1)))
+}
+
+// Quantiles returns the q-quantiles of added values for each of qs,
+// sorting the retained values only once.
+//
+// Quantiles panics if any of qs is outside [0, 1].
+// Quantiles returns NaNs for empty sketch.
+func (s *KLL) Quantiles(qs ...float64) []float64 {
+	for _, q := range qs {
+		if math.IsNaN(q) || q < 0 || q > 1 {
+			panic("q must be in [0, 1]")
+		}
+	}
+
+	res := make([]float64, len(qs))
+	if s.n == 0 {
+		for i := range res {
+			res[i] = math.NaN()
+		}
+		return res
+	}
+
+	items := s.sorted()
+	for i, q := range qs {
+		res[i] = weightedQuantile(items, uint64(q*float64(s.n-1)))
+	}
+
+	return res
+}
+
+func weightedQuantile(items []weighted, rank uint64) float64 {
+	sum := uint64(0)
+	for _, it := range items {
+		sum += it.w
+		if sum > rank {
+			return it.v
+		}
+	}
+
+	return items[len(items)-1].v
+}
+
+// Rank returns the estimated fraction of added values not greater than v.
+//
+// Rank returns NaN for empty sketch.
+func (s *KLL) Rank(v float64) float64 {
+	if s.n == 0 {
+		return math.NaN()
+	}
+
+	sum := uint64(0)
+	for h, l := range s.levels {
+		for _, x := range l {
+			if x <= v {
+				sum += 1 << h
+			}
+		}
+	}
+
+	return float64(sum) / float64(s.n)
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+//
+// Binary representation starts with a magic number and a format version
+// byte, followed by the varint-encoded k, number of values and numbers
+// of values of each level, and the values themselves as 64-bit floats.
+func (s *KLL) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 0, prefixSize+3*binary.MaxVarintLen64+s.Size()*8)
+	buf = append(buf, magic...)
+	buf = append(buf, formatKLL)
+	buf = appendUvarint(buf, uint64(s.k))
+	buf = appendUvarint(buf, s.n)
+	buf = appendUvarint(buf, uint64(len(s.levels)))
+	for _, l := range s.levels {
+		buf = appendUvarint(buf, uint64(len(l)))
+	}
+	for _, l := range s.levels {
+		for _, v := range l {
+			buf = appendUint64(buf, math.Float64bits(v))
+		}
+	}
+
+	return buf, nil
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+func (s *KLL) UnmarshalBinary(data []byte) error {
+	if !hasPrefix(data) || data[len(magic)] != formatKLL {
+		return fmt.Errorf("invalid KLL sketch prefix")
+	}
+
+	r := bytes.NewReader(data[prefixSize:])
+	var hdr [3]uint64
+	for i := range hdr {
+		var err error
+		if hdr[i], err = binary.ReadUvarint(r); err != nil {
+			return fmt.Errorf("failed to read header: %w", err)
+		}
+	}
+	k, n, numLevels := hdr[0], hdr[1], hdr[2]
+	if k < kllMinWidth || k > kllMaxK {
+		return fmt.Errorf("invalid k %v", k)
+	}
+	if numLevels == 0 || numLevels > 64 {
+		return fmt.Errorf("invalid number of levels %v", numLevels)
+	}
+
+	t := newKLL(int(k))
+	t.n = n
+	t.levels = make([][]float64, numLevels)
+	lens := make([]uint64, numLevels)
+	total := uint64(0)
+	for h := range lens {
+		var err error
+		if lens[h], err = binary.ReadUvarint(r); err != nil {
+			return fmt.Errorf("failed to read level %v size: %w", h, err)
+		}
+		if lens[h] > uint64(r.Len())/8 {
+			return fmt.Errorf("level %v size %v exceeds the data size", h, lens[h])
+		}
+		total += lens[h]
+	}
+	if total*8 != uint64(r.Len()) {
+		return fmt.Errorf("wrong values data size: %v bytes instead of %v", r.Len(), total*8)
+	}
+
+	var buf [8]byte
+	weight := uint64(0)
+	for h, m := range lens {
+		if h >= 64 || m > (n-weight)>>h {
+			return fmt.Errorf("invalid number of values %v", n)
+		}
+		t.levels[h] = make([]float64, m)
+		for i := range t.levels[h] {
+			_, _ = io.ReadFull(r, buf[:])
+			v := math.Float64frombits(binary.LittleEndian.Uint64(buf[:]))
+			if math.IsNaN(v) {
+				return fmt.Errorf("invalid value %v", v)
+			}
+			t.levels[h][i] = v
+		}
+		weight += m << h
+	}
+	if (weight == 0) != (n == 0) {
+		return fmt.Errorf("invalid number of values %v", n)
+	}
+
+	*s = *t
+	return nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest_test
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/rapid"
+)
+
+func TestKLL(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			rankErr = rapid.SampledFrom([]float64{0.01, 0.05, 0.1}).Draw(t, "rank error")
+			seed    = rapid.Int64().Draw(t, "seed")
+			count   = rapid.IntRange(1, 20000).Draw(t, "count")
+			parts   = rapid.IntRange(1, 4).Draw(t, "parts")
+		)
+
+		r := rand.New(rand.NewSource(seed))
+		s := bdigest.NewKLL(rankErr)
+		values := make([]float64, count)
+		for i := range values {
+			values[i] = math.Floor(r.Float64() * 1000)
+		}
+		for p := 0; p < parts; p++ {
+			k := bdigest.NewKLL(rankErr)
+			for _, v := range values[p*count/parts : (p+1)*count/parts] {
+				k.Add(v)
+			}
+			if err := s.Merge(k); err != nil {
+				t.Fatalf("failed to merge %v: %v", k, err)
+			}
+		}
+		if s.Count() != uint64(count) {
+			t.Fatalf("got count %v instead of %v", s.Count(), count)
+		}
+		sort.Float64s(values)
+
+		// the guarantee is probabilistic; allow for a margin
+		maxErr := 2 * rankErr * float64(count)
+		for _, q := range quantiles {
+			v := s.Quantile(q)
+			rank := math.Floor(q * float64(count-1))
+			lo := sort.SearchFloat64s(values, v)
+			hi := sort.Search(count, func(i int) bool { return values[i] > v }) - 1
+			if lo > hi {
+				t.Fatalf("q%v: %v was not added", q, v)
+			}
+			if d := math.Max(float64(lo)-rank, rank-float64(hi)); d > maxErr {
+				t.Fatalf("q%v: rank of %v is [%v, %v] instead of %v (max error %v)", q, v, lo, hi, rank, maxErr)
+			}
+		}
+
+		data, err := s.MarshalBinary()
+		if err != nil {
+			t.Fatalf("failed to marshal %v: %v", s, err)
+		}
+		var s2 bdigest.KLL
+		if err := s2.UnmarshalBinary(data); err != nil {
+			t.Fatalf("failed to unmarshal %v: %v", s, err)
+		}
+		q1, q2 := s.Quantiles(quantiles...), s2.Quantiles(quantiles...)
+		for i := range q1 {
+			if q1[i] != q2[i] {
+				t.Fatalf("q%v after unmarshal is %v instead of %v", quantiles[i], q2[i], q1[i])
+			}
+		}
+	})
+}
+
+func TestKLL_Empty(t *testing.T) {
+	t.Parallel()
+
+	s := bdigest.NewKLL(0.01)
+	if q := s.Quantile(0.5); !math.IsNaN(q) {
+		t.Fatalf("empty sketch median is %v", q)
+	}
+	if err := s.Merge(bdigest.NewKLL(0.1)); err == nil {
+		t.Fatalf("merged sketches with different accuracy")
+	}
+	data, _ := s.MarshalBinary()
+	if err := new(bdigest.Digest).UnmarshalBinary(data); err == nil {
+		t.Fatalf("unmarshaled sketch as digest")
+	}
+}
+
+func TestKLL_UnmarshalOverflow(t *testing.T) {
+	t.Parallel()
+
+	empty, _ := bdigest.NewKLL(0.01).MarshalBinary()
+	data := append([]byte(nil), empty[:len(empty)-3]...) // prefix and k
+	data = append(data, 0, 64)                           // count 0, 64 levels
+	data = append(data, make([]byte, 63)...)
+	data = append(data, 2) // 2 values of weight 2^63 each
+	data = append(data, make([]byte, 16)...)
+
+	var s bdigest.KLL
+	if err := s.UnmarshalBinary(data); err == nil {
+		t.Fatalf("unmarshaled sketch with overflowing weight")
+	}
+}
@@ -0,0 +1,445 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+var (
+	errCustomMapping = errors.New("can not marshal digest with custom index mapping")
+)
+
+// IndexMapping maps positive values to the keys of histogram buckets.
+// Bucket k holds the values in (LowerBound(k), LowerBound(k+1)].
+//
+// For the digest to have relative error err, Value(k) must be within err
+// of all the values of bucket k, and the relative error of Index must be
+// small enough for LowerBound(Index(v)) < v <= LowerBound(Index(v)+1)
+// to hold.
+type IndexMapping interface {
+	// Index returns the key of the bucket holding v > 0.
+	Index(v float64) int
+	// Value returns the representative value of bucket k.
+	Value(k int) float64
+	// LowerBound returns the exclusive lower bound of bucket k.
+	LowerBound(k int) float64
+}
+
+// NewIndexMapping returns the IndexMapping used by digests created
+// with NewDigest(err, WithMapping(m)).
+//
+// NewIndexMapping panics if m is not a known mapping
+// or err is outside (0, 1).
+func NewIndexMapping(m Mapping, err float64) IndexMapping {
+	if m >= numMappings {
+		panic(fmt.Sprintf("unknown mapping %v", m))
+	}
+	if math.IsNaN(err) || err <= 0 || err >= 1 {
+		panic("err must be in (0, 1)")
+	}
+
+	return newBuiltinMapping(m, err)
+}
+
+// WithIndexMapping makes digest use custom mapping m.
+// Digest has relative error err only if m provides it.
+//
+// Digests with custom mapping can not be marshaled, and can only be merged
+// with digests with the same mapping, as compared by ==.
+// Custom mapping is replaced with the one of the data by unmarshaling.
+//
+// WithIndexMapping panics if m is nil or its type is not comparable
+// (like a struct with a slice field; use a pointer to it instead).
+func WithIndexMapping(m IndexMapping) Option {
+	if m == nil || !reflect.TypeOf(m).Comparable() {
+		panic("m must be non-nil and of comparable type")
+	}
+
+	return func(d *Digest) {
+		d.index = m
+	}
+}
+
+// NewGammaDigest returns digest like NewDigest, but with explicit
+// logarithmic mapping parameters instead of the relative error, so that
+// the keys of histogram buckets align with the ones of sketches produced
+// by other libraries. Bucket k holds the values in (γ^(k-1-offset), γ^(k-offset)],
+// that is, the key of v is ⌈log_γ(v) + offset⌉. For libraries using
+// ⌊log_γ(v) + o⌋ as the key (like DDSketch), offset of o-1 results
+// in the same keys for all the values but the exact powers of γ.
+// Relative error of the digest is (γ-1)/(γ+1).
+//
+// Digests created with NewGammaDigest can not be marshaled, and can
+// only be merged with digests with the same gamma and offset.
+//
+// NewGammaDigest panics if gamma is not in (1, math.MaxFloat64]
+// or offset is not finite.
+func NewGammaDigest(gamma float64, offset float64, opts ...Option) *Digest {
+	if math.IsNaN(gamma) || gamma <= 1 || gamma > math.MaxFloat64 {
+		panic("gamma must be in (1, math.MaxFloat64]")
+	}
+	if math.IsNaN(offset) || math.IsInf(offset, 0) {
+		panic("offset must be finite")
+	}
+
+	m := gammaMapping{gamma: gamma, gammaLn: math.Log(gamma), offset: offset}
+	return newDigest((gamma-1)/(gamma+1), m, opts)
+}
+
+// gammaMapping is the logarithmic mapping with explicit parameters.
+type gammaMapping struct {
+	gamma   float64
+	gammaLn float64
+	offset  float64
+}
+
+func (m gammaMapping) Index(v float64) int {
+	return int(math.Ceil(math.Log(v)/m.gammaLn + m.offset))
+}
+
+func (m gammaMapping) Value(k int) float64 {
+	return 2 * m.LowerBound(k+1) / (m.gamma + 1)
+}
+
+func (m gammaMapping) LowerBound(k int) float64 {
+	return math.Exp((float64(k-1) - m.offset) * m.gammaLn)
+}
+
+func (m gammaMapping) String() string {
+	return fmt.Sprintf("gamma(%v, offset=%v)", m.gamma, m.offset)
+}
+
+// Mapping selects how values are mapped to histogram buckets.
+// All mappings guarantee the same relative error, but differ
+// in the speed of Add and in the number of buckets used.
+type Mapping byte
+
+const (
+	// MappingLogarithmic maps values to buckets using the logarithm,
+	// which results in the minimal number of buckets.
+	MappingLogarithmic Mapping = iota
+	// MappingLinear approximates the logarithm by linear interpolation
+	// between the powers of 2, which avoids the expensive math.Log call
+	// in Add at the cost of about 44% more buckets.
+	MappingLinear
+	// MappingCubic approximates the logarithm by cubic interpolation
+	// between the powers of 2, which avoids the expensive math.Log call
+	// in Add at the cost of about 1% more buckets.
+	MappingCubic
+	// MappingHDR maps values to buckets like HdrHistogram, dividing
+	// each range between the powers of 2 into 2^p linear sub-buckets,
+	// with p the smallest precision providing the relative error.
+	// Bucket bounds are exact binary fractions, so that the buckets
+	// align bit-for-bit with the ones of HDR-style histograms, at the cost
+	// of up to 2 times more buckets than MappingLinear.
+	MappingHDR
+
+	numMappings = iota
+)
+
+var mappingNames = [numMappings]string{
+	MappingLogarithmic: "logarithmic",
+	MappingLinear:      "linear",
+	MappingCubic:       "cubic",
+	MappingHDR:         "hdr",
+}
+
+// String returns the name of the mapping.
+func (m Mapping) String() string {
+	if int(m) < len(mappingNames) {
+		return mappingNames[m]
+	}
+	return fmt.Sprintf("Mapping(%d)", m)
+}
+
+// WithMapping makes digest use mapping m instead of MappingLogarithmic.
+//
+// WithMapping panics if m is not a known mapping.
+func WithMapping(m Mapping) Option {
+	if m >= numMappings {
+		panic(fmt.Sprintf("unknown mapping %v", m))
+	}
+
+	return func(d *Digest) {
+		d.mapping = newBuiltinMapping(m, d.alpha)
+	}
+}
+
+// Remap returns digest with the content of d, converted to mapping m,
+// for interoperation with digests and histograms using m.
+// Since values inside each bucket of d are unknown, they are assumed
+// to be distributed uniformly within it, and the buckets are apportioned
+// between the overlapping buckets of m. As a result, the relative error
+// of the returned digest is that of d widened by the relative error.
+//
+// Remap panics if m is not a known mapping.
+func (d *Digest) Remap(m Mapping) *Digest {
+	if m >= numMappings {
+		panic(fmt.Sprintf("unknown mapping %v", m))
+	}
+
+	r := &Digest{
+		alpha:       d.alpha,
+		mapping:     newBuiltinMapping(m, d.alpha),
+		store:       &denseStore{},
+		numZero:     d.numZero,
+		dropped:     d.dropped,
+		hint:        d.hint,
+		interpolate: d.interpolate,
+		rank:        d.rank,
+		budget:      d.budget,
+	}
+	if d.store != nil {
+		r.store = d.store.Empty()
+	}
+	d.ascend(func(k int, n uint64) bool {
+		r.addInterval(d.lowerBound(k), d.lowerBound(k+1), n)
+		return true
+	})
+
+	return r
+}
+
+// Compress returns digest with the content of d, converted to a larger
+// relative error newErr, for example to reduce the size of digests kept
+// for a long time. If newErr is the relative error of d coarsened one
+// or more times (like WithMemoryBudget does, squaring gamma each time),
+// adjacent buckets are merged and the relative error is exactly newErr.
+// Otherwise, the buckets are apportioned like in Remap, and the relative
+// error of the returned digest is newErr widened by the relative error of d.
+//
+// Compress returns an error if newErr is less than the relative error of d,
+// or if d uses custom index mapping.
+//
+// Compress panics if newErr is outside (0, 1).
+func (d *Digest) Compress(newErr float64) (*Digest, error) {
+	if math.IsNaN(newErr) || newErr <= 0 || newErr >= 1 {
+		panic("err must be in (0, 1)")
+	}
+	if d.index != nil {
+		return nil, errors.New("can not compress digest with custom index mapping")
+	}
+	if newErr < d.alpha && !approxEqual(newErr, d.alpha) {
+		return nil, fmt.Errorf("can not compress digest with relative error %v%% into smaller %v%%", d.alpha*100, newErr*100)
+	}
+
+	r := &Digest{
+		alpha:       newErr,
+		mapping:     newBuiltinMapping(d.mapping.kind, newErr),
+		store:       &denseStore{},
+		numZero:     d.numZero,
+		dropped:     d.dropped,
+		hint:        d.hint,
+		interpolate: d.interpolate,
+		rank:        d.rank,
+		budget:      d.budget,
+	}
+	if d.store != nil {
+		r.store = d.store.Empty()
+	}
+
+	n, m := 0, d.mapping
+	for ; m.gammaLn < r.mapping.gammaLn && !approxEqual(m.gammaLn, r.mapping.gammaLn); n++ {
+		var ok bool
+		if m, ok = m.doubled(); !ok {
+			break
+		}
+	}
+	if approxEqual(m.gammaLn, r.mapping.gammaLn) && m.bits == r.mapping.bits {
+		d.ascend(func(k int, c uint64) bool {
+			if c > 0 {
+				r.addKey(-(-k >> n), c)
+			}
+			return true
+		})
+	} else {
+		d.ascend(func(k int, c uint64) bool {
+			r.addInterval(d.lowerBound(k), d.lowerBound(k+1), c)
+			return true
+		})
+	}
+
+	return r, nil
+}
+
+// approxEqual reports whether a and b are equal up to rounding errors.
+func approxEqual(a float64, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*math.Max(math.Abs(a), math.Abs(b))
+}
+
+// builtinMapping implements IndexMapping for the built-in mappings.
+type builtinMapping struct {
+	kind    Mapping
+	gamma   float64
+	gammaLn float64
+	bits    int // log2 of the number of sub-buckets for MappingHDR
+}
+
+func newBuiltinMapping(m Mapping, alpha float64) builtinMapping {
+	b := builtinMapping{
+		kind:    m,
+		gamma:   1 + 2*alpha/(1-alpha),
+		gammaLn: math.Log1p(2 * alpha / (1 - alpha)),
+	}
+	if m == MappingHDR {
+		// Widest sub-bucket, the first one, is 1+2^-p times wide.
+		b.bits = int(math.Ceil(-math.Log2(2 * alpha / (1 - alpha))))
+		if b.bits < 0 {
+			b.bits = 0
+		}
+	}
+
+	return b
+}
+
+func (m builtinMapping) Index(v float64) int {
+	switch m.kind {
+	case MappingLinear:
+		return int(math.Ceil(linearLog2(v) / m.gammaLn))
+	case MappingCubic:
+		return int(math.Ceil(cubicLog2(v) / (cubicSlope * m.gammaLn)))
+	case MappingHDR:
+		frac, exp := math.Frexp(v)
+		sub := math.Ceil(math.Ldexp(2*frac-1, m.bits))
+		return (exp-1)<<m.bits + int(sub)
+	}
+
+	logGammaV := math.Log(v) / m.gammaLn
+	return int(math.Ceil(logGammaV))
+}
+
+func (m builtinMapping) Value(k int) float64 {
+	if m.kind != MappingLogarithmic {
+		// Harmonic mean of the bucket bounds has relative error
+		// of at most alpha for any bucket at most gamma times wide.
+		lo, hi := m.LowerBound(k), m.LowerBound(k+1)
+		return 2 * lo / (1 + lo/hi)
+	}
+
+	powGammaK := math.Exp(float64(k) * m.gammaLn)
+	return 2 * powGammaK / (m.gamma + 1)
+}
+
+func (m builtinMapping) LowerBound(k int) float64 {
+	switch m.kind {
+	case MappingLinear:
+		return linearExp2(float64(k-1) * m.gammaLn)
+	case MappingCubic:
+		return cubicExp2(float64(k-1) * cubicSlope * m.gammaLn)
+	case MappingHDR:
+		exp := (k - 1) >> m.bits
+		sub := (k - 1) - exp<<m.bits
+		return math.Ldexp(1+math.Ldexp(float64(sub), -m.bits), exp)
+	}
+
+	return math.Exp(float64(k-1) * m.gammaLn)
+}
+
+// doubled returns the mapping with squared gamma, where bucket k holds
+// the values of buckets 2k-1 and 2k, if there is one.
+func (m builtinMapping) doubled() (builtinMapping, bool) {
+	g := m.gamma * m.gamma
+	if math.IsInf(g, 1) || (m.kind == MappingHDR && m.bits == 0) {
+		return m, false
+	}
+
+	d := builtinMapping{kind: m.kind, gamma: g, gammaLn: 2 * m.gammaLn}
+	if m.kind == MappingHDR {
+		d.bits = m.bits - 1
+	}
+	return d, true
+}
+
+// doublings returns the number of times m has to be doubled to become c,
+// or 0 if it is not possible.
+func (m builtinMapping) doublings(c builtinMapping) int {
+	for n := 1; m.kind == c.kind && m.gammaLn < c.gammaLn; n++ {
+		var ok bool
+		if m, ok = m.doubled(); !ok {
+			return 0
+		}
+		if m == c {
+			return n
+		}
+	}
+
+	return 0
+}
+
+func (m builtinMapping) String() string {
+	return m.kind.String()
+}
+
+func parseMapping(name string) (Mapping, error) {
+	for m, n := range mappingNames {
+		if n == name {
+			return Mapping(m), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown mapping %q", name)
+}
+
+// linearLog2 approximates log2(x) by linear interpolation
+// between the powers of 2, matching it at those points.
+// For x = m*2^e with m in [1, 2) it returns e + m - 1.
+func linearLog2(x float64) float64 {
+	frac, exp := math.Frexp(x)
+	return float64(exp-1) + (2*frac - 1)
+}
+
+// linearExp2 is the inverse of linearLog2.
+func linearExp2(y float64) float64 {
+	e := math.Floor(y)
+	return math.Ldexp(1+y-e, int(e))
+}
+
+// Coefficients of the cubic polynomial of cubicLog2, as in DDSketch.
+const (
+	cubicA = 6.0 / 35
+	cubicB = -3.0 / 5
+	cubicC = 10.0 / 7
+
+	// cubicSlope is the minimum derivative of cubicLog2(x) with respect
+	// to ln(x), so that buckets of width cubicSlope*gammaLn in terms of
+	// cubicLog2 are at most gamma times wide.
+	cubicSlope = cubicC
+)
+
+// cubicLog2 approximates log2(x) by cubic interpolation between
+// the powers of 2, matching it at those points. For x = (1+s)*2^e
+// with s in [0, 1) it returns e + P(s) for a cubic polynomial P.
+func cubicLog2(x float64) float64 {
+	frac, exp := math.Frexp(x)
+	s := 2*frac - 1
+	return float64(exp-1) + ((cubicA*s+cubicB)*s+cubicC)*s
+}
+
+// cubicExp2 is the inverse of cubicLog2, solving the cubic equation
+// using Cardano's formula.
+func cubicExp2(y float64) float64 {
+	e := math.Floor(y)
+	t := y - e
+
+	d0 := cubicB*cubicB - 3*cubicA*cubicC
+	d1 := 2*cubicB*cubicB*cubicB - 9*cubicA*cubicB*cubicC - 27*cubicA*cubicA*t
+	c := math.Cbrt((d1 - math.Sqrt(d1*d1-4*d0*d0*d0)) / 2)
+	s := -(cubicB + c + d0/c) / (3 * cubicA)
+
+	return math.Ldexp(1+s, int(e))
+}
@@ -0,0 +1,337 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest_test
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/rapid"
+)
+
+var (
+	mappings = []bdigest.Mapping{bdigest.MappingLogarithmic, bdigest.MappingLinear, bdigest.MappingCubic, bdigest.MappingHDR}
+)
+
+func TestMappingRoundtrip(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr  = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			seed    = rapid.Int64().Draw(t, "seed")
+			count   = rapid.IntRange(0, 10000).Draw(t, "count")
+			mapping = rapid.SampledFrom(mappings).Draw(t, "mapping")
+			opts    = rapid.Custom(func(t *rapid.T) bdigest.MarshalOptions {
+				return bdigest.MarshalOptions{
+					Compact:  rapid.Bool().Draw(t, "compact"),
+					Checksum: rapid.Bool().Draw(t, "checksum"),
+				}
+			}).Draw(t, "options")
+		)
+
+		d1 := logNormalDigest(relErr, seed, count, int32(count)/10, bdigest.WithMapping(mapping))
+		data, err := opts.Marshal(d1)
+		if err != nil {
+			t.Fatalf("failed to marshal digest: %v", err)
+		}
+
+		d2 := &bdigest.Digest{}
+		err = d2.UnmarshalBinary(data)
+		if err != nil {
+			t.Fatalf("failed to unmarshal digest: %v", err)
+		}
+		if !reflect.DeepEqual(d1, d2) {
+			t.Fatalf("got back %#v which is different than %#v", d2, d1)
+		}
+
+		d3 := &bdigest.Digest{}
+		_, err = d3.ReadFrom(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("failed to read digest: %v", err)
+		}
+		if !reflect.DeepEqual(d1, d3) {
+			t.Fatalf("got back %#v which is different than %#v", d3, d1)
+		}
+
+		text, err := d1.MarshalText()
+		if err != nil {
+			t.Fatalf("failed to marshal digest to text: %v", err)
+		}
+		d4 := &bdigest.Digest{}
+		err = d4.UnmarshalText(text)
+		if err != nil {
+			t.Fatalf("failed to unmarshal digest from text: %v", err)
+		}
+		text4, _ := d4.MarshalText()
+		if !bytes.Equal(text, text4) {
+			t.Fatalf("got back %q which is different than %q", text4, text)
+		}
+
+		d5 := bdigest.NewDigest(relErr, bdigest.WithMapping(mapping))
+		err = d5.MergeBinary(data)
+		if err != nil {
+			t.Fatalf("failed to merge digest: %v", err)
+		}
+		if d5.Count() != d1.Count() || d5.Quantile(0.5) != d1.Quantile(0.5) && count > 0 {
+			t.Fatalf("merged %v which is different than %v", d5, d1)
+		}
+	})
+}
+
+func TestMappingMismatch(t *testing.T) {
+	t.Parallel()
+
+	d1 := bdigest.NewDigest(0.01)
+	d2 := bdigest.NewDigest(0.01, bdigest.WithMapping(bdigest.MappingLinear))
+	d2.Add(1)
+	if err := d1.Merge(d2); err == nil {
+		t.Fatalf("merged digest with different mapping")
+	}
+
+	data, err := d2.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal digest: %v", err)
+	}
+	if err := d1.MergeBinary(data); err == nil {
+		t.Fatalf("merged digest with different mapping")
+	}
+	if d1.Count() != 0 {
+		t.Fatalf("failed merge changed the digest")
+	}
+}
+
+type offsetMapping struct {
+	bdigest.IndexMapping
+	offset int
+}
+
+func (m *offsetMapping) Index(v float64) int {
+	return m.IndexMapping.Index(v) + m.offset
+}
+
+func (m *offsetMapping) Value(k int) float64 {
+	return m.IndexMapping.Value(k - m.offset)
+}
+
+func (m *offsetMapping) LowerBound(k int) float64 {
+	return m.IndexMapping.LowerBound(k - m.offset)
+}
+
+func TestIndexMapping(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr  = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			seed    = rapid.Int64().Draw(t, "seed")
+			count   = rapid.IntRange(0, 10000).Draw(t, "count")
+			mapping = rapid.SampledFrom(mappings).Draw(t, "mapping")
+			offset  = rapid.IntRange(-1000, 1000).Draw(t, "offset")
+			q       = rapid.Float64Range(0, 1).Draw(t, "quantile")
+		)
+
+		m := &offsetMapping{bdigest.NewIndexMapping(mapping, relErr), offset}
+		d1 := logNormalDigest(relErr, seed, count, int32(count)/10, bdigest.WithMapping(mapping))
+		d2 := logNormalDigest(relErr, seed, count, int32(count)/10, bdigest.WithIndexMapping(m))
+		d3 := logNormalDigest(relErr, seed, count, int32(count)/10, bdigest.WithIndexMapping(bdigest.NewIndexMapping(mapping, relErr)))
+		for _, d := range []*bdigest.Digest{d2, d3} {
+			if q1, q2 := d1.Quantile(q), d.Quantile(q); q1 != q2 && count > 0 {
+				t.Fatalf("q%v is %v instead of %v", q, q2, q1)
+			}
+		}
+
+		if err := d2.Merge(logNormalDigest(relErr, seed, count, int32(count)/10, bdigest.WithIndexMapping(m))); err != nil {
+			t.Fatalf("failed to merge digests with the same mapping: %v", err)
+		}
+		if err := d2.Merge(d1); err == nil {
+			t.Fatalf("merged digests with different mappings")
+		}
+		if _, err := d2.MarshalBinary(); err == nil {
+			t.Fatalf("marshaled digest with custom mapping")
+		}
+		if _, err := d2.MarshalText(); err == nil {
+			t.Fatalf("marshaled digest with custom mapping to text")
+		}
+	})
+}
+
+type boundsMapping struct {
+	bounds []float64
+}
+
+func (m boundsMapping) Index(v float64) int      { return sort.SearchFloat64s(m.bounds, v) }
+func (m boundsMapping) Value(k int) float64      { return m.bounds[k] }
+func (m boundsMapping) LowerBound(k int) float64 { return m.bounds[k-1] }
+
+func TestWithIndexMapping_Uncomparable(t *testing.T) {
+	t.Parallel()
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("no panic for uncomparable mapping")
+		}
+	}()
+	bdigest.WithIndexMapping(boundsMapping{[]float64{1, 2, 4}})
+}
+
+func TestDigest_Remap(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-3, 0.1).Draw(t, "relative error")
+			seed   = rapid.Int64().Draw(t, "seed")
+			count  = rapid.IntRange(0, 10000).Draw(t, "count")
+			from   = rapid.SampledFrom(mappings).Draw(t, "from")
+			to     = rapid.SampledFrom(mappings).Draw(t, "to")
+		)
+
+		d1 := logNormalDigest(relErr, seed, count, int32(count)/10, bdigest.WithMapping(from))
+		d2 := d1.Remap(to)
+		if d2.Count() != d1.Count() {
+			t.Fatalf("remapped digest count is %v instead of %v", d2.Count(), d1.Count())
+		}
+		if err := d2.Merge(bdigest.NewDigest(relErr, bdigest.WithMapping(to))); err != nil {
+			t.Fatalf("failed to merge into remapped digest: %v", err)
+		}
+		if count == 0 {
+			return
+		}
+
+		// Each remapped value can end up anywhere in the buckets
+		// overlapping the original one, of two mappings.
+		gamma := 1 + 2*relErr/(1-relErr)
+		for _, q := range quantiles {
+			q1, q2 := d1.Quantile(q), d2.Quantile(q)
+			if r := q2 / q1; r > gamma*gamma || r < 1/(gamma*gamma) {
+				t.Fatalf("q%v of remapped digest is %v instead of %v", q, q2, q1)
+			}
+		}
+	})
+}
+
+func TestDigest_Compress(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr    = rapid.Float64Range(1e-3, 0.1).Draw(t, "relative error")
+			mapping   = rapid.SampledFrom(mappings[:3]).Draw(t, "mapping")
+			seed      = rapid.Int64().Draw(t, "seed")
+			count     = rapid.IntRange(1, 1000).Draw(t, "count")
+			doublings = rapid.IntRange(0, 3).Draw(t, "doublings")
+		)
+
+		d := bdigest.NewDigest(relErr, bdigest.WithMapping(mapping))
+		values := logNormalValues(seed, count, 0)
+		for _, v := range values {
+			d.Add(v)
+		}
+
+		// Squaring gamma keeps the exact relative error.
+		gamma := 1 + 2*relErr/(1-relErr)
+		g := math.Pow(gamma, math.Pow(2, float64(doublings)))
+		newErr := (g - 1) / (g + 1)
+		c, err := d.Compress(newErr)
+		if err != nil {
+			t.Fatalf("failed to compress digest to %v relative error: %v", newErr, err)
+		}
+		if c.Count() != d.Count() || c.Size() > d.Size() {
+			t.Fatalf("compressed digest %v does not match %v", c, d)
+		}
+		for _, q := range quantiles {
+			v, e := c.Quantile(q), exactQuantile(values, q, bdigest.RankLower)
+			if math.Abs(v-e) > e*newErr*(1+1e-6) {
+				t.Fatalf("q%v of compressed digest is %v instead of %v", q, v, e)
+			}
+		}
+
+		if _, err := d.Compress(relErr / 2); err == nil {
+			t.Fatalf("compressed digest to smaller relative error")
+		}
+	})
+}
+
+func TestDigest_CompressApportion(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr  = rapid.Float64Range(1e-3, 0.1).Draw(t, "relative error")
+			newErr  = rapid.Float64Range(relErr, 0.2).Draw(t, "new relative error")
+			mapping = rapid.SampledFrom(mappings).Draw(t, "mapping")
+			seed    = rapid.Int64().Draw(t, "seed")
+			count   = rapid.IntRange(1, 1000).Draw(t, "count")
+		)
+
+		d := logNormalDigest(relErr, seed, count, int32(count)/10, bdigest.WithMapping(mapping))
+		c, err := d.Compress(newErr)
+		if err != nil {
+			t.Fatalf("failed to compress digest to %v relative error: %v", newErr, err)
+		}
+		if c.Count() != d.Count() {
+			t.Fatalf("compressed digest count is %v instead of %v", c.Count(), d.Count())
+		}
+		if err := c.Merge(bdigest.NewDigest(newErr, bdigest.WithMapping(mapping))); err != nil {
+			t.Fatalf("failed to merge into compressed digest: %v", err)
+		}
+
+		gamma := (1 + relErr) / (1 - relErr) * (1 + newErr) / (1 - newErr)
+		for _, q := range quantiles {
+			q1, q2 := d.Quantile(q), c.Quantile(q)
+			if r := q2 / q1; r > gamma || r < 1/gamma {
+				t.Fatalf("q%v of compressed digest is %v instead of %v", q, q2, q1)
+			}
+		}
+	})
+}
+
+func TestGammaDigest(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			gamma  = rapid.Float64Range(1.001, 2).Draw(t, "gamma")
+			offset = rapid.Float64Range(-1000, 1000).Draw(t, "offset")
+			values = rapid.SliceOfN(rapid.Float64Range(1e-6, 1e6), 1, 1000).Draw(t, "values")
+		)
+
+		d := bdigest.NewGammaDigest(gamma, offset)
+		for _, v := range values {
+			d.Add(v)
+		}
+		sort.Float64s(values)
+
+		relErr := (gamma - 1) / (gamma + 1)
+		for _, q := range quantiles {
+			want := values[int(q*float64(len(values)-1))]
+			if got := d.Quantile(q); math.Abs(got-want) > want*relErr*(1+1e-9) {
+				t.Fatalf("q%v is %v instead of %v", q, got, want)
+			}
+		}
+
+		if err := d.Merge(bdigest.NewGammaDigest(gamma, offset)); err != nil {
+			t.Fatalf("failed to merge digests with the same parameters: %v", err)
+		}
+		if err := d.Merge(bdigest.NewGammaDigest(gamma, offset+1)); err == nil {
+			t.Fatalf("merged digests with different offsets")
+		}
+	})
+}
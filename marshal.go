@@ -0,0 +1,121 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+const (
+	// flagChecksum is set in the format version byte when
+	// the data is followed by a CRC-32C checksum.
+	flagChecksum = 0x80
+	checksumSize = 4
+
+	// The mapping of the digest is stored in the format version byte
+	// above the format itself.
+	formatMask   = 0x0f
+	mappingShift = 4
+	mappingMask  = 0x70
+)
+
+var (
+	// ErrChecksum is returned when decoding data whose checksum
+	// does not match its content.
+	ErrChecksum = errors.New("digest checksum mismatch")
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// MarshalOptions configures binary encoding of digests.
+// All the encoded forms can be decoded with UnmarshalBinary.
+type MarshalOptions struct {
+	// Compact selects the format of MarshalCompact
+	// instead of the one of MarshalBinary.
+	Compact bool
+	// Checksum appends a CRC-32C checksum of the data, which is
+	// verified during decoding to detect corruption or truncation.
+	Checksum bool
+}
+
+// Marshal returns the binary representation of d.
+func (o MarshalOptions) Marshal(d *Digest) ([]byte, error) {
+	return o.Append(nil, d)
+}
+
+// Append appends the binary representation of d to dst
+// and returns the extended buffer.
+func (o MarshalOptions) Append(dst []byte, d *Digest) ([]byte, error) {
+	start := len(dst)
+	var err error
+	if o.Compact {
+		dst, err = d.appendCompact(dst)
+	} else {
+		dst, err = d.AppendBinary(dst)
+	}
+	if err != nil || !o.Checksum {
+		return dst, err
+	}
+
+	dst[start+len(magic)] |= flagChecksum
+	var sum [checksumSize]byte
+	binary.LittleEndian.PutUint32(sum[:], crc32.Checksum(dst[start:], crcTable))
+
+	return append(dst, sum[:]...), nil
+}
+
+// parsePrefix returns the format, the mapping and the body of data following
+// the prefix, verifying and stripping the checksum if it is present.
+func parsePrefix(data []byte) (byte, Mapping, []byte, error) {
+	if !hasPrefix(data) {
+		return formatFixed, MappingLogarithmic, data, nil // legacy format
+	}
+
+	v := data[len(magic)]
+	if v&flagChecksum != 0 {
+		if len(data) < prefixSize+checksumSize {
+			return 0, 0, nil, fmt.Errorf("not enough data to read checksum: %v bytes", len(data))
+		}
+		n := len(data) - checksumSize
+		if binary.LittleEndian.Uint32(data[n:]) != crc32.Checksum(data[:n], crcTable) {
+			return 0, 0, nil, ErrChecksum
+		}
+		data = data[:n]
+	}
+
+	format, m, err := parseVersion(v)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	return format, m, data[prefixSize:], nil
+}
+
+// parseVersion splits the format version byte into the format and the mapping.
+func parseVersion(v byte) (byte, Mapping, error) {
+	format := v & formatMask
+	if format != formatFixed && format != formatCompact {
+		return 0, 0, fmt.Errorf("unsupported format version %v", v&^flagChecksum)
+	}
+	m := Mapping((v & mappingMask) >> mappingShift)
+	if m >= numMappings {
+		return 0, 0, fmt.Errorf("unsupported mapping %v", m)
+	}
+
+	return format, m, nil
+}
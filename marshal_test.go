@@ -0,0 +1,99 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/rapid"
+)
+
+func TestMarshalOptions_Roundtrip(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-5, 1-1e-5).Draw(t, "relative error")
+			seed   = rapid.Int64().Draw(t, "seed")
+			count  = rapid.IntRange(0, 100000).Draw(t, "count")
+			opts   = bdigest.MarshalOptions{
+				Compact:  rapid.Bool().Draw(t, "compact"),
+				Checksum: rapid.Bool().Draw(t, "checksum"),
+			}
+		)
+
+		d1 := logNormalDigest(relErr, seed, count, int32(count)/10)
+		data, err := opts.Marshal(d1)
+		if err != nil {
+			t.Fatalf("failed to marshal digest: %v", err)
+		}
+
+		d2 := &bdigest.Digest{}
+		if err = d2.UnmarshalBinary(data); err != nil {
+			t.Fatalf("failed to unmarshal digest: %v", err)
+		}
+		if !reflect.DeepEqual(d1, d2) {
+			t.Fatalf("got back %#v which is different than %#v", d2, d1)
+		}
+
+		d3 := &bdigest.Digest{}
+		if _, err = d3.ReadFrom(bytes.NewReader(data)); err != nil {
+			t.Fatalf("failed to read digest: %v", err)
+		}
+		if !reflect.DeepEqual(d1, d3) {
+			t.Fatalf("got back %#v which is different than %#v", d3, d1)
+		}
+	})
+}
+
+func TestMarshalOptions_Checksum(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			count   = rapid.IntRange(0, 1000).Draw(t, "count")
+			compact = rapid.Bool().Draw(t, "compact")
+		)
+
+		d := logNormalDigest(0.01, 0, count, 10)
+		data, err := bdigest.MarshalOptions{Compact: compact, Checksum: true}.Marshal(d)
+		if err != nil {
+			t.Fatalf("failed to marshal digest: %v", err)
+		}
+
+		i := rapid.IntRange(4, len(data)-1).Draw(t, "corrupted byte")
+		corrupted := append([]byte(nil), data...)
+		corrupted[i] ^= rapid.ByteRange(1, 255).Draw(t, "corruption")
+		truncated := data[:rapid.IntRange(0, len(data)-1).Draw(t, "truncated size")]
+
+		for _, bad := range [][]byte{corrupted, truncated} {
+			if err = (&bdigest.Digest{}).UnmarshalBinary(bad); err == nil {
+				t.Fatalf("unmarshaled corrupted data")
+			}
+			if _, err = (&bdigest.Digest{}).ReadFrom(bytes.NewReader(bad)); err == nil {
+				t.Fatalf("read corrupted data")
+			}
+			if err = logNormalDigest(0.01, 0, count, 10).MergeBinary(bad); err == nil {
+				t.Fatalf("merged corrupted data")
+			}
+		}
+		if err = (&bdigest.Digest{}).UnmarshalBinary(corrupted); err != bdigest.ErrChecksum {
+			t.Fatalf("got %v instead of checksum error", err)
+		}
+	})
+}
@@ -0,0 +1,76 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+)
+
+var (
+	errNoDigests = errors.New("no digests to merge")
+)
+
+// ParallelMerge returns new digest with the merged content of all of ds,
+// splitting the work between up to workers goroutines
+// (or runtime.GOMAXPROCS(0), if workers is zero or negative).
+// Digests ds must be distinct, and are not modified.
+//
+// ParallelMerge returns an error if ds is empty
+// or the digests have different relative errors,
+// and ErrOverflow if the total count would overflow.
+func ParallelMerge(ds []*Digest, workers int) (*Digest, error) {
+	if len(ds) == 0 {
+		return nil, errNoDigests
+	}
+	for _, v := range ds[1:] {
+		if err := ds[0].checkMerge(v); err != nil {
+			return nil, err
+		}
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > len(ds) {
+		workers = len(ds)
+	}
+
+	res := make([]*Digest, workers)
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for i := range res {
+		part := ds[i*len(ds)/workers : (i+1)*len(ds)/workers]
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			d := part[0].Clone()
+			errs[i] = d.MergeAll(part[1:]...)
+			res[i] = d
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	d := res[0]
+	if err := d.MergeAll(res[1:]...); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
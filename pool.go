@@ -0,0 +1,68 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest
+
+import (
+	"math"
+	"sync"
+)
+
+// Pool recycles digests, avoiding the allocations of short-lived
+// (for example, per-request or per-time-window) digests. Recycled digests
+// keep the memory allocated for the histogram buckets.
+//
+// Zero Pool is ready to use. Pool is safe for concurrent use.
+type Pool struct {
+	opts  []Option
+	pools sync.Map // relative error -> *sync.Pool
+}
+
+// NewPool returns pool of digests created with options opts.
+// With WithValueRangeHint, the buckets are preallocated
+// when the digests are created, and kept when they are recycled.
+func NewPool(opts ...Option) *Pool {
+	return &Pool{opts: opts}
+}
+
+// Get returns empty digest with relative error err, reusing one
+// previously returned to the pool with Put if possible.
+//
+// Get panics if err is outside (0, 1).
+func (p *Pool) Get(err float64) *Digest {
+	if d, ok := p.pool(err).Get().(*Digest); ok {
+		return d
+	}
+
+	return NewDigest(err, p.opts...)
+}
+
+// Put resets d and returns it to the pool. Put must only be called
+// with digests returned by Get, and d must not be used after the call.
+func (p *Pool) Put(d *Digest) {
+	d.Reset()
+	p.pool(d.alpha).Put(d)
+}
+
+func (p *Pool) pool(err float64) *sync.Pool {
+	if math.IsNaN(err) || err <= 0 || err >= 1 {
+		panic("err must be in (0, 1)")
+	}
+	if sp, ok := p.pools.Load(err); ok {
+		return sp.(*sync.Pool)
+	}
+
+	sp, _ := p.pools.LoadOrStore(err, &sync.Pool{})
+	return sp.(*sync.Pool)
+}
@@ -0,0 +1,61 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest_test
+
+import (
+	"testing"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/rapid"
+)
+
+func TestPool(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			store = rapid.SampledFrom(storeNames).Draw(t, "store")
+			p     = bdigest.NewPool(stores[store]...)
+			errs  = []float64{0.01, 0.05}
+		)
+
+		n := rapid.IntRange(1, 10).Draw(t, "n")
+		for i := 0; i < n; i++ {
+			var (
+				relErr = rapid.SampledFrom(errs).Draw(t, "relative error")
+				seed   = rapid.Int64().Draw(t, "seed")
+				count  = rapid.IntRange(0, 1000).Draw(t, "count")
+			)
+
+			d := p.Get(relErr)
+			if d.Count() != 0 {
+				t.Fatalf("pooled digest count is %v", d.Count())
+			}
+			if s := bdigest.NewDigest(relErr).String(); d.String() != s {
+				t.Fatalf("pooled digest is %v instead of %v", d, s)
+			}
+			l := logNormalDigest(relErr, seed, count, int32(count)/10)
+			if err := d.Merge(l); err != nil {
+				t.Fatalf("failed to merge into pooled digest: %v", err)
+			}
+			data1, _ := d.MarshalBinary()
+			data2, _ := l.MarshalBinary()
+			if string(data1) != string(data2) {
+				t.Fatalf("pooled digest data %q differs from %q", data1, data2)
+			}
+			p.Put(d)
+		}
+	})
+}
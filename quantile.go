@@ -0,0 +1,317 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// RankMethod selects how the q-quantile of n values is estimated
+// when q*(n-1) is not an integer, like the method parameter
+// of numpy.quantile. All methods guarantee the same relative error.
+type RankMethod byte
+
+const (
+	// RankLower estimates the quantile as the value of 0-based
+	// rank ⌊q*(n-1)⌋ among the sorted values.
+	RankLower RankMethod = iota
+	// RankHigher estimates the quantile as the value of 0-based
+	// rank ⌈q*(n-1)⌉ among the sorted values.
+	RankHigher
+	// RankNearest estimates the quantile as the value of 0-based
+	// rank q*(n-1) rounded to the nearest integer (to even on ties)
+	// among the sorted values.
+	RankNearest
+	// RankMidpoint estimates the quantile as the mean of the values
+	// of RankLower and RankHigher.
+	RankMidpoint
+	// RankLinear estimates the quantile by linear interpolation
+	// between the values of RankLower and RankHigher.
+	RankLinear
+
+	numRankMethods = iota
+)
+
+var rankMethodNames = [numRankMethods]string{
+	RankLower:    "lower",
+	RankHigher:   "higher",
+	RankNearest:  "nearest",
+	RankMidpoint: "midpoint",
+	RankLinear:   "linear",
+}
+
+// String returns the name of the rank method.
+func (m RankMethod) String() string {
+	if int(m) < len(rankMethodNames) {
+		return rankMethodNames[m]
+	}
+	return fmt.Sprintf("RankMethod(%d)", m)
+}
+
+// WithRankMethod makes digest estimate quantiles using rank method m
+// instead of RankLower.
+//
+// WithRankMethod panics if m is not a known rank method.
+func WithRankMethod(m RankMethod) Option {
+	if m >= numRankMethods {
+		panic(fmt.Sprintf("unknown rank method %v", m))
+	}
+
+	return func(d *Digest) {
+		d.rank = m
+	}
+}
+
+// Quantiles returns the q-quantiles of added values for each of qs,
+// like Quantile. Each of the quantiles is found with a binary search
+// over the cumulative counts of histogram buckets.
+//
+// Quantiles panics if any of qs is outside [0, 1].
+func (d *Digest) Quantiles(qs ...float64) []float64 {
+	for _, q := range qs {
+		if math.IsNaN(q) || q < 0 || q > 1 {
+			panic("q must be in [0, 1]")
+		}
+	}
+
+	c := d.cumulative()
+	res := make([]float64, len(qs))
+	for i, q := range qs {
+		res[i] = d.quantileAt(c, q)
+	}
+
+	return res
+}
+
+// Median returns the median of added values, like Quantile(0.5).
+func (d *Digest) Median() float64 {
+	return d.Quantile(0.5)
+}
+
+// P90 returns the 90th percentile of added values, like Quantile(0.9).
+func (d *Digest) P90() float64 {
+	return d.Quantile(0.9)
+}
+
+// P95 returns the 95th percentile of added values, like Quantile(0.95).
+func (d *Digest) P95() float64 {
+	return d.Quantile(0.95)
+}
+
+// P99 returns the 99th percentile of added values, like Quantile(0.99).
+func (d *Digest) P99() float64 {
+	return d.Quantile(0.99)
+}
+
+// IQR returns the interquartile range of added values,
+// the difference between Quantile(0.75) and Quantile(0.25).
+//
+// IQR returns NaN for empty digest.
+func (d *Digest) IQR() float64 {
+	c := d.cumulative()
+	return d.quantileAt(c, 0.75) - d.quantileAt(c, 0.25)
+}
+
+// CDFPoint is a point of the cumulative distribution function of
+// added values: fraction P of values are not greater than V.
+type CDFPoint struct {
+	V float64
+	P float64
+}
+
+// CDFPoints returns n points of the cumulative distribution function
+// of added values, with P evenly spaced from 0 to 1 and V equal to
+// the P-quantile, suitable for plotting the whole distribution.
+//
+// CDFPoints panics if n is less than 2.
+// All V are NaN for empty digest.
+func (d *Digest) CDFPoints(n int) []CDFPoint {
+	if n < 2 {
+		panic("n must be at least 2")
+	}
+
+	c := d.cumulative()
+	res := make([]CDFPoint, n)
+	for i := range res {
+		p := float64(i) / float64(n-1)
+		res[i] = CDFPoint{V: d.quantileAt(c, p), P: p}
+	}
+
+	return res
+}
+
+// cumulative returns the cached cumulative counts, building them if needed.
+// Concurrent callers can build them more than once: the cache is
+// replaced atomically, and the rest of the digest is only read.
+func (d *Digest) cumulative() *cumulative {
+	if c, _ := d.cum.Load().(*cumulative); c != nil {
+		return c
+	}
+
+	c := &cumulative{}
+	c.build(d.store)
+	if d.isExact() {
+		c.exact = append([]float64(nil), d.exact...)
+		sort.Float64s(c.exact)
+	}
+	d.cum.Store(c)
+
+	return c
+}
+
+func (d *Digest) quantileAt(c *cumulative, q float64) float64 {
+	if d.Count() == 0 {
+		return math.NaN()
+	}
+
+	h := q * float64(d.Count()-1)
+	switch d.rank {
+	case RankHigher:
+		return d.valueAt(c, uint64(math.Ceil(h)))
+	case RankNearest:
+		return d.valueAt(c, uint64(math.RoundToEven(h)))
+	case RankMidpoint, RankLinear:
+		i := math.Floor(h)
+		v := d.valueAt(c, uint64(i))
+		if i == h {
+			return v
+		}
+		w := d.valueAt(c, uint64(i)+1)
+		if d.rank == RankMidpoint {
+			return (v + w) / 2
+		}
+		return v + (h-i)*(w-v)
+	}
+
+	return d.valueAt(c, uint64(h))
+}
+
+// valueAt returns the estimate of the value of 0-based rank i
+// among the added values.
+func (d *Digest) valueAt(c *cumulative, i uint64) float64 {
+	if d.isExact() {
+		if i < d.numZero {
+			return 0
+		}
+		return c.exact[i-d.numZero]
+	}
+
+	j, rank := d.bucketAt(c, i)
+	if j < 0 {
+		return 0
+	}
+	if !d.interpolate {
+		return d.quantile(c.keys[j])
+	}
+
+	// Values of rank prev+1, ..., sums[j] are spread evenly over the bucket.
+	prev := uint64(0)
+	if j > 0 {
+		prev = c.sums[j-1]
+	}
+	if rank > c.sums[j] {
+		rank = c.sums[j]
+	}
+	k := c.keys[j]
+	lo, hi := d.lowerBound(k), d.lowerBound(k+1)
+	return lo + (hi-lo)*(float64(rank-prev)-0.5)/float64(c.sums[j]-prev)
+}
+
+// bucketAt returns the index in c of the bucket holding the value
+// of 0-based rank i among the added values and the 1-based rank
+// of the value among the values of c, or -1 if the value is zero.
+func (d *Digest) bucketAt(c *cumulative, i uint64) (int, uint64) {
+	rank := i + 1
+	if rank <= d.numZero {
+		return -1, 0
+	}
+	rank -= d.numZero
+
+	return c.search(rank), rank
+}
+
+// QuantileBounds returns the bounds of the histogram buckets holding
+// the values the q-quantile of added values is estimated from:
+// the quantile is guaranteed to be greater than lo (or equal to lo
+// if it is zero) and not greater than hi, regardless of the rank method
+// (see WithRankMethod). For empty digest both bounds are NaN.
+//
+// Without WithInterpolation, Quantile is estimated from the middles
+// of the buckets, within err of any value in them. With WithInterpolation,
+// Quantile can return any value between lo and hi, so that only
+// the weaker error bound of RelativeError holds.
+//
+// QuantileBounds panics if q is outside [0, 1].
+func (d *Digest) QuantileBounds(q float64) (lo float64, hi float64) {
+	if math.IsNaN(q) || q < 0 || q > 1 {
+		panic("q must be in [0, 1]")
+	}
+
+	return d.quantileBoundsAt(d.cumulative(), q)
+}
+
+func (d *Digest) quantileBoundsAt(c *cumulative, q float64) (float64, float64) {
+	if d.Count() == 0 {
+		return math.NaN(), math.NaN()
+	}
+
+	h := q * float64(d.Count()-1)
+	lo, hi := 0.0, 0.0
+	if i, _ := d.bucketAt(c, uint64(h)); i >= 0 {
+		lo = d.lowerBound(c.keys[i])
+	}
+	if i, _ := d.bucketAt(c, uint64(math.Ceil(h))); i >= 0 {
+		hi = d.lowerBound(c.keys[i] + 1)
+	}
+
+	return lo, hi
+}
+
+// cumulative holds the keys of non-empty histogram buckets
+// with the cumulative counts, for binary search by rank.
+type cumulative struct {
+	keys  []int
+	sums  []uint64  // sums[i] is the total count of buckets keys[0], ..., keys[i]
+	exact []float64 // sorted exact values, if the digest keeps them
+}
+
+func (c *cumulative) build(s Store) {
+	c.keys = c.keys[:0]
+	c.sums = c.sums[:0]
+	if s == nil {
+		return
+	}
+
+	sum := uint64(0)
+	s.Ascend(func(k int, n uint64) bool {
+		if n > 0 {
+			sum += n
+			c.keys = append(c.keys, k)
+			c.sums = append(c.sums, sum)
+		}
+		return true
+	})
+}
+
+// search returns the index of the bucket holding the value of rank ≥ 1.
+func (c *cumulative) search(rank uint64) int {
+	i := sort.Search(len(c.sums), func(i int) bool { return c.sums[i] >= rank })
+	if i == len(c.sums) {
+		i--
+	}
+	return i
+}
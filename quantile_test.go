@@ -0,0 +1,264 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest_test
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"testing"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/rapid"
+)
+
+// normalValue excludes subnormal values, which math.Log
+// is not precise enough for on some platforms.
+var normalValue = rapid.Float64Range(0, 1e6).Filter(func(v float64) bool { return v == 0 || v >= 0x1p-1022 })
+
+func TestDigest_Quantiles(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			seed   = rapid.Int64().Draw(t, "seed")
+			count  = rapid.IntRange(0, 10000).Draw(t, "count")
+			store  = rapid.SampledFrom(storeNames).Draw(t, "store")
+			qs     = rapid.SliceOf(rapid.Float64Range(0, 1)).Draw(t, "quantiles")
+		)
+
+		d := logNormalDigest(relErr, seed, count, int32(count)/10, stores[store]...)
+		res := d.Quantiles(qs...)
+		if len(res) != len(qs) {
+			t.Fatalf("got %v quantiles instead of %v", len(res), len(qs))
+		}
+		for i, q := range qs {
+			if v := d.Quantile(q); res[i] != v && !(math.IsNaN(res[i]) && math.IsNaN(v)) {
+				t.Fatalf("q%v is %v instead of %v", q, res[i], v)
+			}
+		}
+	})
+}
+
+func TestDigest_QuantilesPanics(t *testing.T) {
+	t.Parallel()
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("no panic for invalid quantile")
+		}
+	}()
+	bdigest.NewDigest(0.01).Quantiles(0.5, 1.5)
+}
+
+func TestDigest_QuantileCache(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			seed   = rapid.Int64().Draw(t, "seed")
+			count  = rapid.IntRange(1, 10000).Draw(t, "count")
+			store  = rapid.SampledFrom(storeNames).Draw(t, "store")
+			q      = rapid.Float64Range(0, 1).Draw(t, "quantile")
+		)
+
+		d := logNormalDigest(relErr, seed, count, int32(count)/10, stores[store]...)
+		d.Quantile(q)
+		if store != "rle" {
+			allocs := testing.AllocsPerRun(10, func() { d.Quantile(q) })
+			if allocs != 0 {
+				t.Fatalf("%v allocations on repeated Quantile", allocs)
+			}
+		}
+
+		max := d.Quantile(1) * 10
+		d.Add(max)
+		if m := d.Quantile(1); m < max*(1-relErr) {
+			t.Fatalf("q1 is %v after adding %v", m, max)
+		}
+		d.Reset()
+		if !math.IsNaN(d.Quantile(q)) {
+			t.Fatalf("q%v of empty digest is %v instead of NaN", q, d.Quantile(q))
+		}
+	})
+}
+
+func TestDigest_ConcurrentQuantile(t *testing.T) {
+	t.Parallel()
+
+	d := logNormalDigest(0.01, 1, 1000, 100, bdigest.WithExactSamples(2000))
+	want := d.Clone().Quantiles(quantiles...)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j, q := range quantiles {
+				if got := d.Quantile(q); got != want[j] {
+					t.Errorf("q%v is %v instead of %v", q, got, want[j])
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestDigest_RankMethods(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			method = bdigest.RankMethod(rapid.IntRange(0, int(bdigest.RankLinear)).Draw(t, "method"))
+			values = rapid.SliceOfN(normalValue, 1, -1).Draw(t, "values")
+			q      = rapid.Float64Range(0, 1).Draw(t, "quantile")
+		)
+
+		d := bdigest.NewDigest(relErr, bdigest.WithRankMethod(method))
+		for _, v := range values {
+			d.Add(v)
+		}
+		sort.Float64s(values)
+
+		exact := exactQuantile(values, q, method)
+		dq := d.Quantile(q)
+		if re := math.Abs(dq-exact) / exact; re > relErr && (re-relErr)/relErr > 1e-9 {
+			t.Fatalf("%v q%v error is %v%% instead of max %v%% (%v instead of %v)", method, q, re*100, relErr*100, dq, exact)
+		}
+		blo, bhi := d.QuantileBounds(q)
+		if exact != 0 && (exact <= blo*(1-1e-9) || exact > bhi*(1+1e-9)) {
+			t.Fatalf("%v q%v bounds (%v, %v] do not contain %v", method, q, blo, bhi, exact)
+		}
+	})
+}
+
+// exactQuantile returns the q-quantile of sorted values like numpy.
+func exactQuantile(values []float64, q float64, method bdigest.RankMethod) float64 {
+	h := q * float64(len(values)-1)
+	lo, hi := values[int(math.Floor(h))], values[int(math.Ceil(h))]
+	switch method {
+	case bdigest.RankHigher:
+		return hi
+	case bdigest.RankNearest:
+		return values[int(math.RoundToEven(h))]
+	case bdigest.RankMidpoint:
+		if lo == hi {
+			return lo
+		}
+		return (lo + hi) / 2
+	case bdigest.RankLinear:
+		return lo + (h-math.Floor(h))*(hi-lo)
+	}
+
+	return lo
+}
+
+func TestWithExactSamples(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			n      = rapid.IntRange(1, 100).Draw(t, "n")
+			rank   = bdigest.RankMethod(rapid.IntRange(0, int(bdigest.RankLinear)).Draw(t, "method"))
+			values = rapid.SliceOfN(normalValue, 1, 200).Draw(t, "values")
+			split  = rapid.IntRange(0, len(values)).Draw(t, "split")
+		)
+
+		opts := []bdigest.Option{bdigest.WithExactSamples(n), bdigest.WithRankMethod(rank)}
+		d := bdigest.NewDigest(0.05, opts...)
+		for _, v := range values[:split] {
+			d.Add(v)
+		}
+		v := bdigest.NewDigest(0.05, opts...)
+		for _, x := range values[split:] {
+			v.Add(x)
+		}
+		if err := d.Merge(v); err != nil {
+			t.Fatalf("failed to merge %v: %v", v, err)
+		}
+		f := d.Freeze()
+
+		nonZero := 0
+		for _, x := range values {
+			if x != 0 {
+				nonZero++
+			}
+		}
+		sorted := append([]float64(nil), values...)
+		sort.Float64s(sorted)
+		for _, q := range quantiles {
+			want := exactQuantile(sorted, q, rank)
+			got, frozen := d.Quantile(q), f.Quantile(q)
+			if nonZero <= n && (got != want || frozen != want) {
+				t.Fatalf("q%v is %v (frozen %v) instead of exact %v", q, got, frozen, want)
+			}
+			if math.Abs(got-want) > want*0.05*(1+1e-9) {
+				t.Fatalf("q%v is %v instead of %v", q, got, want)
+			}
+		}
+	})
+}
+
+func TestDigest_Percentiles(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			seed   = rapid.Int64().Draw(t, "seed")
+			count  = rapid.IntRange(0, 1000).Draw(t, "count")
+		)
+
+		d := logNormalDigest(relErr, seed, count, int32(count)/10)
+		qs := d.Quantiles(0.5, 0.9, 0.95, 0.99, 0.25, 0.75)
+		for i, v := range []float64{d.Median(), d.P90(), d.P95(), d.P99(), d.IQR()} {
+			want := qs[i]
+			if i == 4 {
+				want = qs[5] - qs[4]
+			}
+			if v != want && !(math.IsNaN(v) && math.IsNaN(want)) {
+				t.Fatalf("accessor %v returned %v instead of %v", i, v, want)
+			}
+		}
+	})
+}
+
+func TestDigest_CDFPoints(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			seed   = rapid.Int64().Draw(t, "seed")
+			count  = rapid.IntRange(0, 1000).Draw(t, "count")
+			n      = rapid.IntRange(2, 100).Draw(t, "n")
+		)
+
+		d := logNormalDigest(relErr, seed, count, int32(count)/10)
+		ps := d.CDFPoints(n)
+		if len(ps) != n || ps[0].P != 0 || ps[n-1].P != 1 {
+			t.Fatalf("got points %v instead of %v from 0 to 1", ps, n)
+		}
+		for i, p := range ps {
+			if v := d.Quantile(p.P); p.V != v && !(math.IsNaN(v) && math.IsNaN(p.V)) {
+				t.Fatalf("point %+v instead of q%v %v", p, p.P, v)
+			}
+			if i > 0 && (p.P <= ps[i-1].P || p.V < ps[i-1].V) {
+				t.Fatalf("point %+v is not after %+v", p, ps[i-1])
+			}
+		}
+	})
+}
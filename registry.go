@@ -0,0 +1,260 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry is a concurrency-safe set of digests identified by string keys
+// (for example, metric names with labels encoded by FormatKey).
+type Registry struct {
+	mu      sync.RWMutex
+	proto   *Digest
+	digests map[string]*Concurrent
+}
+
+// NewRegistry returns empty registry of digests
+// with parameters of NewDigest.
+func NewRegistry(err float64, opts ...Option) *Registry {
+	return &Registry{
+		proto:   NewDigest(err, opts...),
+		digests: map[string]*Concurrent{},
+	}
+}
+
+// String returns short description of the registry digests.
+func (r *Registry) String() string {
+	return r.proto.String()
+}
+
+// Get returns the digest with the given key, if any.
+func (r *Registry) Get(key string) (*Concurrent, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	c, ok := r.digests[key]
+	return c, ok
+}
+
+// GetOrCreate returns the digest with the given key,
+// adding new empty digest to the registry if needed.
+func (r *Registry) GetOrCreate(key string) *Concurrent {
+	if c, ok := r.Get(key); ok {
+		return c
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.digests[key]
+	if !ok {
+		c = &Concurrent{d: r.proto.Clone()}
+		r.digests[key] = c
+	}
+
+	return c
+}
+
+// MergeBinaryKey merges serialized digest into the digest with the given
+// key, like Concurrent.MergeBinary, adding the digest to the registry
+// only if data is merged successfully.
+func (r *Registry) MergeBinaryKey(key string, data []byte) (*Concurrent, error) {
+	if c, ok := r.Get(key); ok {
+		return c, c.MergeBinary(data)
+	}
+
+	d := r.proto.Clone()
+	if err := d.MergeBinary(data); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	c, ok := r.digests[key]
+	if !ok {
+		c = &Concurrent{d: d}
+		r.digests[key] = c
+	}
+	r.mu.Unlock()
+
+	if ok {
+		return c, c.Merge(d)
+	}
+	return c, nil
+}
+
+// Delete removes the digest with the given key from the registry.
+func (r *Registry) Delete(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.digests, key)
+}
+
+// Len returns the number of digests in the registry.
+func (r *Registry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return len(r.digests)
+}
+
+// Range calls f for the digests in increasing key order, until f returns
+// false. Digests added or deleted concurrently may or may not be visited.
+func (r *Registry) Range(f func(key string, c *Concurrent) bool) {
+	r.mu.RLock()
+	keys := make([]string, 0, len(r.digests))
+	for k := range r.digests {
+		keys = append(keys, k)
+	}
+	r.mu.RUnlock()
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if c, ok := r.Get(k); ok && !f(k, c) {
+			return
+		}
+	}
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+//
+// Binary representation is a sequence of the digests in increasing key order,
+// each as the varint-prefixed key followed by the varint-prefixed binary
+// representation of the digest.
+func (r *Registry) MarshalBinary() ([]byte, error) {
+	var buf []byte
+	var err error
+	r.Range(func(key string, c *Concurrent) bool {
+		var data []byte
+		if data, err = c.MarshalBinary(); err != nil {
+			return false
+		}
+		buf = appendUvarint(buf, uint64(len(key)))
+		buf = append(buf, key...)
+		buf = appendUvarint(buf, uint64(len(data)))
+		buf = append(buf, data...)
+		return true
+	})
+
+	return buf, err
+}
+
+// MergeBinary merges the digests in the binary representation of a registry
+// into the digests with the same keys, creating them if needed.
+// If data is invalid, digests preceding the invalid one are still merged,
+// and no digest is created for the invalid one.
+func (r *Registry) MergeBinary(data []byte) error {
+	rd := bytes.NewReader(data)
+	for rd.Len() > 0 {
+		key, err := readBytes(rd)
+		if err != nil {
+			return fmt.Errorf("failed to read key: %w", err)
+		}
+		d, err := readBytes(rd)
+		if err != nil {
+			return fmt.Errorf("failed to read digest %q: %w", key, err)
+		}
+		if _, err = r.MergeBinaryKey(string(key), d); err != nil {
+			return fmt.Errorf("failed to merge digest %q: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+func readBytes(r *bytes.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	b := make([]byte, n)
+	_, _ = r.Read(b)
+	return b, nil
+}
+
+// Label is a name-value pair of a registry key.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// FormatKey returns the registry key of metric name with labels in the
+// given order, of the form `name{label="value",other="value"}` with the
+// values quoted like by strconv.Quote, or name if there are no labels.
+func FormatKey(name string, labels ...Label) string {
+	if len(labels) == 0 {
+		return name
+	}
+
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteByte('{')
+	for i, l := range labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(l.Name)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(l.Value))
+	}
+	b.WriteByte('}')
+
+	return b.String()
+}
+
+// ParseKey returns the metric name and the labels of key of the form
+// returned by FormatKey, or key as the name for other keys.
+func ParseKey(key string) (string, []Label) {
+	i := strings.IndexByte(key, '{')
+	if i <= 0 || !strings.HasSuffix(key, "}") {
+		return key, nil
+	}
+
+	name, rest := key[:i], key[i+1:len(key)-1]
+	var labels []Label
+	for rest != "" {
+		j := strings.IndexByte(rest, '=')
+		if j <= 0 {
+			return key, nil
+		}
+		quoted, err := strconv.QuotedPrefix(rest[j+1:])
+		if err != nil {
+			return key, nil
+		}
+		v, _ := strconv.Unquote(quoted)
+		labels = append(labels, Label{Name: rest[:j], Value: v})
+		rest = rest[j+1+len(quoted):]
+		if rest != "" {
+			if rest[0] != ',' {
+				return key, nil
+			}
+			rest = rest[1:]
+		}
+	}
+
+	return name, labels
+}
@@ -0,0 +1,167 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest_test
+
+import (
+	"sort"
+	"testing"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/rapid"
+)
+
+func TestRegistry(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			keys   = rapid.SliceOf(rapid.String()).Draw(t, "keys")
+		)
+
+		r := bdigest.NewRegistry(relErr)
+		counts := map[string]uint64{}
+		for i, k := range keys {
+			c := r.GetOrCreate(k)
+			if c2 := r.GetOrCreate(k); c2 != c {
+				t.Fatalf("got different digests for key %q", k)
+			}
+			c.Add(float64(i))
+			counts[k]++
+		}
+		if r.Len() != len(counts) {
+			t.Fatalf("registry has %v digests instead of %v", r.Len(), len(counts))
+		}
+
+		var visited []string
+		r.Range(func(k string, c *bdigest.Concurrent) bool {
+			if c.Count() != counts[k] {
+				t.Fatalf("digest %q count is %v instead of %v", k, c.Count(), counts[k])
+			}
+			visited = append(visited, k)
+			return true
+		})
+		if len(visited) != len(counts) || !sort.StringsAreSorted(visited) {
+			t.Fatalf("visited keys %q instead of all %v keys in order", visited, len(counts))
+		}
+
+		data, err := r.MarshalBinary()
+		if err != nil {
+			t.Fatalf("failed to marshal registry: %v", err)
+		}
+		r2 := bdigest.NewRegistry(relErr)
+		for i := 0; i < 2; i++ {
+			if err = r2.MergeBinary(data); err != nil {
+				t.Fatalf("failed to merge registry: %v", err)
+			}
+		}
+		for k, n := range counts {
+			if c, ok := r2.Get(k); !ok || c.Count() != 2*n {
+				t.Fatalf("merged digest %q is missing or has wrong count", k)
+			}
+		}
+		if len(data) > 0 {
+			if err = r2.MergeBinary(data[:len(data)-1]); err == nil {
+				t.Fatalf("successfully merged truncated data")
+			}
+		}
+
+		for k := range counts {
+			r.Delete(k)
+		}
+		if r.Len() != 0 {
+			t.Fatalf("registry has %v digests after deleting all", r.Len())
+		}
+	})
+}
+
+func TestRegistry_MergeBinaryKey(t *testing.T) {
+	t.Parallel()
+
+	r := bdigest.NewRegistry(0.01)
+	bad, _ := bdigest.FromValues(0.05, 1).MarshalBinary()
+	if _, err := r.MergeBinaryKey("a", bad); err == nil {
+		t.Fatalf("merged digest with different relative error")
+	}
+	if _, ok := r.Get("a"); ok {
+		t.Fatalf("failed merge added digest to registry")
+	}
+
+	data, _ := bdigest.FromValues(0.01, 1, 2).MarshalBinary()
+	for i := 1; i <= 2; i++ {
+		c, err := r.MergeBinaryKey("a", data)
+		if err != nil {
+			t.Fatalf("failed to merge digest: %v", err)
+		}
+		if c2, _ := r.Get("a"); c2 != c || c.Count() != uint64(2*i) {
+			t.Fatalf("got digest with count %v after %v merges", c.Count(), i)
+		}
+	}
+}
+
+func TestRegistry_MergeBinaryInvalid(t *testing.T) {
+	t.Parallel()
+
+	r := bdigest.NewRegistry(0.01)
+	r.GetOrCreate("a").Add(1)
+	r.GetOrCreate("b").Add(1)
+	data, err := r.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to marshal registry: %v", err)
+	}
+
+	r2 := bdigest.NewRegistry(0.05)
+	if err := r2.MergeBinary(data); err == nil {
+		t.Fatalf("merged digests with different relative error")
+	}
+	if r2.Len() != 0 {
+		t.Fatalf("failed merge added %v digests to registry", r2.Len())
+	}
+}
+
+func TestFormatKey(t *testing.T) {
+	t.Parallel()
+
+	name := rapid.StringMatching(`[a-z_][a-z0-9_.]*`)
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			n      = name.Draw(t, "name")
+			labels = rapid.SliceOf(rapid.Custom(func(t *rapid.T) bdigest.Label {
+				return bdigest.Label{Name: name.Draw(t, "label"), Value: rapid.String().Draw(t, "value")}
+			})).Draw(t, "labels")
+		)
+
+		key := bdigest.FormatKey(n, labels...)
+		n2, labels2 := bdigest.ParseKey(key)
+		if n2 != n || len(labels2) != len(labels) {
+			t.Fatalf("key %q parsed as %q with %v instead of %q with %v", key, n2, labels2, n, labels)
+		}
+		for i := range labels {
+			if labels2[i] != labels[i] {
+				t.Fatalf("label %v of key %q parsed as %v", labels[i], key, labels2[i])
+			}
+		}
+	})
+}
+
+func TestParseKey_Invalid(t *testing.T) {
+	t.Parallel()
+
+	for _, key := range []string{"", "name", "{a=\"b\"}", "name{a}", "name{a=b}", "name{a=\"b\"c=\"d\"}", "name{a=\"b\""} {
+		if n, labels := bdigest.ParseKey(key); n != key || labels != nil {
+			t.Fatalf("key %q parsed as %q with %v", key, n, labels)
+		}
+	}
+}
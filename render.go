@@ -0,0 +1,159 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// TextOption configures the chart rendered by Digest.RenderText.
+type TextOption func(*textOptions)
+
+type textOptions struct {
+	width int
+	rows  int
+	qs    []float64
+}
+
+// TextWidth sets the maximum width of the bars (50 by default).
+//
+// TextWidth panics if n is not positive.
+func TextWidth(n int) TextOption {
+	if n <= 0 {
+		panic("n must be positive")
+	}
+
+	return func(o *textOptions) {
+		o.width = n
+	}
+}
+
+// TextRows sets the maximum number of rows (30 by default).
+// If there are more non-empty histogram buckets, adjacent ones
+// are combined into a single row.
+//
+// TextRows panics if n is not positive.
+func TextRows(n int) TextOption {
+	if n <= 0 {
+		panic("n must be positive")
+	}
+
+	return func(o *textOptions) {
+		o.rows = n
+	}
+}
+
+// TextQuantiles sets the quantiles marked on the chart
+// (0.5, 0.9 and 0.99 by default).
+//
+// TextQuantiles panics if any of qs is outside [0, 1].
+func TextQuantiles(qs ...float64) TextOption {
+	for _, q := range qs {
+		if math.IsNaN(q) || q < 0 || q > 1 {
+			panic("q must be in [0, 1]")
+		}
+	}
+
+	return func(o *textOptions) {
+		o.qs = qs
+	}
+}
+
+// RenderText writes a bar chart of the distribution of added values
+// to w, for quick inspection in a terminal. After the description
+// of the digest, each row holds the range of values (or 0 for zero
+// values), their count and a bar of length proportional to the logarithm
+// of the count, followed by the marks of quantiles falling into the range.
+func (d *Digest) RenderText(w io.Writer, opts ...TextOption) error {
+	o := textOptions{width: 50, rows: 30, qs: []float64{0.5, 0.9, 0.99}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	type row struct {
+		lo, hi float64
+		n      uint64
+		marks  []string
+	}
+	var rows, buckets []row
+	d.ForEachBucket(func(lo float64, hi float64, n uint64) bool {
+		if hi == 0 {
+			rows = append(rows, row{n: n})
+		} else {
+			buckets = append(buckets, row{lo: lo, hi: hi, n: n})
+		}
+		return true
+	})
+
+	m := o.rows - len(rows)
+	if m < 1 {
+		m = 1
+	}
+	size := (len(buckets) + m - 1) / m
+	for i := 0; i < len(buckets); i += size {
+		j := i + size
+		if j > len(buckets) {
+			j = len(buckets)
+		}
+		r := row{lo: buckets[i].lo, hi: buckets[j-1].hi}
+		for _, b := range buckets[i:j] {
+			r.n += b.n
+		}
+		rows = append(rows, r)
+	}
+
+	c := d.cumulative()
+	top := uint64(0)
+	for _, r := range rows {
+		if r.n > top {
+			top = r.n
+		}
+	}
+	for _, q := range o.qs {
+		v := d.quantileAt(c, q)
+		for i := range rows {
+			if v <= rows[i].hi || i == len(rows)-1 {
+				rows[i].marks = append(rows[i].marks, "p"+strconv.FormatFloat(q*100, 'g', 6, 64))
+				break
+			}
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%v count=%v\n", d, d.Count())
+	for _, r := range rows {
+		bounds := "0"
+		if r.hi > 0 {
+			bounds = fmt.Sprintf("(%.4g, %.4g]", r.lo, r.hi)
+		}
+		n := int(math.Round(float64(o.width) * math.Log1p(float64(r.n)) / math.Log1p(float64(top))))
+		if n < 1 {
+			n = 1
+		}
+		line := fmt.Sprintf("%-24s %10d %s", bounds, r.n, strings.Repeat("#", n))
+		if len(r.marks) > 0 {
+			line = fmt.Sprintf("%-*s <- %v", 24+1+10+1+o.width, line, strings.Join(r.marks, ", "))
+		}
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,78 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/rapid"
+)
+
+func TestDigest_RenderText(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			seed   = rapid.Int64().Draw(t, "seed")
+			count  = rapid.IntRange(0, 10000).Draw(t, "count")
+			width  = rapid.IntRange(1, 100).Draw(t, "width")
+			rows   = rapid.IntRange(1, 50).Draw(t, "rows")
+			qs     = rapid.SliceOfN(rapid.SampledFrom([]float64{0, 0.5, 0.9, 0.99, 0.999, 1}), 0, 3).Draw(t, "quantiles")
+		)
+
+		d := logNormalDigest(relErr, seed, count, int32(count)/10)
+		var buf bytes.Buffer
+		err := d.RenderText(&buf, bdigest.TextWidth(width), bdigest.TextRows(rows), bdigest.TextQuantiles(qs...))
+		if err != nil {
+			t.Fatalf("failed to render digest: %v", err)
+		}
+
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		if !strings.HasPrefix(lines[0], d.String()) {
+			t.Fatalf("first line %q does not describe %v", lines[0], d)
+		}
+		lines = lines[1:]
+		if count == 0 {
+			if len(lines) != 0 {
+				t.Fatalf("got %v rows for empty digest", len(lines))
+			}
+			return
+		}
+		// Zero values are always in a separate row.
+		if len(lines) > rows+1 {
+			t.Fatalf("got %v rows instead of at most %v", len(lines), rows)
+		}
+
+		marks := 0
+		for _, l := range lines {
+			if n := strings.Count(l, "#"); n < 1 || n > width {
+				t.Fatalf("bar in row %q is not 1 to %v long", l, width)
+			}
+			if l != strings.TrimRight(l, " ") {
+				t.Fatalf("row %q has trailing spaces", l)
+			}
+			if i := strings.Index(l, "<- "); i >= 0 {
+				marks += len(strings.Split(l[i+3:], ", "))
+			}
+		}
+		if marks != len(qs) {
+			t.Fatalf("got %v quantile marks instead of %v:\n%v", marks, len(qs), buf.String())
+		}
+	})
+}
@@ -0,0 +1,145 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest
+
+import (
+	"sync"
+	"time"
+)
+
+// Rotator is a concurrency-safe digest which is periodically replaced
+// by a fresh one, with the completed digest passed to a callback
+// (for example, to ship or to merge it) and kept in a bounded history.
+type Rotator struct {
+	mu      sync.Mutex
+	proto   *Digest
+	cur     *Digest
+	history []*Digest // oldest first
+	max     int
+	report  func(*Digest)
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewRotator returns rotator of digests with parameters of NewDigest,
+// which rotates the digest every period (if it is positive) and keeps
+// up to history completed digests. If report is not nil, it is called
+// with every completed digest on rotation. Completed digests must not
+// be modified.
+//
+// NewRotator panics if history is negative.
+func NewRotator(err float64, period time.Duration, history int, report func(*Digest), opts ...Option) *Rotator {
+	if history < 0 {
+		panic("history must not be negative")
+	}
+
+	proto := NewDigest(err, opts...)
+	r := &Rotator{
+		proto:  proto,
+		cur:    proto.Clone(),
+		max:    history,
+		report: report,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if period > 0 {
+		go r.run(period)
+	} else {
+		close(r.done)
+	}
+
+	return r
+}
+
+func (r *Rotator) run(period time.Duration) {
+	defer close(r.done)
+
+	t := time.NewTicker(period)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			r.Rotate()
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// Stop stops the periodic rotation. Stop must not be called more than once.
+func (r *Rotator) Stop() {
+	close(r.stop)
+	<-r.done
+}
+
+// String returns short digest description.
+func (r *Rotator) String() string {
+	return r.proto.String()
+}
+
+// Add adds finite non-negative value v to the current digest,
+// like Digest.Add.
+func (r *Rotator) Add(v float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cur.Add(v)
+}
+
+// Merge merges the content of v into the current digest, like Digest.Merge.
+func (r *Rotator) Merge(v *Digest) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.cur.Merge(v)
+}
+
+// Snapshot returns a copy of the current digest.
+func (r *Rotator) Snapshot() *Digest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.cur.Clone()
+}
+
+// Rotate replaces the current digest with a fresh one, adds the completed
+// digest to the history and reports it. It returns the completed digest.
+func (r *Rotator) Rotate() *Digest {
+	r.mu.Lock()
+	d := r.cur
+	r.cur = r.proto.Clone()
+	if r.max > 0 {
+		if len(r.history) == r.max {
+			copy(r.history, r.history[1:])
+			r.history = r.history[:r.max-1]
+		}
+		r.history = append(r.history, d)
+	}
+	r.mu.Unlock()
+
+	if r.report != nil {
+		r.report(d)
+	}
+
+	return d
+}
+
+// History returns the completed digests kept, oldest first.
+func (r *Rotator) History() []*Digest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]*Digest(nil), r.history...)
+}
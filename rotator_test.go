@@ -0,0 +1,84 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest_test
+
+import (
+	"testing"
+	"time"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/rapid"
+)
+
+func TestRotator(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr  = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			history = rapid.IntRange(0, 5).Draw(t, "history")
+			steps   = rapid.IntRange(0, 10).Draw(t, "steps")
+		)
+
+		var reported []*bdigest.Digest
+		r := bdigest.NewRotator(relErr, 0, history, func(d *bdigest.Digest) {
+			reported = append(reported, d)
+		})
+		var counts []uint64
+		for i := 0; i < steps; i++ {
+			n := rapid.IntRange(0, 100).Draw(t, "count")
+			for j := 0; j < n; j++ {
+				r.Add(float64(j))
+			}
+			if c := r.Snapshot().Count(); c != uint64(n) {
+				t.Fatalf("current digest count is %v instead of %v", c, n)
+			}
+			d := r.Rotate()
+			counts = append(counts, uint64(n))
+			if d.Count() != uint64(n) || reported[len(reported)-1] != d {
+				t.Fatalf("completed digest count is %v instead of %v", d.Count(), n)
+			}
+		}
+		r.Stop()
+
+		h := r.History()
+		if len(counts) > history {
+			counts = counts[len(counts)-history:]
+		}
+		if len(h) != len(counts) {
+			t.Fatalf("history has %v digests instead of %v", len(h), len(counts))
+		}
+		for i, d := range h {
+			if d.Count() != counts[i] {
+				t.Fatalf("history digest %v count is %v instead of %v", i, d.Count(), counts[i])
+			}
+		}
+	})
+}
+
+func TestRotator_Period(t *testing.T) {
+	t.Parallel()
+
+	total := bdigest.NewConcurrent(0.01)
+	r := bdigest.NewRotator(0.01, time.Millisecond, 1, func(d *bdigest.Digest) {
+		_ = total.Merge(d)
+	})
+	defer r.Stop()
+
+	r.Add(1)
+	for total.Count() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+}
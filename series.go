@@ -0,0 +1,131 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Series is a concurrency-safe time series of digests, one per interval
+// of time, which keeps the intervals within the retention period only.
+type Series struct {
+	mu        sync.Mutex
+	proto     *Digest
+	interval  int64     // in nanoseconds
+	digests   []*Digest // ring buffer, digests[ringSlot(i)] holds interval i
+	intervals []int64
+	latest    int64
+	init      bool
+}
+
+// NewSeries returns series of digests with parameters of NewDigest,
+// each for an interval of time, keeping the intervals for the last
+// retention period of time (rounded up to the interval).
+//
+// NewSeries panics if interval or retention is not positive.
+func NewSeries(err float64, interval time.Duration, retention time.Duration, opts ...Option) *Series {
+	if interval <= 0 {
+		panic("interval must be positive")
+	}
+	if retention <= 0 {
+		panic("retention must be positive")
+	}
+
+	n := int((retention + interval - 1) / interval)
+	return &Series{
+		proto:     NewDigest(err, opts...),
+		interval:  int64(interval),
+		digests:   make([]*Digest, n),
+		intervals: make([]int64, n),
+	}
+}
+
+// String returns short digest description.
+func (s *Series) String() string {
+	return s.proto.String()
+}
+
+// Add adds finite non-negative value v to the digest
+// of the current interval, like Digest.Add.
+func (s *Series) Add(v float64) {
+	s.AddAt(time.Now(), v)
+}
+
+// AddAt adds finite non-negative value v to the digest of the interval
+// holding time t, like Digest.Add. Values older than the retention period
+// (relative to the latest time a value was added at) are ignored.
+//
+// AddAt panics if v is outside [0, math.MaxFloat64].
+func (s *Series) AddAt(t time.Time, v float64) {
+	if math.IsNaN(v) || v < 0 || v > math.MaxFloat64 {
+		panic("v must be in [0, math.MaxFloat64]")
+	}
+
+	i := timeSlot(t, s.interval)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.init || i > s.latest {
+		s.latest = i
+		s.init = true
+	}
+	if i <= s.latest-int64(len(s.digests)) {
+		return
+	}
+
+	j := ringSlot(i, len(s.digests))
+	d := s.digests[j]
+	switch {
+	case d == nil:
+		d = s.proto.Clone()
+		s.digests[j] = d
+	case s.intervals[j] != i:
+		d.Reset()
+	}
+	s.intervals[j] = i
+	d.Add(v)
+}
+
+// Range returns a digest with the values of all the kept intervals
+// overlapping the period of time [from, to). If the total count
+// would overflow, the values which do not fit are dropped.
+func (s *Series) Range(from time.Time, to time.Time) *Digest {
+	lo, hi := timeSlot(from, s.interval), timeSlot(to, s.interval)
+	if to.UnixNano()%s.interval == 0 {
+		hi-- // to is exclusive
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := s.proto.Clone()
+	for j, d := range s.digests {
+		i := s.intervals[j]
+		if d != nil && i > s.latest-int64(len(s.digests)) && i >= lo && i <= hi {
+			_ = r.mergeSaturating(d) // same parameters
+		}
+	}
+
+	return r
+}
+
+// Quantile returns the q-quantile of values of all the kept intervals
+// overlapping the period of time [from, to), like Digest.Quantile.
+func (s *Series) Quantile(from time.Time, to time.Time, q float64) float64 {
+	return s.Range(from, to).Quantile(q)
+}
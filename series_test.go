@@ -0,0 +1,73 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest_test
+
+import (
+	"testing"
+	"time"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/rapid"
+)
+
+func TestSeries(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr    = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			interval  = time.Duration(rapid.Int64Range(1, 1e10).Draw(t, "interval"))
+			retention = time.Duration(rapid.Int64Range(1, 20*int64(interval)).Draw(t, "retention"))
+			adds      = rapid.IntRange(0, 100).Draw(t, "adds")
+			n         = int64((retention + interval - 1) / interval)
+			span      = 3 * int64(retention)
+		)
+
+		s := bdigest.NewSeries(relErr, interval, retention)
+		type added struct {
+			t time.Time
+			v float64
+		}
+		var values []added
+		latest := int64(0)
+		for i := 0; i < adds; i++ {
+			at := time.Unix(0, rapid.Int64Range(-span, span).Draw(t, "time"))
+			v := rapid.Float64Range(0, 1e6).Draw(t, "value")
+			s.AddAt(at, v)
+			values = append(values, added{at, v})
+			if ix := slice(at, interval); i == 0 || ix > latest {
+				latest = ix
+			}
+
+			var (
+				from = time.Unix(0, rapid.Int64Range(-span, span).Draw(t, "from"))
+				to   = from.Add(time.Duration(rapid.Int64Range(1, span).Draw(t, "duration")))
+				lo   = slice(from, interval)
+				hi   = slice(to.Add(-1), interval)
+			)
+			d := bdigest.NewDigest(relErr)
+			for _, a := range values {
+				if ix := slice(a.t, interval); ix > latest-n && ix >= lo && ix <= hi {
+					d.Add(a.v)
+				}
+			}
+			data1, _ := s.Range(from, to).MarshalBinary()
+			data2, _ := d.MarshalBinary()
+			if string(data1) != string(data2) {
+				t.Fatalf("series range data %q differs from %q", data1, data2)
+			}
+		}
+	})
+}
@@ -0,0 +1,169 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// ShardedDigest is a digest safe for concurrent use, optimized
+// for adding values from many goroutines at once. It keeps
+// a sub-digest per shard, with values added to any shard not
+// in use at the moment, and merges all the shards on read.
+type ShardedDigest struct {
+	next   uint32
+	proto  *Digest // empty, for String, Snapshot and Merge checks
+	shards []shard
+}
+
+type shard struct {
+	mu sync.Mutex
+	d  *Digest
+	_  [64]byte // avoid false sharing
+}
+
+// NewShardedDigest returns concurrency-safe digest with parameters
+// of NewDigest, with a shard per each of runtime.GOMAXPROCS(0) threads.
+func NewShardedDigest(err float64, opts ...Option) *ShardedDigest {
+	s := &ShardedDigest{
+		proto:  NewDigest(err, opts...),
+		shards: make([]shard, runtime.GOMAXPROCS(0)),
+	}
+	for i := range s.shards {
+		s.shards[i].d = s.proto.Clone()
+	}
+
+	return s
+}
+
+// String returns short digest description.
+func (s *ShardedDigest) String() string {
+	return s.proto.String()
+}
+
+// lock locks and returns one of the shards, preferring ones not in use.
+func (s *ShardedDigest) lock() *shard {
+	n := uint32(len(s.shards))
+	i := atomic.AddUint32(&s.next, 1) % n
+	for j := uint32(0); j < n; j++ {
+		sh := &s.shards[(i+j)%n]
+		if sh.mu.TryLock() {
+			return sh
+		}
+	}
+	sh := &s.shards[i]
+	sh.mu.Lock()
+	return sh
+}
+
+// Add adds finite non-negative value v to the digest, like Digest.Add.
+func (s *ShardedDigest) Add(v float64) {
+	sh := s.lock()
+	defer sh.mu.Unlock()
+
+	sh.d.Add(v)
+}
+
+// Merge merges the content of v into the digest, like Digest.Merge.
+// Digest v must not be modified concurrently.
+func (s *ShardedDigest) Merge(v *Digest) error {
+	sh := s.lock()
+	defer sh.mu.Unlock()
+
+	return sh.d.Merge(v)
+}
+
+// Reset resets digest to the initial empty state.
+// Values added concurrently with Reset may or may not be kept.
+func (s *ShardedDigest) Reset() {
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		sh.d.Reset()
+		sh.mu.Unlock()
+	}
+}
+
+// Count returns the number of added values.
+func (s *ShardedDigest) Count() uint64 {
+	n := uint64(0)
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		n += sh.d.Count()
+		sh.mu.Unlock()
+	}
+
+	return n
+}
+
+// Snapshot returns a digest with the content of all the shards.
+// Values added concurrently with Snapshot may or may not be included.
+// If the total count would overflow, the values which do not fit
+// are dropped.
+func (s *ShardedDigest) Snapshot() *Digest {
+	d := s.proto.Clone()
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		_ = d.mergeSaturating(sh.d) // same parameters
+		sh.mu.Unlock()
+	}
+
+	return d
+}
+
+// SnapshotAndReset returns a digest with the content of all the shards,
+// resetting them to the empty state and keeping their allocated memory.
+// Every value added concurrently is either included in the returned
+// digest or kept in s. Like with Snapshot, the values which do not fit
+// in the total count are dropped.
+func (s *ShardedDigest) SnapshotAndReset() *Digest {
+	d := s.proto.Clone()
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		_ = d.mergeSaturating(sh.d) // same parameters
+		sh.d.Reset()
+		sh.mu.Unlock()
+	}
+
+	return d
+}
+
+// Quantile returns the q-quantile of added values, like Digest.Quantile.
+// Since it merges all the shards, prefer Snapshot or Freeze
+// for multiple queries.
+func (s *ShardedDigest) Quantile(q float64) float64 {
+	return s.Snapshot().Quantile(q)
+}
+
+// Quantiles returns the q-quantiles of added values for each of qs,
+// like Digest.Quantiles.
+func (s *ShardedDigest) Quantiles(qs ...float64) []float64 {
+	return s.Snapshot().Quantiles(qs...)
+}
+
+// Freeze returns an immutable snapshot of the digest.
+func (s *ShardedDigest) Freeze() *FrozenDigest {
+	return s.Snapshot().Freeze()
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (s *ShardedDigest) MarshalBinary() ([]byte, error) {
+	return s.Snapshot().MarshalBinary()
+}
@@ -0,0 +1,68 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest
+
+import (
+	"math"
+	"time"
+)
+
+// SLO is a latency service level objective: at least Target fraction
+// of values (like 0.999) must not be greater than Threshold.
+type SLO struct {
+	Threshold float64
+	Target    float64
+}
+
+// BurnRate returns the rate of consumption of the error budget of slo
+// by the values added during the digest: the fraction of values greater
+// than the threshold, divided by the allowed fraction 1-Target.
+// Burn rate of 1 consumes exactly the whole budget over the SLO period.
+//
+// BurnRate panics if Target is not in (0, 1) or Threshold is NaN.
+// BurnRate returns NaN for empty digest.
+func (d *Digest) BurnRate(slo SLO) float64 {
+	if math.IsNaN(slo.Target) || slo.Target <= 0 || slo.Target >= 1 {
+		panic("target must be in (0, 1)")
+	}
+
+	return (1 - d.FractionBelow(slo.Threshold)[0]) / (1 - slo.Target)
+}
+
+// BurnRates returns the burn rates of slo (see Digest.BurnRate)
+// over each of the trailing windows ending at the current time,
+// like the short and long windows of multi-window burn rate alerts.
+// Windows are rounded up to the whole slices of the digest.
+//
+// BurnRates panics if any of the windows is not positive or longer
+// than the window of the digest.
+func (w *WindowedDigest) BurnRates(slo SLO, windows ...time.Duration) []float64 {
+	return w.BurnRatesAt(time.Now(), slo, windows...)
+}
+
+// BurnRatesAt is like BurnRates, but for windows ending at time t,
+// or at the latest time used before if it is after t.
+func (w *WindowedDigest) BurnRatesAt(t time.Time, slo SLO, windows ...time.Duration) []float64 {
+	res := make([]float64, len(windows))
+	for i, win := range windows {
+		n := (int64(win) + w.width - 1) / w.width
+		if win <= 0 || n > int64(len(w.slices)) {
+			panic("windows must be positive and not longer than the window of the digest")
+		}
+		res[i] = w.lastAt(t, int(n)).BurnRate(slo)
+	}
+
+	return res
+}
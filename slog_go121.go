@@ -0,0 +1,127 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.21
+
+package bdigest
+
+import (
+	"context"
+	"log/slog"
+	"math"
+)
+
+// SlogHandler is a slog.Handler which passes records to another handler,
+// adding the values of configured numeric attributes of each record
+// to the digests of a registry, with the attribute keys as the registry
+// keys. Attributes in groups have keys qualified by the group names,
+// like "request.duration_ms".
+//
+// Integer and floating-point values are added as is, and durations
+// in seconds. Negative, NaN and infinite values are skipped, as are
+// the attributes bound to loggers with With, which are not specific
+// to a record. Records not enabled by the wrapped handler are not
+// handled, and their attributes are not added.
+type SlogHandler struct {
+	next   slog.Handler
+	reg    *Registry
+	keys   map[string]bool
+	prefix string // of the keys in the open groups
+}
+
+// NewSlogHandler returns handler passing records to next and adding
+// the values of attributes with keys to the digests of registry reg.
+func NewSlogHandler(next slog.Handler, reg *Registry, keys ...string) *SlogHandler {
+	h := &SlogHandler{
+		next: next,
+		reg:  reg,
+		keys: make(map[string]bool, len(keys)),
+	}
+	for _, k := range keys {
+		h.keys[k] = true
+	}
+
+	return h
+}
+
+// Enabled reports whether the wrapped handler handles records at level l.
+func (h *SlogHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return h.next.Enabled(ctx, l)
+}
+
+// Handle adds the values of the configured attributes of r
+// to the registry digests, and passes r to the wrapped handler.
+func (h *SlogHandler) Handle(ctx context.Context, r slog.Record) error {
+	r.Attrs(func(a slog.Attr) bool {
+		h.add(h.prefix, a)
+		return true
+	})
+
+	return h.next.Handle(ctx, r)
+}
+
+func (h *SlogHandler) add(prefix string, a slog.Attr) {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, g := range v.Group() {
+			h.add(prefix, g)
+		}
+		return
+	}
+	if !h.keys[prefix+a.Key] {
+		return
+	}
+
+	var f float64
+	switch v.Kind() {
+	case slog.KindInt64:
+		f = float64(v.Int64())
+	case slog.KindUint64:
+		f = float64(v.Uint64())
+	case slog.KindFloat64:
+		f = v.Float64()
+	case slog.KindDuration:
+		f = v.Duration().Seconds()
+	default:
+		return
+	}
+	if math.IsNaN(f) || f < 0 || f > math.MaxFloat64 {
+		return
+	}
+	h.reg.GetOrCreate(prefix + a.Key).Add(f)
+}
+
+// WithAttrs returns handler passing records to the wrapped handler
+// with attrs, without adding attrs to the digests.
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.next = h.next.WithAttrs(attrs)
+	return &c
+}
+
+// WithGroup returns handler passing records to the wrapped handler
+// in group name, qualifying the keys of the attributes by it.
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	c := *h
+	c.next = h.next.WithGroup(name)
+	c.prefix = h.prefix + name + "."
+	return &c
+}
@@ -0,0 +1,63 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.21
+
+package bdigest_test
+
+import (
+	"bytes"
+	"log/slog"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"pgregory.net/bdigest"
+)
+
+func TestSlogHandler(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	reg := bdigest.NewRegistry(0.01)
+	h := bdigest.NewSlogHandler(slog.NewTextHandler(&buf, nil), reg, "duration_ms", "request.latency", "size")
+	log := slog.New(h)
+
+	log.Info("done", "duration_ms", 12, "size", uint64(5))
+	log.Info("done", "duration_ms", 15.5, "size", "big")
+	log.With("duration_ms", 1).Info("bound")
+	log.Info("grouped", slog.Group("request", slog.Duration("latency", 2*time.Second)))
+	log.WithGroup("request").Info("group", "latency", time.Second)
+	log.Info("invalid", "duration_ms", -1, "size", math.NaN())
+	log.Debug("disabled", "duration_ms", 100)
+
+	for key, n := range map[string]uint64{"duration_ms": 2, "size": 1, "request.latency": 2} {
+		if c, ok := reg.Get(key); !ok || c.Count() != n {
+			t.Fatalf("digest %q is missing or has wrong count", key)
+		}
+	}
+	if reg.Len() != 3 {
+		t.Fatalf("got %v digests instead of 3", reg.Len())
+	}
+	if c, _ := reg.Get("request.latency"); math.Abs(c.Quantile(1)-2) > 0.02 {
+		t.Fatalf("got maximum latency %v instead of 2", c.Quantile(1))
+	}
+	if n := strings.Count(buf.String(), "\n"); n != 6 {
+		t.Fatalf("got %v log lines instead of 6", n)
+	}
+	if !strings.Contains(buf.String(), "request.latency=1s") {
+		t.Fatalf("group was not passed to the wrapped handler: %q", buf.String())
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest
+
+import (
+	"database/sql/driver"
+	"fmt"
+)
+
+// Scan implements the sql.Scanner interface, reading digest
+// from a binary column in the format of MarshalBinary.
+func (d *Digest) Scan(src any) error {
+	switch src := src.(type) {
+	case []byte:
+		return d.UnmarshalBinary(src)
+	case string:
+		return d.UnmarshalBinary([]byte(src))
+	default:
+		return fmt.Errorf("can not scan %T into digest", src)
+	}
+}
+
+// Value implements the driver.Valuer interface, storing digest
+// in the format of MarshalBinary.
+func (d *Digest) Value() (driver.Value, error) {
+	return d.MarshalBinary()
+}
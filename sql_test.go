@@ -0,0 +1,58 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest_test
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"reflect"
+	"testing"
+
+	"pgregory.net/bdigest"
+)
+
+var (
+	_ sql.Scanner   = (*bdigest.Digest)(nil)
+	_ driver.Valuer = (*bdigest.Digest)(nil)
+)
+
+func TestDigest_ScanValue(t *testing.T) {
+	t.Parallel()
+
+	d1 := logNormalDigest(0.01, 0, 1000, 10)
+	v, err := d1.Value()
+	if err != nil {
+		t.Fatalf("failed to get digest value: %v", err)
+	}
+	if !driver.IsValue(v) {
+		t.Fatalf("%T is not a valid driver value", v)
+	}
+
+	for _, src := range []any{v, string(v.([]byte))} {
+		d2 := &bdigest.Digest{}
+		err = d2.Scan(src)
+		if err != nil {
+			t.Fatalf("failed to scan %T into digest: %v", src, err)
+		}
+		if !reflect.DeepEqual(d1, d2) {
+			t.Fatalf("got back %#v which is different than %#v", d2, d1)
+		}
+	}
+
+	err = (&bdigest.Digest{}).Scan(nil)
+	if err == nil {
+		t.Fatalf("scan of NULL succeeded")
+	}
+}
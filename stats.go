@@ -0,0 +1,298 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest
+
+import (
+	"math"
+	"sort"
+)
+
+// Density returns the estimated probability density of added values at v:
+// the fraction of values in the histogram bucket holding v, divided by
+// the width of the bucket. Since zero values are counted exactly,
+// density at 0 is +Inf if any zero values were added, and 0 otherwise.
+//
+// Density panics if v is outside [0, math.MaxFloat64].
+// Density returns NaN for empty digest.
+func (d *Digest) Density(v float64) float64 {
+	if math.IsNaN(v) || v < 0 || v > math.MaxFloat64 {
+		panic("v must be in [0, math.MaxFloat64]")
+	}
+	if d.Count() == 0 {
+		return math.NaN()
+	}
+	if v == 0 {
+		if d.numZero > 0 {
+			return math.Inf(1)
+		}
+		return 0
+	}
+
+	c := d.cumulative()
+	k := d.bucketKey(v)
+	i := sort.SearchInts(c.keys, k)
+	if i == len(c.keys) || c.keys[i] != k {
+		return 0
+	}
+	n := c.sums[i]
+	if i > 0 {
+		n -= c.sums[i-1]
+	}
+	lo, hi := d.lowerBound(k), d.lowerBound(k+1)
+
+	return float64(n) / float64(d.Count()) / (hi - lo)
+}
+
+// Mode returns the representative value of the most populated histogram
+// bucket (or 0, if zero values are the most common), preferring the lower
+// values in case of ties. It is equivalent to SmoothedMode(0).
+//
+// Mode returns NaN for empty digest.
+func (d *Digest) Mode() float64 {
+	return d.SmoothedMode(0)
+}
+
+// SmoothedMode is like Mode, but compares the total counts of buckets
+// within radius of each bucket instead of the bucket counts, which makes
+// it less sensitive to noise.
+//
+// SmoothedMode panics if radius is negative.
+func (d *Digest) SmoothedMode(radius int) float64 {
+	if radius < 0 {
+		panic("radius must be non-negative")
+	}
+	if d.Count() == 0 {
+		return math.NaN()
+	}
+
+	c := d.cumulative()
+	count := func(i int) uint64 {
+		if i == 0 {
+			return c.sums[0]
+		}
+		return c.sums[i] - c.sums[i-1]
+	}
+	if n := len(c.keys); n > 0 && radius > c.keys[n-1]-c.keys[0] {
+		radius = c.keys[n-1] - c.keys[0] // to not overflow k±radius
+	}
+
+	mode, best := 0.0, d.numZero
+	lo, hi, sum := 0, 0, uint64(0)
+	for i, k := range c.keys {
+		for hi < len(c.keys) && c.keys[hi] <= k+radius {
+			sum += count(hi)
+			hi++
+		}
+		for c.keys[lo] < k-radius {
+			sum -= count(lo)
+			lo++
+		}
+		if sum > best {
+			mode, best = d.quantile(c.keys[i]), sum
+		}
+	}
+
+	return mode
+}
+
+// Entropy returns the estimated differential entropy (in nats) of the
+// distribution of non-zero added values, computed from the fractions
+// of values in each histogram bucket and the widths of the buckets.
+// Zero values are not included, since any of them would make the entropy
+// of the distribution negative infinity.
+//
+// Entropy returns NaN if no non-zero values were added.
+func (d *Digest) Entropy() float64 {
+	if d.numNonZero == 0 {
+		return math.NaN()
+	}
+
+	n := float64(d.numNonZero)
+	h := 0.0
+	d.ascend(func(k int, c uint64) bool {
+		if c > 0 {
+			p := float64(c) / n
+			h -= p * math.Log(p/(d.lowerBound(k+1)-d.lowerBound(k)))
+		}
+		return true
+	})
+
+	return h
+}
+
+// GeometricMean returns the geometric mean of added values
+// with a maximum relative error of err, computed from
+// the representative values of the histogram buckets.
+// It is 0 if any zero values were added.
+//
+// GeometricMean returns NaN for empty digest.
+func (d *Digest) GeometricMean() float64 {
+	if d.Count() == 0 {
+		return math.NaN()
+	}
+	if d.numZero > 0 {
+		return 0
+	}
+
+	sum := 0.0
+	d.ascend(func(k int, n uint64) bool {
+		if n > 0 {
+			sum += float64(n) * math.Log(d.quantile(k))
+		}
+		return true
+	})
+
+	return math.Exp(sum / float64(d.numNonZero))
+}
+
+// HarmonicMean returns the harmonic mean of added values
+// with a maximum relative error of err, computed from
+// the representative values of the histogram buckets.
+// It is 0 if any zero values were added.
+//
+// HarmonicMean returns NaN for empty digest.
+func (d *Digest) HarmonicMean() float64 {
+	if d.Count() == 0 {
+		return math.NaN()
+	}
+	if d.numZero > 0 {
+		return 0
+	}
+
+	sum := 0.0
+	d.ascend(func(k int, n uint64) bool {
+		if n > 0 {
+			sum += float64(n) / d.quantile(k)
+		}
+		return true
+	})
+
+	return float64(d.numNonZero) / sum
+}
+
+// TailIndex returns the estimated index of the Pareto distribution
+// fitted to the tail of added values above the q-quantile, and
+// the coefficient of determination of the fit (r2, 1 for a perfect fit).
+// The fit is a weighted least-squares regression of the logarithm
+// of the fraction of values greater than the lower bound of each
+// non-empty bucket in the tail on the logarithm of the bound.
+// Smaller index means heavier tail.
+//
+// TailIndex panics if q is outside [0, 1).
+// TailIndex returns NaN if the tail has fewer than 3 non-empty buckets.
+func (d *Digest) TailIndex(q float64) (index float64, r2 float64) {
+	if math.IsNaN(q) || q < 0 || q >= 1 {
+		panic("q must be in [0, 1)")
+	}
+	if d.numNonZero == 0 {
+		return math.NaN(), math.NaN()
+	}
+
+	c := d.cumulative()
+	n := float64(d.Count())
+	var sw, sx, sy, sxx, sxy, syy float64
+	m := 0
+	for i, k := range c.keys {
+		tail := d.numNonZero
+		if i > 0 {
+			tail -= c.sums[i-1]
+		}
+		if float64(tail) > (1-q)*n {
+			continue
+		}
+		w := float64(tail) // variance of log(tail) is about 1/tail
+		x := math.Log(d.lowerBound(k))
+		y := math.Log(float64(tail) / n)
+		sw += w
+		sx += w * x
+		sy += w * y
+		sxx += w * x * x
+		sxy += w * x * y
+		syy += w * y * y
+		m++
+	}
+	if m < 3 {
+		return math.NaN(), math.NaN()
+	}
+
+	vx := sxx - sx*sx/sw
+	vy := syy - sy*sy/sw
+	cxy := sxy - sx*sy/sw
+	if vy == 0 {
+		return math.NaN(), math.NaN()
+	}
+
+	return -cxy / vx, cxy * cxy / (vx * vy)
+}
+
+// Summary holds the basic statistics of the distribution of added values,
+// as returned by Digest.Summary.
+type Summary struct {
+	Count     uint64          `json:"count"`
+	Min       float64         `json:"min"`
+	Max       float64         `json:"max"`
+	Sum       float64         `json:"sum"`
+	Mean      float64         `json:"mean"`
+	Quantiles []QuantileValue `json:"quantiles,omitempty"`
+}
+
+// QuantileValue is a q-quantile of added values.
+type QuantileValue struct {
+	Q     float64 `json:"q"`
+	Value float64 `json:"value"`
+}
+
+// Summary returns the number of added values, estimates of their minimum,
+// maximum, sum and mean, and the q-quantiles for each of qs, all with
+// a maximum relative error of err (or of RelativeError for the quantiles,
+// with WithInterpolation). For empty digest, all the estimates
+// are 0 instead of NaN, so that the summary can be encoded as JSON.
+//
+// Summary panics if any of qs is outside [0, 1].
+func (d *Digest) Summary(qs ...float64) Summary {
+	for _, q := range qs {
+		if math.IsNaN(q) || q < 0 || q > 1 {
+			panic("q must be in [0, 1]")
+		}
+	}
+
+	s := Summary{Count: d.Count()}
+	if len(qs) > 0 {
+		s.Quantiles = make([]QuantileValue, len(qs))
+	}
+	for i, q := range qs {
+		s.Quantiles[i].Q = q
+	}
+	if s.Count == 0 {
+		return s
+	}
+
+	c := d.cumulative()
+	s.Min = d.quantileAt(c, 0)
+	s.Max = d.quantileAt(c, 1)
+	for i, k := range c.keys {
+		n := c.sums[i]
+		if i > 0 {
+			n -= c.sums[i-1]
+		}
+		s.Sum += float64(n) * d.quantile(k)
+	}
+	s.Mean = s.Sum / float64(s.Count)
+	for i, q := range qs {
+		s.Quantiles[i].Value = d.quantileAt(c, q)
+	}
+
+	return s
+}
@@ -0,0 +1,278 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest_test
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"testing"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/rapid"
+)
+
+// uniformDigest returns digest of n evenly spaced values in [lo, hi).
+func uniformDigest(relErr float64, lo float64, hi float64, n int, opts ...bdigest.Option) *bdigest.Digest {
+	d := bdigest.NewDigest(relErr, opts...)
+	for i := 0; i < n; i++ {
+		d.Add(lo + (hi-lo)*float64(i)/float64(n))
+	}
+
+	return d
+}
+
+func TestDigest_Density(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr  = rapid.Float64Range(0.01, 0.1).Draw(t, "relative error")
+			mapping = rapid.SampledFrom(mappings).Draw(t, "mapping")
+			v       = rapid.Float64Range(13, 15).Draw(t, "v")
+		)
+
+		// Uniform distribution over [10, 20) has density 0.1.
+		d := uniformDigest(relErr, 10, 20, 100000, bdigest.WithMapping(mapping))
+		if p := d.Density(v); math.Abs(p-0.1) > 0.001 {
+			t.Fatalf("density at %v is %v instead of 0.1", v, p)
+		}
+		if p := d.Density(v / 10); p != 0 {
+			t.Fatalf("density at %v is %v instead of 0", v/10, p)
+		}
+		if p := d.Density(0); p != 0 {
+			t.Fatalf("density at 0 is %v instead of 0", p)
+		}
+		d.Add(0)
+		if p := d.Density(0); !math.IsInf(p, 1) {
+			t.Fatalf("density at 0 is %v instead of +Inf", p)
+		}
+	})
+}
+
+func TestDigest_Mode(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-3, 0.1).Draw(t, "relative error")
+			mode   = rapid.Float64Range(1, 1000).Draw(t, "mode")
+			count  = rapid.IntRange(1, 1000).Draw(t, "count")
+			zeros  = rapid.Bool().Draw(t, "zeros")
+			radius = rapid.IntRange(0, 3).Draw(t, "radius")
+		)
+
+		// Peak on top of a uniform body, with peak count greater than
+		// the body count.
+		d := uniformDigest(relErr, 1, 1000, count)
+		for i := 0; i < 2*count; i++ {
+			d.Add(mode)
+		}
+		if zeros {
+			for i := 0; i < 3*count+1; i++ {
+				d.Add(0)
+			}
+			if m, s := d.Mode(), d.SmoothedMode(radius); m != 0 || s != 0 {
+				t.Fatalf("mode is %v (smoothed %v) instead of 0", m, s)
+			}
+			return
+		}
+
+		if m := d.Mode(); math.Abs(m-mode) > mode*relErr*(1+1e-9) {
+			t.Fatalf("mode is %v instead of %v", m, mode)
+		}
+		// Smoothed mode can be any of the buckets with peak within radius.
+		gamma := 1 + 2*relErr/(1-relErr)
+		if m := d.SmoothedMode(radius); m/mode > math.Pow(gamma, float64(radius+1)) || mode/m > math.Pow(gamma, float64(radius+1)) {
+			t.Fatalf("smoothed mode is %v instead of about %v", m, mode)
+		}
+	})
+
+	if m := bdigest.NewDigest(0.01).Mode(); !math.IsNaN(m) {
+		t.Fatalf("mode of empty digest is %v", m)
+	}
+	// All the buckets are within the radius of the first one.
+	if m := bdigest.FromValues(0.01, 1, 10, 100).SmoothedMode(math.MaxInt); math.Abs(m-1) > 0.01 {
+		t.Fatalf("smoothed mode with maximum radius is %v instead of 1", m)
+	}
+}
+
+func TestDigest_Entropy(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr  = rapid.Float64Range(1e-3, 0.01).Draw(t, "relative error")
+			mapping = rapid.SampledFrom(mappings).Draw(t, "mapping")
+			lo      = rapid.Float64Range(1, 100).Draw(t, "lo")
+			width   = rapid.Float64Range(lo, 100*lo).Draw(t, "width")
+			zeros   = rapid.IntRange(0, 100).Draw(t, "zeros")
+		)
+
+		// Uniform distribution over [lo, lo+width) has entropy ln(width).
+		d := uniformDigest(relErr, lo, lo+width, 100000, bdigest.WithMapping(mapping))
+		for i := 0; i < zeros; i++ {
+			d.Add(0)
+		}
+		if h, want := d.Entropy(), math.Log(width); math.Abs(h-want) > 0.02 {
+			t.Fatalf("entropy is %v instead of %v", h, want)
+		}
+	})
+
+	d := bdigest.NewDigest(0.01)
+	d.Add(0)
+	if h := d.Entropy(); !math.IsNaN(h) {
+		t.Fatalf("entropy without non-zero values is %v", h)
+	}
+}
+
+func TestDigest_GeometricMean(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr  = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			mapping = rapid.SampledFrom(mappings).Draw(t, "mapping")
+			seed    = rapid.Int64().Draw(t, "seed")
+			count   = rapid.IntRange(1, 1000).Draw(t, "count")
+		)
+
+		d := bdigest.NewDigest(relErr, bdigest.WithMapping(mapping))
+		sum := 0.0
+		for _, v := range logNormalValues(seed, count, 0) {
+			d.Add(v)
+			sum += math.Log(v)
+		}
+		want := math.Exp(sum / float64(count))
+		if g := d.GeometricMean(); math.Abs(g-want) > want*relErr*(1+1e-9) {
+			t.Fatalf("geometric mean is %v instead of %v", g, want)
+		}
+
+		d.Add(0)
+		if g := d.GeometricMean(); g != 0 {
+			t.Fatalf("geometric mean with zero value is %v", g)
+		}
+	})
+}
+
+func TestDigest_HarmonicMean(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr  = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			mapping = rapid.SampledFrom(mappings).Draw(t, "mapping")
+			seed    = rapid.Int64().Draw(t, "seed")
+			count   = rapid.IntRange(1, 1000).Draw(t, "count")
+		)
+
+		d := bdigest.NewDigest(relErr, bdigest.WithMapping(mapping))
+		sum := 0.0
+		for _, v := range logNormalValues(seed, count, 0) {
+			d.Add(v)
+			sum += 1 / v
+		}
+		want := float64(count) / sum
+		if h := d.HarmonicMean(); math.Abs(h-want) > want*relErr*(1+1e-9) {
+			t.Fatalf("harmonic mean is %v instead of %v", h, want)
+		}
+
+		d.Add(0)
+		if h := d.HarmonicMean(); h != 0 {
+			t.Fatalf("harmonic mean with zero value is %v", h)
+		}
+	})
+}
+
+func TestDigest_TailIndex(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr  = rapid.Float64Range(0.005, 0.05).Draw(t, "relative error")
+			mapping = rapid.SampledFrom(mappings).Draw(t, "mapping")
+			index   = rapid.Float64Range(0.5, 4).Draw(t, "index")
+			seed    = rapid.Int64().Draw(t, "seed")
+		)
+
+		d := bdigest.NewDigest(relErr, bdigest.WithMapping(mapping))
+		r := rand.New(rand.NewSource(seed))
+		for i := 0; i < 100000; i++ {
+			d.Add(math.Pow(1-r.Float64(), -1/index)) // Pareto with x_m = 1
+		}
+		a, r2 := d.TailIndex(0.5)
+		if math.Abs(a-index) > index*0.05 || r2 < 0.99 {
+			t.Fatalf("tail index is %v (r2 %v) instead of %v", a, r2, index)
+		}
+	})
+
+	d := bdigest.NewDigest(0.01)
+	d.Add(1)
+	d.Add(2)
+	if a, r2 := d.TailIndex(0); !math.IsNaN(a) || !math.IsNaN(r2) {
+		t.Fatalf("tail index of 2 buckets is %v (r2 %v)", a, r2)
+	}
+}
+
+func TestDigest_Summary(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			seed   = rapid.Int64().Draw(t, "seed")
+			count  = rapid.IntRange(0, 1000).Draw(t, "count")
+			qs     = rapid.SliceOfN(rapid.Float64Range(0, 1), 0, 10).Draw(t, "quantiles")
+		)
+
+		d := bdigest.NewDigest(relErr)
+		values := logNormalValues(seed, count, 0)
+		sum := 0.0
+		for _, v := range values {
+			d.Add(v)
+			sum += v
+		}
+
+		s := d.Summary(qs...)
+		if _, err := json.Marshal(s); err != nil {
+			t.Fatalf("failed to marshal summary %+v to JSON: %v", s, err)
+		}
+		if s.Count != uint64(count) || len(s.Quantiles) != len(qs) {
+			t.Fatalf("summary %+v does not match %v values and %v quantiles", s, count, len(qs))
+		}
+		if count == 0 {
+			return
+		}
+
+		for _, c := range []struct {
+			name      string
+			got, want float64
+		}{
+			{"min", s.Min, values[0]},
+			{"max", s.Max, values[count-1]},
+			{"sum", s.Sum, sum},
+			{"mean", s.Mean, sum / float64(count)},
+		} {
+			if math.Abs(c.got-c.want) > c.want*relErr*(1+1e-9) {
+				t.Fatalf("%v is %v instead of %v", c.name, c.got, c.want)
+			}
+		}
+		for i, q := range qs {
+			if v := d.Quantile(q); s.Quantiles[i].Q != q || s.Quantiles[i].Value != v {
+				t.Fatalf("summary quantile %+v instead of q%v %v", s.Quantiles[i], q, v)
+			}
+		}
+	})
+}
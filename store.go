@@ -0,0 +1,762 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+const (
+	wordSize = bits.UintSize / 8
+)
+
+// Store holds the counts of histogram buckets, indexed by bucket key.
+// Keys of buckets with values ≤ 1 are ≤ 0, keys of the rest are ≥ 1.
+//
+// Digest does not modify Store concurrently, but the methods of the digest
+// not modifying it can call Ascend and Size concurrently.
+type Store interface {
+	// Add adds n to the count of bucket k.
+	Add(k int, n uint64)
+	// Ascend calls f for buckets in increasing key order, until f
+	// returns false. Empty buckets may or may not be reported.
+	Ascend(f func(k int, n uint64) bool)
+	// Size returns the number of buckets held.
+	Size() int
+	// Reset removes all buckets, possibly keeping the allocated memory.
+	Reset()
+	// Empty returns new empty store of the same kind.
+	Empty() Store
+}
+
+// memStore is implemented by the stores
+// which can report their memory usage.
+type memStore interface {
+	Store
+	// memSize returns the size of the allocated memory in bytes.
+	memSize() int
+}
+
+// compactStore is implemented by the stores
+// which can release the memory they do not need.
+type compactStore interface {
+	memStore
+	// compact removes empty buckets where possible and reallocates
+	// the memory to the minimum size.
+	compact()
+}
+
+// trimStore is implemented by the stores which can remove
+// the empty buckets with the smallest and the largest keys.
+type trimStore interface {
+	Store
+	// trim removes the empty buckets with the smallest and the largest
+	// keys held, keeping the allocated memory.
+	trim()
+}
+
+// reserveStore is implemented by the stores
+// which can preallocate the buckets.
+type reserveStore interface {
+	Store
+	// reserve preallocates the buckets with keys in [lo, hi].
+	reserve(lo int, hi int)
+}
+
+// NewDenseStore returns the default store, which holds all buckets between
+// the smallest and the largest keys in two slices, one for keys ≤ 0
+// and one for keys ≥ 1.
+func NewDenseStore() Store {
+	return &denseStore{}
+}
+
+// NewSparseStore returns store used by WithSparseStore.
+func NewSparseStore() Store {
+	return &sparseStore{}
+}
+
+// NewRLEStore returns store used by WithRLEStore.
+func NewRLEStore() Store {
+	return &rleStore{}
+}
+
+// NewChunkedStore returns store used by WithChunkedStore.
+func NewChunkedStore() Store {
+	return &chunkedStore{}
+}
+
+// NewSmallCountersStore returns store used by WithSmallCounters.
+func NewSmallCountersStore() Store {
+	return &smallStore{}
+}
+
+// NewCollapsingStore returns store which holds at most maxBuckets
+// consecutive buckets, collapsing the buckets with the smallest keys into
+// the smallest bucket held when needed. It bounds the memory usage, but
+// lower quantiles of digest lose the relative error guarantees when the
+// values span more than maxBuckets buckets.
+//
+// NewCollapsingStore panics if maxBuckets is less than 1.
+func NewCollapsingStore(maxBuckets int) Store {
+	if maxBuckets < 1 {
+		panic("maxBuckets must be at least 1")
+	}
+
+	return &collapsingStore{max: maxBuckets}
+}
+
+// denseStore holds buckets in two slices indexed by key,
+// one for keys ≤ 0 (in decreasing key order) and one for keys ≥ 1.
+type denseStore struct {
+	neg []uint64
+	pos []uint64
+}
+
+func (s *denseStore) Add(k int, n uint64) {
+	if k < 1 {
+		s.neg = grow(s.neg, -k)
+		s.neg[-k] += n
+	} else {
+		s.pos = grow(s.pos, k-1)
+		s.pos[k-1] += n
+	}
+}
+
+func (s *denseStore) Ascend(f func(k int, n uint64) bool) {
+	for i := len(s.neg) - 1; i >= 0; i-- {
+		if !f(-i, s.neg[i]) {
+			return
+		}
+	}
+	for i, n := range s.pos {
+		if !f(i+1, n) {
+			return
+		}
+	}
+}
+
+func (s *denseStore) Size() int {
+	return len(s.neg) + len(s.pos)
+}
+
+func (s *denseStore) Reset() {
+	s.neg = s.neg[:0]
+	s.pos = s.pos[:0]
+}
+
+func (s *denseStore) Empty() Store {
+	return &denseStore{}
+}
+
+func (s *denseStore) merge(v *denseStore) {
+	s.neg = grow(s.neg, len(v.neg)-1)
+	for i, n := range v.neg {
+		s.neg[i] += n
+	}
+	s.pos = grow(s.pos, len(v.pos)-1)
+	for i, n := range v.pos {
+		s.pos[i] += n
+	}
+}
+
+func (s *denseStore) compact() {
+	s.neg = trimZeros(s.neg)
+	s.pos = trimZeros(s.pos)
+}
+
+func (s *denseStore) trim() {
+	s.neg = trimTrailing(s.neg)
+	s.pos = trimTrailing(s.pos)
+}
+
+func (s *denseStore) memSize() int {
+	return (cap(s.neg) + cap(s.pos)) * 8
+}
+
+func (s *denseStore) reserve(lo int, hi int) {
+	if lo < 1 {
+		s.neg = reserve(s.neg, -lo+1)
+	}
+	if hi > 0 {
+		s.pos = reserve(s.pos, hi)
+	}
+}
+
+// sparseStore holds non-empty buckets only, as key/count pairs
+// sorted by key. It is suitable for data with huge dynamic range,
+// where most of the buckets of denseStore would be empty.
+type sparseStore struct {
+	keys   []int
+	counts []uint64
+}
+
+func (s *sparseStore) Add(k int, n uint64) {
+	i := sort.SearchInts(s.keys, k)
+	if i < len(s.keys) && s.keys[i] == k {
+		s.counts[i] += n
+		return
+	}
+
+	s.keys = append(s.keys, 0)
+	s.counts = append(s.counts, 0)
+	copy(s.keys[i+1:], s.keys[i:])
+	copy(s.counts[i+1:], s.counts[i:])
+	s.keys[i] = k
+	s.counts[i] = n
+}
+
+func (s *sparseStore) Ascend(f func(k int, n uint64) bool) {
+	for i, k := range s.keys {
+		if !f(k, s.counts[i]) {
+			return
+		}
+	}
+}
+
+func (s *sparseStore) Size() int {
+	return len(s.keys)
+}
+
+func (s *sparseStore) Reset() {
+	s.keys = s.keys[:0]
+	s.counts = s.counts[:0]
+}
+
+func (s *sparseStore) Empty() Store {
+	return &sparseStore{}
+}
+
+func (s *sparseStore) compact() {
+	keys, counts := s.keys[:0], s.counts[:0]
+	for i, n := range s.counts {
+		if n != 0 {
+			keys = append(keys, s.keys[i])
+			counts = append(counts, n)
+		}
+	}
+	s.keys = append([]int(nil), keys...)
+	s.counts = append([]uint64(nil), counts...)
+}
+
+func (s *sparseStore) trim() {
+	keys, counts := s.keys[:0], s.counts[:0]
+	for i, n := range s.counts {
+		if n != 0 {
+			keys = append(keys, s.keys[i])
+			counts = append(counts, n)
+		}
+	}
+	s.keys, s.counts = keys, counts
+}
+
+func (s *sparseStore) memSize() int {
+	return cap(s.keys)*wordSize + cap(s.counts)*8
+}
+
+// mergeStores adds the content of v to s.
+func mergeStores(s Store, v Store) {
+	if ds, ok := s.(*denseStore); ok {
+		if dv, ok := v.(*denseStore); ok {
+			ds.merge(dv)
+			return
+		}
+	}
+	if s == v {
+		c := &denseStore{}
+		mergeStores(c, v)
+		v = c
+	}
+
+	v.Ascend(func(k int, n uint64) bool {
+		if n > 0 {
+			s.Add(k, n)
+		}
+		return true
+	})
+}
+
+// denseBuckets returns the buckets of s in the dense layout used by
+// the binary formats: keys 0, -1, -2, ... followed by keys 1, 2, 3, ...,
+// including the empty buckets. For denseStore it does not allocate.
+func denseBuckets(s Store) ([]uint64, []uint64) {
+	ds, ok := s.(*denseStore)
+	if !ok {
+		ds = &denseStore{}
+		mergeStores(ds, s)
+	}
+	return ds.neg, ds.pos
+}
+
+// rleStore holds buckets run-length encoded in the compact format, which
+// typically takes 1-2 bytes per bucket. Writes switch it to denseStore,
+// and it is encoded back by compact only, so that reads do not modify it
+// and write-heavy use is not slowed down by repeated encoding.
+type rleStore struct {
+	data  []byte // counts of buckets lo, lo+1, ..., lo+n-1
+	lo    int
+	n     int
+	dense *denseStore
+}
+
+func (s *rleStore) Add(k int, n uint64) {
+	s.decode()
+	s.dense.Add(k, n)
+}
+
+func (s *rleStore) Ascend(f func(k int, n uint64) bool) {
+	if s.dense != nil {
+		s.dense.Ascend(f)
+		return
+	}
+
+	k := s.lo
+	for i := 0; i < len(s.data); {
+		v, m := binary.Uvarint(s.data[i:])
+		i += m
+		if v != 0 {
+			if !f(k, v) {
+				return
+			}
+			k++
+			continue
+		}
+		run, m := binary.Uvarint(s.data[i:])
+		i += m
+		k += int(run)
+	}
+}
+
+func (s *rleStore) Size() int {
+	if s.dense != nil {
+		return s.dense.Size()
+	}
+	return s.n
+}
+
+func (s *rleStore) Reset() {
+	s.data = s.data[:0]
+	s.lo = 0
+	s.n = 0
+	s.dense = nil
+}
+
+func (s *rleStore) Empty() Store {
+	return &rleStore{}
+}
+
+func (s *rleStore) decode() {
+	if s.dense != nil {
+		return
+	}
+
+	d := &denseStore{}
+	s.Ascend(func(k int, n uint64) bool {
+		d.Add(k, n)
+		return true
+	})
+	s.data = s.data[:0]
+	s.lo = 0
+	s.n = 0
+	s.dense = d
+}
+
+func (s *rleStore) encode() {
+	data := s.data[:0]
+	lo, n, run := 0, 0, 0
+	s.dense.Ascend(func(k int, c uint64) bool {
+		if c == 0 {
+			if n > 0 {
+				run++
+			}
+			return true
+		}
+		if n == 0 {
+			lo = k
+		}
+		if run > 0 {
+			data = append(data, 0)
+			data = appendUvarint(data, uint64(run))
+			n += run
+			run = 0
+		}
+		data = appendUvarint(data, c)
+		n++
+		return true
+	})
+
+	s.data = data
+	s.lo = lo
+	s.n = n
+	s.dense = nil
+}
+
+func (s *rleStore) compact() {
+	if s.dense != nil {
+		s.encode()
+	}
+	s.data = append([]byte(nil), s.data...)
+}
+
+func (s *rleStore) memSize() int {
+	n := cap(s.data)
+	if s.dense != nil {
+		n += s.dense.memSize()
+	}
+	return n
+}
+
+const (
+	chunkSize = 128
+)
+
+// chunkedStore holds buckets in fixed-size chunks indexed by key range,
+// allocating the chunks only for the populated key ranges. Unlike with
+// denseStore, extending the key range does not copy the buckets.
+type chunkedStore struct {
+	first  int // index of chunks[0], chunk i holds keys [i*chunkSize, (i+1)*chunkSize)
+	chunks []*[chunkSize]uint64
+	n      int // number of allocated chunks
+}
+
+func (s *chunkedStore) Add(k int, n uint64) {
+	i := k / chunkSize
+	if k < 0 && k%chunkSize != 0 {
+		i--
+	}
+
+	switch {
+	case len(s.chunks) == 0:
+		s.first = i
+		s.chunks = append(s.chunks, nil)
+	case i < s.first:
+		m := s.first - i
+		s.chunks = append(s.chunks, make([]*[chunkSize]uint64, m)...)
+		copy(s.chunks[m:], s.chunks)
+		for j := 0; j < m; j++ {
+			s.chunks[j] = nil
+		}
+		s.first = i
+	case i >= s.first+len(s.chunks):
+		s.chunks = append(s.chunks, make([]*[chunkSize]uint64, i-s.first-len(s.chunks)+1)...)
+	}
+
+	c := s.chunks[i-s.first]
+	if c == nil {
+		c = new([chunkSize]uint64)
+		s.chunks[i-s.first] = c
+		s.n++
+	}
+	c[k-i*chunkSize] += n
+}
+
+func (s *chunkedStore) Ascend(f func(k int, n uint64) bool) {
+	for i, c := range s.chunks {
+		if c == nil {
+			continue
+		}
+		k := (s.first + i) * chunkSize
+		for j, n := range c {
+			if !f(k+j, n) {
+				return
+			}
+		}
+	}
+}
+
+func (s *chunkedStore) Size() int {
+	return s.n * chunkSize
+}
+
+func (s *chunkedStore) Reset() {
+	for i := range s.chunks {
+		s.chunks[i] = nil
+	}
+	s.chunks = s.chunks[:0]
+	s.first = 0
+	s.n = 0
+}
+
+func (s *chunkedStore) Empty() Store {
+	return &chunkedStore{}
+}
+
+func (s *chunkedStore) compact() {
+	for i, c := range s.chunks {
+		if c != nil && *c == [chunkSize]uint64{} {
+			s.chunks[i] = nil
+			s.n--
+		}
+	}
+	lo, hi := 0, len(s.chunks)
+	for lo < hi && s.chunks[lo] == nil {
+		lo++
+	}
+	for hi > lo && s.chunks[hi-1] == nil {
+		hi--
+	}
+	if lo == hi {
+		s.chunks = nil
+		s.first = 0
+		return
+	}
+	s.chunks = append([]*[chunkSize]uint64(nil), s.chunks[lo:hi]...)
+	s.first += lo
+}
+
+func (s *chunkedStore) memSize() int {
+	return cap(s.chunks)*wordSize + s.n*chunkSize*8
+}
+
+// smallStore is like denseStore, but holds 32-bit counts until
+// any of the counts overflows, and denseStore after that.
+type smallStore struct {
+	neg  []uint32
+	pos  []uint32
+	wide *denseStore
+}
+
+func (s *smallStore) Add(k int, n uint64) {
+	if s.wide == nil {
+		var c *uint32
+		if k < 1 {
+			s.neg = grow(s.neg, -k)
+			c = &s.neg[-k]
+		} else {
+			s.pos = grow(s.pos, k-1)
+			c = &s.pos[k-1]
+		}
+		if n <= math.MaxUint32-uint64(*c) {
+			*c += uint32(n)
+			return
+		}
+		s.promote()
+	}
+
+	s.wide.Add(k, n)
+}
+
+func (s *smallStore) promote() {
+	w := &denseStore{
+		neg: make([]uint64, len(s.neg)),
+		pos: make([]uint64, len(s.pos)),
+	}
+	for i, n := range s.neg {
+		w.neg[i] = uint64(n)
+	}
+	for i, n := range s.pos {
+		w.pos[i] = uint64(n)
+	}
+	s.neg = nil
+	s.pos = nil
+	s.wide = w
+}
+
+func (s *smallStore) Ascend(f func(k int, n uint64) bool) {
+	if s.wide != nil {
+		s.wide.Ascend(f)
+		return
+	}
+
+	for i := len(s.neg) - 1; i >= 0; i-- {
+		if !f(-i, uint64(s.neg[i])) {
+			return
+		}
+	}
+	for i, n := range s.pos {
+		if !f(i+1, uint64(n)) {
+			return
+		}
+	}
+}
+
+func (s *smallStore) Size() int {
+	if s.wide != nil {
+		return s.wide.Size()
+	}
+	return len(s.neg) + len(s.pos)
+}
+
+func (s *smallStore) Reset() {
+	s.neg = s.neg[:0]
+	s.pos = s.pos[:0]
+	s.wide = nil
+}
+
+func (s *smallStore) Empty() Store {
+	return &smallStore{}
+}
+
+func (s *smallStore) compact() {
+	if s.wide != nil {
+		s.wide.compact()
+		return
+	}
+	s.neg = trimZeros(s.neg)
+	s.pos = trimZeros(s.pos)
+}
+
+func (s *smallStore) trim() {
+	if s.wide != nil {
+		s.wide.trim()
+		return
+	}
+	s.neg = trimTrailing(s.neg)
+	s.pos = trimTrailing(s.pos)
+}
+
+func (s *smallStore) memSize() int {
+	if s.wide != nil {
+		return s.wide.memSize()
+	}
+	return (cap(s.neg) + cap(s.pos)) * 4
+}
+
+func (s *smallStore) reserve(lo int, hi int) {
+	if s.wide != nil {
+		s.wide.reserve(lo, hi)
+		return
+	}
+	if lo < 1 {
+		s.neg = reserve(s.neg, -lo+1)
+	}
+	if hi > 0 {
+		s.pos = reserve(s.pos, hi)
+	}
+}
+
+// collapsingStore holds counts of buckets lo, lo+1, ..., lo+len(counts)-1,
+// with at most max buckets.
+type collapsingStore struct {
+	max    int
+	lo     int
+	counts []uint64
+}
+
+func (s *collapsingStore) Add(k int, n uint64) {
+	switch {
+	case len(s.counts) == 0:
+		s.lo = k
+		s.counts = append(s.counts, n)
+		return
+	case k < s.lo:
+		if k < s.lo+len(s.counts)-s.max {
+			k = s.lo + len(s.counts) - s.max
+		}
+		m := s.lo - k
+		if m > 0 {
+			s.counts = append(s.counts, make([]uint64, m)...)
+			copy(s.counts[m:], s.counts)
+			for i := 0; i < m; i++ {
+				s.counts[i] = 0
+			}
+			s.lo = k
+		}
+	case k >= s.lo+len(s.counts):
+		s.counts = grow(s.counts, k-s.lo)
+		if m := len(s.counts) - s.max; m > 0 {
+			sum := uint64(0)
+			for _, c := range s.counts[:m+1] {
+				sum += c
+			}
+			s.counts[m] = sum
+			s.counts = append(s.counts[:0], s.counts[m:]...)
+			s.lo += m
+		}
+	}
+
+	s.counts[k-s.lo] += n
+}
+
+func (s *collapsingStore) Ascend(f func(k int, n uint64) bool) {
+	for i, n := range s.counts {
+		if !f(s.lo+i, n) {
+			return
+		}
+	}
+}
+
+func (s *collapsingStore) Size() int {
+	return len(s.counts)
+}
+
+func (s *collapsingStore) Reset() {
+	s.lo = 0
+	s.counts = s.counts[:0]
+}
+
+func (s *collapsingStore) Empty() Store {
+	return &collapsingStore{max: s.max}
+}
+
+func (s *collapsingStore) compact() {
+	s.trim()
+	if len(s.counts) == 0 {
+		s.counts = nil
+		return
+	}
+	s.counts = append([]uint64(nil), s.counts...)
+}
+
+func (s *collapsingStore) trim() {
+	lo, hi := 0, len(s.counts)
+	for lo < hi && s.counts[lo] == 0 {
+		lo++
+	}
+	for hi > lo && s.counts[hi-1] == 0 {
+		hi--
+	}
+	if lo == hi {
+		s.Reset()
+		return
+	}
+	s.counts = append(s.counts[:0], s.counts[lo:hi]...)
+	s.lo += lo
+}
+
+func (s *collapsingStore) memSize() int {
+	return cap(s.counts) * 8
+}
+
+func (s *collapsingStore) reserve(lo int, hi int) {
+	n := hi - lo + 1
+	if n > s.max {
+		n = s.max
+	}
+	s.counts = reserve(s.counts, n)
+}
+
+// trimZeros returns copy of buckets without the trailing empty buckets,
+// allocated with the minimum capacity.
+func trimZeros[T uint32 | uint64](buckets []T) []T {
+	buckets = trimTrailing(buckets)
+	if len(buckets) == 0 {
+		return nil
+	}
+
+	return append([]T(nil), buckets...)
+}
+
+// trimTrailing returns buckets without the trailing empty buckets.
+func trimTrailing[T uint32 | uint64](buckets []T) []T {
+	n := len(buckets)
+	for n > 0 && buckets[n-1] == 0 {
+		n--
+	}
+
+	return buckets[:n]
+}
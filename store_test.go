@@ -0,0 +1,423 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/rapid"
+)
+
+var (
+	stores = map[string][]bdigest.Option{
+		"arena":   {bdigest.WithArena(bdigest.NewArena(1024))},
+		"dense":   nil,
+		"sparse":  {bdigest.WithSparseStore()},
+		"rle":     {bdigest.WithRLEStore()},
+		"chunked": {bdigest.WithChunkedStore()},
+		"small":   {bdigest.WithSmallCounters()},
+		"custom":  {bdigest.WithStore(bdigest.NewCollapsingStore(1 << 30))},
+	}
+	storeNames = sortedKeys(stores)
+)
+
+func sortedKeys(m map[string][]bdigest.Option) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestStores(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			seed   = rapid.Int64().Draw(t, "seed")
+			count  = rapid.IntRange(0, 10000).Draw(t, "count")
+			store  = rapid.SampledFrom(storeNames).Draw(t, "store")
+			reads  = rapid.IntRange(0, 3).Draw(t, "reads")
+			q      = rapid.Float64Range(0, 1).Draw(t, "quantile")
+		)
+
+		dense := logNormalDigest(relErr, seed, count, int32(count)/10)
+		d := logNormalDigest(relErr, seed/2, count/2, int32(count)/10, stores[store]...)
+		for i := 0; i < reads; i++ {
+			d.Quantile(q)
+		}
+		err := d.Merge(logNormalDigest(relErr, seed, count, int32(count)/10, stores[store]...))
+		if err != nil {
+			t.Fatalf("failed to merge digests: %v", err)
+		}
+		err = dense.Merge(logNormalDigest(relErr, seed/2, count/2, int32(count)/10))
+		if err != nil {
+			t.Fatalf("failed to merge digests: %v", err)
+		}
+		for i := 0; i < reads; i++ {
+			d.Quantile(q)
+		}
+
+		if store != "chunked" && store != "arena" && d.Size() > dense.Size() {
+			t.Fatalf("%v store size %v is greater than dense store size %v", store, d.Size(), dense.Size())
+		}
+		if d.Count() != dense.Count() {
+			t.Fatalf("%v store digest count is %v instead of %v", store, d.Count(), dense.Count())
+		}
+		if d.Populated() != dense.Populated() || d.Populated() > d.Size() {
+			t.Fatalf("%v store digest has %v/%v non-empty buckets instead of %v", store, d.Populated(), d.Size(), dense.Populated())
+		}
+		if sq, dq := d.Quantile(q), dense.Quantile(q); sq != dq && !(math.IsNaN(sq) && math.IsNaN(dq)) {
+			t.Fatalf("%v store digest q%v is %v instead of %v", store, q, sq, dq)
+		}
+
+		for _, marshal := range []func(*bdigest.Digest) ([]byte, error){
+			(*bdigest.Digest).MarshalBinary,
+			(*bdigest.Digest).MarshalCompact,
+		} {
+			data, err := marshal(d)
+			if err != nil {
+				t.Fatalf("failed to marshal %v store digest: %v", store, err)
+			}
+			dd, err := marshal(dense)
+			if err != nil {
+				t.Fatalf("failed to marshal dense store digest: %v", err)
+			}
+			if !bytes.Equal(data, dd) {
+				t.Fatalf("%v store digest data %q differs from dense store digest data %q", store, data, dd)
+			}
+
+			d2 := bdigest.NewDigest(relErr, stores[store]...)
+			err = d2.UnmarshalBinary(data)
+			if err != nil {
+				t.Fatalf("failed to unmarshal digest: %v", err)
+			}
+			data2, err := marshal(d2)
+			if err != nil {
+				t.Fatalf("failed to marshal %v store digest: %v", store, err)
+			}
+			if !bytes.Equal(data, data2) {
+				t.Fatalf("got back %q which is different than %q", data2, data)
+			}
+			d2.Quantile(q) // fill the cache like in d
+			if store == "sparse" && !reflect.DeepEqual(d, d2) {
+				t.Fatalf("got back %#v which is different than %#v", d2, d)
+			}
+		}
+	})
+}
+
+func TestStores_ConcurrentRead(t *testing.T) {
+	t.Parallel()
+
+	for _, store := range storeNames {
+		d := logNormalDigest(0.01, 1, 1000, 100, stores[store]...)
+		d.Add(1000)
+		want := d.Clone()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if c := d.Clone(); !c.Equal(want) {
+					t.Errorf("%v store digest clone differs from the original", store)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+func TestDigest_Compact(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr  = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			seed    = rapid.Int64().Draw(t, "seed")
+			count   = rapid.IntRange(0, 10000).Draw(t, "count")
+			store   = rapid.SampledFrom(storeNames).Draw(t, "store")
+			outlier = rapid.Float64Range(0, 1e100).Draw(t, "outlier")
+			reset   = rapid.Bool().Draw(t, "reset")
+		)
+
+		d := logNormalDigest(relErr, seed, count, int32(count)/10, stores[store]...)
+		d.Add(outlier)
+		d.Quantile(0.5)
+		if reset {
+			d.Reset()
+		}
+		data, err := d.MarshalBinary()
+		if err != nil {
+			t.Fatalf("failed to marshal digest: %v", err)
+		}
+
+		m := d.MemoryBytes()
+		if n := d.Compact(); n < 0 || m-d.MemoryBytes() != n {
+			t.Fatalf("%v store digest compaction freed %v bytes, memory usage changed from %v to %v bytes", store, n, m, d.MemoryBytes())
+		}
+		data2, err := d.MarshalBinary()
+		if err != nil {
+			t.Fatalf("failed to marshal digest: %v", err)
+		}
+		if !bytes.Equal(data, data2) {
+			t.Fatalf("compacted digest data %q differs from %q", data2, data)
+		}
+		if n := d.Compact(); n != 0 {
+			t.Fatalf("repeated %v store digest compaction freed %v bytes", store, n)
+		}
+		if reset && d.Size() != 0 {
+			t.Fatalf("compacted empty %v store digest size is %v", store, d.Size())
+		}
+	})
+}
+
+func TestDigest_Trim(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			seed   = rapid.Int64().Draw(t, "seed")
+			count  = rapid.IntRange(0, 1000).Draw(t, "count")
+			padNeg = rapid.IntRange(0, 10).Draw(t, "negative padding")
+			padPos = rapid.IntRange(0, 10).Draw(t, "positive padding")
+			offNeg = 4 + 16 // prefix and the offset in the header
+			offPos = offNeg + 4
+		)
+
+		d := logNormalDigest(relErr, seed, count, int32(count)/10)
+		data, err := d.MarshalBinary()
+		if err != nil {
+			t.Fatalf("failed to marshal digest: %v", err)
+		}
+
+		// Pad both histograms with empty buckets at the far ends.
+		numNeg := int(binary.LittleEndian.Uint32(data[offNeg:]))
+		numPos := int(binary.LittleEndian.Uint32(data[offPos:]))
+		i := len(data) - 8*numPos
+		var padded []byte
+		padded = append(padded, data[:i]...)
+		padded = append(padded, make([]byte, 8*padNeg)...)
+		padded = append(padded, data[i:]...)
+		padded = append(padded, make([]byte, 8*padPos)...)
+		binary.LittleEndian.PutUint32(padded[offNeg:], uint32(numNeg+padNeg))
+		binary.LittleEndian.PutUint32(padded[offPos:], uint32(numPos+padPos))
+
+		var p bdigest.Digest
+		if err := p.UnmarshalBinary(padded); err != nil {
+			t.Fatalf("failed to unmarshal padded data: %v", err)
+		}
+		if data2, _ := p.MarshalBinary(); !bytes.Equal(data, data2) {
+			t.Fatalf("padded digest data %q differs from %q", data2, data)
+		}
+		size := p.Size()
+		if n := p.Trim(); n != padNeg+padPos || p.Size() != size-n {
+			t.Fatalf("trimmed %v buckets instead of %v, size changed from %v to %v", n, padNeg+padPos, size, p.Size())
+		}
+		if !p.Equal(d) {
+			t.Fatalf("trimmed digest %v differs from %v", &p, d)
+		}
+		if n := p.Trim(); n != 0 {
+			t.Fatalf("repeated trim removed %v buckets", n)
+		}
+	})
+}
+
+func TestArena(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr   = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			slabSize = rapid.IntRange(0, 1024).Draw(t, "slab size")
+			n        = rapid.IntRange(1, 10).Draw(t, "digests")
+			resets   = rapid.IntRange(0, 3).Draw(t, "resets")
+		)
+
+		a := bdigest.NewArena(slabSize)
+		ds := make([]*bdigest.Digest, n)
+		for i := range ds {
+			ds[i] = bdigest.NewDigest(relErr, bdigest.WithArena(a))
+		}
+		for r := 0; r <= resets; r++ {
+			if r > 0 {
+				a.Reset()
+			}
+			for i, d := range ds {
+				count := rapid.IntRange(0, 1000).Draw(t, "count")
+				d.Reset()
+				if err := d.Merge(logNormalDigest(relErr, int64(i), count, 10)); err != nil {
+					t.Fatalf("failed to merge digests: %v", err)
+				}
+			}
+			for i, d := range ds {
+				dense := logNormalDigest(relErr, int64(i), int(d.Count()), 10)
+				data1, _ := d.MarshalBinary()
+				data2, _ := dense.MarshalBinary()
+				if string(data1) != string(data2) {
+					t.Fatalf("arena digest %v data %q differs from %q", i, data1, data2)
+				}
+			}
+		}
+	})
+}
+
+func TestWithValueRangeHint(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			seed   = rapid.Int64().Draw(t, "seed")
+			count  = rapid.IntRange(0, 1000).Draw(t, "count")
+			store  = rapid.SampledFrom(storeNames).Draw(t, "store")
+			min    = rapid.Float64Range(1e-3, 1).Draw(t, "min")
+			max    = rapid.Float64Range(1, 1e3).Draw(t, "max")
+		)
+
+		opts := append([]bdigest.Option{bdigest.WithValueRangeHint(min, max)}, stores[store]...)
+		d := logNormalDigest(relErr, seed, count, int32(count)/10, opts...)
+		data1, _ := d.MarshalBinary()
+		data2, _ := logNormalDigest(relErr, seed, count, int32(count)/10, stores[store]...).MarshalBinary()
+		if string(data1) != string(data2) {
+			t.Fatalf("digest with value range hint data %q differs from %q", data1, data2)
+		}
+
+		if store == "dense" {
+			// Unlike the number of allocations, memory is not affected by -race.
+			d := bdigest.NewDigest(relErr, opts...)
+			m := d.MemoryBytes()
+			for v := min; v < max; v *= 1 + relErr {
+				d.Add(v)
+			}
+			if d.MemoryBytes() != m {
+				t.Fatalf("memory grew from %v to %v bytes when adding values in [%v, %v]", m, d.MemoryBytes(), min, max)
+			}
+		}
+	})
+}
+
+func TestSmallCountersOverflow(t *testing.T) {
+	t.Parallel()
+
+	text := []byte("0.01 zero:3 -2:4294967295 5:7")
+	small := bdigest.NewDigest(0.01, bdigest.WithSmallCounters())
+	dense := bdigest.NewDigest(0.01)
+	for _, d := range []*bdigest.Digest{small, dense} {
+		err := d.UnmarshalText(text)
+		if err != nil {
+			t.Fatalf("failed to unmarshal digest: %v", err)
+		}
+		err = d.Merge(d)
+		if err != nil {
+			t.Fatalf("failed to merge digests: %v", err)
+		}
+		d.Add(1)
+	}
+
+	st, err := small.MarshalText()
+	if err != nil {
+		t.Fatalf("failed to marshal digest: %v", err)
+	}
+	dt, err := dense.MarshalText()
+	if err != nil {
+		t.Fatalf("failed to marshal digest: %v", err)
+	}
+	if !bytes.Equal(st, dt) {
+		t.Fatalf("got %q instead of %q", st, dt)
+	}
+}
+
+func TestCollapsingStore(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr     = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			seed       = rapid.Int64().Draw(t, "seed")
+			count      = rapid.IntRange(1, 10000).Draw(t, "count")
+			maxBuckets = rapid.IntRange(1, 200).Draw(t, "max buckets")
+			q          = rapid.Float64Range(0, 1).Draw(t, "quantile")
+		)
+
+		dense := logNormalDigest(relErr, seed, count, int32(count)/10)
+		d := logNormalDigest(relErr, seed, count, int32(count)/10, bdigest.WithStore(bdigest.NewCollapsingStore(maxBuckets)))
+		if d.Size() > maxBuckets {
+			t.Fatalf("size is %v instead of maximum %v", d.Size(), maxBuckets)
+		}
+		if d.Count() != dense.Count() {
+			t.Fatalf("count is %v instead of %v", d.Count(), dense.Count())
+		}
+		if q1, q2 := d.Quantile(1), dense.Quantile(1); q1 != q2 {
+			t.Fatalf("q1 is %v instead of %v", q1, q2)
+		}
+		if q1, q2 := d.Quantile(q), dense.Quantile(q); q1 < q2 {
+			t.Fatalf("q%v is %v, less than %v", q, q1, q2)
+		}
+	})
+}
+
+// droppingStore is a broken store, dropping every other addition.
+type droppingStore struct {
+	bdigest.Store
+	adds int
+}
+
+func (s *droppingStore) Add(k int, n uint64) {
+	s.adds++
+	if s.adds%2 == 0 {
+		s.Store.Add(k, n)
+	}
+}
+
+func (s *droppingStore) Empty() bdigest.Store {
+	return &droppingStore{Store: s.Store.Empty()}
+}
+
+func TestDigest_Validate(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			seed   = rapid.Int64().Draw(t, "seed")
+			count  = rapid.IntRange(0, 10000).Draw(t, "count")
+			store  = rapid.SampledFrom(storeNames).Draw(t, "store")
+		)
+
+		d := logNormalDigest(relErr, seed, count, int32(count)/10, stores[store]...)
+		if err := d.Validate(); err != nil {
+			t.Fatalf("%v store digest is invalid: %v", store, err)
+		}
+
+		d = logNormalDigest(relErr, seed, count, 0, bdigest.WithStore(&droppingStore{Store: bdigest.NewDenseStore()}))
+		if err := d.Validate(); err == nil && count > 0 {
+			t.Fatalf("digest with dropped values is valid")
+		}
+	})
+
+	if err := new(bdigest.Digest).Validate(); err == nil {
+		t.Fatalf("zero digest is valid")
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest
+
+import (
+	"fmt"
+	"math"
+)
+
+// NewTailDigest returns digest like NewDigest, but with two relative
+// errors: err for values up to threshold, and tailErr for values above it.
+// It is useful when only the tail of the distribution (like latencies
+// at p95 and above) needs high precision, since it avoids wasting memory
+// on the fine buckets for the bulk of the values. The threshold is
+// rounded up to the upper bound of the coarse bucket holding it.
+//
+// Digests created with NewTailDigest can not be marshaled, and can only
+// be merged with digests with the same err, tailErr and threshold.
+//
+// NewTailDigest panics if err or tailErr are outside (0, 1),
+// or threshold is not in (0, math.MaxFloat64].
+func NewTailDigest(err float64, tailErr float64, threshold float64, opts ...Option) *Digest {
+	if math.IsNaN(err) || err <= 0 || err >= 1 || math.IsNaN(tailErr) || tailErr <= 0 || tailErr >= 1 {
+		panic("err and tailErr must be in (0, 1)")
+	}
+	if math.IsNaN(threshold) || threshold <= 0 || threshold > math.MaxFloat64 {
+		panic("threshold must be in (0, math.MaxFloat64]")
+	}
+
+	m := tailMapping{
+		body:    newBuiltinMapping(MappingLogarithmic, err),
+		tail:    newBuiltinMapping(MappingLogarithmic, tailErr),
+		tailErr: tailErr,
+	}
+	m.split = m.body.Index(threshold)
+	m.bound = m.body.LowerBound(m.split + 1)
+	m.offset = m.split + 1 - m.tail.Index(m.bound)
+
+	return newDigest(math.Max(err, tailErr), m, opts)
+}
+
+// tailMapping maps values up to bound like body, with keys up to split,
+// and values above bound like tail, with keys shifted by offset to follow
+// the ones of body. First bucket of tail is cut at bound.
+type tailMapping struct {
+	body    builtinMapping
+	tail    builtinMapping
+	tailErr float64
+	split   int
+	bound   float64
+	offset  int
+}
+
+func (m tailMapping) Index(v float64) int {
+	if v <= m.bound {
+		if k := m.body.Index(v); k < m.split {
+			return k
+		}
+		return m.split
+	}
+
+	if k := m.tail.Index(v) + m.offset; k > m.split {
+		return k
+	}
+	return m.split + 1
+}
+
+func (m tailMapping) Value(k int) float64 {
+	if k <= m.split {
+		return m.body.Value(k)
+	}
+
+	// Harmonic mean of the bucket bounds, as the first bucket is cut.
+	lo, hi := m.LowerBound(k), m.LowerBound(k+1)
+	return 2 * lo / (1 + lo/hi)
+}
+
+func (m tailMapping) LowerBound(k int) float64 {
+	if k <= m.split+1 {
+		return m.body.LowerBound(k)
+	}
+	return m.tail.LowerBound(k - m.offset)
+}
+
+func (m tailMapping) String() string {
+	return fmt.Sprintf("tail(err=%v%% above %v)", m.tailErr*100, m.bound)
+}
@@ -0,0 +1,61 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest_test
+
+import (
+	"math"
+	"sort"
+	"testing"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/rapid"
+)
+
+func TestTailDigest(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr    = rapid.Float64Range(0.01, 0.1).Draw(t, "relative error")
+			tailErr   = rapid.Float64Range(1e-4, 0.01).Draw(t, "tail relative error")
+			threshold = rapid.Float64Range(1e-3, 1e3).Draw(t, "threshold")
+			values    = rapid.SliceOfN(rapid.Float64Range(1e-6, 1e6), 1, 1000).Draw(t, "values")
+		)
+
+		d := bdigest.NewTailDigest(relErr, tailErr, threshold)
+		for _, v := range values {
+			d.Add(v)
+		}
+		sort.Float64s(values)
+
+		for _, q := range quantiles {
+			want := values[int(q*float64(len(values)-1))]
+			maxErr := relErr
+			if want > threshold*(1+2*relErr/(1-relErr)) {
+				maxErr = tailErr
+			}
+			if got := d.Quantile(q); math.Abs(got-want) > want*maxErr*(1+1e-9) {
+				t.Fatalf("q%v is %v instead of %v (max error %v)", q, got, want, maxErr)
+			}
+		}
+
+		if err := d.Merge(bdigest.NewTailDigest(relErr, tailErr, threshold)); err != nil {
+			t.Fatalf("failed to merge digests with the same parameters: %v", err)
+		}
+		if err := d.Merge(bdigest.NewDigest(relErr)); err == nil {
+			t.Fatalf("merged digest without tail precision")
+		}
+	})
+}
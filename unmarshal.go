@@ -0,0 +1,324 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+const (
+	// DefaultMaxBuckets is the default limit on the number of histogram
+	// buckets of decoded digests, which corresponds to 128MiB of memory.
+	DefaultMaxBuckets = 1 << 24
+)
+
+// UnmarshalOptions limits the resources used to decode digests,
+// making it safe to decode digests from untrusted input.
+type UnmarshalOptions struct {
+	// MaxBuckets limits the total number of histogram buckets.
+	// Zero means DefaultMaxBuckets, negative value means the maximum
+	// supported by the binary format.
+	MaxBuckets int
+	// MaxSize limits the size of the encoded digest in bytes.
+	// Zero or negative value means no limit.
+	MaxSize int
+}
+
+// Unmarshal decodes data in any of the formats accepted by
+// UnmarshalBinary into d.
+func (o UnmarshalOptions) Unmarshal(data []byte, d *Digest) error {
+	if o.MaxSize > 0 && len(data) > o.MaxSize {
+		return fmt.Errorf("data size %v exceeds the limit of %v bytes", len(data), o.MaxSize)
+	}
+
+	format, m, body, err := parsePrefix(data)
+	if err != nil {
+		return err
+	}
+	if format == formatCompact {
+		return d.unmarshalCompact(body, m, o.maxBuckets())
+	}
+
+	return d.unmarshalFixed(body, m, o.maxBuckets())
+}
+
+// Decode reads exactly one digest from r into d, like Digest.ReadFrom.
+func (o UnmarshalOptions) Decode(r io.Reader, d *Digest) (int64, error) {
+	if o.MaxSize > 0 {
+		r = &sizeLimitedReader{r: r, n: int64(o.MaxSize), max: o.MaxSize}
+	}
+
+	var buf [512]byte
+	m, err := io.ReadFull(r, buf[:prefixSize])
+	n := int64(m)
+	if err == io.EOF {
+		return n, err
+	} else if err != nil {
+		return n, fmt.Errorf("failed to read header: %w", err)
+	}
+
+	if !hasPrefix(buf[:prefixSize]) {
+		// Legacy format: the prefix is the beginning of the header.
+		m, err = io.ReadFull(r, buf[prefixSize:headerSize])
+		n += int64(m)
+		if err != nil {
+			return n, fmt.Errorf("failed to read header: %w", err)
+		}
+		m64, err := d.readFixed(r, buf[:], MappingLogarithmic, o.maxBuckets())
+		return n + m64, err
+	}
+
+	v := buf[len(magic)]
+	format, mapping, err := parseVersion(v)
+	if err != nil {
+		return n, err
+	}
+	var h hash.Hash32
+	body := r
+	if v&flagChecksum != 0 {
+		h = crc32.New(crcTable)
+		_, _ = h.Write(buf[:prefixSize])
+		body = io.TeeReader(r, h)
+	}
+
+	t := Digest{store: d.store}
+	switch format {
+	case formatFixed:
+		m, err = io.ReadFull(body, buf[:headerSize])
+		n += int64(m)
+		if err != nil {
+			return n, fmt.Errorf("failed to read header: %w", err)
+		}
+		m64, err := t.readFixed(body, buf[:], mapping, o.maxBuckets())
+		n += m64
+		if err != nil {
+			return n, err
+		}
+	case formatCompact:
+		cr := &countingReader{r: body}
+		err = t.readCompact(cr, mapping, o.maxBuckets())
+		n += cr.n
+		if err != nil {
+			return n, err
+		}
+	}
+
+	if h != nil {
+		m, err = io.ReadFull(r, buf[:checksumSize])
+		n += int64(m)
+		if err != nil {
+			return n, fmt.Errorf("failed to read checksum: %w", err)
+		}
+		if binary.LittleEndian.Uint32(buf[:]) != h.Sum32() {
+			return n, ErrChecksum
+		}
+	}
+	*d = t
+
+	return n, nil
+}
+
+func (o UnmarshalOptions) maxBuckets() uint64 {
+	switch {
+	case o.MaxBuckets == 0:
+		return DefaultMaxBuckets
+	case o.MaxBuckets < 0:
+		return math.MaxUint32
+	default:
+		return uint64(o.MaxBuckets)
+	}
+}
+
+func checkBuckets(lenNeg uint64, lenPos uint64, max uint64) error {
+	if lenNeg > max || lenPos > max-lenNeg {
+		return fmt.Errorf("too many histogram buckets: %v+%v instead of maximum %v", lenNeg, lenPos, max)
+	}
+
+	return nil
+}
+
+func addCount(a uint64, b uint64) (uint64, error) {
+	if a > math.MaxUint64-b {
+		return 0, ErrOverflow
+	}
+
+	return a + b, nil
+}
+
+type sizeLimitedReader struct {
+	r   io.Reader
+	n   int64
+	max int
+}
+
+func (l *sizeLimitedReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		return 0, fmt.Errorf("data size exceeds the limit of %v bytes", l.max)
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	return n, err
+}
+
+// MergeBinary merges digest encoded in any of the formats accepted by
+// UnmarshalBinary into d, without decoding it into an intermediate Digest.
+// The result is the same as of UnmarshalBinary followed by Merge.
+//
+// MergeBinary returns an error if data is invalid or the encoded digest
+// has a different relative error, or ErrOverflow if the total count
+// overflows; d is left unchanged in that case.
+func (d *Digest) MergeBinary(data []byte) error {
+	// The first pass validates the data, the second one merges it.
+	alpha, m, numZero, lenNeg, lenPos, n, err := scanBinary(data, DefaultMaxBuckets, nil)
+	if err != nil {
+		return err
+	}
+	if n, err = addCount(n, numZero); err != nil {
+		return err
+	}
+	if _, err = addCount(d.Count(), n); err != nil {
+		return err
+	}
+
+	if d.budget != 0 && alpha != d.alpha {
+		// Merging digests coarsened differently because of WithMemoryBudget
+		// remaps the buckets, which is left to Merge.
+		v := &Digest{}
+		if err = v.UnmarshalBinary(data); err != nil {
+			return err
+		}
+		return d.Merge(v)
+	}
+	if err = d.checkMerge(&Digest{alpha: alpha, mapping: builtinMapping{kind: m}}); err != nil {
+		return err
+	}
+
+	if d.exactMax > 0 && d.isExact() && n > numZero {
+		d.exact = d.exact[:0]
+	}
+	d.numZero += numZero
+	if s, ok := d.store.(*denseStore); ok {
+		// Grow the buckets at once, like merging the decoded digest,
+		// so that the memory budget is applied the same way.
+		s.neg = grow(s.neg, int(lenNeg)-1)
+		s.pos = grow(s.pos, int(lenPos)-1)
+	}
+	_, _, _, _, _, _, err = scanBinary(data, DefaultMaxBuckets, d.addKey)
+	d.fitBudget()
+
+	return err
+}
+
+// scanBinary parses data in any of the binary formats, calling f (if not nil)
+// for each non-empty histogram bucket. It returns the mapping, the header
+// fields and the total count of values in the histogram buckets.
+func scanBinary(data []byte, maxBuckets uint64, f func(k int, n uint64)) (alpha float64, m Mapping, numZero uint64, lenNeg uint64, lenPos uint64, total uint64, err error) {
+	format, m, data, err := parsePrefix(data)
+	if err != nil {
+		return 0, 0, 0, 0, 0, 0, err
+	}
+	compact := format == formatCompact
+
+	var next func() (uint64, uint64, error)
+	if compact {
+		r := bytes.NewReader(data)
+		var buf [8]byte
+		if _, err = io.ReadFull(r, buf[:]); err != nil {
+			return 0, 0, 0, 0, 0, 0, fmt.Errorf("failed to read relative error: %w", err)
+		}
+		alpha = math.Float64frombits(binary.LittleEndian.Uint64(buf[:]))
+		var hdr [3]uint64
+		for i := range hdr {
+			if hdr[i], err = binary.ReadUvarint(r); err != nil {
+				return 0, 0, 0, 0, 0, 0, fmt.Errorf("failed to read header: %w", err)
+			}
+		}
+		numZero, lenNeg, lenPos = hdr[0], hdr[1], hdr[2]
+		next = func() (uint64, uint64, error) {
+			v, err := binary.ReadUvarint(r)
+			if err != nil || v != 0 {
+				return v, 1, err
+			}
+			run, err := binary.ReadUvarint(r)
+			return 0, run, err
+		}
+		defer func() {
+			if err == nil && r.Len() != 0 {
+				err = fmt.Errorf("%v bytes of trailing data", r.Len())
+			}
+		}()
+	} else {
+		if len(data) < headerSize {
+			return 0, 0, 0, 0, 0, 0, fmt.Errorf("not enough data to read header: %v bytes instead of minimum %v", len(data), headerSize)
+		}
+		var ln, lp uint32
+		alpha, numZero, ln, lp, err = parseHeader(data)
+		if err != nil {
+			return 0, 0, 0, 0, 0, 0, err
+		}
+		lenNeg, lenPos = uint64(ln), uint64(lp)
+		if size := (lenNeg + lenPos) * 8; uint64(len(data[headerSize:])) != size {
+			return 0, 0, 0, 0, 0, 0, fmt.Errorf("wrong histograms data size: %v bytes instead of %v", len(data[headerSize:]), size)
+		}
+		data = data[headerSize:]
+		next = func() (uint64, uint64, error) {
+			v := binary.LittleEndian.Uint64(data)
+			data = data[8:]
+			return v, 1, nil
+		}
+	}
+
+	if math.IsNaN(alpha) || alpha <= 0 || alpha >= 1 {
+		return 0, 0, 0, 0, 0, 0, fmt.Errorf("invalid relative error %v", alpha)
+	}
+	if err = checkBuckets(lenNeg, lenPos, maxBuckets); err != nil {
+		return 0, 0, 0, 0, 0, 0, err
+	}
+
+	total = numZero
+	for i := uint64(0); i < lenNeg+lenPos; {
+		v, run, err := next()
+		if err != nil {
+			return 0, 0, 0, 0, 0, 0, fmt.Errorf("failed to read histogram bucket %v: %w", i, err)
+		}
+		if run == 0 || run > lenNeg+lenPos-i || (run > 1 && i < lenNeg && i+run > lenNeg) {
+			return 0, 0, 0, 0, 0, 0, fmt.Errorf("invalid run of %v empty histogram buckets at %v", run, i)
+		}
+		if v != 0 {
+			if total, err = addCount(total, v); err != nil {
+				return 0, 0, 0, 0, 0, 0, err
+			}
+			if f != nil {
+				if i < lenNeg {
+					f(-int(i), v)
+				} else {
+					f(int(i-lenNeg)+1, v)
+				}
+			}
+		}
+		i += run
+	}
+
+	return alpha, m, numZero, lenNeg, lenPos, total - numZero, nil
+}
@@ -0,0 +1,218 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/rapid"
+)
+
+func TestUnmarshalOptions_Limits(t *testing.T) {
+	t.Parallel()
+
+	d := logNormalDigest(0.01, 0, 1000, 10)
+	fixed, _ := d.MarshalBinary()
+	compact, _ := d.MarshalCompact()
+
+	for _, data := range [][]byte{fixed, compact, fixed[4:]} {
+		var d2 bdigest.Digest
+		err := bdigest.UnmarshalOptions{MaxBuckets: d.Size()}.Unmarshal(data, &d2)
+		if err != nil {
+			t.Errorf("failed to unmarshal digest with exact bucket limit: %v", err)
+		}
+		err = bdigest.UnmarshalOptions{MaxBuckets: d.Size() - 1}.Unmarshal(data, &d2)
+		if err == nil {
+			t.Errorf("unmarshaled digest exceeding bucket limit")
+		}
+		_, err = bdigest.UnmarshalOptions{MaxBuckets: d.Size() - 1}.Decode(bytes.NewReader(data), &d2)
+		if err == nil {
+			t.Errorf("decoded digest exceeding bucket limit")
+		}
+		err = bdigest.UnmarshalOptions{MaxSize: len(data) - 1}.Unmarshal(data, &d2)
+		if err == nil {
+			t.Errorf("unmarshaled digest exceeding size limit")
+		}
+		_, err = bdigest.UnmarshalOptions{MaxSize: len(data) - 1}.Decode(bytes.NewReader(data), &d2)
+		if err == nil {
+			t.Errorf("decoded digest exceeding size limit")
+		}
+	}
+}
+
+func TestDigest_UnmarshalBinaryHostile(t *testing.T) {
+	t.Parallel()
+
+	header := func(lenNeg uint32, lenPos uint32) []byte {
+		buf := make([]byte, 24)
+		binary.LittleEndian.PutUint64(buf[0:], math.Float64bits(0.01))
+		binary.LittleEndian.PutUint32(buf[16:], lenNeg)
+		binary.LittleEndian.PutUint32(buf[20:], lenPos)
+		return buf
+	}
+	overflow := append(header(0, 2), make([]byte, 16)...)
+	binary.LittleEndian.PutUint64(overflow[24:], math.MaxUint64)
+	binary.LittleEndian.PutUint64(overflow[32:], 1)
+
+	for name, data := range map[string][]byte{
+		"wrapping size":    header(1<<29, 0),
+		"huge header":      header(math.MaxUint32, math.MaxUint32),
+		"count overflow":   overflow,
+		"huge compact":     {'b', 'd', 'g', 2, 0x7b, 0x14, 0xae, 0x47, 0xe1, 0x7a, 0x84, 0x3f, 0, 0xff, 0xff, 0xff, 0xff, 0x0f, 0},
+		"runaway zero run": {'b', 'd', 'g', 2, 0x7b, 0x14, 0xae, 0x47, 0xe1, 0x7a, 0x84, 0x3f, 0, 0, 2, 0, 3},
+	} {
+		var d bdigest.Digest
+		if err := d.UnmarshalBinary(data); err == nil {
+			t.Errorf("%v: unmarshaled invalid data", name)
+		}
+		if _, err := d.ReadFrom(bytes.NewReader(data)); err == nil {
+			t.Errorf("%v: read invalid data", name)
+		}
+	}
+}
+
+func TestDigest_UnmarshalCompactTruncated(t *testing.T) {
+	// Not parallel, to measure the memory allocated by the test only.
+	data := []byte{'b', 'd', 'g', 2, 0x7b, 0x14, 0xae, 0x47, 0xe1, 0x7a, 0x84, 0x3f, 0, 0, 0xff, 0xff, 0xff, 0x07, 1}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	var d bdigest.Digest
+	if err := d.UnmarshalBinary(data); err == nil {
+		t.Fatalf("unmarshaled truncated data")
+	}
+	if err := d.MergeBinary(data); err == nil {
+		t.Fatalf("merged truncated data")
+	}
+	runtime.ReadMemStats(&after)
+
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+		t.Fatalf("allocated %v bytes for %v bytes of data", n, len(data))
+	}
+}
+
+func TestDigest_UnmarshalBinaryArbitrary(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			format = rapid.SampledFrom([]string{"", "bdg\x01", "bdg\x02"}).Draw(t, "format")
+			data   = rapid.SliceOfN(rapid.Byte(), 0, 64).Draw(t, "data")
+		)
+
+		data = append([]byte(format), data...)
+		opts := bdigest.UnmarshalOptions{MaxBuckets: 1 << 16}
+		var d bdigest.Digest
+		if err := opts.Unmarshal(data, &d); err == nil {
+			_ = d.Quantile(0.5)
+		}
+		if _, err := opts.Decode(bytes.NewReader(data), &d); err == nil {
+			_ = d.Quantile(0.5)
+		}
+	})
+}
+
+func TestDigest_MergeBinary(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr  = rapid.Float64Range(1e-5, 1-1e-5).Draw(t, "relative error")
+			seed1   = rapid.Int64().Draw(t, "seed 1")
+			seed2   = rapid.Int64().Draw(t, "seed 2")
+			count1  = rapid.IntRange(0, 100000).Draw(t, "count 1")
+			count2  = rapid.IntRange(0, 100000).Draw(t, "count 2")
+			compact = rapid.Bool().Draw(t, "compact")
+		)
+
+		d1 := logNormalDigest(relErr, seed1, count1, int32(count1)/10)
+		d2 := logNormalDigest(relErr, seed2, count2, int32(count2)/10)
+		marshal := d2.MarshalBinary
+		if compact {
+			marshal = d2.MarshalCompact
+		}
+		data, err := marshal()
+		if err != nil {
+			t.Fatalf("failed to marshal digest: %v", err)
+		}
+
+		d3 := logNormalDigest(relErr, seed1, count1, int32(count1)/10)
+		if err = d1.Merge(d2); err != nil {
+			t.Fatalf("failed to merge digest: %v", err)
+		}
+		if err = d3.MergeBinary(data); err != nil {
+			t.Fatalf("failed to merge serialized digest: %v", err)
+		}
+		if !reflect.DeepEqual(d1, d3) {
+			t.Fatalf("got %#v which is different than %#v", d3, d1)
+		}
+
+		other, _ := bdigest.NewDigest(relErr / 2).MarshalBinary()
+		if err = d3.MergeBinary(other); err == nil {
+			t.Fatalf("merged digest with different relative error")
+		}
+		if !reflect.DeepEqual(d1, d3) {
+			t.Fatalf("failed merge has modified the digest")
+		}
+	})
+}
+
+func TestDigest_MergeBinaryBudget(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			budget = rapid.IntRange(128, 2048).Draw(t, "budget")
+			seed   = rapid.Int64().Draw(t, "seed")
+			count  = rapid.IntRange(0, 10000).Draw(t, "count")
+		)
+
+		targets := []*bdigest.Digest{
+			bdigest.NewDigest(0.01, bdigest.WithMemoryBudget(budget)),
+			logNormalDigest(0.01, seed, count, 0, bdigest.WithMemoryBudget(budget)),
+		}
+		sources := []*bdigest.Digest{
+			logNormalDigest(0.01, seed+1, count, 0),
+			logNormalDigest(0.01, seed+2, count, 0, bdigest.WithMemoryBudget(budget/2)),
+		}
+		for _, target := range targets {
+			for _, source := range sources {
+				data, err := source.MarshalBinary()
+				if err != nil {
+					t.Fatalf("failed to marshal digest: %v", err)
+				}
+				v := &bdigest.Digest{}
+				if err = v.UnmarshalBinary(data); err != nil {
+					t.Fatalf("failed to unmarshal digest: %v", err)
+				}
+
+				d1, d2 := target.Clone(), target.Clone()
+				err1, err2 := d1.Merge(v), d2.MergeBinary(data)
+				if (err1 == nil) != (err2 == nil) {
+					t.Fatalf("merge error %v differs from %v", err2, err1)
+				}
+				if !d1.Equal(d2) || d1.RelativeError() != d2.RelativeError() {
+					t.Fatalf("got %v which is different than %v", d2, d1)
+				}
+			}
+		}
+	})
+}
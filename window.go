@@ -0,0 +1,195 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest
+
+import (
+	"math"
+	"time"
+)
+
+// WindowedDigest tracks distribution of values added during the trailing
+// time window, split into a fixed number of slices of equal width.
+// Slices are expired as a whole, so the window covers between n-1 and n
+// slices of time, including the current partial slice.
+//
+// Like Digest, WindowedDigest is not safe for concurrent use.
+type WindowedDigest struct {
+	proto  *Digest
+	width  int64 // of slice in nanoseconds
+	slices []*Digest
+	cur    int64 // index of the current slice, slices[cur%len(slices)]
+	init   bool
+}
+
+// NewWindowedDigest returns digest with parameters of NewDigest
+// for values added during the last n slices of time of the given width.
+//
+// NewWindowedDigest panics if n is less than 1 or width is not positive.
+func NewWindowedDigest(err float64, n int, width time.Duration, opts ...Option) *WindowedDigest {
+	if n < 1 {
+		panic("n must be at least 1")
+	}
+	if width <= 0 {
+		panic("width must be positive")
+	}
+
+	w := &WindowedDigest{
+		proto:  NewDigest(err, opts...),
+		width:  int64(width),
+		slices: make([]*Digest, n),
+	}
+	for i := range w.slices {
+		w.slices[i] = w.proto.Clone()
+	}
+
+	return w
+}
+
+// String returns short digest description.
+func (w *WindowedDigest) String() string {
+	return w.proto.String()
+}
+
+// Add adds finite non-negative value v to the digest at the current time,
+// like Digest.Add.
+func (w *WindowedDigest) Add(v float64) {
+	w.AddAt(time.Now(), v)
+}
+
+// AddAt adds finite non-negative value v to the digest at time t,
+// like Digest.Add. Values added at time before the window are ignored.
+//
+// AddAt panics if v is outside [0, math.MaxFloat64].
+func (w *WindowedDigest) AddAt(t time.Time, v float64) {
+	if math.IsNaN(v) || v < 0 || v > math.MaxFloat64 {
+		panic("v must be in [0, math.MaxFloat64]")
+	}
+
+	i := w.advance(t)
+	if i <= w.cur-int64(len(w.slices)) {
+		return
+	}
+
+	w.slices[w.slot(i)].Add(v)
+}
+
+// Advance expires the slices of time before the window ending at time t.
+// Time of the window never goes backwards.
+func (w *WindowedDigest) Advance(t time.Time) {
+	w.advance(t)
+}
+
+func (w *WindowedDigest) advance(t time.Time) int64 {
+	i := timeSlot(t, w.width)
+
+	switch {
+	case !w.init:
+		w.cur = i
+		w.init = true
+	case i > w.cur:
+		for j := w.cur + 1; j <= i && j <= w.cur+int64(len(w.slices)); j++ {
+			w.slices[w.slot(j)].Reset()
+		}
+		w.cur = i
+	}
+
+	return i
+}
+
+func (w *WindowedDigest) slot(i int64) int {
+	return ringSlot(i, len(w.slices))
+}
+
+// timeSlot returns the index of the slot of the given width
+// in nanoseconds holding time t, counting from the Unix epoch.
+func timeSlot(t time.Time, width int64) int64 {
+	ns := t.UnixNano()
+	i := ns / width
+	if ns < 0 && ns%width != 0 {
+		i--
+	}
+	return i
+}
+
+// ringSlot returns the position of slot i in ring buffer of size n.
+func ringSlot(i int64, n int) int {
+	m := int64(n)
+	return int((i%m + m) % m)
+}
+
+// Reset resets digest to the initial empty state.
+func (w *WindowedDigest) Reset() {
+	for _, d := range w.slices {
+		d.Reset()
+	}
+	w.cur = 0
+	w.init = false
+}
+
+// Count returns the number of values in the window ending at the current time.
+func (w *WindowedDigest) Count() uint64 {
+	w.advance(time.Now())
+
+	n := uint64(0)
+	for _, d := range w.slices {
+		n += d.Count()
+	}
+
+	return n
+}
+
+// Snapshot returns a digest with the values in the window
+// ending at the current time.
+func (w *WindowedDigest) Snapshot() *Digest {
+	return w.SnapshotAt(time.Now())
+}
+
+// SnapshotAt returns a digest with the values in the window ending at time t,
+// or at the latest time used before if it is after t. If the total count
+// would overflow, the values which do not fit are dropped.
+func (w *WindowedDigest) SnapshotAt(t time.Time) *Digest {
+	return w.lastAt(t, len(w.slices))
+}
+
+// lastAt returns a digest with the values in the last n slices
+// of the window ending at time t.
+func (w *WindowedDigest) lastAt(t time.Time, n int) *Digest {
+	w.advance(t)
+
+	d := w.proto.Clone()
+	for i := 0; i < n; i++ {
+		_ = d.mergeSaturating(w.slices[w.slot(w.cur-int64(i))]) // same parameters
+	}
+
+	return d
+}
+
+// Quantile returns the q-quantile of values in the window ending
+// at the current time, like Digest.Quantile. Since it merges all
+// the slices, prefer Snapshot or Freeze for multiple queries.
+func (w *WindowedDigest) Quantile(q float64) float64 {
+	return w.Snapshot().Quantile(q)
+}
+
+// Quantiles returns the q-quantiles of values in the window ending
+// at the current time for each of qs, like Digest.Quantiles.
+func (w *WindowedDigest) Quantiles(qs ...float64) []float64 {
+	return w.Snapshot().Quantiles(qs...)
+}
+
+// Freeze returns an immutable snapshot of the window ending at the current time.
+func (w *WindowedDigest) Freeze() *FrozenDigest {
+	return w.Snapshot().Freeze()
+}
@@ -0,0 +1,107 @@
+// Copyright 2020 Gregory Petrosyan <gregory.petrosyan@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bdigest_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"pgregory.net/bdigest"
+	"pgregory.net/rapid"
+)
+
+func TestWindowedDigest(t *testing.T) {
+	t.Parallel()
+
+	rapid.Check(t, func(t *rapid.T) {
+		var (
+			relErr = rapid.Float64Range(1e-3, 0.5).Draw(t, "relative error")
+			n      = rapid.IntRange(1, 12).Draw(t, "slices")
+			width  = time.Duration(rapid.Int64Range(1, 1e10).Draw(t, "width"))
+			start  = time.Unix(0, rapid.Int64Range(-1e18, 1e18).Draw(t, "start"))
+			adds   = rapid.IntRange(0, 100).Draw(t, "adds")
+		)
+
+		w := bdigest.NewWindowedDigest(relErr, n, width)
+		type added struct {
+			t time.Time
+			v float64
+		}
+		var values []added
+		now := start
+		for i := 0; i < adds; i++ {
+			now = now.Add(time.Duration(rapid.Int64Range(0, 3*int64(width)).Draw(t, "delay")))
+			v := rapid.Float64Range(0, 1e6).Draw(t, "value")
+			at := now.Add(-time.Duration(rapid.Int64Range(0, int64(n+1)*int64(width)).Draw(t, "age")))
+			w.AddAt(at, v)
+			values = append(values, added{at, v})
+
+			d := bdigest.NewDigest(relErr)
+			for _, a := range values {
+				if slice(a.t, width) > slice(now, width)-int64(n) {
+					d.Add(a.v)
+				}
+			}
+			data1, _ := w.SnapshotAt(now).MarshalBinary()
+			data2, _ := d.MarshalBinary()
+			if string(data1) != string(data2) {
+				t.Fatalf("windowed digest data %q differs from %q", data1, data2)
+			}
+		}
+	})
+}
+
+func slice(t time.Time, width time.Duration) int64 {
+	ns := t.UnixNano()
+	i := ns / int64(width)
+	if ns < 0 && ns%int64(width) != 0 {
+		i--
+	}
+	return i
+}
+
+func TestWindowedDigest_BurnRates(t *testing.T) {
+	t.Parallel()
+
+	var (
+		slo   = bdigest.SLO{Threshold: 100, Target: 0.99}
+		w     = bdigest.NewWindowedDigest(0.01, 60, time.Minute)
+		start = time.Unix(0, 0)
+	)
+	// 1% of slow values for the first 55 minutes, 10% for the last 5.
+	for m := 0; m < 60; m++ {
+		slow := 1
+		if m >= 55 {
+			slow = 10
+		}
+		for i := 0; i < 100; i++ {
+			v := 10.0
+			if i < slow {
+				v = 1000
+			}
+			w.AddAt(start.Add(time.Duration(m)*time.Minute), v)
+		}
+	}
+
+	now := start.Add(59 * time.Minute)
+	rates := w.BurnRatesAt(now, slo, 5*time.Minute, time.Hour)
+	if math.Abs(rates[0]-10) > 1e-9 {
+		t.Fatalf("short window burn rate is %v instead of 10", rates[0])
+	}
+	if want := (55*1.0 + 5*10) / 60; math.Abs(rates[1]-want) > 1e-9 {
+		t.Fatalf("long window burn rate is %v instead of %v", rates[1], want)
+	}
+}